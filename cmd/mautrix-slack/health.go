@@ -0,0 +1,40 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"go.mau.fi/util/exstrings"
+)
+
+// healthAuth requires the provisioning shared secret for the health and metrics endpoints, as they expose user and team IDs.
+func healthAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := m.Config.Provisioning.SharedSecret
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if secret == "" || secret == "disable" || !exstrings.ConstantTimeEqual(auth, secret) {
+			jsonResponse(w, http.StatusUnauthorized, &Error{
+				Error:   "Invalid auth token",
+				ErrCode: "M_UNKNOWN_TOKEN",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
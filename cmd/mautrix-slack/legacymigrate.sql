@@ -55,7 +55,7 @@ SELECT
         WHEN type=3 THEN 'group_dm'
         ELSE ''
     END, -- room_type
-    '{}' -- metadata
+    '{"legacy_migrated": true}' -- metadata
 FROM portal_old;
 
 INSERT INTO ghost (
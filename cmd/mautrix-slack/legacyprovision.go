@@ -151,6 +151,24 @@ func legacyProvLogin(w http.ResponseWriter, r *http.Request) {
 			ErrCode: "M_UNKNOWN",
 		})
 		return
+	} else if nextStep.StepID != connector.LoginStepIDConfirm {
+		jsonResponse(w, http.StatusInternalServerError, Error{
+			Error:   "Unexpected login step",
+			ErrCode: "M_UNKNOWN",
+		})
+		return
+	}
+	// The legacy API has no confirmation step, so the login preview is accepted automatically
+	nextStep, err = login.(bridgev2.LoginProcessUserInput).SubmitUserInput(r.Context(), map[string]string{
+		"confirm": "yes",
+	})
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to confirm login")
+		jsonResponse(w, http.StatusInternalServerError, Error{
+			Error:   "Failed to confirm login",
+			ErrCode: "M_UNKNOWN",
+		})
+		return
 	} else if nextStep.StepID != connector.LoginStepIDComplete {
 		jsonResponse(w, http.StatusInternalServerError, Error{
 			Error:   "Unexpected login step",
@@ -39,6 +39,8 @@ var m = mxmain.BridgeMain{
 	URL:         "https://github.com/mautrix/slack",
 	Version:     "0.2.0",
 	Connector:   c,
+
+	AdditionalLongFlags: " [--migrate-db <postgres URI>]",
 }
 
 func main() {
@@ -51,13 +53,20 @@ func main() {
 			m.LegacyMigrateSimple(legacyMigrateRenameTables, legacyMigrateCopyData, 14),
 			true,
 		)
+		c.ConfigPath = m.ConfigPath
+		migrateDatabaseAndExit()
 	}
 	m.PostStart = func() {
 		if m.Matrix.Provisioning != nil {
 			m.Matrix.Provisioning.Router.HandleFunc("/v1/ping", legacyProvPing).Methods(http.MethodGet)
 			m.Matrix.Provisioning.Router.HandleFunc("/v1/login", legacyProvLogin).Methods(http.MethodPost)
 			m.Matrix.Provisioning.Router.HandleFunc("/v1/logout", legacyProvLogout).Methods(http.MethodPost)
+			m.Matrix.Provisioning.Router.HandleFunc("/v1/purge", provPurge).Methods(http.MethodPost)
 		}
+		m.Matrix.AS.Router.HandleFunc("/_slack/health", healthAuth(c.ServeHealth)).Methods(http.MethodGet)
+		m.Matrix.AS.Router.HandleFunc("/_slack/metrics", healthAuth(c.ServeMetrics)).Methods(http.MethodGet)
+		m.Matrix.AS.Router.HandleFunc("/_slack/events", c.ServeEventsAPI).Methods(http.MethodPost)
+		go reloadConfigOnSignal()
 	}
 	m.InitVersion(Tag, Commit, BuildTime)
 	m.Run()
@@ -0,0 +1,325 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	flag "maunium.net/go/mauflag"
+	"maunium.net/go/mautrix/crypto/sql_store_upgrade"
+	"maunium.net/go/mautrix/sqlstatestore"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+)
+
+var migrateDBTarget = flag.Make().LongKey("migrate-db").ValueName("postgres URI").Usage("Copy the SQLite database into the given empty Postgres database and quit.").String()
+
+// migrateDBSection is one of the independently versioned parts of the bridge database.
+type migrateDBSection struct {
+	name         string
+	versionTable string
+	child        func(db *dbutil.Database, log zerolog.Logger) *dbutil.Database
+}
+
+var migrateDBSections = []migrateDBSection{{
+	name:         "main",
+	versionTable: "version",
+	child: func(db *dbutil.Database, log zerolog.Logger) *dbutil.Database {
+		db.UpgradeTable = m.DB.UpgradeTable
+		return db
+	},
+}, {
+	name:         "slack",
+	versionTable: "slack_version",
+	child: func(db *dbutil.Database, log zerolog.Logger) *dbutil.Database {
+		return slackdb.New(db, log).Database
+	},
+}, {
+	name:         "matrix_state",
+	versionTable: sqlstatestore.VersionTableName,
+	child: func(db *dbutil.Database, log zerolog.Logger) *dbutil.Database {
+		return db.Child(sqlstatestore.VersionTableName, sqlstatestore.UpgradeTable, dbutil.ZeroLogger(log))
+	},
+}, {
+	name:         "crypto",
+	versionTable: sql_store_upgrade.VersionTableName,
+	child: func(db *dbutil.Database, log zerolog.Logger) *dbutil.Database {
+		return db.Child(sql_store_upgrade.VersionTableName, sql_store_upgrade.Table, dbutil.ZeroLogger(log))
+	},
+}}
+
+// migrateDatabaseAndExit runs the --migrate-db flag if it was given.
+func migrateDatabaseAndExit() {
+	if *migrateDBTarget == "" {
+		return
+	}
+	log := m.Log.With().Str("action", "migrate database").Logger()
+	ctx := log.WithContext(context.Background())
+	err := migrateDatabase(ctx, m.DB, *migrateDBTarget)
+	if err != nil {
+		log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to migrate database")
+		os.Exit(15)
+	}
+	log.Info().Msg("Database migrated successfully. Update database.type and database.uri in the config before starting the bridge.")
+	os.Exit(0)
+}
+
+func migrateDatabase(ctx context.Context, source *dbutil.Database, targetURI string) error {
+	log := zerolog.Ctx(ctx)
+	if source.Dialect != dbutil.SQLite {
+		return fmt.Errorf("source database must be SQLite, configured database is %s", source.Dialect)
+	}
+	target, err := dbutil.NewWithDialect(targetURI, "postgres")
+	if err != nil {
+		return fmt.Errorf("failed to open target database: %w", err)
+	}
+	defer target.Close()
+	target.Owner = source.Owner
+	target.IgnoreForeignTables = source.IgnoreForeignTables
+	target.Log = source.Log
+
+	// Bring both databases to the same schema version, so the tables can be copied as-is.
+	skipTables := []string{"database_owner"}
+	for _, section := range migrateDBSections {
+		sectionLog := log.With().Str("db_section", section.name).Logger()
+		sourceSection := section.child(source, sectionLog)
+		targetSection := section.child(target, sectionLog)
+		if err = sourceSection.Upgrade(ctx); err != nil {
+			return fmt.Errorf("failed to upgrade %s section of source database: %w", section.name, err)
+		} else if err = targetSection.Upgrade(ctx); err != nil {
+			return fmt.Errorf("failed to upgrade %s section of target database: %w", section.name, err)
+		}
+		var sourceVersion, targetVersion int
+		if err = source.QueryRow(ctx, "SELECT version FROM "+section.versionTable).Scan(&sourceVersion); err != nil {
+			return fmt.Errorf("failed to get %s version of source database: %w", section.name, err)
+		} else if err = target.QueryRow(ctx, "SELECT version FROM "+section.versionTable).Scan(&targetVersion); err != nil {
+			return fmt.Errorf("failed to get %s version of target database: %w", section.name, err)
+		} else if sourceVersion != targetVersion {
+			return fmt.Errorf("%s schema version mismatch: source is v%d, target is v%d", section.name, sourceVersion, targetVersion)
+		}
+		skipTables = append(skipTables, section.versionTable)
+	}
+
+	tables, err := getSQLiteTables(ctx, source)
+	if err != nil {
+		return err
+	}
+	var copyTables []sqliteTable
+	for _, table := range tables {
+		if slices.Contains(skipTables, table.name) {
+			continue
+		}
+		if exists, err := target.TableExists(ctx, table.name); err != nil {
+			return fmt.Errorf("failed to check if %s exists in target database: %w", table.name, err)
+		} else if !exists {
+			log.Warn().Str("table", table.name).Msg("Table doesn't exist in target schema, not copying it")
+			continue
+		}
+		copyTables = append(copyTables, table)
+	}
+	copyTables = sortTablesForCopy(copyTables)
+
+	return target.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, table := range copyTables {
+			var existing int
+			if err := target.QueryRow(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, table.name)).Scan(&existing); err != nil {
+				return fmt.Errorf("failed to count rows in target %s: %w", table.name, err)
+			} else if existing > 0 {
+				return fmt.Errorf("target table %s is not empty", table.name)
+			}
+			copied, err := copyTable(ctx, source, target, table)
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", table.name, err)
+			}
+			log.Info().Str("table", table.name).Int("rows", copied).Msg("Copied table")
+		}
+		for _, table := range copyTables {
+			var sourceCount, targetCount int
+			query := fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, table.name)
+			if err := source.QueryRow(ctx, query).Scan(&sourceCount); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", table.name, err)
+			} else if err = target.QueryRow(ctx, query).Scan(&targetCount); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", table.name, err)
+			} else if sourceCount != targetCount {
+				return fmt.Errorf("row count mismatch in %s: source has %d, target has %d", table.name, sourceCount, targetCount)
+			}
+		}
+		return resetPostgresSequences(ctx, target)
+	})
+}
+
+type sqliteTable struct {
+	name    string
+	columns []string
+	// references contains the tables this table has foreign keys to
+	references []string
+	// selfRef is the first column of a foreign key pointing back to the same table
+	selfRef string
+}
+
+var scanString = dbutil.ConvertRowFn[string](dbutil.ScanSingleColumn[string])
+
+func getSQLiteTables(ctx context.Context, db *dbutil.Database) ([]sqliteTable, error) {
+	names, err := scanString.NewRowIter(db.Query(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name")).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	tables := make([]sqliteTable, len(names))
+	for i, name := range names {
+		tables[i].name = name
+		tables[i].columns, err = scanString.NewRowIter(db.Query(ctx, "SELECT name FROM pragma_table_info($1) ORDER BY cid", name)).AsList()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get columns of %s: %w", name, err)
+		}
+		rows, err := db.Query(ctx, `SELECT "table", "from" FROM pragma_foreign_key_list($1) WHERE seq=0`, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get foreign keys of %s: %w", name, err)
+		}
+		for rows.Next() {
+			var refTable, fromColumn string
+			if err = rows.Scan(&refTable, &fromColumn); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("failed to scan foreign key of %s: %w", name, err)
+			}
+			if refTable == name {
+				tables[i].selfRef = fromColumn
+			} else if !slices.Contains(tables[i].references, refTable) {
+				tables[i].references = append(tables[i].references, refTable)
+			}
+		}
+		if err = rows.Close(); err != nil {
+			return nil, fmt.Errorf("failed to read foreign keys of %s: %w", name, err)
+		}
+	}
+	return tables, nil
+}
+
+// sortTablesForCopy orders tables so that every table comes after the tables it references.
+func sortTablesForCopy(tables []sqliteTable) []sqliteTable {
+	sorted := make([]sqliteTable, 0, len(tables))
+	done := make(map[string]bool, len(tables))
+	present := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		present[table.name] = true
+	}
+	for len(sorted) < len(tables) {
+		progressed := false
+		for _, table := range tables {
+			if done[table.name] {
+				continue
+			}
+			ready := true
+			for _, ref := range table.references {
+				if present[ref] && !done[ref] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, table)
+				done[table.name] = true
+				progressed = true
+			}
+		}
+		if !progressed {
+			// Foreign key cycle between tables, copy the rest in the original order and let the database complain.
+			for _, table := range tables {
+				if !done[table.name] {
+					sorted = append(sorted, table)
+					done[table.name] = true
+				}
+			}
+		}
+	}
+	return sorted
+}
+
+func copyTable(ctx context.Context, source, target *dbutil.Database, table sqliteTable) (int, error) {
+	quotedColumns := make([]string, len(table.columns))
+	placeholders := make([]string, len(table.columns))
+	for i, col := range table.columns {
+		quotedColumns[i] = fmt.Sprintf(`"%s"`, col)
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	columnList := strings.Join(quotedColumns, ", ")
+	selectQuery := fmt.Sprintf(`SELECT %s FROM "%s"`, columnList, table.name)
+	if table.selfRef != "" {
+		// Rows without a parent have to be inserted before their children
+		selectQuery += fmt.Sprintf(` ORDER BY "%s" IS NOT NULL`, table.selfRef)
+	}
+	insertQuery := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s)`, table.name, columnList, strings.Join(placeholders, ", "))
+	rows, err := source.Query(ctx, selectQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var count int
+	values := make([]any, len(table.columns))
+	pointers := make([]any, len(table.columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return count, err
+		} else if _, err = target.Exec(ctx, insertQuery, values...); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+const getPostgresSequencesQuery = `
+	SELECT table_name, column_name, pg_get_serial_sequence(quote_ident(table_name), column_name)
+	FROM information_schema.columns
+	WHERE table_schema=current_schema() AND (column_default LIKE 'nextval(%' OR is_identity='YES')
+`
+
+// resetPostgresSequences moves auto-increment sequences past the copied rows.
+func resetPostgresSequences(ctx context.Context, db *dbutil.Database) error {
+	rows, err := db.Query(ctx, getPostgresSequencesQuery)
+	if err != nil {
+		return fmt.Errorf("failed to list sequences: %w", err)
+	}
+	type sequence struct{ table, column, name string }
+	var sequences []sequence
+	for rows.Next() {
+		var seq sequence
+		if err = rows.Scan(&seq.table, &seq.column, &seq.name); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to scan sequence: %w", err)
+		}
+		sequences = append(sequences, seq)
+	}
+	if err = rows.Close(); err != nil {
+		return fmt.Errorf("failed to list sequences: %w", err)
+	}
+	for _, seq := range sequences {
+		_, err = db.Exec(ctx, fmt.Sprintf(`SELECT setval($1, COALESCE(MAX("%s"), 0) + 1, false) FROM "%s"`, seq.column, seq.table), seq.name)
+		if err != nil {
+			return fmt.Errorf("failed to reset sequence of %s.%s: %w", seq.table, seq.column, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+)
+
+func TestGetSQLiteTablesAndSort(t *testing.T) {
+	db, err := dbutil.NewWithDialect("file::memory:?_foreign_keys=on", "sqlite3")
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = db.Exec(ctx, `
+		CREATE TABLE reaction (message_id TEXT REFERENCES message(id), emoji TEXT);
+		CREATE TABLE message (id TEXT PRIMARY KEY, portal_id TEXT REFERENCES portal(id));
+		CREATE TABLE portal (id TEXT PRIMARY KEY, parent_id TEXT REFERENCES portal(id));
+		CREATE TABLE emoji (id TEXT PRIMARY KEY);
+	`)
+	require.NoError(t, err)
+
+	tables, err := getSQLiteTables(ctx, db)
+	require.NoError(t, err)
+	require.Len(t, tables, 4)
+	byName := make(map[string]sqliteTable)
+	for _, table := range tables {
+		byName[table.name] = table
+	}
+	assert.Equal(t, []string{"id", "parent_id"}, byName["portal"].columns)
+	assert.Equal(t, "parent_id", byName["portal"].selfRef)
+	assert.Empty(t, byName["portal"].references)
+	assert.Equal(t, []string{"portal"}, byName["message"].references)
+
+	var order []string
+	for _, table := range sortTablesForCopy(tables) {
+		order = append(order, table.name)
+	}
+	assert.Equal(t, []string{"emoji", "portal", "message", "reaction"}, order)
+}
+
+func TestSortTablesForCopy(t *testing.T) {
+	tests := []struct {
+		name   string
+		tables []sqliteTable
+		want   []string
+	}{
+		{"Independent", []sqliteTable{{name: "a"}, {name: "b"}}, []string{"a", "b"}},
+		{"MissingReference", []sqliteTable{{name: "a", references: []string{"gone"}}}, []string{"a"}},
+		{"Chain", []sqliteTable{
+			{name: "c", references: []string{"b"}},
+			{name: "b", references: []string{"a"}},
+			{name: "a"},
+		}, []string{"a", "b", "c"}},
+		{"Cycle", []sqliteTable{
+			{name: "x", references: []string{"y"}},
+			{name: "y", references: []string{"x"}},
+			{name: "z"},
+		}, []string{"z", "x", "y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var order []string
+			for _, table := range sortTablesForCopy(tt.tables) {
+				order = append(order, table.name)
+			}
+			assert.Equal(t, tt.want, order)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+
+	"go.mau.fi/mautrix-slack/pkg/connector"
+)
+
+type purgeRequest struct {
+	TeamID string `json:"team_id"`
+	UserID string `json:"user_id,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+type purgeResponse struct {
+	Success bool `json:"success"`
+	DryRun  bool `json:"dry_run"`
+	connector.PurgeReport
+}
+
+// provPurge is the provisioning API equivalent of the purge admin command.
+func provPurge(w http.ResponseWriter, r *http.Request) {
+	user := m.Matrix.Provisioning.GetUser(r)
+	if !user.Permissions.Admin {
+		jsonResponse(w, http.StatusForbidden, Error{
+			Error:   "Purging data requires bridge admin permissions",
+			ErrCode: "M_FORBIDDEN",
+		})
+		return
+	}
+	var req purgeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   "Invalid JSON",
+			ErrCode: "M_NOT_JSON",
+		})
+		return
+	}
+	var report connector.PurgeReport
+	if req.UserID == "" {
+		report, err = c.PurgeTeam(r.Context(), req.TeamID, req.DryRun)
+	} else {
+		report, err = c.PurgeUser(r.Context(), req.TeamID, req.UserID, req.DryRun)
+	}
+	if errors.Is(err, connector.ErrInvalidTeamID) || errors.Is(err, connector.ErrInvalidUserID) {
+		jsonResponse(w, http.StatusBadRequest, Error{
+			Error:   err.Error(),
+			ErrCode: "M_INVALID_PARAM",
+		})
+		return
+	} else if errors.Is(err, connector.ErrPurgeActiveLogin) {
+		jsonResponse(w, http.StatusConflict, Error{
+			Error:   "Can't purge data while there are logins for it, log them out first",
+			ErrCode: "FI.MAU.SLACK.ACTIVE_LOGIN",
+		})
+		return
+	} else if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to purge data")
+		jsonResponse(w, http.StatusInternalServerError, Error{
+			Error:   "Failed to purge data",
+			ErrCode: "M_UNKNOWN",
+		})
+		return
+	}
+	jsonResponse(w, http.StatusOK, purgeResponse{Success: true, DryRun: req.DryRun, PurgeReport: report})
+}
@@ -0,0 +1,69 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+)
+
+// MaxRetentionDays is the longest custom message retention that can be set with the slack-admin command.
+const MaxRetentionDays = 3650
+
+var (
+	errNoAdminToken      = errors.New("admin APIs require a user token, bot tokens can't be used")
+	errNotWorkspaceAdmin = errors.New("your Slack account isn't an owner or admin of the workspace")
+)
+
+// workspaceAdmin returns the client for Slack's admin APIs if the login belongs to a workspace owner or admin.
+// The role of the user token in hybrid logins isn't known, so for those, Slack decides whether calls are allowed.
+func (s *SlackClient) workspaceAdmin() (*slackapi.AdminClient, error) {
+	if s.AdminAPI == nil {
+		return nil, errNoAdminToken
+	} else if self := s.selfInfo(); s.UserClient == nil && self != nil {
+		if !self.IsAdmin && !self.IsOwner && !self.IsPrimaryOwner {
+			return nil, errNotWorkspaceAdmin
+		}
+	}
+	return s.AdminAPI, nil
+}
+
+// parseChannelArg parses a channel ID given as a command argument, either plain or as a Slack channel mention.
+func parseChannelArg(arg string) (string, error) {
+	channelID := strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(arg, "<#"), ">"))
+	channelID, _, _ = strings.Cut(channelID, "|")
+	if !slackIDRegex.MatchString(channelID) {
+		return "", fmt.Errorf("invalid channel ID %q", arg)
+	}
+	return channelID, nil
+}
+
+// parseRetentionDays parses a retention duration in days. Zero means the workspace default.
+func parseRetentionDays(arg string) (int, error) {
+	if arg == "default" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+	if err != nil || days < 1 || days > MaxRetentionDays {
+		return 0, fmt.Errorf("retention must be `default` or a number of days between 1 and %d", MaxRetentionDays)
+	}
+	return days, nil
+}
@@ -0,0 +1,119 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+)
+
+func TestParseChannelArg(t *testing.T) {
+	testCases := []struct {
+		arg      string
+		expected string
+		wantErr  bool
+	}{
+		{"C123ABC", "C123ABC", false},
+		{"c123abc", "C123ABC", false},
+		{"<#C123ABC>", "C123ABC", false},
+		{"<#C123ABC|general>", "C123ABC", false},
+		{"#general", "", true},
+		{"", "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.arg, func(t *testing.T) {
+			channelID, err := parseChannelArg(tc.arg)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, channelID)
+			}
+		})
+	}
+}
+
+func TestParseRetentionDays(t *testing.T) {
+	testCases := []struct {
+		arg      string
+		expected int
+		wantErr  bool
+	}{
+		{"default", 0, false},
+		{"30", 30, false},
+		{"90d", 90, false},
+		{"0", 0, true},
+		{"3651", 0, true},
+		{"forever", 0, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.arg, func(t *testing.T) {
+			days, err := parseRetentionDays(tc.arg)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, days)
+			}
+		})
+	}
+}
+
+func TestWorkspaceAdmin(t *testing.T) {
+	s := newTestSlackClient(nil)
+	_, err := s.workspaceAdmin()
+	assert.ErrorIs(t, err, errNoAdminToken)
+
+	s.AdminAPI = slackapi.NewAdminClient("xoxc-test", "cookie")
+	s.BootResp = &slack.ClientUserBootResponse{}
+	_, err = s.workspaceAdmin()
+	assert.ErrorIs(t, err, errNotWorkspaceAdmin)
+
+	s.BootResp.Self.IsAdmin = true
+	admin, err := s.workspaceAdmin()
+	require.NoError(t, err)
+	assert.Same(t, s.AdminAPI, admin)
+}
+
+func TestAdminClient(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Respond("admin.users.invite", nil)
+	srv.Handle("admin.conversations.rename", func(form url.Values) (any, error) {
+		return nil, slackapitest.Error("feature_not_enabled")
+	})
+	admin := slackapi.NewAdminClient("xoxp-test", "")
+	admin.APIURL = srv.URL + "/api/"
+	ctx := context.Background()
+
+	require.NoError(t, admin.InviteUser(ctx, "T1", "user@example.com", []string{"C1", "C2"}))
+	calls := srv.Calls("admin.users.invite")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "T1", calls[0].Get("team_id"))
+	assert.Equal(t, "user@example.com", calls[0].Get("email"))
+	assert.Equal(t, "C1,C2", calls[0].Get("channel_ids"))
+
+	err := admin.RenameChannel(ctx, "C1", "new-name")
+	assert.True(t, isSlackError(err, "feature_not_enabled"))
+}
@@ -0,0 +1,90 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// auditLogPruneInterval is how often old audit log entries are deleted.
+const auditLogPruneInterval = 1 * time.Hour
+
+// auditLog records a bridged action in the audit log, if it's enabled.
+// Failures are only logged, as the action itself has already been bridged.
+func (s *SlackClient) auditLog(ctx context.Context, action slackdb.AuditAction, channelID, sender, target string) {
+	if !s.Main.currentConfig().AuditLog.Enabled {
+		return
+	}
+	err := s.Main.DB.AuditLog.Insert(ctx, &slackdb.AuditEntry{
+		Timestamp: time.Now(),
+		Action:    action,
+		TeamID:    s.TeamID,
+		LoginID:   string(s.UserLogin.ID),
+		UserMXID:  s.UserLogin.UserMXID,
+		ChannelID: channelID,
+		Sender:    sender,
+		Target:    target,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("audit_action", string(action)).Msg("Failed to write audit log entry")
+	}
+}
+
+// auditSlackMessage records an incoming Slack message, edit or deletion in the audit log.
+func (s *SlackClient) auditSlackMessage(ctx context.Context, msg *SlackMessage, sender string) {
+	switch msg.GetType() {
+	case bridgev2.RemoteEventMessage:
+		s.auditLog(ctx, slackdb.AuditActionSlackMessage, msg.Data.Channel, sender, msg.Data.Timestamp)
+	case bridgev2.RemoteEventEdit:
+		_, _, targetTS, _ := slackid.ParseMessageID(msg.GetTargetMessage())
+		s.auditLog(ctx, slackdb.AuditActionSlackEdit, msg.Data.Channel, sender, targetTS)
+	case bridgev2.RemoteEventMessageRemove:
+		_, _, targetTS, _ := slackid.ParseMessageID(msg.GetTargetMessage())
+		s.auditLog(ctx, slackdb.AuditActionSlackDelete, msg.Data.Channel, sender, targetTS)
+	}
+}
+
+func (s *SlackConnector) runAuditLogPruneLoop(ctx context.Context) {
+	log := s.br.Log.With().Str("action", "prune audit log").Logger()
+	ctx = log.WithContext(ctx)
+	ticker := time.NewTicker(auditLogPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The config is read on every tick so that reloading it takes effect without a restart
+			if !s.currentConfig().AuditLog.Enabled || s.currentConfig().AuditLog.Retention <= 0 {
+				continue
+			}
+			deleted, err := s.DB.AuditLog.Prune(ctx, time.Now().Add(-s.currentConfig().AuditLog.Retention))
+			if err != nil {
+				log.Err(err).Msg("Failed to prune audit log")
+			} else if deleted > 0 {
+				log.Debug().Int64("deleted_count", deleted).Msg("Pruned old audit log entries")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
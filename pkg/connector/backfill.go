@@ -20,6 +20,8 @@ import (
 	"context"
 	"fmt"
 	"slices"
+	"strconv"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/slack-go/slack"
@@ -51,13 +53,21 @@ func (s *SlackClient) GetBackfillMaxBatchCount(ctx context.Context, portal *brid
 }
 
 func (s *SlackClient) FetchMessages(ctx context.Context, params bridgev2.FetchMessagesParams) (*bridgev2.FetchMessagesResponse, error) {
-	if s.Client == nil {
-		return nil, bridgev2.ErrNotLoggedIn
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
 	}
 	_, channelID := slackid.ParsePortalID(params.Portal.ID)
 	if channelID == "" {
 		return nil, fmt.Errorf("invalid channel ID")
 	}
+	// The initial forward backfill runs right after room creation, before any remote event handlers
+	s.applyPendingEncryption(ctx, params.Portal)
+	if params.Forward && params.AnchorMessage == nil && params.ThreadRoot == "" {
+		params.Count = s.Main.currentConfig().Backfill.InitialMessages.Limit(params.Portal.RoomType, params.Count)
+		if params.Count == 0 {
+			return &bridgev2.FetchMessagesResponse{Forward: true}, nil
+		}
+	}
 	var anchorMessageID string
 	if params.AnchorMessage != nil {
 		_, _, anchorMessageID, _ = slackid.ParseMessageID(params.AnchorMessage.ID)
@@ -77,6 +87,20 @@ func (s *SlackClient) FetchMessages(ctx context.Context, params bridgev2.FetchMe
 	var chunk *slack.GetConversationHistoryResponse
 	var err error
 	var threadTS string
+	if params.Task != nil && !params.Forward {
+		var done func(int)
+		done, err = s.Main.backfillThrottle.Load().Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if chunk != nil {
+				done(len(chunk.Messages))
+			} else {
+				done(0)
+			}
+		}()
+	}
 	if params.ThreadRoot != "" {
 		var ok bool
 		_, _, threadTS, ok = slackid.ParseMessageID(params.ThreadRoot)
@@ -94,9 +118,11 @@ func (s *SlackClient) FetchMessages(ctx context.Context, params bridgev2.FetchMe
 		return nil, err
 	}
 	convertedMessages := make([]*bridgev2.BackfillMessage, 0, len(chunk.Messages))
-	var maxMsgID string
+	var maxMsgID, threadLastRead string
 	for _, msg := range chunk.Messages {
 		if threadTS != "" && msg.Timestamp == threadTS {
+			// Thread read markers are only available in the thread root
+			threadLastRead = msg.LastRead
 			continue
 		} else if threadTS == "" && msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
 			continue
@@ -108,15 +134,48 @@ func (s *SlackClient) FetchMessages(ctx context.Context, params bridgev2.FetchMe
 	}
 	slices.Reverse(convertedMessages)
 	lastRead := s.getLastReadCache(channelID)
+	if threadTS != "" {
+		lastRead = threadLastRead
+	}
 	return &bridgev2.FetchMessagesResponse{
 		Messages: convertedMessages,
 		Cursor:   networkid.PaginationCursor(chunk.ResponseMetadata.Cursor),
 		HasMore:  chunk.HasMore,
 		Forward:  params.Forward,
 		MarkRead: lastRead != "" && maxMsgID != "" && lastRead >= maxMsgID,
+		// Legacy bridge history isn't contiguous, so backfill can't just cut off at the oldest/newest known message
+		AggressiveDeduplication: s.isLegacyMigrated(ctx, params.Portal),
 	}, nil
 }
 
+// Messages copied from the legacy bridge database don't have a sender MXID, while bridgev2 always sets one.
+const hasLegacyMessagesQuery = `
+	SELECT EXISTS(SELECT 1 FROM message WHERE bridge_id=$1 AND room_id=$2 AND room_receiver=$3 AND sender_mxid='')
+`
+
+// isLegacyMigrated checks whether the portal has message history from the legacy bridge. The flag is set when
+// migrating, but portals that were migrated before it existed are detected from their messages and flagged here.
+func (s *SlackClient) isLegacyMigrated(ctx context.Context, portal *bridgev2.Portal) bool {
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	if meta.LegacyMigrated {
+		return true
+	}
+	var hasLegacyMessages bool
+	err := s.Main.br.DB.QueryRow(ctx, hasLegacyMessagesQuery, s.Main.br.ID, portal.ID, portal.Receiver).Scan(&hasLegacyMessages)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to check for legacy bridge messages")
+		return false
+	} else if !hasLegacyMessages {
+		return false
+	}
+	meta.LegacyMigrated = true
+	err = portal.Save(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to save portal after flagging it as migrated from the legacy bridge")
+	}
+	return true
+}
+
 func (s *SlackClient) wrapBackfillMessage(ctx context.Context, portal *bridgev2.Portal, msg *slack.Msg, inThread bool) *bridgev2.BackfillMessage {
 	senderID := msg.User
 	if senderID == "" {
@@ -139,18 +198,38 @@ func (s *SlackClient) wrapBackfillMessage(ctx context.Context, portal *bridgev2.
 		Sender:           sender,
 		ID:               slackid.MakeMessageID(s.TeamID, channelID, msg.Timestamp),
 		Timestamp:        slackid.ParseSlackTimestamp(msg.Timestamp),
-		Reactions:        make([]*bridgev2.BackfillReaction, 0, len(msg.Reactions)),
+		StreamOrder:      slackid.ParseSlackStreamOrder(msg.Timestamp),
+		Reactions:        s.convertReactions(ctx, channelID, msg),
 	}
 	if msg.ReplyCount > 0 && !inThread {
 		out.ShouldBackfillThread = true
 		out.LastThreadMessage = slackid.MakeMessageID(s.TeamID, channelID, msg.LatestReply)
 	}
-	for _, reaction := range msg.Reactions {
+	return out
+}
+
+// convertReactions converts the reactions of a message, fetching the full list if Slack truncated it.
+func (s *SlackClient) convertReactions(ctx context.Context, channelID string, msg *slack.Msg) []*bridgev2.BackfillReaction {
+	reactions := msg.Reactions
+	if hasTruncatedReactions(reactions) {
+		fullReactions, err := s.Client.GetReactionsContext(ctx, slack.ItemRef{
+			Channel:   channelID,
+			Timestamp: msg.Timestamp,
+		}, slack.GetReactionsParameters{Full: true})
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("message_ts", msg.Timestamp).Msg("Failed to fetch full reaction list")
+		} else {
+			reactions = fullReactions
+		}
+	}
+	out := make([]*bridgev2.BackfillReaction, 0, len(reactions))
+	for _, reaction := range reactions {
 		emoji, extraContent := s.getReactionInfo(ctx, reaction.Name)
+		emojiID := s.reactionEmojiID(ctx, reaction.Name)
 		for _, user := range reaction.Users {
-			out.Reactions = append(out.Reactions, &bridgev2.BackfillReaction{
+			out = append(out, &bridgev2.BackfillReaction{
 				Sender:       s.makeEventSender(user),
-				EmojiID:      networkid.EmojiID(reaction.Name),
+				EmojiID:      emojiID,
 				Emoji:        emoji,
 				ExtraContent: extraContent,
 			})
@@ -158,3 +237,93 @@ func (s *SlackClient) wrapBackfillMessage(ctx context.Context, portal *bridgev2.
 	}
 	return out
 }
+
+// hasTruncatedReactions returns true if any of the given reactions is missing some users.
+// Slack only includes a limited number of reacting users in message history responses.
+func hasTruncatedReactions(reactions []slack.ItemReaction) bool {
+	for _, reaction := range reactions {
+		if reaction.Count > len(reaction.Users) {
+			return true
+		}
+	}
+	return false
+}
+
+// queueHistoryMessage queues a message fetched from the history API as if it was received live.
+func (s *SlackClient) queueHistoryMessage(portalKey networkid.PortalKey, channelID string, msg *slack.Msg) {
+	sender := msg.User
+	if sender == "" {
+		sender = msg.BotID
+	}
+	evt := &slack.MessageEvent{Msg: *msg}
+	evt.Channel = channelID
+	s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackMessage{
+		SlackEventMeta: &SlackEventMeta{
+			Type:         bridgev2.RemoteEventMessage,
+			PortalKey:    portalKey,
+			Sender:       s.makeEventSender(sender),
+			RawTimestamp: msg.Timestamp,
+		},
+		Data:   evt,
+		Client: s,
+	})
+}
+
+// MaxHistoryRangeMessages is the maximum number of messages fetched by a single history range request.
+const MaxHistoryRangeMessages = 1000
+
+func formatSlackTimestamp(ts time.Time) string {
+	return strconv.FormatFloat(float64(ts.UnixMicro())/1e6, 'f', 6, 64)
+}
+
+// fetchHistoryRange fetches top-level messages sent between the given times and queues the ones that
+// haven't been bridged yet as remote events. Returns the number of queued messages.
+//
+// bridgev2 can't insert backfill batches at arbitrary points in the room, so the messages are sent
+// at the end of the timeline with their original timestamps.
+func (s *SlackClient) fetchHistoryRange(ctx context.Context, portal *bridgev2.Portal, channelID string, from, to time.Time) (int, error) {
+	log := zerolog.Ctx(ctx)
+	var messages []slack.Message
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Oldest:    formatSlackTimestamp(from),
+		Latest:    formatSlackTimestamp(to),
+		Limit:     200,
+	}
+	for len(messages) < MaxHistoryRangeMessages {
+		chunk, err := s.Client.GetConversationHistoryContext(ctx, params)
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, chunk.Messages...)
+		if !chunk.HasMore || chunk.ResponseMetadata.Cursor == "" {
+			break
+		}
+		params.Cursor = chunk.ResponseMetadata.Cursor
+	}
+	if len(messages) > MaxHistoryRangeMessages {
+		messages = messages[:MaxHistoryRangeMessages]
+	}
+	// Slack returns the newest messages first
+	slices.Reverse(messages)
+	queued := 0
+	for _, msg := range messages {
+		if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
+			continue
+		}
+		msgID := slackid.MakeMessageID(s.TeamID, channelID, msg.Timestamp)
+		existing, err := s.Main.br.DB.Message.GetFirstPartByID(ctx, portal.Receiver, msgID)
+		if err != nil {
+			return queued, fmt.Errorf("failed to check if message is already bridged: %w", err)
+		} else if existing != nil {
+			continue
+		}
+		s.queueHistoryMessage(portal.PortalKey, channelID, &msg.Msg)
+		queued++
+	}
+	log.Debug().
+		Int("fetched_count", len(messages)).
+		Int("queued_count", queued).
+		Msg("Fetched history range")
+	return queued, nil
+}
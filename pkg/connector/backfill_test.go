@@ -0,0 +1,140 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestBackfillInitialMessagesLimit(t *testing.T) {
+	cfg := &BackfillInitialMessagesConfig{DM: 500, GroupDM: -1}
+	tests := []struct {
+		name     string
+		roomType database.RoomType
+		expected int
+	}{
+		{"DM", database.RoomTypeDM, 500},
+		{"GroupDMDisabled", database.RoomTypeGroupDM, 0},
+		{"ChannelDefault", database.RoomTypeDefault, 50},
+		{"Space", database.RoomTypeSpace, 50},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, cfg.Limit(test.roomType, 50))
+		})
+	}
+}
+
+func TestFetchMessages_InitialMessagesLimit(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.history", func(form url.Values) (any, error) {
+		return map[string]any{"messages": []any{}}, nil
+	})
+	s := newTestSlackClient(srv.Client())
+	s.Main = &SlackConnector{br: newTestBridgeDB(t), Config: Config{Backfill: BackfillConfig{
+		InitialMessages: BackfillInitialMessagesConfig{DM: 500, GroupDM: -1},
+	}}}
+	makePortal := func(channelID string, roomType database.RoomType) *bridgev2.Portal {
+		return &bridgev2.Portal{Portal: &database.Portal{
+			PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", channelID)},
+			RoomType:  roomType,
+			Metadata:  &slackid.PortalMetadata{},
+		}}
+	}
+	ctx := context.Background()
+
+	_, err := s.FetchMessages(ctx, bridgev2.FetchMessagesParams{Portal: makePortal("D1", database.RoomTypeDM), Forward: true, Count: 50})
+	require.NoError(t, err)
+	_, err = s.FetchMessages(ctx, bridgev2.FetchMessagesParams{Portal: makePortal("C1", database.RoomTypeDefault), Forward: true, Count: 50})
+	require.NoError(t, err)
+	resp, err := s.FetchMessages(ctx, bridgev2.FetchMessagesParams{Portal: makePortal("G1", database.RoomTypeGroupDM), Forward: true, Count: 50})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Messages)
+	assert.False(t, resp.HasMore)
+
+	calls := srv.Calls("conversations.history")
+	require.Len(t, calls, 2)
+	assert.Equal(t, "D1", calls[0].Get("channel"))
+	assert.Equal(t, "500", calls[0].Get("limit"))
+	assert.Equal(t, "C1", calls[1].Get("channel"))
+	assert.Equal(t, "50", calls[1].Get("limit"))
+}
+
+// newTestBridgeDB returns a bridge with only an in-memory bridgev2 database set up.
+func newTestBridgeDB(t *testing.T) *bridgev2.Bridge {
+	rawDB, err := dbutil.NewWithDialect("file::memory:", "sqlite3")
+	require.NoError(t, err)
+	rawDB.RawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = rawDB.Close() })
+	db := database.New("", database.MetaTypes{
+		Portal:  func() any { return &slackid.PortalMetadata{} },
+		Message: func() any { return &slackid.MessageMetadata{} },
+	}, rawDB)
+	require.NoError(t, db.Upgrade(context.Background()))
+	return &bridgev2.Bridge{DB: db}
+}
+
+func TestIsLegacyMigrated(t *testing.T) {
+	br := newTestBridgeDB(t)
+	s := newTestSlackClient(nil)
+	s.Main = &SlackConnector{br: br}
+	ctx := context.Background()
+	makePortal := func(channelID string) *bridgev2.Portal {
+		portal := &bridgev2.Portal{Bridge: br, Portal: &database.Portal{
+			PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", channelID)},
+			Metadata:  &slackid.PortalMetadata{},
+		}}
+		require.NoError(t, br.DB.Portal.Insert(ctx, portal.Portal))
+		return portal
+	}
+	insertMessage := func(portal *bridgev2.Portal, ts string, senderMXID id.UserID) {
+		require.NoError(t, br.DB.Message.Insert(ctx, &database.Message{
+			ID:         slackid.MakeMessageID("T1", "C1", ts),
+			MXID:       id.EventID("$" + ts),
+			Room:       portal.PortalKey,
+			SenderMXID: senderMXID,
+			Timestamp:  time.Unix(1700000000, 0),
+			Metadata:   &slackid.MessageMetadata{},
+		}))
+	}
+
+	native := makePortal("C1")
+	insertMessage(native, "1700000000.000100", "@slack_t1-u1:example.com")
+	assert.False(t, s.isLegacyMigrated(ctx, native))
+
+	// Portals migrated before the flag existed are detected from their messages and flagged
+	migrated := makePortal("C2")
+	insertMessage(migrated, "1700000000.000200", "")
+	assert.True(t, s.isLegacyMigrated(ctx, migrated))
+	saved, err := br.DB.Portal.GetByKey(ctx, migrated.PortalKey)
+	require.NoError(t, err)
+	assert.True(t, saved.Metadata.(*slackid.PortalMetadata).LegacyMigrated)
+}
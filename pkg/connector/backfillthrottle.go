@@ -0,0 +1,165 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// BackfillThrottle limits how fast queued (historical) backfills fetch messages from Slack,
+// so that large imports don't starve live bridging or hit Slack rate limits.
+// Forward backfills (catching up on missed messages) are never throttled.
+type BackfillThrottle struct {
+	sema       chan struct{}
+	perMessage time.Duration
+	window     *dailyWindow
+
+	lock sync.Mutex
+	next time.Time
+}
+
+// dailyWindow is a time of day range, which may wrap around midnight.
+type dailyWindow struct {
+	start, end time.Duration
+	location   *time.Location
+}
+
+func parseTimeOfDay(val string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", val)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+func newDailyWindow(cfg BackfillScheduleConfig) (*dailyWindow, error) {
+	if cfg.Start == "" && cfg.End == "" {
+		return nil, nil
+	}
+	start, err := parseTimeOfDay(cfg.Start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time: %w", err)
+	}
+	end, err := parseTimeOfDay(cfg.End)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end time: %w", err)
+	}
+	loc := time.Local
+	if cfg.Timezone != "" {
+		loc, err = time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	return &dailyWindow{start: start, end: end, location: loc}, nil
+}
+
+// untilOpen returns how long it takes until the window is open at the given time, or 0 if it's already open.
+func (dw *dailyWindow) untilOpen(now time.Time) time.Duration {
+	now = now.In(dw.location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, dw.location)
+	sinceMidnight := now.Sub(midnight)
+	var open bool
+	if dw.start <= dw.end {
+		open = sinceMidnight >= dw.start && sinceMidnight < dw.end
+	} else {
+		open = sinceMidnight >= dw.start || sinceMidnight < dw.end
+	}
+	if open {
+		return 0
+	}
+	nextStart := midnight.Add(dw.start)
+	if !nextStart.After(now) {
+		nextStart = midnight.AddDate(0, 0, 1).Add(dw.start)
+	}
+	return nextStart.Sub(now)
+}
+
+func NewBackfillThrottle(cfg BackfillConfig) (*BackfillThrottle, error) {
+	bt := &BackfillThrottle{}
+	if cfg.MaxConcurrency > 0 {
+		bt.sema = make(chan struct{}, cfg.MaxConcurrency)
+	}
+	if cfg.MessagesPerSecond > 0 {
+		bt.perMessage = time.Duration(float64(time.Second) / cfg.MessagesPerSecond)
+	}
+	var err error
+	bt.window, err = newDailyWindow(cfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	return bt, nil
+}
+
+// Acquire waits until a queued backfill request is allowed to run.
+// The returned function must be called with the number of fetched messages when the request is done.
+func (bt *BackfillThrottle) Acquire(ctx context.Context) (func(messageCount int), error) {
+	if bt == nil {
+		return func(int) {}, nil
+	}
+	if bt.window != nil {
+		if wait := bt.window.untilOpen(time.Now()); wait > 0 {
+			zerolog.Ctx(ctx).Debug().Stringer("wait", wait).Msg("Waiting for backfill schedule window to open")
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if bt.sema != nil {
+		select {
+		case bt.sema <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	releaseSlot := func() {
+		if bt.sema != nil {
+			<-bt.sema
+		}
+	}
+	// The rate is only checked after getting a slot, as other backfills may have pushed it forward while waiting
+	bt.lock.Lock()
+	wait := time.Until(bt.next)
+	bt.lock.Unlock()
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			releaseSlot()
+			return nil, ctx.Err()
+		}
+	}
+	return func(messageCount int) {
+		// Push the rate forward before releasing the slot, so the next backfill sees it
+		if bt.perMessage > 0 && messageCount > 0 {
+			bt.lock.Lock()
+			if now := time.Now(); bt.next.Before(now) {
+				bt.next = now
+			}
+			bt.next = bt.next.Add(time.Duration(messageCount) * bt.perMessage)
+			bt.lock.Unlock()
+		}
+		releaseSlot()
+	}, nil
+}
@@ -0,0 +1,81 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDailyWindow_UntilOpen(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 10, hour, minute, 0, 0, time.UTC)
+	}
+	type testCase struct {
+		name     string
+		start    string
+		end      string
+		now      time.Time
+		expected time.Duration
+	}
+	testCases := []testCase{
+		{"SameDayInside", "09:00", "17:00", at(12, 0), 0},
+		{"SameDayBefore", "09:00", "17:00", at(8, 30), 30 * time.Minute},
+		{"SameDayAfter", "09:00", "17:00", at(17, 0), 16 * time.Hour},
+		{"OvernightLateInside", "22:00", "06:00", at(23, 0), 0},
+		{"OvernightEarlyInside", "22:00", "06:00", at(5, 59), 0},
+		{"OvernightOutside", "22:00", "06:00", at(12, 0), 10 * time.Hour},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dw, err := newDailyWindow(BackfillScheduleConfig{Start: tc.start, End: tc.end, Timezone: "UTC"})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, dw.untilOpen(tc.now))
+		})
+	}
+}
+
+func TestBackfillThrottle_RateCheckedAfterSlot(t *testing.T) {
+	bt, err := NewBackfillThrottle(BackfillConfig{MaxConcurrency: 1, MessagesPerSecond: 100})
+	require.NoError(t, err)
+	ctx := context.Background()
+	done, err := bt.Acquire(ctx)
+	require.NoError(t, err)
+
+	acquired := make(chan time.Time, 1)
+	go func() {
+		secondDone, err := bt.Acquire(ctx)
+		if err == nil {
+			acquired <- time.Now()
+			secondDone(0)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	released := time.Now()
+	// 20 messages at 100 per second means the next backfill has to wait 200ms
+	done(20)
+	select {
+	case at := <-acquired:
+		assert.GreaterOrEqual(t, at.Sub(released), 150*time.Millisecond)
+	case <-time.After(2 * time.Second):
+		t.Fatal("second backfill didn't get a slot")
+	}
+}
@@ -0,0 +1,76 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var _ bridgev2.PortalBridgeInfoFillingNetwork = (*SlackConnector)(nil)
+
+// FillPortalBridgeInfo uses plain Slack team and channel IDs in the bridge info state events
+// and adds links to the workspace and channel, so that rooms can be mapped to Slack channels.
+func (s *SlackConnector) FillPortalBridgeInfo(portal *bridgev2.Portal, content *event.BridgeEventContent) {
+	teamID, channelID := slackid.ParsePortalID(portal.ID)
+	var teamURL string
+	if parent := portal.GetTopLevelParent(); parent != nil {
+		if teamDomain := parent.Metadata.(*slackid.PortalMetadata).TeamDomain; teamDomain != "" {
+			teamURL = fmt.Sprintf("https://%s.slack.com", teamDomain)
+		}
+	}
+	if content.Network == nil {
+		content.Network = &event.BridgeInfoSection{}
+	}
+	content.Network.ID = teamID
+	content.Network.ExternalURL = teamURL
+	if channelID == "" {
+		content.Channel.ID = teamID
+		content.Channel.ExternalURL = teamURL
+	} else {
+		content.Channel.ID = channelID
+		if teamURL != "" {
+			content.Channel.ExternalURL = fmt.Sprintf("%s/archives/%s", teamURL, channelID)
+		}
+	}
+}
+
+// updateChildBridgeInfo resends the bridge info of all portals in the given team space,
+// which is necessary when team-level information like the domain changes.
+func (s *SlackConnector) updateChildBridgeInfo(ctx context.Context, teamPortalKey networkid.PortalKey) {
+	log := zerolog.Ctx(ctx)
+	children, err := s.br.DB.Portal.GetChildren(ctx, teamPortalKey)
+	if err != nil {
+		log.Err(err).Msg("Failed to get child portals to update bridge info")
+		return
+	}
+	for _, dbPortal := range children {
+		portal, err := s.br.GetExistingPortalByKey(ctx, dbPortal.PortalKey)
+		if err != nil {
+			log.Err(err).Object("portal_key", dbPortal.PortalKey).Msg("Failed to get portal to update bridge info")
+		} else if portal != nil {
+			portal.UpdateBridgeInfo(ctx)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestFillPortalBridgeInfo(t *testing.T) {
+	teamPortal := &bridgev2.Portal{Portal: &database.Portal{
+		PortalKey: networkid.PortalKey{ID: slackid.MakeTeamPortalID("T1")},
+		RoomType:  database.RoomTypeSpace,
+		Metadata:  &slackid.PortalMetadata{TeamDomain: "example"},
+	}}
+	channelPortal := &bridgev2.Portal{
+		Portal: &database.Portal{PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", "C2")}},
+		Parent: teamPortal,
+	}
+	orphanPortal := &bridgev2.Portal{Portal: &database.Portal{PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", "C3")}}}
+	s := &SlackConnector{}
+
+	var content event.BridgeEventContent
+	s.FillPortalBridgeInfo(channelPortal, &content)
+	assert.Equal(t, "C2", content.Channel.ID)
+	assert.Equal(t, "https://example.slack.com/archives/C2", content.Channel.ExternalURL)
+	assert.Equal(t, "T1", content.Network.ID)
+	assert.Equal(t, "https://example.slack.com", content.Network.ExternalURL)
+
+	content = event.BridgeEventContent{}
+	s.FillPortalBridgeInfo(teamPortal, &content)
+	assert.Equal(t, "T1", content.Channel.ID)
+	assert.Equal(t, "https://example.slack.com", content.Channel.ExternalURL)
+
+	content = event.BridgeEventContent{}
+	s.FillPortalBridgeInfo(orphanPortal, &content)
+	assert.Equal(t, "C3", content.Channel.ID)
+	assert.Empty(t, content.Channel.ExternalURL)
+	assert.Equal(t, "T1", content.Network.ID)
+}
@@ -39,7 +39,7 @@ func (s *SlackConnector) GetCapabilities() *bridgev2.NetworkGeneralCapabilities
 }
 
 func (s *SlackConnector) GetBridgeInfoVersion() (info, caps int) {
-	return 1, 1
+	return 2, 1
 }
 
 func supportedIfFFmpeg() event.CapabilitySupportLevel {
@@ -50,7 +50,7 @@ func supportedIfFFmpeg() event.CapabilitySupportLevel {
 }
 
 func capID() string {
-	base := "fi.mau.slack.capabilities.2025_01_10"
+	base := "fi.mau.slack.capabilities.2026_10_16"
 	if ffmpeg.Supported() {
 		return base + "+ffmpeg"
 	}
@@ -130,6 +130,16 @@ var roomCaps = &event.RoomFeatures{
 			MaxCaptionLength: MaxTextLength,
 			MaxSize:          MaxFileSize,
 		},
+		event.CapMsgSticker: {
+			MimeTypes: map[string]event.CapabilitySupportLevel{
+				"image/jpeg": event.CapLevelFullySupported,
+				"image/png":  event.CapLevelFullySupported,
+				"image/gif":  event.CapLevelFullySupported,
+				"image/webp": event.CapLevelFullySupported,
+			},
+			Caption: event.CapLevelDropped,
+			MaxSize: MaxFileSize,
+		},
 		event.CapMsgVoice: {
 			MimeTypes: map[string]event.CapabilitySupportLevel{
 				"audio/ogg":               supportedIfFFmpeg(),
@@ -150,7 +160,22 @@ var roomCaps = &event.RoomFeatures{
 	Reaction:        event.CapLevelFullySupported,
 }
 
+// readOnlyRoomCaps are the capabilities of rooms bridged by mirror logins, which don't accept anything from Matrix.
+var readOnlyRoomCaps = &event.RoomFeatures{
+	ID:              capID() + "+read_only",
+	LocationMessage: event.CapLevelRejected,
+	Poll:            event.CapLevelRejected,
+	Thread:          event.CapLevelRejected,
+	Reply:           event.CapLevelRejected,
+	Edit:            event.CapLevelRejected,
+	Delete:          event.CapLevelRejected,
+	Reaction:        event.CapLevelRejected,
+}
+
 func (s *SlackClient) GetCapabilities(ctx context.Context, portal *bridgev2.Portal) *event.RoomFeatures {
+	if s.isReadOnly() {
+		return readOnlyRoomCaps
+	}
 	meta := &slackid.PortalMetadata{}
 	topLevel := portal.GetTopLevelParent()
 	if topLevel != nil {
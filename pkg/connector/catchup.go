@@ -0,0 +1,237 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+const (
+	// ReconnectCatchupLookback is how recently a channel must have had a bridged event
+	// for it to be caught up on from its own cursor after a reconnect.
+	ReconnectCatchupLookback = 24 * time.Hour
+	// MaxReconnectCatchupChannels limits the number of channels caught up on after a single reconnect.
+	MaxReconnectCatchupChannels = 50
+	// reconnectCatchupMargin is subtracted from the time the connection was lost, in case the last events
+	// before it were received out of order. Messages that are already bridged are skipped anyway.
+	reconnectCatchupMargin = 1 * time.Minute
+)
+
+// liveCursors returns the timestamps of the last handled event in each channel.
+func (ect *eventCursorTracker) liveCursors() map[string]string {
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+	return maps.Clone(ect.live)
+}
+
+// isRTMReconnect returns true if the connected event is for a reconnection rather than the first connection.
+// slack-go counts connections starting from zero.
+func isRTMReconnect(evt *slack.ConnectedEvent) bool {
+	return evt.ConnectionCount > 0
+}
+
+func unixMilliOrZero(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+type catchupTarget struct {
+	ChannelID string
+	Oldest    string
+}
+
+// reconnectCatchupChannels returns the channels to check for missed events after a reconnect and the timestamp
+// to check from. Recently active channels come first, most recently active first, starting from their cursor.
+// They're followed by the other channels from the startup sync (e.g. quiet channels or ones that were created
+// after the startup sync), which are checked starting from the time the connection was lost.
+// Channels that are neither recently active nor part of the startup sync aren't caught up on, and neither are
+// the channels that don't fit in MaxReconnectCatchupChannels.
+func reconnectCatchupChannels(cursors map[string]string, syncedChannels []string, lostAt, now time.Time) []catchupTarget {
+	cutoff := formatSlackTimestamp(now.Add(-ReconnectCatchupLookback))
+	var channelIDs []string
+	for channelID, ts := range cursors {
+		if ts >= cutoff {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	slices.SortFunc(channelIDs, func(a, b string) int {
+		return cmp.Or(cmp.Compare(cursors[b], cursors[a]), cmp.Compare(a, b))
+	})
+	targets := make([]catchupTarget, 0, min(len(channelIDs)+len(syncedChannels), MaxReconnectCatchupChannels))
+	for _, channelID := range channelIDs {
+		targets = append(targets, catchupTarget{ChannelID: channelID, Oldest: cursors[channelID]})
+	}
+	if !lostAt.IsZero() {
+		since := formatSlackTimestamp(lostAt.Add(-reconnectCatchupMargin))
+		for _, channelID := range syncedChannels {
+			if cursors[channelID] >= cutoff {
+				continue
+			}
+			targets = append(targets, catchupTarget{ChannelID: channelID, Oldest: max(cursors[channelID], since)})
+		}
+	}
+	if len(targets) > MaxReconnectCatchupChannels {
+		targets = targets[:MaxReconnectCatchupChannels]
+	}
+	return targets
+}
+
+// setSyncedChannels remembers the channels found during the startup sync for catching up after reconnects.
+func (s *SlackClient) setSyncedChannels(channelIDs []string) {
+	s.syncedChannelsLock.Lock()
+	s.syncedChannels = channelIDs
+	s.syncedChannelsLock.Unlock()
+}
+
+func (s *SlackClient) getSyncedChannels() []string {
+	s.syncedChannelsLock.Lock()
+	defer s.syncedChannelsLock.Unlock()
+	return s.syncedChannels
+}
+
+// catchUpAfterReconnect queues events that were missed while the realtime connection was down.
+// lostAt is the time of the last event received before the connection was lost.
+//
+// Slack doesn't replay events over a new RTM or socket mode connection, so the history of recently active
+// channels is fetched starting from the last handled event. Messages that aren't bridged yet are queued as
+// new messages, and bridged ones get their edits and reactions synced. Edits and reactions of messages older
+// than the last handled event can't be detected this way.
+func (s *SlackClient) catchUpAfterReconnect(ctx context.Context, lostAt time.Time) {
+	log := zerolog.Ctx(ctx).With().Str("action", "catch up after reconnect").Logger()
+	ctx = log.WithContext(ctx)
+	targets := reconnectCatchupChannels(s.eventCursors.liveCursors(), s.getSyncedChannels(), lostAt, time.Now())
+	log.Debug().Int("channel_count", len(targets)).Time("lost_at", lostAt).Msg("Checking channels for missed events")
+	for _, target := range targets {
+		portalKey, err := s.Main.br.FindPortalReceiver(ctx, slackid.MakePortalID(s.TeamID, target.ChannelID), s.UserLogin.ID)
+		if err != nil {
+			log.Err(err).Str("channel_id", target.ChannelID).Msg("Failed to find portal receiver")
+			continue
+		} else if portalKey.IsEmpty() {
+			continue
+		}
+		queued, err := s.catchUpChannel(ctx, portalKey, target.ChannelID, target.Oldest)
+		if err != nil {
+			log.Err(err).Str("channel_id", target.ChannelID).Msg("Failed to catch up on missed events")
+		} else if queued > 0 {
+			log.Debug().Str("channel_id", target.ChannelID).Int("queued_count", queued).Msg("Queued missed events")
+		}
+	}
+}
+
+func (s *SlackClient) catchUpChannel(ctx context.Context, portalKey networkid.PortalKey, channelID, oldest string) (int, error) {
+	var messages []slack.Message
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Oldest:    oldest,
+		Limit:     200,
+	}
+	for len(messages) < MaxHistoryRangeMessages {
+		chunk, err := s.Client.GetConversationHistoryContext(ctx, params)
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, chunk.Messages...)
+		if !chunk.HasMore || chunk.ResponseMetadata.Cursor == "" {
+			break
+		}
+		params.Cursor = chunk.ResponseMetadata.Cursor
+	}
+	// Slack returns the newest messages first
+	slices.Reverse(messages)
+	queued := 0
+	for _, msg := range messages {
+		if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
+			continue
+		}
+		msgID := slackid.MakeMessageID(s.TeamID, channelID, msg.Timestamp)
+		existing, err := s.Main.br.DB.Message.GetLastPartByID(ctx, portalKey.Receiver, msgID)
+		if err != nil {
+			return queued, err
+		} else if existing == nil {
+			s.queueHistoryMessage(portalKey, channelID, &msg.Msg)
+			queued++
+		} else if msg.Edited != nil && msg.Edited.Timestamp > existing.Metadata.(*slackid.MessageMetadata).LastEditTS {
+			s.queueSlackEdit(portalKey, channelID, &msg.Msg)
+			queued++
+		}
+		if len(msg.Reactions) > 0 || existing != nil {
+			s.queueReactionSync(ctx, portalKey, channelID, &msg.Msg)
+		}
+		if msg.LatestReply > oldest {
+			n, err := s.replayThread(ctx, portalKey, channelID, msg.Timestamp, oldest)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Str("thread_ts", msg.Timestamp).Msg("Failed to fetch missed thread replies")
+			}
+			queued += n
+		}
+	}
+	return queued, nil
+}
+
+// queueReactionSync queues an event that replaces the reactions of a bridged message with the ones on Slack.
+func (s *SlackClient) queueReactionSync(ctx context.Context, portalKey networkid.PortalKey, channelID string, msg *slack.Msg) {
+	data := &bridgev2.ReactionSyncData{
+		Users:       make(map[networkid.UserID]*bridgev2.ReactionSyncUser),
+		HasAllUsers: true,
+	}
+	for _, reaction := range s.convertReactions(ctx, channelID, msg) {
+		user, ok := data.Users[reaction.Sender.Sender]
+		if !ok {
+			user = &bridgev2.ReactionSyncUser{HasAllReactions: true}
+			data.Users[reaction.Sender.Sender] = user
+		}
+		user.Reactions = append(user.Reactions, reaction)
+	}
+	s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackReactionSync{
+		SlackEventMeta: &SlackEventMeta{
+			Type:         bridgev2.RemoteEventReactionSync,
+			PortalKey:    portalKey,
+			RawTimestamp: msg.Timestamp,
+		},
+		TargetID:  slackid.MakeMessageID(s.TeamID, channelID, msg.Timestamp),
+		Reactions: data,
+	})
+}
+
+type SlackReactionSync struct {
+	*SlackEventMeta
+	TargetID  networkid.MessageID
+	Reactions *bridgev2.ReactionSyncData
+}
+
+var _ bridgev2.RemoteReactionSync = (*SlackReactionSync)(nil)
+
+func (s *SlackReactionSync) GetTargetMessage() networkid.MessageID {
+	return s.TargetID
+}
+
+func (s *SlackReactionSync) GetReactions() *bridgev2.ReactionSyncData {
+	return s.Reactions
+}
@@ -0,0 +1,84 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectCatchupChannels(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := func(ago time.Duration) string {
+		return formatSlackTimestamp(now.Add(-ago))
+	}
+	cursors := map[string]string{
+		"C1": ts(time.Hour),
+		"C2": ts(time.Minute),
+		"C3": ts(48 * time.Hour),
+		"C4": ts(time.Hour),
+	}
+	channels := reconnectCatchupChannels(cursors, nil, time.Time{}, now)
+	assert.Equal(t, []catchupTarget{
+		{"C2", ts(time.Minute)},
+		{"C1", ts(time.Hour)},
+		{"C4", ts(time.Hour)},
+	}, channels)
+
+	// Quiet channels from the startup sync are checked from when the connection was lost
+	lostAt := now.Add(-10 * time.Minute)
+	channels = reconnectCatchupChannels(cursors, []string{"C5", "C2", "C3"}, lostAt, now)
+	since := formatSlackTimestamp(lostAt.Add(-reconnectCatchupMargin))
+	assert.Equal(t, []catchupTarget{
+		{"C2", ts(time.Minute)},
+		{"C1", ts(time.Hour)},
+		{"C4", ts(time.Hour)},
+		{"C5", since},
+		{"C3", since},
+	}, channels)
+}
+
+func TestReconnectCatchupChannels_Limit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cursors := make(map[string]string)
+	for i := range MaxReconnectCatchupChannels + 10 {
+		cursors[fmt.Sprintf("C%03d", i)] = formatSlackTimestamp(now.Add(-time.Duration(i) * time.Second))
+	}
+	channels := reconnectCatchupChannels(cursors, []string{"C999"}, now, now)
+	assert.Len(t, channels, MaxReconnectCatchupChannels)
+	assert.Equal(t, "C000", channels[0].ChannelID)
+}
+
+func TestIsRTMReconnect(t *testing.T) {
+	assert.False(t, isRTMReconnect(&slack.ConnectedEvent{ConnectionCount: 0}), "first connection")
+	assert.True(t, isRTMReconnect(&slack.ConnectedEvent{ConnectionCount: 1}), "first reconnect")
+	assert.True(t, isRTMReconnect(&slack.ConnectedEvent{ConnectionCount: 5}))
+}
+
+func TestEventCursorTracker_LiveCursors(t *testing.T) {
+	var ect eventCursorTracker
+	ect.reset(map[string]string{"C1": "1700000000.000100"}, time.Now())
+	ect.record("C2", "1700000000.000200")
+	cursors := ect.liveCursors()
+	assert.Equal(t, map[string]string{"C1": "1700000000.000100", "C2": "1700000000.000200"}, cursors)
+	cursors["C3"] = "1700000000.000300"
+	assert.NotContains(t, ect.liveCursors(), "C3")
+}
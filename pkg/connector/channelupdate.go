@@ -0,0 +1,83 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+// ChannelUpdateCoalesceDelay is how long to wait for more channel_update events of the same channel
+// before fetching the channel info, so that a burst of updates only causes a single resync.
+const ChannelUpdateCoalesceDelay = 2 * time.Second
+
+// queueChannelUpdate schedules a chat info resync for the channel of the event after ChannelUpdateCoalesceDelay.
+// The resync runs in a timer goroutine, so it recovers from panics like goWithRecover.
+func (s *SlackClient) queueChannelUpdate(evt *slack.ChannelUpdateEvent) {
+	s.coalesceChannelUpdate(evt.Channel, ChannelUpdateCoalesceDelay, func() {
+		log := s.UserLogin.Log.With().Str("action", "channel update").Str("channel_id", evt.Channel).Logger()
+		ctx := log.WithContext(context.Background())
+		defer s.recoverEventPanic(ctx, evt)
+		s.flushChannelUpdate(ctx, evt.Channel)
+	})
+}
+
+// coalesceChannelUpdate calls fn once delay has passed without further updates for the same channel.
+func (s *SlackClient) coalesceChannelUpdate(channelID string, delay time.Duration, fn func()) {
+	s.pendingChannelUpdatesLock.Lock()
+	defer s.pendingChannelUpdatesLock.Unlock()
+	if timer, ok := s.pendingChannelUpdates[channelID]; ok {
+		timer.Reset(delay)
+		return
+	} else if s.pendingChannelUpdates == nil {
+		s.pendingChannelUpdates = make(map[string]*time.Timer)
+	}
+	s.pendingChannelUpdates[channelID] = time.AfterFunc(delay, func() {
+		s.pendingChannelUpdatesLock.Lock()
+		delete(s.pendingChannelUpdates, channelID)
+		s.pendingChannelUpdatesLock.Unlock()
+		fn()
+	})
+}
+
+// stopChannelUpdates cancels all pending channel update resyncs.
+func (s *SlackClient) stopChannelUpdates() {
+	s.pendingChannelUpdatesLock.Lock()
+	defer s.pendingChannelUpdatesLock.Unlock()
+	for channelID, timer := range s.pendingChannelUpdates {
+		timer.Stop()
+		delete(s.pendingChannelUpdates, channelID)
+	}
+}
+
+func (s *SlackClient) flushChannelUpdate(ctx context.Context, channelID string) {
+	if !s.IsLoggedIn() {
+		return
+	}
+	s.invalidateChatInfoCache(channelID)
+	meta, err := s.makeEventMeta(ctx, channelID, nil, "", "")
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to prepare resync after channel update")
+		return
+	}
+	meta.Type = bridgev2.RemoteEventChatResync
+	s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackChatResync{SlackEventMeta: &meta, Client: s, ShouldSyncInfo: true})
+}
@@ -0,0 +1,59 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesceChannelUpdate(t *testing.T) {
+	s := newTestSlackClient(nil)
+	var calls atomic.Int32
+	done := make(chan struct{}, 2)
+	fn := func() {
+		calls.Add(1)
+		done <- struct{}{}
+	}
+	for range 5 {
+		s.coalesceChannelUpdate("C1", 50*time.Millisecond, fn)
+	}
+	s.coalesceChannelUpdate("C2", 50*time.Millisecond, fn)
+	for range 2 {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for coalesced update")
+		}
+	}
+	assert.EqualValues(t, 2, calls.Load(), "each channel should be flushed exactly once")
+	s.pendingChannelUpdatesLock.Lock()
+	assert.Empty(t, s.pendingChannelUpdates)
+	s.pendingChannelUpdatesLock.Unlock()
+}
+
+func TestStopChannelUpdates(t *testing.T) {
+	s := newTestSlackClient(nil)
+	var calls atomic.Int32
+	s.coalesceChannelUpdate("C1", 20*time.Millisecond, func() { calls.Add(1) })
+	s.stopChannelUpdates()
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, calls.Load())
+}
@@ -18,11 +18,13 @@ package connector
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"maps"
 	"net/url"
 	"slices"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -62,9 +64,25 @@ func (s *SlackClient) fetchChatInfoWithCache(ctx context.Context, channelID stri
 	return info, nil
 }
 
-func (s *SlackClient) fetchChannelMembers(ctx context.Context, channelID string, limit int) (output map[networkid.UserID]bridgev2.ChatMember) {
+// invalidateChatInfoCache removes the cached info of the given channel, so that the next fetch gets fresh data.
+func (s *SlackClient) invalidateChatInfoCache(channelID string) {
+	s.chatInfoCacheLock.Lock()
+	delete(s.chatInfoCache, channelID)
+	s.chatInfoCacheLock.Unlock()
+}
+
+func (s *SlackClient) fetchChannelMembers(ctx context.Context, channelID string, limit int) map[networkid.UserID]bridgev2.ChatMember {
+	memberIDs := s.fetchChannelMemberIDs(ctx, channelID, limit)
+	output := make(map[networkid.UserID]bridgev2.ChatMember, len(memberIDs))
+	for _, member := range memberIDs {
+		evtSender := s.makeEventSender(member)
+		output[evtSender.Sender] = bridgev2.ChatMember{EventSender: evtSender}
+	}
+	return output
+}
+
+func (s *SlackClient) fetchChannelMemberIDs(ctx context.Context, channelID string, limit int) (output []string) {
 	var cursor string
-	output = make(map[networkid.UserID]bridgev2.ChatMember)
 	for limit > 0 {
 		chunkLimit := limit
 		if chunkLimit > 200 {
@@ -79,10 +97,7 @@ func (s *SlackClient) fetchChannelMembers(ctx context.Context, channelID string,
 			zerolog.Ctx(ctx).Err(err).Msg("Failed to get channel members")
 			break
 		}
-		for _, member := range membersChunk {
-			evtSender := s.makeEventSender(member)
-			output[evtSender.Sender] = bridgev2.ChatMember{EventSender: evtSender}
-		}
+		output = append(output, membersChunk...)
 		cursor = nextCursor
 		limit -= len(membersChunk)
 		if nextCursor == "" || len(membersChunk) < chunkLimit {
@@ -147,7 +162,7 @@ func (s *SlackClient) generateMemberList(ctx context.Context, info *slack.Channe
 			},
 		}
 	}
-	members.MemberMap = s.fetchChannelMembers(ctx, info.ID, s.Main.Config.ParticipantSyncCount)
+	members.MemberMap = s.fetchChannelMembers(ctx, info.ID, s.Main.currentConfig().ParticipantSyncCount)
 	if _, hasSelf := members.MemberMap[selfUserID]; !hasSelf && info.IsMember {
 		members.MemberMap[selfUserID] = bridgev2.ChatMember{EventSender: s.makeEventSender(s.UserID)}
 	}
@@ -155,16 +170,53 @@ func (s *SlackClient) generateMemberList(ctx context.Context, info *slack.Channe
 	return
 }
 
-func (s *SlackClient) wrapChatInfo(ctx context.Context, info *slack.Channel, isNew bool) (*bridgev2.ChatInfo, error) {
+// maxGroupDMMembers is the maximum number of members in a Slack group DM (including the user themselves).
+const maxGroupDMMembers = 9
+
+// MinFullMemberSyncInterval is the minimum time between fetching the full member list of a channel during resyncs.
+const MinFullMemberSyncInterval = 6 * time.Hour
+
+func getChannelType(info *slack.Channel) string {
+	switch {
+	case info.IsIM:
+		return "im"
+	case info.IsMpIM:
+		return "mpim"
+	case info.IsPrivate || info.IsGroup:
+		return "private_channel"
+	default:
+		return "public_channel"
+	}
+}
+
+func (s *SlackClient) shouldFetchMemberList(info *slack.Channel, portal *bridgev2.Portal) bool {
+	if portal == nil || portal.MXID == "" {
+		return true
+	} else if s.Main.currentConfig().ParticipantSyncOnlyOnCreate || info.IsArchived {
+		return false
+	}
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	return time.Since(meta.MembersSyncedAt.Time) > MinFullMemberSyncInterval
+}
+
+// wrapChatInfo converts Slack channel info into a bridgev2 ChatInfo.
+// The portal is nil when the info is for a chat that doesn't exist in the database yet.
+func (s *SlackClient) wrapChatInfo(ctx context.Context, info *slack.Channel, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
+	isNew := portal == nil || portal.MXID == ""
 	var members bridgev2.ChatMemberList
 	var avatar *bridgev2.Avatar
 	var roomType database.RoomType
 	var err error
-	var extraUpdates func(ctx context.Context, portal *bridgev2.Portal) bool
 	var userLocal *bridgev2.UserLocalPortalInfo
+	var fetchedMembers bool
 	switch {
 	case info.IsMpIM:
 		roomType = database.RoomTypeGroupDM
+		if len(info.Members) == 0 {
+			// The conversation list and boot responses don't include group DM members,
+			// without fetching them the room would be created with only the bridge bot.
+			info.Members = s.fetchChannelMemberIDs(ctx, info.ID, maxGroupDMMembers)
+		}
 		members.IsFull = true
 		members.MemberMap = make(map[networkid.UserID]bridgev2.ChatMember, len(info.Members))
 		for _, member := range info.Members {
@@ -192,8 +244,9 @@ func (s *SlackClient) wrapChatInfo(ctx context.Context, info *slack.Channel, isN
 		ghost.UpdateInfoIfNecessary(ctx, s.UserLogin, bridgev2.RemoteEventUnknown)
 		info.Name = ghost.Name
 	case info.Name != "":
-		members = s.generateMemberList(ctx, info, !s.Main.Config.ParticipantSyncOnlyOnCreate || isNew)
-		if isNew && s.Main.Config.MuteChannelsByDefault {
+		fetchedMembers = s.shouldFetchMemberList(info, portal)
+		members = s.generateMemberList(ctx, info, fetchedMembers)
+		if isNew && s.Main.currentConfig().MuteChannelsByDefault {
 			userLocal = &bridgev2.UserLocalPortalInfo{
 				MutedUntil: &event.MutedForever,
 			}
@@ -201,7 +254,13 @@ func (s *SlackClient) wrapChatInfo(ctx context.Context, info *slack.Channel, isN
 	default:
 		return nil, fmt.Errorf("unrecognized channel type")
 	}
-	if s.Main.Config.WorkspaceAvatarInRooms && (roomType == database.RoomTypeDefault || roomType == database.RoomTypeGroupDM) {
+	if tag := s.getFavouriteTag(info.ID); tag != nil {
+		if userLocal == nil {
+			userLocal = &bridgev2.UserLocalPortalInfo{}
+		}
+		userLocal.Tag = tag
+	}
+	if s.Main.currentConfig().WorkspaceAvatarInRooms && (roomType == database.RoomTypeDefault || roomType == database.RoomTypeGroupDM) {
 		avatar = &bridgev2.Avatar{
 			ID:     s.TeamPortal.AvatarID,
 			Remove: s.TeamPortal.AvatarID == "",
@@ -210,41 +269,142 @@ func (s *SlackClient) wrapChatInfo(ctx context.Context, info *slack.Channel, isN
 		}
 	}
 	members.TotalMemberCount = info.NumMembers
+	if isNew {
+		members.PowerLevels = s.Main.currentConfig().PowerLevels.Overrides()
+	}
 	var name *string
 	if roomType != database.RoomTypeDM || len(members.MemberMap) == 1 {
-		name = ptr.Ptr(s.Main.Config.FormatChannelName(&ChannelNameParams{
+		name = ptr.Ptr(s.Main.currentConfig().FormatChannelName(&ChannelNameParams{
 			Channel:      info,
-			Team:         &s.BootResp.Team.TeamInfo,
+			Team:         s.teamInfo(),
 			IsNoteToSelf: info.IsIM && info.User == s.UserID,
 		}))
 	}
-	return &bridgev2.ChatInfo{
-		Name:         name,
-		Topic:        ptr.Ptr(info.Topic.Value),
-		Avatar:       avatar,
-		Members:      &members,
-		Type:         &roomType,
-		ParentID:     ptr.Ptr(slackid.MakeTeamPortalID(s.TeamID)),
-		ExtraUpdates: extraUpdates,
-		UserLocal:    userLocal,
-		CanBackfill:  true,
-	}, nil
+	wrapped := &bridgev2.ChatInfo{
+		Name:        name,
+		Topic:       ptr.Ptr(info.Topic.Value),
+		Avatar:      avatar,
+		Members:     &members,
+		Type:        &roomType,
+		ParentID:    ptr.Ptr(slackid.MakeTeamPortalID(s.TeamID)),
+		UserLocal:   userLocal,
+		CanBackfill: true,
+	}
+	channelType := getChannelType(info)
+	isPrivate := info.IsPrivate || info.IsGroup || info.IsIM || info.IsMpIM
+	isShared := info.IsShared || info.IsExtShared || info.IsOrgShared
+	if roomType == database.RoomTypeDefault && (isNew || portal.Metadata.(*slackid.PortalMetadata).IsPrivate != isPrivate) {
+		wrapped.JoinRule = s.channelJoinRule(isPrivate)
+	}
+	infoHash := hashChatInfo(wrapped)
+	wrapped.ExtraUpdates = func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
+		meta := portal.Metadata.(*slackid.PortalMetadata)
+		if meta.InfoHash != infoHash {
+			meta.InfoHash = infoHash
+			changed = true
+		}
+		if portal.MXID == "" && !meta.EncryptionPending && s.Main.shouldEncryptNewPortal(isPrivate) {
+			meta.EncryptionPending = true
+			changed = true
+		} else if meta.EncryptionPending {
+			s.applyPendingEncryption(ctx, portal)
+		}
+		if meta.ChannelType != "" && portal.MXID != "" {
+			notice := describeVisibilityChange(meta.IsPrivate, isPrivate, meta.IsShared, isShared)
+			if notice != "" {
+				becameRestricted := (isPrivate && !meta.IsPrivate) || (isShared && !meta.IsShared)
+				s.handleVisibilityChange(ctx, portal, notice, becameRestricted)
+			}
+		}
+		if meta.ChannelType != channelType || meta.IsPrivate != isPrivate || meta.IsShared != isShared || meta.IsArchived != info.IsArchived {
+			meta.ChannelType = channelType
+			meta.IsPrivate = isPrivate
+			meta.IsShared = isShared
+			meta.IsArchived = info.IsArchived
+			changed = true
+		}
+		meta.InfoSyncedAt = jsontime.UnixNow()
+		if fetchedMembers {
+			meta.MembersSyncedAt = meta.InfoSyncedAt
+		}
+		if !changed {
+			// Sync timestamps alone shouldn't cause a bridge info update, so just save the portal directly
+			err := portal.Save(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Msg("Failed to save portal after updating sync timestamps")
+			}
+		}
+		return
+	}
+	return wrapped, nil
+}
+
+// needsInfoRefresh returns true if the metadata of the given existing portal should be refreshed during a resync.
+func (s *SlackClient) needsInfoRefresh(portal *bridgev2.Portal) bool {
+	if portal.MXID == "" || s.Main.currentConfig().MetadataRefreshInterval <= 0 {
+		return true
+	}
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	return time.Since(meta.InfoSyncedAt.Time) > s.Main.currentConfig().MetadataRefreshInterval
+}
+
+// hashChatInfo returns a hash of the parts of the chat info that are reflected in room state.
+func hashChatInfo(info *bridgev2.ChatInfo) string {
+	hasher := sha256.New()
+	_, _ = fmt.Fprintf(hasher, "%s\x00%s\x00%s\x00", ptr.Val(info.Name), ptr.Val(info.Topic), ptr.Val(info.Type))
+	if info.Avatar != nil {
+		_, _ = fmt.Fprintf(hasher, "%s\x00%t\x00", info.Avatar.ID, info.Avatar.Remove)
+	}
+	if info.Members != nil {
+		_, _ = fmt.Fprintf(hasher, "%d\x00%t", info.Members.TotalMemberCount, info.Members.IsFull)
+		for _, userID := range slices.Sorted(maps.Keys(info.Members.MemberMap)) {
+			_, _ = fmt.Fprintf(hasher, "\x00%s=%s", userID, info.Members.MemberMap[userID].Membership)
+		}
+		_, _ = hasher.Write([]byte{0})
+	}
+	if info.UserLocal != nil && ptr.Val(info.UserLocal.Tag) != "" {
+		_, _ = fmt.Fprintf(hasher, "\x00%s", *info.UserLocal.Tag)
+	}
+	if info.JoinRule != nil {
+		// Join rules are only included when the visibility of the channel changed
+		_, _ = fmt.Fprintf(hasher, "\x00%s", info.JoinRule.JoinRule)
+		for _, allow := range info.JoinRule.Allow {
+			_, _ = fmt.Fprintf(hasher, "\x00%s", allow.RoomID)
+		}
+	}
+	return base64.RawStdEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+// skipUnchangedChatInfo removes the room state parts of the given info if they're identical to what was last
+// applied to the portal, so that periodic resyncs don't cause any Matrix state updates. The member list and
+// extra updates are always kept: the periodic member sync repairs memberships that drifted on the Matrix side,
+// and the extra updates store the portal metadata that isn't covered by the hash.
+func skipUnchangedChatInfo(portal *bridgev2.Portal, info *bridgev2.ChatInfo) *bridgev2.ChatInfo {
+	if info == nil || portal.MXID == "" {
+		return info
+	}
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	if meta.InfoHash != "" && meta.InfoHash == hashChatInfo(info) {
+		return &bridgev2.ChatInfo{Members: info.Members, ExtraUpdates: info.ExtraUpdates}
+	}
+	return info
 }
 
-func (s *SlackClient) fetchChatInfo(ctx context.Context, channelID string, isNew bool) (*bridgev2.ChatInfo, error) {
+func (s *SlackClient) fetchChatInfo(ctx context.Context, channelID string, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
 	info, err := s.fetchChatInfoWithCache(ctx, channelID)
 	if err != nil {
 		return nil, err
-	} else if isNew && info.IsChannel && !info.IsMember {
+	} else if portal.MXID == "" && info.IsChannel && !info.IsMember {
 		return nil, fmt.Errorf("request cancelled due to user not being in channel")
 	}
-	return s.wrapChatInfo(ctx, info, isNew)
+	return s.wrapChatInfo(ctx, info, portal)
 }
 
 func (s *SlackClient) getTeamInfo() *bridgev2.ChatInfo {
-	name := s.Main.Config.FormatTeamName(&s.BootResp.Team.TeamInfo)
-	avatarURL, _ := s.BootResp.Team.Icon["image_230"].(string)
-	if s.BootResp.Team.Icon["image_default"] == true {
+	team := s.teamInfo()
+	name := s.Main.currentConfig().FormatTeamName(team)
+	avatarURL, _ := team.Icon["image_230"].(string)
+	if team.Icon["image_default"] == true {
 		avatarURL = ""
 	}
 	selfEvtSender := s.makeEventSender(s.UserID)
@@ -261,9 +421,14 @@ func (s *SlackClient) getTeamInfo() *bridgev2.ChatInfo {
 		Type: ptr.Ptr(database.RoomTypeSpace),
 		ExtraUpdates: func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
 			meta := portal.Metadata.(*slackid.PortalMetadata)
-			if meta.TeamDomain != s.BootResp.Team.Domain {
-				meta.TeamDomain = s.BootResp.Team.Domain
+			if meta.TeamDomain != team.Domain {
+				hadDomain := meta.TeamDomain != ""
+				meta.TeamDomain = team.Domain
 				changed = true
+				if hadDomain {
+					// The links in the bridge info of channel portals contain the domain too
+					go s.Main.updateChildBridgeInfo(context.WithoutCancel(ctx), portal.PortalKey)
+				}
 			}
 			prefs := s.BootResp.Team.Prefs
 			if prefs.MsgEditWindowMins != nil && (meta.EditMaxAge == nil || *meta.EditMaxAge != *prefs.MsgEditWindowMins) {
@@ -286,7 +451,7 @@ func (s *SlackClient) GetChatInfo(ctx context.Context, portal *bridgev2.Portal)
 	} else if channelID == "" {
 		return s.getTeamInfo(), nil
 	} else {
-		return s.fetchChatInfo(ctx, channelID, portal.MXID == "")
+		return s.fetchChatInfo(ctx, channelID, portal)
 	}
 }
 
@@ -308,11 +473,12 @@ func (s *SlackClient) wrapUserInfo(userID string, info *slack.User, botInfo *sla
 	var name *string
 	var avatar *bridgev2.Avatar
 	var extraUpdateAvatarID networkid.AvatarID
-	isBot := userID == "USLACKBOT"
+	var avatarHash string
+	isBot := userID == SlackbotUserID
 	if info != nil {
-		name = ptr.Ptr(s.Main.Config.FormatDisplayname(&DisplaynameParams{
+		name = ptr.Ptr(s.Main.currentConfig().FormatDisplayname(&DisplaynameParams{
 			User: info,
-			Team: &s.BootResp.Team.TeamInfo,
+			Team: s.teamInfo(),
 		}))
 		avatarURL := info.Profile.ImageOriginal
 		if avatarURL == "" && info.Profile.Image512 != "" {
@@ -326,15 +492,18 @@ func (s *SlackClient) wrapUserInfo(userID string, info *slack.User, botInfo *sla
 			}).String()
 		}
 		avatar = makeAvatar(avatarURL, info.Profile.AvatarHash)
+		avatarHash = info.Profile.AvatarHash
 		// Optimization to avoid updating legacy avatars
 		oldAvatarID := string(ghost.AvatarID)
 		if strings.HasPrefix(oldAvatarID, "https://") && (oldAvatarID == avatarURL || strings.Contains(oldAvatarID, info.Profile.AvatarHash)) {
 			extraUpdateAvatarID = avatar.ID
 			avatar = nil
+		} else if avatarHash != "" && ghost.AvatarSet && ghost.Metadata.(*slackid.GhostMetadata).AvatarHash == avatarHash {
+			avatar = nil
 		}
 		isBot = isBot || info.IsBot || info.IsAppUser
 	} else if botInfo != nil {
-		name = ptr.Ptr(s.Main.Config.FormatBotDisplayname(botInfo, &s.BootResp.Team.TeamInfo))
+		name = ptr.Ptr(s.Main.currentConfig().FormatBotDisplayname(botInfo, s.teamInfo()))
 		avatar = makeAvatar(botInfo.Icons.Image72, botInfo.Icons.Image72)
 		isBot = true
 	}
@@ -346,10 +515,18 @@ func (s *SlackClient) wrapUserInfo(userID string, info *slack.User, botInfo *sla
 		ExtraUpdates: func(ctx context.Context, ghost *bridgev2.Ghost) bool {
 			meta := ghost.Metadata.(*slackid.GhostMetadata)
 			meta.LastSync = jsontime.UnixNow()
+			var updatedTS int64
 			if info != nil {
-				meta.SlackUpdatedTS = int64(info.Updated)
+				updatedTS = int64(info.Updated)
 			} else if botInfo != nil {
-				meta.SlackUpdatedTS = int64(botInfo.Updated)
+				updatedTS = int64(botInfo.Updated)
+			}
+			if meta.SlackUpdatedTS != updatedTS {
+				meta.SlackUpdatedTS = updatedTS
+				meta.ProfileVersion++
+			}
+			if avatarHash != "" {
+				meta.AvatarHash = avatarHash
 			}
 			if extraUpdateAvatarID != "" {
 				ghost.AvatarID = extraUpdateAvatarID
@@ -360,15 +537,28 @@ func (s *SlackClient) wrapUserInfo(userID string, info *slack.User, botInfo *sla
 }
 
 func (s *SlackClient) syncManyUsers(ctx context.Context, ghosts map[string]*bridgev2.Ghost) {
+	batchSize := s.Main.currentConfig().GhostSync.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultGhostSyncBatchSize
+	}
+	userIDs := slices.Sorted(maps.Keys(ghosts))
+	for batch := range slices.Chunk(userIDs, batchSize) {
+		if ctx.Err() != nil {
+			return
+		}
+		s.syncUserBatch(ctx, batch, ghosts)
+	}
+	zerolog.Ctx(ctx).Debug().Msg("Finished syncing users")
+}
+
+func (s *SlackClient) syncUserBatch(ctx context.Context, userIDs []string, ghosts map[string]*bridgev2.Ghost) {
 	params := slack.GetCachedUsersParameters{
 		CheckInteraction:        true,
 		IncludeProfileOnlyUsers: true,
-		UpdatedIDs:              make(map[string]int64, len(ghosts)),
+		UpdatedIDs:              make(map[string]int64, len(userIDs)),
 	}
-	for _, ghost := range ghosts {
-		meta := ghost.Metadata.(*slackid.GhostMetadata)
-		_, userID := slackid.ParseUserID(ghost.ID)
-		params.UpdatedIDs[userID] = meta.SlackUpdatedTS
+	for _, userID := range userIDs {
+		params.UpdatedIDs[userID] = ghosts[userID].Metadata.(*slackid.GhostMetadata).SlackUpdatedTS
 	}
 	zerolog.Ctx(ctx).Debug().Any("request_map", params.UpdatedIDs).Msg("Requesting user info")
 	infos, err := s.Client.GetUsersCacheContext(ctx, s.TeamID, params)
@@ -377,22 +567,16 @@ func (s *SlackClient) syncManyUsers(ctx context.Context, ghosts map[string]*brid
 		return
 	}
 	zerolog.Ctx(ctx).Debug().Int("updated_user_count", len(infos)).Msg("Got user info")
-	var wg sync.WaitGroup
-	wg.Add(len(infos))
+	updates := make([]ghostUpdate, 0, len(infos))
 	for userID, info := range infos {
 		ghost, ok := ghosts[userID]
 		if !ok {
-			wg.Done()
 			zerolog.Ctx(ctx).Warn().Str("user_id", userID).Msg("Got unexpected user info")
 			continue
 		}
-		go func() {
-			defer wg.Done()
-			ghost.UpdateInfo(ctx, s.wrapUserInfo(userID, info, nil, ghost))
-		}()
+		updates = append(updates, ghostUpdate{ghost: ghost, info: s.wrapUserInfo(userID, info, nil, ghost)})
 	}
-	wg.Wait()
-	zerolog.Ctx(ctx).Debug().Msg("Finished syncing users")
+	s.applyGhostUpdates(ctx, updates)
 }
 
 func (s *SlackClient) fetchUserInfo(ctx context.Context, userID string, lastUpdated int64, ghost *bridgev2.Ghost) (*bridgev2.UserInfo, error) {
@@ -0,0 +1,202 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func newTestSlackClient(api slackapi.Client) *SlackClient {
+	return &SlackClient{
+		Main:       &SlackConnector{},
+		Client:     api,
+		UserID:     "U1",
+		TeamID:     "T1",
+		IsRealUser: true,
+
+		chatInfoCache:   make(map[string]chatInfoCacheEntry),
+		lastReadCache:   make(map[string]string),
+		lastTyping:      make(map[typingKey]time.Time),
+		userResyncQueue: make(chan *bridgev2.Ghost, 16),
+	}
+}
+
+func TestFetchChatInfoWithCache(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.info", func(form url.Values) (any, error) {
+		return map[string]any{"channel": map[string]any{"id": form.Get("channel"), "name": "general", "num_members": 3}}, nil
+	})
+	s := newTestSlackClient(srv.Client())
+	ctx := context.Background()
+
+	info, err := s.fetchChatInfoWithCache(ctx, "C1")
+	require.NoError(t, err)
+	assert.Equal(t, "C1", info.ID)
+	assert.Equal(t, "general", info.Name)
+	assert.Equal(t, 3, info.NumMembers)
+
+	cached, err := s.fetchChatInfoWithCache(ctx, "C1")
+	require.NoError(t, err)
+	assert.Same(t, info, cached)
+	calls := srv.Calls("conversations.info")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "true", calls[0].Get("include_num_members"))
+
+	s.chatInfoCache["C1"] = chatInfoCacheEntry{ts: time.Now().Add(-ChatInfoCacheExpiry), data: info}
+	_, err = s.fetchChatInfoWithCache(ctx, "C1")
+	require.NoError(t, err)
+	assert.Len(t, srv.Calls("conversations.info"), 2)
+}
+
+func TestFetchChatInfoWithCache_Error(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.info", func(form url.Values) (any, error) {
+		if form.Get("channel") == "C2" {
+			return nil, slackapitest.RateLimited(time.Second)
+		}
+		return nil, slackapitest.Error("channel_not_found")
+	})
+	s := newTestSlackClient(srv.Client())
+
+	_, err := s.fetchChatInfoWithCache(context.Background(), "C1")
+	assert.True(t, isSlackError(err, "channel_not_found"))
+	_, err = s.fetchChatInfoWithCache(context.Background(), "C2")
+	assert.Equal(t, "ratelimited", slackErrorCode(err))
+	assert.Empty(t, s.chatInfoCache)
+}
+
+func TestFetchChannelMembers(t *testing.T) {
+	fake := slackapitest.NewFake()
+	fake.Members["C1"] = []string{"U1", "U2", "U3", "U4", "U5"}
+	s := newTestSlackClient(fake)
+
+	members := s.fetchChannelMembers(context.Background(), "C1", 3)
+	require.Len(t, members, 3)
+	assert.True(t, members[slackid.MakeUserID("T1", "U1")].IsFromMe)
+	assert.False(t, members[slackid.MakeUserID("T1", "U2")].IsFromMe)
+	assert.Equal(t, slackid.MakeUserLoginID("T1", "U3"), members[slackid.MakeUserID("T1", "U3")].SenderLogin)
+
+	assert.Empty(t, s.fetchChannelMembers(context.Background(), "C404", 3))
+}
+
+func TestFetchChannelMemberIDs(t *testing.T) {
+	fake := slackapitest.NewFake()
+	fake.Members["G1"] = []string{"U1", "U2", "U3"}
+	s := newTestSlackClient(fake)
+
+	assert.Equal(t, []string{"U1", "U2", "U3"}, s.fetchChannelMemberIDs(context.Background(), "G1", maxGroupDMMembers))
+	assert.Equal(t, []string{"U1", "U2"}, s.fetchChannelMemberIDs(context.Background(), "G1", 2))
+	assert.Empty(t, s.fetchChannelMemberIDs(context.Background(), "G404", maxGroupDMMembers))
+}
+
+func TestGetLatestMessageIDs(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Respond("client.counts", &slack.ClientCountsResponse{
+		Channels: []slack.ClientCountsChannel{{ID: "C1", Latest: "1700000002.000000", LastRead: "1700000001.000000"}},
+		IMs:      []slack.ClientCountsChannel{{ID: "D1", Latest: "1700000003.000000", LastRead: "1700000003.000000"}},
+		MpIMs:    []slack.ClientCountsChannel{{ID: "G1", Latest: "1700000004.000000"}},
+	})
+	s := newTestSlackClient(srv.Client())
+
+	latest := s.getLatestMessageIDs(context.Background())
+	assert.Equal(t, map[string]string{
+		"C1": "1700000002.000000",
+		"D1": "1700000003.000000",
+		"G1": "1700000004.000000",
+	}, latest)
+	assert.Equal(t, "1700000001.000000", s.getLastReadCache("C1"))
+	assert.Equal(t, "1700000003.000000", s.getLastReadCache("D1"))
+	assert.Equal(t, "", s.getLastReadCache("G1"))
+
+	s.IsRealUser = false
+	assert.Nil(t, s.getLatestMessageIDs(context.Background()))
+	assert.Len(t, srv.Calls("client.counts"), 1)
+}
+
+func TestChannelLink(t *testing.T) {
+	fake := slackapitest.NewFake()
+	fake.Channels["C1"] = &slack.Channel{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "C1"}}}
+	fake.Channels["G1"] = &slack.Channel{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "G1", IsPrivate: true}}}
+	s := newTestSlackClient(fake)
+	ctx := context.Background()
+
+	link, err := s.channelLink(ctx, "C1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.slack.com/client/T1/C1", link)
+
+	s.TeamPortal = &bridgev2.Portal{Portal: &database.Portal{Metadata: &slackid.PortalMetadata{TeamDomain: "example"}}}
+	link, err = s.channelLink(ctx, "C1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.slack.com/archives/C1", link)
+
+	_, err = s.channelLink(ctx, "G1")
+	assert.ErrorIs(t, err, errNoChannelLink)
+}
+
+func TestSkipUnchangedChatInfo(t *testing.T) {
+	makeInfo := func(memberIDs ...string) *bridgev2.ChatInfo {
+		members := &bridgev2.ChatMemberList{MemberMap: make(map[networkid.UserID]bridgev2.ChatMember)}
+		for _, id := range memberIDs {
+			members.MemberMap[networkid.UserID(id)] = bridgev2.ChatMember{Membership: event.MembershipJoin}
+		}
+		return &bridgev2.ChatInfo{
+			Name:         ptr.Ptr("general"),
+			Members:      members,
+			ExtraUpdates: func(ctx context.Context, portal *bridgev2.Portal) bool { return false },
+		}
+	}
+	info := makeInfo("U1", "U2")
+	portal := &bridgev2.Portal{Portal: &database.Portal{
+		MXID:     "!room:example.com",
+		Metadata: &slackid.PortalMetadata{InfoHash: hashChatInfo(info)},
+	}}
+
+	skipped := skipUnchangedChatInfo(portal, makeInfo("U2", "U1"))
+	require.NotNil(t, skipped)
+	assert.Nil(t, skipped.Name)
+	assert.NotNil(t, skipped.Members, "member syncs must never be skipped")
+	assert.NotNil(t, skipped.ExtraUpdates, "extra updates must never be skipped")
+
+	swapped := makeInfo("U1", "U3")
+	assert.Same(t, swapped, skipUnchangedChatInfo(portal, swapped))
+	left := makeInfo("U1", "U2")
+	left.Members.MemberMap["U2"] = bridgev2.ChatMember{Membership: event.MembershipLeave}
+	assert.Same(t, left, skipUnchangedChatInfo(portal, left))
+}
+
+func TestHashChatInfo_JoinRule(t *testing.T) {
+	info := &bridgev2.ChatInfo{Name: ptr.Ptr("general")}
+	before := hashChatInfo(info)
+	info.JoinRule = &event.JoinRulesEventContent{JoinRule: event.JoinRuleInvite}
+	assert.NotEqual(t, before, hashChatInfo(info), "visibility conversions must not be skipped")
+}
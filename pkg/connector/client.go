@@ -20,6 +20,7 @@ import (
 	"cmp"
 	"context"
 	"fmt"
+	"math/rand/v2"
 	"slices"
 	"strings"
 	"sync"
@@ -33,7 +34,9 @@ import (
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/bridgev2/status"
 
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
 	"go.mau.fi/mautrix-slack/pkg/msgconv"
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
@@ -46,11 +49,11 @@ func init() {
 	})
 }
 
-func makeSlackClient(log *zerolog.Logger, token, cookieToken, appToken string) *slack.Client {
-	options := []slack.Option{
+func makeSlackClient(log *zerolog.Logger, token, cookieToken, appToken string, extraOptions ...slack.Option) *slack.Client {
+	options := append([]slack.Option{
 		slack.OptionLog(slackgoZerolog{Logger: log.With().Str("component", "slackgo").Logger()}),
 		slack.OptionDebug(log.GetLevel() == zerolog.TraceLevel),
-	}
+	}, extraOptions...)
 	if cookieToken != "" {
 		options = append(options, slack.OptionCookie("d", cookieToken))
 	} else if appToken != "" {
@@ -62,11 +65,18 @@ func makeSlackClient(log *zerolog.Logger, token, cookieToken, appToken string) *
 func (s *SlackConnector) LoadUserLogin(ctx context.Context, login *bridgev2.UserLogin) error {
 	teamID, userID := slackid.ParseUserLoginID(login.ID)
 	meta := login.Metadata.(*slackid.UserLoginMetadata)
+	if s.secrets != nil {
+		err := s.loadExternalCredentials(ctx, login, meta)
+		if err != nil {
+			return err
+		}
+	}
 	var sc *SlackClient
 	if meta.Token == "" {
 		sc = &SlackClient{Main: s, UserLogin: login, UserID: userID, TeamID: teamID}
 	} else {
-		client := makeSlackClient(&login.Log, meta.Token, meta.CookieToken, meta.AppToken)
+		rateLimitOption := s.rateLimits.HTTPClientOption(teamID, &login.Log)
+		client := makeSlackClient(&login.Log, meta.Token, meta.CookieToken, meta.AppToken, rateLimitOption)
 		sc = &SlackClient{
 			Main:       s,
 			UserLogin:  login,
@@ -77,14 +87,26 @@ func (s *SlackConnector) LoadUserLogin(ctx context.Context, login *bridgev2.User
 
 			chatInfoCache:   make(map[string]chatInfoCacheEntry),
 			lastReadCache:   make(map[string]string),
+			lastTyping:      make(map[typingKey]time.Time),
 			userResyncQueue: make(chan *bridgev2.Ghost, 16),
 		}
+		if meta.UserToken != "" {
+			sc.UserClient = makeSlackClient(&login.Log, meta.UserToken, "", "", rateLimitOption)
+			sc.AdminAPI = slackapi.NewAdminClient(meta.UserToken, "")
+		} else if sc.IsRealUser {
+			sc.AdminAPI = slackapi.NewAdminClient(meta.Token, meta.CookieToken)
+		}
+		if sc.AdminAPI != nil {
+			sc.AdminAPI.HTTP.Transport = s.rateLimits.Transport(teamID, &login.Log)
+		}
 		if sc.IsRealUser {
 			sc.RTM = client.NewRTM()
-		} else {
+			sc.WebAPI = slackapi.NewWebClient(meta.Token, meta.CookieToken)
+			sc.WebAPI.HTTP.Transport = s.rateLimits.Transport(teamID, &login.Log)
+		} else if strings.HasPrefix(meta.AppToken, "xapp-") {
 			log := login.Log.With().Str("component", "slackgo socketmode").Logger()
 			sc.SocketMode = socketmode.New(
-				sc.Client,
+				client,
 				socketmode.OptionLog(slackgoZerolog{Logger: log}),
 				socketmode.OptionDebug(log.GetLevel() == zerolog.TraceLevel),
 			)
@@ -96,6 +118,13 @@ func (s *SlackConnector) LoadUserLogin(ctx context.Context, login *bridgev2.User
 	if err != nil {
 		return fmt.Errorf("failed to get team portal: %w", err)
 	}
+	if meta.NeedsTokenEncryption() {
+		err = login.Save(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to save login with encrypted credentials: %w", err)
+		}
+		login.Log.Info().Msg("Encrypted plaintext credentials in database")
+	}
 	login.Client = sc
 	return nil
 }
@@ -108,7 +137,10 @@ type chatInfoCacheEntry struct {
 type SlackClient struct {
 	Main       *SlackConnector
 	UserLogin  *bridgev2.UserLogin
-	Client     *slack.Client
+	Client     slackapi.Client
+	UserClient slackapi.Client
+	AdminAPI   *slackapi.AdminClient
+	WebAPI     *slackapi.WebClient
 	RTM        *slack.RTM
 	SocketMode *socketmode.Client
 	UserID     string
@@ -116,17 +148,47 @@ type SlackClient struct {
 	BootResp   *slack.ClientUserBootResponse
 	TeamPortal *bridgev2.Portal
 	IsRealUser bool
-	Ghost      *bridgev2.Ghost
-
-	stopSocketMode  context.CancelFunc
-	stopResyncQueue atomic.Pointer[context.CancelFunc]
-	userResyncQueue chan *bridgev2.Ghost
-	initialConnect  time.Time
-
-	chatInfoCache     map[string]chatInfoCacheEntry
-	chatInfoCacheLock sync.Mutex
-	lastReadCache     map[string]string
-	lastReadCacheLock sync.Mutex
+	// bootRespLock protects the team info and own profile in BootResp, which are updated by events.
+	bootRespLock sync.RWMutex
+	Ghost        *bridgev2.Ghost
+	// EventQueue is the queue of the current connection, it's replaced on every connect
+	EventQueue atomic.Pointer[EventQueue]
+
+	stopSocketMode context.CancelFunc
+	eventsAPIQueue atomic.Pointer[EventQueue]
+	// socketModeAttempts counts failed socket mode connections since the last successful one
+	socketModeAttempts atomic.Int32
+	// socketModeConnections counts successful socket mode connections, used to detect reconnects
+	socketModeConnections atomic.Int32
+	// connectionLostAt is the time of the last event received before the RTM connection was lost
+	connectionLostAt   atomic.Int64
+	stopResyncQueue    atomic.Pointer[context.CancelFunc]
+	stopCursorFlush    atomic.Pointer[context.CancelFunc]
+	userResyncQueue    chan *bridgev2.Ghost
+	initialConnect     time.Time
+	lastEventAt        atomic.Int64
+	syncedChannels     []string
+	syncedChannelsLock sync.Mutex
+	sends              sendTracker
+	eventCursors       eventCursorTracker
+
+	chatInfoCache      map[string]chatInfoCacheEntry
+	chatInfoCacheLock  sync.Mutex
+	lastReadCache      map[string]string
+	lastReadCacheLock  sync.Mutex
+	lastTyping         map[typingKey]time.Time
+	lastTypingLock     sync.Mutex
+	starredChannels    map[string]struct{}
+	starredLock        sync.RWMutex
+	threadSummaryLock  sync.Mutex
+	pendingUploads     map[networkid.TransactionID]struct{}
+	pendingUploadsLock sync.Mutex
+	pendingVotes       map[pendingVoteReaction]struct{}
+	pendingVotesLock   sync.Mutex
+	draftLock          sync.Mutex
+
+	pendingChannelUpdates     map[string]*time.Timer
+	pendingChannelUpdatesLock sync.Mutex
 }
 
 var (
@@ -135,22 +197,34 @@ var (
 	_ status.BridgeStateFiller    = (*SlackClient)(nil)
 )
 
-func (s *SlackClient) GetClient() *slack.Client {
+func (s *SlackClient) GetClient() slackapi.Client {
+	return s.Client
+}
+
+func (s *SlackClient) CanTriggerBlockActions() bool {
+	return s.WebAPI != nil
+}
+
+// userClient returns the client that acts as the Slack user. For hybrid logins, this is the client
+// using the user token, while everything else (including receiving events) goes through the bot token.
+func (s *SlackClient) userClient() slackapi.Client {
+	if s.UserClient != nil {
+		return s.UserClient
+	}
 	return s.Client
 }
 
+// canActAsUser returns true if the login can perform actions that are only available to users.
+func (s *SlackClient) canActAsUser() bool {
+	return s.IsRealUser || s.UserClient != nil
+}
+
 func (s *SlackClient) handleBootError(ctx context.Context, err error) {
-	if err.Error() == "user_removed_from_team" || err.Error() == "invalid_auth" {
-		s.invalidateSession(ctx, status.BridgeState{
-			StateEvent: status.StateBadCredentials,
-			Error:      status.BridgeStateErrorCode(fmt.Sprintf("slack-%s", strings.ReplaceAll(err.Error(), "_", "-"))),
-		})
+	state := slackErrorToBridgeState(err)
+	if state.StateEvent == status.StateBadCredentials {
+		s.invalidateSession(ctx, state)
 	} else {
-		s.UserLogin.BridgeState.Send(status.BridgeState{
-			StateEvent: status.StateUnknownError,
-			Error:      "slack-unknown-fetch-error",
-			Message:    fmt.Sprintf("Unknown error from Slack: %s", err.Error()),
-		})
+		s.UserLogin.BridgeState.Send(state)
 	}
 }
 
@@ -161,6 +235,16 @@ func (s *SlackClient) Connect(ctx context.Context) {
 			Error:      "slack-not-logged-in",
 		})
 		return
+	} else if !s.IsRealUser && s.SocketMode == nil && s.Main.currentConfig().EventsAPI.SigningSecret == "" {
+		s.UserLogin.BridgeState.Send(status.BridgeState{
+			StateEvent: status.StateBadCredentials,
+			Error:      "slack-missing-app-token",
+			Message:    "An app-level token (xapp-) or the Events API is required to receive events with a bot token",
+		})
+		return
+	} else if !s.Main.ownsTeam(s.TeamID) {
+		zerolog.Ctx(ctx).Debug().Msg("Not connecting, the team is handled by another bridge instance")
+		return
 	}
 	var bootResp *slack.ClientUserBootResponse
 	if s.IsRealUser {
@@ -194,6 +278,7 @@ func (s *SlackClient) Connect(ctx context.Context) {
 			},
 		}
 	}
+	s.sends.reset()
 	err := s.connect(ctx, bootResp)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to connect")
@@ -217,29 +302,155 @@ func (s *SlackClient) connect(ctx context.Context, bootResp *slack.ClientUserBoo
 		return fmt.Errorf("failed to get own ghost: %w", err)
 	}
 	ghost.UpdateInfo(ctx, s.wrapUserInfo(s.UserID, &s.BootResp.Self, nil, ghost))
-	s.UserLogin.RemoteProfile = status.RemoteProfile{
-		Phone:  s.BootResp.Self.Profile.Phone,
-		Email:  s.BootResp.Self.Profile.Email,
-		Name:   ghost.Name,
-		Avatar: ghost.AvatarMXC,
-	}
 	s.Ghost = ghost
+	s.updateRemoteProfile(ctx, s.selfInfo())
+	s.loadEventCursors(ctx)
+	s.startEventCursorFlushLoop()
+	var catchupDone chan struct{}
+	if s.Main.currentConfig().Backfill.CatchupBeforeLive {
+		catchupDone = make(chan struct{})
+	}
 	if s.IsRealUser {
-		go s.consumeRTMEvents()
+		go s.consumeRTMEvents(catchupDone)
 		go s.RTM.ManageConnection()
 		go s.resyncUsers()
-	} else {
+	} else if s.SocketMode != nil {
+		// Socket mode events must be acknowledged quickly, so they aren't held back for catch-up
 		go s.consumeSocketModeEvents()
 		go s.runSocketMode(ctx)
+	} else {
+		// Events are pushed to ServeEventsAPI, so there's no connection to wait for
+		queue := NewEventQueue(s.Main.currentConfig().EventQueueSize, s.UserLogin.Log.With().Str("component", "event queue").Logger())
+		if oldQueue := s.eventsAPIQueue.Swap(queue); oldQueue != nil {
+			oldQueue.Close()
+		}
+		s.EventQueue.Store(queue)
+		go queue.Consume(s.HandleSlackEvent)
+		s.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
 	}
-	go s.SyncEmojis(ctx)
-	go s.SyncChannels(ctx)
+	go s.runStartupSync(ctx, catchupDone)
 	return nil
 }
 
-func (s *SlackClient) consumeRTMEvents() {
-	for evt := range s.RTM.IncomingEvents {
-		s.HandleSlackEvent(evt.Data)
+// waitForCatchup returns a channel that's closed when the catch-up phase of the startup sync is done
+// or the configured timeout is reached. If there's no catch-up phase, the returned channel is already closed.
+func (s *SlackClient) waitForCatchup(catchupDone <-chan struct{}) <-chan struct{} {
+	live := make(chan struct{})
+	if catchupDone == nil {
+		close(live)
+		return live
+	}
+	timeout := s.Main.currentConfig().Backfill.CatchupTimeout
+	if timeout <= 0 {
+		timeout = DefaultCatchupTimeout
+	}
+	go func() {
+		defer close(live)
+		select {
+		case <-catchupDone:
+			s.UserLogin.Log.Debug().Msg("Catch-up finished, starting to handle live events")
+		case <-time.After(timeout):
+			s.UserLogin.Log.Warn().Stringer("timeout", timeout).Msg("Catch-up didn't finish in time, starting to handle live events anyway")
+		}
+	}()
+	return live
+}
+
+// acquireSyncSlot waits for a free slot in the global startup sync concurrency limit.
+// The returned function must be called to release the slot. Only the startup sync uses the limit,
+// so that steady-state user resyncs aren't held up by other logins connecting.
+func (s *SlackClient) acquireSyncSlot(ctx context.Context) (func(), error) {
+	sema := s.Main.startupSyncSema
+	if sema == nil {
+		return func() {}, nil
+	}
+	select {
+	case sema <- struct{}{}:
+		return func() { <-sema }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// DefaultCatchupTimeout is the maximum time live events are held back while catching up if no timeout is configured.
+const DefaultCatchupTimeout = 2 * time.Minute
+
+// runStartupSync syncs emojis and channels after connecting. If catchupDone is not nil, it's closed once
+// all channel resyncs (and therefore forward backfills of missed messages) have been queued.
+func (s *SlackClient) runStartupSync(ctx context.Context, catchupDone chan struct{}) {
+	log := zerolog.Ctx(ctx)
+	markCatchupDone := sync.OnceFunc(func() {
+		if catchupDone != nil {
+			close(catchupDone)
+		}
+	})
+	defer markCatchupDone()
+	if maxJitter := s.Main.currentConfig().StartupSync.MaxJitter; maxJitter > 0 && catchupDone == nil {
+		delay := rand.N(maxJitter)
+		log.Debug().Stringer("delay", delay).Msg("Delaying startup sync")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+	release, err := s.acquireSyncSlot(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Startup sync cancelled while waiting for free slot")
+		return
+	}
+	defer release()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.SyncEmojis(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		s.loadStarredChannels(ctx)
+		s.SyncChannels(ctx)
+		markCatchupDone()
+	}()
+	wg.Wait()
+}
+
+func (s *SlackClient) consumeRTMEvents(catchupDone <-chan struct{}) {
+	queue := NewEventQueue(s.Main.currentConfig().EventQueueSize, s.UserLogin.Log.With().Str("component", "event queue").Logger())
+	s.EventQueue.Store(queue)
+	incoming := s.RTM.IncomingEvents
+	heldEvents := make(chan []any, 1)
+	go func() {
+		for _, evt := range <-heldEvents {
+			s.HandleSlackEvent(evt)
+		}
+		queue.Consume(s.HandleSlackEvent)
+	}()
+	defer queue.Close()
+	// Live events are held in an unbounded buffer during catch-up instead of the event queue,
+	// so that the websocket reader never blocks and the connection doesn't time out.
+	held := holdEventsUntil(incoming, s.waitForCatchup(catchupDone))
+	heldEvents <- held
+	if len(held) > 0 {
+		s.UserLogin.Log.Debug().Int("count", len(held)).Msg("Handling live events held back during catch-up")
+	}
+	for evt := range incoming {
+		queue.Push(evt.Data)
+	}
+}
+
+// holdEventsUntil collects events from the channel until live is closed or the channel is closed.
+func holdEventsUntil(incoming <-chan slack.RTMEvent, live <-chan struct{}) (held []any) {
+	for {
+		select {
+		case <-live:
+			return held
+		case evt, ok := <-incoming:
+			if !ok {
+				return held
+			}
+			held = append(held, evt.Data)
+		}
 	}
 }
 
@@ -290,6 +501,20 @@ func (s *SlackClient) resyncUsers() {
 	}
 }
 
+// SocketModeMinBackoff and SocketModeMaxBackoff are the bounds of the delay between socket mode reconnection attempts.
+const (
+	SocketModeMinBackoff = 5 * time.Second
+	SocketModeMaxBackoff = 5 * time.Minute
+)
+
+// socketModeBackoff returns how long to wait before the given reconnection attempt (starting from zero).
+func socketModeBackoff(attempt int) time.Duration {
+	if attempt >= 6 {
+		return SocketModeMaxBackoff
+	}
+	return min(SocketModeMinBackoff<<attempt, SocketModeMaxBackoff)
+}
+
 func (s *SlackClient) runSocketMode(ctx context.Context) {
 	var cancel context.CancelFunc
 	ctx, cancel = context.WithCancel(ctx)
@@ -298,18 +523,27 @@ func (s *SlackClient) runSocketMode(ctx context.Context) {
 	log := zerolog.Ctx(ctx)
 	for ctx.Err() == nil {
 		err := s.SocketMode.RunContext(ctx)
-		if err != nil {
-			log.Err(err).Msg("Error in socket mode connection")
-			s.UserLogin.BridgeState.Send(status.BridgeState{
-				StateEvent: status.StateTransientDisconnect,
-				Error:      "slack-socketmode-error",
-				Message:    err.Error(),
-			})
-			time.Sleep(10 * time.Second)
-		} else {
+		if err == nil || ctx.Err() != nil {
 			log.Info().Msg("Socket disconnected without error")
 			return
 		}
+		log.Err(err).Msg("Error in socket mode connection")
+		state := slackErrorToBridgeState(err)
+		if state.StateEvent == status.StateBadCredentials {
+			s.invalidateSession(ctx, state)
+			return
+		}
+		attempt := int(s.socketModeAttempts.Add(1)) - 1
+		s.UserLogin.BridgeState.Send(status.BridgeState{
+			StateEvent: status.StateTransientDisconnect,
+			Error:      "slack-socketmode-error",
+			Message:    err.Error(),
+		})
+		select {
+		case <-time.After(socketModeBackoff(attempt)):
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
@@ -386,7 +620,7 @@ func (s *SlackClient) SyncChannels(ctx context.Context) {
 	}
 	var channels []*slack.Channel
 	token := s.UserLogin.Metadata.(*slackid.UserLoginMetadata).Token
-	if s.IsRealUser && (strings.HasPrefix(token, "xoxs-") || s.Main.Config.Backfill.ConversationCount == -1) {
+	if s.IsRealUser && (strings.HasPrefix(token, "xoxs-") || s.conversationCount() == -1) {
 		for _, ch := range s.BootResp.Channels {
 			ch.IsMember = true
 			channels = append(channels, &ch.Channel)
@@ -397,7 +631,7 @@ func (s *SlackClient) SyncChannels(ctx context.Context) {
 		}
 		log.Debug().Int("channel_count", len(channels)).Msg("Using channels from boot response for sync")
 	} else {
-		totalLimit := s.Main.Config.Backfill.ConversationCount
+		totalLimit := s.conversationCount()
 		if totalLimit < 0 {
 			totalLimit = 50
 		}
@@ -433,45 +667,33 @@ func (s *SlackClient) SyncChannels(ctx context.Context) {
 			return cmp.Compare(latestMessageIDs[a.ID], latestMessageIDs[b.ID])
 		})
 	}
+	// Most recently active channels first
+	syncedChannels := make([]string, len(channels))
+	for i, ch := range channels {
+		syncedChannels[len(channels)-1-i] = ch.ID
+	}
+	s.setSyncedChannels(syncedChannels)
+	workers := s.Main.currentConfig().SyncWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	sema := make(chan struct{}, workers)
+	var wg sync.WaitGroup
 	for _, ch := range channels {
 		portalKey := s.makePortalKey(ch)
 		delete(existingPortals, portalKey)
-		var latestMessageID string
-		var hasCounts bool
-		if !s.IsRealUser {
-			ch, err = s.Client.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{
-				ChannelID:         ch.ID,
-				IncludeLocale:     true,
-				IncludeNumMembers: true,
-			})
-			if err != nil {
-				log.Err(err).Str("channel_id", ch.ID).Msg("Failed to fetch channel info")
-				continue
-			}
-			hasCounts = ch.Latest != nil
-			if hasCounts {
-				latestMessageID = ch.Latest.Timestamp
-			}
-		} else {
-			latestMessageID, hasCounts = latestMessageIDs[ch.ID]
-		}
-		// TODO fetch latest message from channel info when using bot account?
-		s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackChatResync{
-			SlackEventMeta: &SlackEventMeta{
-				Type:         bridgev2.RemoteEventChatResync,
-				PortalKey:    portalKey,
-				CreatePortal: hasCounts || (!ch.IsIM && !ch.IsMpIM),
-				LogContext: func(c zerolog.Context) zerolog.Context {
-					return c.
-						Object("portal_key", portalKey).
-						Str("slack_latest_message_id", latestMessageID)
-				},
-			},
-			Client:         s,
-			LatestMessage:  latestMessageID,
-			PreFetchedInfo: ch,
-		})
-	}
+		sema <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			s.syncChannel(ctx, ch, portalKey, latestMessageIDs)
+		}()
+	}
+	wg.Wait()
+	log.Debug().Int("channel_count", len(channels)).Msg("Finished queuing channel syncs")
 	for portalKey := range existingPortals {
 		_, channelID := slackid.ParsePortalID(portalKey.ID)
 		if channelID == "" {
@@ -481,6 +703,8 @@ func (s *SlackClient) SyncChannels(ctx context.Context) {
 		if !ok {
 			// TODO delete portal if it's actually gone?
 			continue
+		} else if s.isClosedDM(ctx, portalKey) {
+			continue
 		}
 		s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackChatResync{
 			SlackEventMeta: &SlackEventMeta{
@@ -493,11 +717,105 @@ func (s *SlackClient) SyncChannels(ctx context.Context) {
 	}
 }
 
+// isClosedDM checks whether the portal is a DM that was closed after the Matrix user left it.
+func (s *SlackClient) isClosedDM(ctx context.Context, portalKey networkid.PortalKey) bool {
+	portal, err := s.Main.br.GetExistingPortalByKey(ctx, portalKey)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Object("portal_key", portalKey).Msg("Failed to get portal to check if DM is closed")
+		return false
+	}
+	return portal != nil && portal.Metadata.(*slackid.PortalMetadata).DMClosed
+}
+
+// markDMReopened clears the closed flag of a DM portal after Slack reopened the DM.
+func (s *SlackClient) markDMReopened(ctx context.Context, portalKey networkid.PortalKey) {
+	portal, err := s.Main.br.GetExistingPortalByKey(ctx, portalKey)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Object("portal_key", portalKey).Msg("Failed to get portal to mark DM as reopened")
+		return
+	} else if portal == nil || !portal.Metadata.(*slackid.PortalMetadata).DMClosed {
+		return
+	}
+	portal.Metadata.(*slackid.PortalMetadata).DMClosed = false
+	err = portal.Save(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Object("portal_key", portalKey).Msg("Failed to save portal after marking DM as reopened")
+	}
+}
+
+func (s *SlackClient) syncChannel(ctx context.Context, ch *slack.Channel, portalKey networkid.PortalKey, latestMessageIDs map[string]string) {
+	if (ch.IsIM || ch.IsMpIM) && s.isClosedDM(ctx, portalKey) {
+		return
+	}
+	var latestMessageID string
+	var hasCounts bool
+	if !s.IsRealUser {
+		channelID := ch.ID
+		var err error
+		ch, err = s.Client.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{
+			ChannelID:         channelID,
+			IncludeLocale:     true,
+			IncludeNumMembers: true,
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("channel_id", channelID).Msg("Failed to fetch channel info")
+			return
+		}
+		hasCounts = ch.Latest != nil
+		if hasCounts {
+			latestMessageID = ch.Latest.Timestamp
+		}
+	} else {
+		latestMessageID, hasCounts = latestMessageIDs[ch.ID]
+	}
+	// TODO fetch latest message from channel info when using bot account?
+	isDM := ch.IsIM || ch.IsMpIM
+	createPortal := !isDM || (hasCounts && s.dmPolicy() == DMPolicyAll)
+	s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackChatResync{
+		SlackEventMeta: &SlackEventMeta{
+			Type:         bridgev2.RemoteEventChatResync,
+			PortalKey:    portalKey,
+			CreatePortal: createPortal,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.
+					Object("portal_key", portalKey).
+					Str("slack_latest_message_id", latestMessageID)
+			},
+		},
+		Client:         s,
+		LatestMessage:  latestMessageID,
+		PreFetchedInfo: ch,
+	})
+	s.replayMissedThreadReplies(ctx, portalKey, ch.ID)
+}
+
+// Disconnect is called by bridgev2 when the bridge is shutting down, so it waits for in-flight sends first.
 func (s *SlackClient) Disconnect() {
+	s.drainSends()
+	s.disconnectNow()
+}
+
+// disconnectNow disconnects without waiting for in-flight sends. It's used for internal disconnects
+// (invalidated sessions, lost shard leases and relogins), which shouldn't block for the drain timeout.
+func (s *SlackClient) disconnectNow() {
+	s.saveEventCursors()
 	s.disconnect()
+	// UserClient and AdminAPI aren't tied to the connection, so they're only cleared when logging out
 	s.Client = nil
 }
 
+// drainSends waits for in-flight Matrix->Slack sends to finish before disconnecting,
+// so that messages and file uploads aren't lost when the bridge is restarted.
+// The realtime connection is kept open while waiting, so that echoes of pending sends are still received.
+func (s *SlackClient) drainSends() {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownDrainTimeout)
+	defer cancel()
+	err := s.sends.drain(ctx)
+	if err != nil {
+		s.UserLogin.Log.Warn().Err(err).Msg("Timed out waiting for in-flight sends to finish")
+	}
+}
+
 func (s *SlackClient) disconnect() {
 	if rtm := s.RTM; rtm != nil {
 		err := rtm.Disconnect()
@@ -511,16 +829,34 @@ func (s *SlackClient) disconnect() {
 		stop()
 		s.SocketMode = nil
 	}
+	if queue := s.eventsAPIQueue.Swap(nil); queue != nil {
+		queue.Close()
+	}
+	s.EventQueue.Store(nil)
 	if cancel := s.stopResyncQueue.Swap(nil); cancel != nil {
 		(*cancel)()
 	}
+	s.stopChannelUpdates()
 }
 
+// IsLoggedIn returns false for logins of teams handled by another bridge instance,
+// so that bridgev2 doesn't pick them for Matrix events or backfills on this instance.
 func (s *SlackClient) IsLoggedIn() bool {
-	return s.Client != nil
+	return s.Client != nil && s.Main.ownsTeam(s.TeamID)
+}
+
+// checkLoggedIn returns an error if events from Matrix can't be bridged with this login on this bridge instance.
+func (s *SlackClient) checkLoggedIn() error {
+	if s.Client == nil {
+		return bridgev2.ErrNotLoggedIn
+	} else if !s.Main.ownsTeam(s.TeamID) {
+		return errNotShardOwner
+	}
+	return nil
 }
 
 func (s *SlackClient) LogoutRemote(ctx context.Context) {
+	s.auditLog(ctx, slackdb.AuditActionLogout, "", "", "")
 	s.disconnect()
 	if s.IsRealUser {
 		if cli := s.Client; cli != nil {
@@ -531,10 +867,24 @@ func (s *SlackClient) LogoutRemote(ctx context.Context) {
 		}
 	}
 	s.Client = nil
+	s.UserClient = nil
+	s.AdminAPI = nil
+	if cancel := s.stopCursorFlush.Swap(nil); cancel != nil {
+		(*cancel)()
+	}
+	err := s.Main.DB.Cursors.DeleteAllForLogin(ctx, string(s.UserLogin.ID))
+	if err != nil {
+		s.UserLogin.Log.Err(err).Msg("Failed to delete event cursors")
+	}
 	meta := s.UserLogin.Metadata.(*slackid.UserLoginMetadata)
 	meta.Token = ""
 	meta.CookieToken = ""
 	meta.AppToken = ""
+	meta.UserToken = ""
+	err = s.Main.storeExternalCredentials(ctx, s.UserLogin)
+	if err != nil {
+		s.UserLogin.Log.Err(err).Msg("Failed to delete credentials from secret backend")
+	}
 }
 
 func (s *SlackClient) invalidateSession(ctx context.Context, state status.BridgeState) {
@@ -545,7 +895,11 @@ func (s *SlackClient) invalidateSession(ctx context.Context, state status.Bridge
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to save user login after invalidating session")
 	}
-	s.Disconnect()
+	err = s.Main.storeExternalCredentials(ctx, s.UserLogin)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to update credentials in secret backend after invalidating session")
+	}
+	s.disconnectNow()
 	s.UserLogin.BridgeState.Send(state)
 }
 
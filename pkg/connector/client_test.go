@@ -0,0 +1,79 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketModeBackoff(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 10 * time.Second},
+		{3, 40 * time.Second},
+		{5, 160 * time.Second},
+		{6, SocketModeMaxBackoff},
+		{100, SocketModeMaxBackoff},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, socketModeBackoff(test.attempt), "attempt %d", test.attempt)
+	}
+}
+
+func TestHoldEventsUntil(t *testing.T) {
+	// More events than the event queue holds must not block the sender while catching up
+	incoming := make(chan slack.RTMEvent)
+	live := make(chan struct{})
+	go func() {
+		for i := 0; i < DefaultEventQueueSize*2; i++ {
+			incoming <- slack.RTMEvent{Data: i}
+		}
+		close(live)
+	}()
+	held := holdEventsUntil(incoming, live)
+	assert.Len(t, held, DefaultEventQueueSize*2)
+	assert.Equal(t, 0, held[0])
+
+	closed := make(chan slack.RTMEvent, 1)
+	closed <- slack.RTMEvent{Data: "last"}
+	close(closed)
+	assert.Equal(t, []any{"last"}, holdEventsUntil(closed, make(chan struct{})))
+}
+
+func TestDisconnectNowDoesntWaitForSends(t *testing.T) {
+	s := newTestSlackClient(nil)
+	done, err := s.sends.start()
+	assert.NoError(t, err)
+	defer done()
+
+	start := time.Now()
+	s.disconnectNow()
+	assert.Less(t, time.Since(start), time.Second)
+	assert.False(t, s.IsLoggedIn())
+
+	// The send tracker isn't drained, so sends can still be started after reconnecting
+	finish, err := s.sends.start()
+	assert.NoError(t, err)
+	finish()
+}
@@ -0,0 +1,62 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"encoding/json"
+	"html"
+	"slices"
+
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/event"
+)
+
+// jsonFlag makes the whoami, search and portal-info commands reply with machine-readable JSON.
+// There's no separate sync command: chats are synced automatically on connect, and the commands
+// that trigger resyncs (resync-ghosts, fix-portals) only reply with a summary.
+const jsonFlag = "--json"
+
+// cutJSONFlag removes the --json flag from command arguments and returns whether it was present.
+func cutJSONFlag(args []string) ([]string, bool) {
+	idx := slices.Index(args, jsonFlag)
+	if idx < 0 {
+		return args, false
+	}
+	return slices.Delete(slices.Clone(args), idx, idx+1), true
+}
+
+// replyJSON sends the given value as a notice whose plain text body is the JSON itself,
+// so that scripts can parse it without stripping any markdown.
+func replyJSON(ce *commands.Event, value any) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to marshal command output")
+		ce.Reply("Failed to marshal output: %v", err)
+		return
+	}
+	_, err = ce.Bot.SendMessage(ce.Ctx, ce.OrigRoomID, event.EventMessage, &event.Content{
+		Parsed: &event.MessageEventContent{
+			MsgType:       event.MsgNotice,
+			Body:          string(data),
+			Format:        event.FormatHTML,
+			FormattedBody: `<pre><code class="language-json">` + html.EscapeString(string(data)) + "</code></pre>",
+		},
+	}, nil)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to reply to command")
+	}
+}
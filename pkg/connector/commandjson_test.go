@@ -0,0 +1,78 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCutJSONFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+		found    bool
+	}{
+		{"Empty", nil, nil, false},
+		{"NoFlag", []string{"T1"}, []string{"T1"}, false},
+		{"OnlyFlag", []string{"--json"}, []string{}, true},
+		{"FlagFirst", []string{"--json", "T1"}, []string{"T1"}, true},
+		{"FlagLast", []string{"T1", "--json"}, []string{"T1"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := strings.Join(test.args, " ")
+			args, found := cutJSONFlag(test.args)
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.expected, args)
+			assert.Equal(t, original, strings.Join(test.args, " "), "input args must not be modified")
+		})
+	}
+}
+
+func TestWhoamiLoginJSON(t *testing.T) {
+	data, err := json.Marshal(&whoamiLogin{
+		LoginID:    "T1-U1",
+		Name:       "user@example.com",
+		TeamID:     "T1",
+		UserID:     "U1",
+		TokenType:  "bot",
+		Mode:       "normal",
+		Connection: "socket mode",
+		State:      "CONNECTED",
+		LastEvent:  &time.Time{},
+	})
+	require.NoError(t, err)
+	var parsed map[string]any
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, "T1-U1", parsed["login_id"])
+	assert.Equal(t, "CONNECTED", parsed["state"])
+	assert.NotContains(t, parsed, "team_name")
+	assert.Contains(t, parsed, "last_event")
+}
+
+func TestSearchResultJSON(t *testing.T) {
+	data, err := json.Marshal(&searchResult{UserID: "U2", Name: "Alice", MXID: "@slack_t1-u2:example.com"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"user_id":"U2","name":"Alice","mxid":"@slack_t1-u2:example.com"}`, string(data))
+}
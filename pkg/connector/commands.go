@@ -0,0 +1,1187 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/msgconv"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var cmdSetTranslation = &commands.FullHandler{
+	Func: fnSetTranslation,
+	Name: "set-translation",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Set the language incoming messages in this room are translated to.",
+		Args:        "<_language code_ | `default` | `off`>",
+	},
+	RequiresPortal:     true,
+	RequiresEventLevel: event.StatePowerLevels,
+}
+
+func fnSetTranslation(ce *commands.Event) {
+	mc := ce.Bridge.Network.(*SlackConnector).MsgConv
+	meta := ce.Portal.Metadata.(*slackid.PortalMetadata)
+	if len(ce.Args) == 0 {
+		current := meta.TranslationTarget
+		if current == "" {
+			current = "default (" + mc.Options().TranslationTarget + ")"
+		}
+		ce.Reply("Usage: `$cmdprefix set-translation <language code|default|off>`\n\nCurrent setting: %s", current)
+		return
+	} else if mc.Translator == nil {
+		ce.Reply("Translation is not enabled on this bridge")
+		return
+	}
+	target := strings.ToLower(ce.Args[0])
+	switch target {
+	case "default":
+		target = ""
+	case msgconv.TranslationDisabled:
+	default:
+		if len(target) < 2 || len(target) > 7 {
+			ce.Reply("Invalid language code `%s`", ce.Args[0])
+			return
+		}
+	}
+	meta.TranslationTarget = target
+	err := ce.Portal.Save(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to save portal after changing translation target")
+		ce.Reply("Failed to save portal: %v", err)
+		return
+	}
+	switch target {
+	case "":
+		ce.Reply("Translation reset to the bridge default")
+	case msgconv.TranslationDisabled:
+		ce.Reply("Translation disabled in this room")
+	default:
+		ce.Reply("Incoming messages will now be translated to `%s`", target)
+	}
+}
+
+var cmdSetBotIdentity = &commands.FullHandler{
+	Func: fnSetBotIdentity,
+	Name: "set-bot-identity",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Set the username and icon the bridge uses when posting relayed or app messages in this room.",
+		Args:        "<`name` _username_ | `icon` <_URL_ | _:emoji:_> | `reset`>",
+	},
+	RequiresPortal:     true,
+	RequiresEventLevel: event.StatePowerLevels,
+}
+
+// maxBotUsernameLength is the maximum length of custom usernames accepted by Slack.
+const maxBotUsernameLength = 80
+
+func fnSetBotIdentity(ce *commands.Event) {
+	meta := ce.Portal.Metadata.(*slackid.PortalMetadata)
+	if len(ce.Args) == 0 {
+		name, icon := meta.BotUsername, meta.BotIcon
+		if name == "" {
+			name = "default"
+		}
+		if icon == "" {
+			icon = "default"
+		}
+		ce.Reply("Usage: `$cmdprefix set-bot-identity <name <username>|icon <URL|:emoji:>|reset>`\n\nCurrent username: %s\n\nCurrent icon: %s", name, icon)
+		return
+	}
+	switch strings.ToLower(ce.Args[0]) {
+	case "name":
+		name := strings.TrimSpace(strings.Join(ce.Args[1:], " "))
+		if name == "" || len(name) > maxBotUsernameLength {
+			ce.Reply("The username must be between 1 and %d characters", maxBotUsernameLength)
+			return
+		}
+		meta.BotUsername = name
+	case "icon":
+		if len(ce.Args) != 2 {
+			ce.Reply("Usage: `$cmdprefix set-bot-identity icon <URL|:emoji:>`")
+			return
+		} else if !msgconv.IsEmojiShortcode(ce.Args[1]) && !strings.HasPrefix(ce.Args[1], "https://") {
+			ce.Reply("The icon must be a `https://` URL or an emoji shortcode like `:robot_face:`")
+			return
+		}
+		meta.BotIcon = ce.Args[1]
+	case "reset":
+		meta.BotUsername = ""
+		meta.BotIcon = ""
+	default:
+		ce.Reply("Usage: `$cmdprefix set-bot-identity <name <username>|icon <URL|:emoji:>|reset>`")
+		return
+	}
+	err := ce.Portal.Save(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to save portal after changing bot identity")
+		ce.Reply("Failed to save portal: %v", err)
+		return
+	}
+	if meta.BotUsername == "" && meta.BotIcon == "" {
+		ce.Reply("Bot identity reset to the default")
+	} else {
+		ce.Reply("Bot identity updated. Note that Slack only applies it to messages sent with an app token that has the `chat:write.customize` scope.")
+	}
+}
+
+var cmdRefreshGhost = &commands.FullHandler{
+	Func: fnRefreshGhost,
+	Name: "refresh-ghost",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Force a full profile refresh of a Slack user's ghost, ignoring cached profile versions.",
+		Args:        "<_Matrix user ID_ | _team ID_-_user ID_>",
+	},
+	RequiresAdmin: true,
+}
+
+// findLoginInTeam returns a logged-in Slack client of the given user in the given team.
+func findLoginInTeam(user *bridgev2.User, teamID string) *SlackClient {
+	for _, login := range user.GetUserLogins() {
+		loginTeamID, _ := slackid.ParseUserLoginID(login.ID)
+		if client, ok := login.Client.(*SlackClient); ok && loginTeamID == teamID && client.IsLoggedIn() {
+			return client
+		}
+	}
+	return nil
+}
+
+func fnRefreshGhost(ce *commands.Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("Usage: `$cmdprefix refresh-ghost <Matrix user ID|team ID-user ID>`")
+		return
+	}
+	var ghostID networkid.UserID
+	if strings.HasPrefix(ce.Args[0], "@") {
+		var ok bool
+		ghostID, ok = ce.Bridge.Matrix.ParseGhostMXID(id.UserID(ce.Args[0]))
+		if !ok {
+			ce.Reply("`%s` is not a Slack user", ce.Args[0])
+			return
+		}
+	} else {
+		ghostID = networkid.UserID(strings.ToLower(ce.Args[0]))
+	}
+	teamID, userID := slackid.ParseUserID(ghostID)
+	if teamID == "" || userID == "" {
+		ce.Reply("Invalid user ID `%s`", ce.Args[0])
+		return
+	}
+	client := findLoginInTeam(ce.User, teamID)
+	if client == nil {
+		ce.Reply("You're not logged into the team of that user")
+		return
+	}
+	ghost, err := ce.Bridge.GetExistingGhostByID(ce.Ctx, ghostID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get ghost")
+		ce.Reply("Failed to get ghost: %v", err)
+		return
+	} else if ghost == nil {
+		ce.Reply("Ghost `%s` doesn't exist", ghostID)
+		return
+	}
+	err = client.forceRefreshGhost(ce.Ctx, ghost)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to refresh ghost")
+		ce.Reply("Failed to refresh ghost: %v", err)
+		return
+	}
+	ce.Reply("Refreshed profile of [%s](%s)", ghost.Name, ghost.Intent.GetMXID().URI().MatrixToURL())
+}
+
+var cmdResyncGhosts = &commands.FullHandler{
+	Func: fnResyncGhosts,
+	Name: "resync-ghosts",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Refetch the profiles of all known users in your Slack teams, e.g. to apply displayname config changes.",
+		Args:        "[_team ID_]",
+	},
+	RequiresAdmin: true,
+	RequiresLogin: true,
+}
+
+func fnResyncGhosts(ce *commands.Event) {
+	var clients []*SlackClient
+	if len(ce.Args) > 0 {
+		client := findLoginInTeam(ce.User, strings.ToUpper(ce.Args[0]))
+		if client == nil {
+			ce.Reply("You're not logged into team `%s`", ce.Args[0])
+			return
+		}
+		clients = append(clients, client)
+	} else {
+		for _, login := range ce.User.GetUserLogins() {
+			if client, ok := login.Client.(*SlackClient); ok && client.IsLoggedIn() {
+				clients = append(clients, client)
+			}
+		}
+	}
+	if len(clients) == 0 {
+		ce.Reply("You're not logged into any Slack teams")
+		return
+	}
+	ce.Reply("Resyncing ghosts in %d teams in the background", len(clients))
+	for _, client := range clients {
+		go func() {
+			log := client.UserLogin.Log.With().Str("action", "resync ghosts").Logger()
+			count, err := client.resyncTeamGhosts(log.WithContext(context.Background()))
+			if err != nil {
+				log.Err(err).Msg("Failed to resync ghosts")
+				ce.Reply("Failed to resync ghosts in team `%s`: %v", client.TeamID, err)
+			} else {
+				ce.Reply("Resynced %d ghosts in team `%s`", count, client.TeamID)
+			}
+		}()
+	}
+}
+
+var cmdHistory = &commands.FullHandler{
+	Func: fnHistory,
+	Name: "history",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Fetch messages sent in this chat within the given date range.",
+		Args:        "<_from date_> [_to date_]",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+const historyDateFormat = "2006-01-02"
+
+// portalLogin returns the Slack client of the given user which has access to the command portal.
+func portalLogin(ce *commands.Event) *SlackClient {
+	login, _, err := ce.Portal.FindPreferredLogin(ce.Ctx, ce.User, false)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to find login for portal")
+		return nil
+	} else if login == nil {
+		return nil
+	}
+	client, ok := login.Client.(*SlackClient)
+	if !ok || !client.IsLoggedIn() {
+		return nil
+	}
+	return client
+}
+
+func fnHistory(ce *commands.Event) {
+	if len(ce.Args) == 0 || len(ce.Args) > 2 {
+		ce.Reply("Usage: `$cmdprefix history <from YYYY-MM-DD> [to YYYY-MM-DD]`")
+		return
+	}
+	from, err := time.ParseInLocation(historyDateFormat, ce.Args[0], time.UTC)
+	if err != nil {
+		ce.Reply("Invalid start date `%s`, expected YYYY-MM-DD", ce.Args[0])
+		return
+	}
+	to := time.Now()
+	if len(ce.Args) > 1 {
+		to, err = time.ParseInLocation(historyDateFormat, ce.Args[1], time.UTC)
+		if err != nil {
+			ce.Reply("Invalid end date `%s`, expected YYYY-MM-DD", ce.Args[1])
+			return
+		}
+		// The end date is inclusive
+		to = to.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		ce.Reply("The end date must be after the start date")
+		return
+	}
+	_, channelID := slackid.ParsePortalID(ce.Portal.ID)
+	if channelID == "" {
+		ce.Reply("This command can only be used in channel portals")
+		return
+	}
+	client := portalLogin(ce)
+	if client == nil {
+		ce.Reply("You're not logged into the team of this chat")
+		return
+	}
+	count, err := client.fetchHistoryRange(ce.Ctx, ce.Portal, channelID, from, to)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to fetch history range")
+		ce.Reply("Failed to fetch messages: %v", err)
+		return
+	} else if count == 0 {
+		ce.Reply("No new messages found in that range")
+		return
+	}
+	ce.Reply("Queued %d messages for bridging", count)
+}
+
+var cmdWhoami = &commands.FullHandler{
+	Func: fnWhoami,
+	Name: "whoami",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAuth,
+		Description: "Show diagnostic information about your Slack logins.",
+		Args:        "[`--json`]",
+	},
+	RequiresLogin: true,
+}
+
+// describeTokenType returns a human-readable description of the kind of Slack token.
+func describeTokenType(token string) string {
+	switch {
+	case strings.HasPrefix(token, "xoxc-"):
+		return "user (browser session)"
+	case strings.HasPrefix(token, "xoxp-"):
+		return "user (OAuth)"
+	case strings.HasPrefix(token, "xoxb-"):
+		return "bot"
+	default:
+		return "unknown"
+	}
+}
+
+func (s *SlackClient) connectionType() string {
+	switch {
+	case s.RTM != nil:
+		return "RTM"
+	case s.SocketMode != nil:
+		return "socket mode"
+	case s.usesEventsAPI():
+		return "Events API"
+	default:
+		return "not connected"
+	}
+}
+
+// whoamiLogin is the output of the whoami command for a single login.
+type whoamiLogin struct {
+	LoginID    string     `json:"login_id"`
+	Name       string     `json:"name"`
+	TeamID     string     `json:"team_id"`
+	TeamName   string     `json:"team_name,omitempty"`
+	UserID     string     `json:"user_id"`
+	TokenType  string     `json:"token_type"`
+	Mode       string     `json:"mode"`
+	Connection string     `json:"connection"`
+	State      string     `json:"state"`
+	LastEvent  *time.Time `json:"last_event,omitempty"`
+}
+
+func fnWhoami(ce *commands.Event) {
+	_, asJSON := cutJSONFlag(ce.Args)
+	logins := []*whoamiLogin{}
+	for _, login := range ce.User.GetUserLogins() {
+		client, ok := login.Client.(*SlackClient)
+		if !ok {
+			continue
+		}
+		meta := login.Metadata.(*slackid.UserLoginMetadata)
+		info := &whoamiLogin{
+			LoginID:    string(login.ID),
+			Name:       login.RemoteName,
+			TeamID:     client.TeamID,
+			UserID:     client.UserID,
+			TokenType:  describeTokenType(meta.Token),
+			Mode:       "normal",
+			Connection: client.connectionType(),
+			State:      string(login.BridgeState.GetPrev().StateEvent),
+		}
+		if client.BootResp != nil {
+			info.TeamName = client.teamInfo().Name
+		}
+		if meta.ReadOnly {
+			info.Mode = "read-only"
+		} else if meta.UserToken != "" {
+			info.Mode = "hybrid"
+		}
+		if lastEvent := client.lastEventAt.Load(); lastEvent != 0 {
+			info.LastEvent = ptr.Ptr(time.UnixMilli(lastEvent).UTC())
+		}
+		logins = append(logins, info)
+	}
+	if asJSON {
+		replyJSON(ce, logins)
+		return
+	} else if len(logins) == 0 {
+		ce.Reply("You're not logged into any Slack teams")
+		return
+	}
+	var out strings.Builder
+	for _, info := range logins {
+		_, _ = fmt.Fprintf(&out, "#### %s\n\n", info.Name)
+		if info.TeamName != "" {
+			_, _ = fmt.Fprintf(&out, "* Team: %s (`%s`)\n", info.TeamName, info.TeamID)
+		} else {
+			_, _ = fmt.Fprintf(&out, "* Team: `%s`\n", info.TeamID)
+		}
+		_, _ = fmt.Fprintf(&out, "* User ID: `%s`\n", info.UserID)
+		_, _ = fmt.Fprintf(&out, "* Token type: %s\n", info.TokenType)
+		switch info.Mode {
+		case "read-only":
+			out.WriteString("* Mode: read-only mirror\n")
+		case "hybrid":
+			out.WriteString("* Mode: hybrid (events via app, read receipts and search via user token)\n")
+		}
+		_, _ = fmt.Fprintf(&out, "* Connection: %s, state `%s`\n", info.Connection, info.State)
+		if info.LastEvent != nil {
+			_, _ = fmt.Fprintf(&out, "* Last event: %s ago\n", time.Since(*info.LastEvent).Truncate(time.Second))
+		} else {
+			out.WriteString("* Last event: never\n")
+		}
+		out.WriteString("\n")
+	}
+	ce.Reply(strings.TrimSpace(out.String()))
+}
+
+// cmdSearch replaces the built-in search command to add JSON output. Without --json, it runs the built-in command.
+var cmdSearch = &commands.FullHandler{
+	Func: fnSearch,
+	Name: commands.CommandSearch.Name,
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Search for users on Slack",
+		Args:        "[`--json`] <_query_>",
+	},
+	RequiresLogin: true,
+	NetworkAPI:    commands.CommandSearch.NetworkAPI,
+}
+
+// searchResult is the output of the search command for a single user.
+type searchResult struct {
+	UserID   string    `json:"user_id"`
+	Name     string    `json:"name,omitempty"`
+	MXID     id.UserID `json:"mxid,omitempty"`
+	DMRoomID id.RoomID `json:"dm_room_id,omitempty"`
+}
+
+func fnSearch(ce *commands.Event) {
+	args, asJSON := cutJSONFlag(ce.Args)
+	if !asJSON {
+		commands.CommandSearch.Func(ce)
+		return
+	} else if len(args) == 0 {
+		ce.Reply("Usage: `$cmdprefix search --json <query>`")
+		return
+	}
+	client := lookupLogin(ce)
+	if client == nil {
+		ce.Reply("You're not logged into any Slack teams")
+		return
+	}
+	resp, err := client.SearchUsers(ce.Ctx, strings.Join(args, " "))
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to search for users")
+		ce.Reply("Failed to search for users: %v", err)
+		return
+	}
+	results := make([]*searchResult, len(resp))
+	for i, res := range resp {
+		_, userID := slackid.ParseUserID(res.UserID)
+		result := &searchResult{UserID: userID}
+		if res.UserInfo != nil && res.UserInfo.Name != nil {
+			result.Name = *res.UserInfo.Name
+		}
+		if res.Ghost != nil {
+			result.MXID = res.Ghost.Intent.GetMXID()
+		}
+		dmPortals, err := ce.Bridge.DB.Portal.GetAllDMsWith(ce.Ctx, res.UserID)
+		if err != nil {
+			ce.Log.Err(err).Str("user_id", userID).Msg("Failed to get DM portals")
+		}
+		for _, dmPortal := range dmPortals {
+			if dmPortal.Receiver == client.UserLogin.ID && dmPortal.MXID != "" {
+				result.DMRoomID = dmPortal.MXID
+				break
+			}
+		}
+		results[i] = result
+	}
+	replyJSON(ce, results)
+}
+
+var cmdPing = &commands.FullHandler{
+	Func: fnPing,
+	Name: "ping",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAuth,
+		Description: "Check that your Slack logins work and measure the Slack API latency.",
+	},
+	RequiresLogin: true,
+}
+
+func fnPing(ce *commands.Event) {
+	var out strings.Builder
+	for _, login := range ce.User.GetUserLogins() {
+		client, ok := login.Client.(*SlackClient)
+		if !ok {
+			continue
+		} else if !client.IsLoggedIn() {
+			_, _ = fmt.Fprintf(&out, "* %s: not logged in\n", login.RemoteName)
+			continue
+		}
+		start := time.Now()
+		_, err := client.Client.AuthTestContext(ce.Ctx)
+		latency := time.Since(start).Truncate(time.Millisecond)
+		if err != nil {
+			ce.Log.Err(err).Str("login_id", string(login.ID)).Msg("Failed to ping Slack")
+			_, _ = fmt.Fprintf(&out, "* %s: error after %s: %v\n", login.RemoteName, latency, err)
+		} else {
+			_, _ = fmt.Fprintf(&out, "* %s: OK, API latency %s\n", login.RemoteName, latency)
+		}
+	}
+	if out.Len() == 0 {
+		ce.Reply("You're not logged into any Slack teams")
+		return
+	}
+	ce.Reply(strings.TrimSpace(out.String()))
+}
+
+var cmdPortalInfo = &commands.FullHandler{
+	Func: fnPortalInfo,
+	Name: "portal-info",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Show the Slack channel and sync status of this room, for debugging.",
+		Args:        "[`--json`]",
+	},
+	RequiresPortal: true,
+}
+
+// formatSyncTime formats a sync timestamp for command output.
+func formatSyncTime(ts time.Time) string {
+	if ts.IsZero() || ts.Unix() == 0 {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", ts.UTC().Format(time.RFC3339), time.Since(ts).Truncate(time.Second))
+}
+
+// portalInfo is the output of the portal-info command.
+type portalInfo struct {
+	PortalID        string              `json:"portal_id"`
+	Receiver        string              `json:"receiver,omitempty"`
+	TeamID          string              `json:"team_id"`
+	ChannelID       string              `json:"channel_id,omitempty"`
+	Type            string              `json:"type"`
+	IsPrivate       bool                `json:"is_private"`
+	IsShared        bool                `json:"is_shared"`
+	IsArchived      bool                `json:"is_archived"`
+	ParentID        string              `json:"parent_id,omitempty"`
+	RelayLoginID    string              `json:"relay_login_id,omitempty"`
+	SlackMembers    *int                `json:"slack_members,omitempty"`
+	SlackError      string              `json:"slack_error,omitempty"`
+	MatrixMembers   *int                `json:"matrix_members,omitempty"`
+	InfoSyncedAt    *time.Time          `json:"info_synced_at,omitempty"`
+	MembersSyncedAt *time.Time          `json:"members_synced_at,omitempty"`
+	Backfill        *portalBackfillInfo `json:"backfill,omitempty"`
+}
+
+type portalBackfillInfo struct {
+	BatchCount      int    `json:"batch_count"`
+	UserLoginID     string `json:"user_login_id"`
+	OldestMessageID string `json:"oldest_message_id"`
+}
+
+func syncTimePtr(ts time.Time) *time.Time {
+	if ts.IsZero() || ts.Unix() == 0 {
+		return nil
+	}
+	return ptr.Ptr(ts.UTC())
+}
+
+func collectPortalInfo(ce *commands.Event) *portalInfo {
+	meta := ce.Portal.Metadata.(*slackid.PortalMetadata)
+	teamID, channelID := slackid.ParsePortalID(ce.Portal.ID)
+	info := &portalInfo{
+		PortalID:  string(ce.Portal.ID),
+		Receiver:  string(ce.Portal.Receiver),
+		TeamID:    teamID,
+		ChannelID: channelID,
+		Type:      "team_space",
+	}
+	if channelID == "" {
+		return info
+	}
+	info.Type = meta.ChannelType
+	info.IsPrivate, info.IsShared, info.IsArchived = meta.IsPrivate, meta.IsShared, meta.IsArchived
+	info.ParentID = string(ce.Portal.ParentKey.ID)
+	info.RelayLoginID = string(ce.Portal.RelayLoginID)
+	if client := portalLogin(ce); client != nil {
+		chatInfo, err := client.fetchChatInfoWithCache(ce.Ctx, channelID)
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to fetch channel info")
+			info.SlackError = err.Error()
+		} else {
+			info.SlackMembers = &chatInfo.NumMembers
+		}
+	}
+	members, err := ce.Bridge.Matrix.GetMembers(ce.Ctx, ce.Portal.MXID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get Matrix room members")
+	} else {
+		joined := 0
+		for _, member := range members {
+			if member.Membership == event.MembershipJoin {
+				joined++
+			}
+		}
+		info.MatrixMembers = &joined
+	}
+	info.InfoSyncedAt = syncTimePtr(meta.InfoSyncedAt.Time)
+	info.MembersSyncedAt = syncTimePtr(meta.MembersSyncedAt.Time)
+	task, err := ce.Bridge.DB.BackfillTask.GetNextForPortal(ce.Ctx, ce.Portal.PortalKey)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get backfill task")
+	} else if task != nil {
+		info.Backfill = &portalBackfillInfo{
+			BatchCount:      max(task.BatchCount, 0),
+			UserLoginID:     string(task.UserLoginID),
+			OldestMessageID: string(task.OldestMessageID),
+		}
+	}
+	return info
+}
+
+func fnPortalInfo(ce *commands.Event) {
+	_, asJSON := cutJSONFlag(ce.Args)
+	info := collectPortalInfo(ce)
+	if asJSON {
+		replyJSON(ce, info)
+		return
+	}
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, "* Portal ID: `%s`\n", info.PortalID)
+	if info.Receiver != "" {
+		_, _ = fmt.Fprintf(&out, "* Receiver: `%s`\n", info.Receiver)
+	}
+	_, _ = fmt.Fprintf(&out, "* Team ID: `%s`\n", info.TeamID)
+	if info.ChannelID == "" {
+		out.WriteString("* Type: team space\n")
+		ce.Reply(strings.TrimSpace(out.String()))
+		return
+	}
+	_, _ = fmt.Fprintf(&out, "* Channel ID: `%s`\n", info.ChannelID)
+	_, _ = fmt.Fprintf(&out, "* Type: %s (private: %t, shared: %t, archived: %t)\n", info.Type, info.IsPrivate, info.IsShared, info.IsArchived)
+	if info.ParentID != "" {
+		_, _ = fmt.Fprintf(&out, "* Parent portal: `%s`\n", info.ParentID)
+	}
+	if info.RelayLoginID != "" {
+		_, _ = fmt.Fprintf(&out, "* Relay login: `%s`\n", info.RelayLoginID)
+	}
+	if info.SlackError != "" {
+		_, _ = fmt.Fprintf(&out, "* Slack members: failed to fetch channel info: %s\n", info.SlackError)
+	} else if info.SlackMembers != nil {
+		_, _ = fmt.Fprintf(&out, "* Slack members: %d\n", *info.SlackMembers)
+	}
+	if info.MatrixMembers != nil {
+		_, _ = fmt.Fprintf(&out, "* Matrix members: %d joined\n", *info.MatrixMembers)
+	}
+	_, _ = fmt.Fprintf(&out, "* Info last synced: %s\n", formatSyncTime(ptr.Val(info.InfoSyncedAt)))
+	_, _ = fmt.Fprintf(&out, "* Members last synced: %s\n", formatSyncTime(ptr.Val(info.MembersSyncedAt)))
+	if info.Backfill == nil {
+		out.WriteString("* Backfill: no pending backfill\n")
+	} else {
+		_, _ = fmt.Fprintf(&out, "* Backfill: %d batches done, via login `%s`, oldest message `%s`\n", info.Backfill.BatchCount, info.Backfill.UserLoginID, info.Backfill.OldestMessageID)
+	}
+	ce.Reply(strings.TrimSpace(out.String()))
+}
+
+var cmdChannelLink = &commands.FullHandler{
+	Func:    fnChannelLink,
+	Name:    "channel-link",
+	Aliases: []string{"invite-link"},
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Get a link to this public channel, which other members of the Slack workspace can open to join it.",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+var errNoChannelLink = errors.New("channel links can't be used to join private channels or DMs")
+
+// channelLink returns a link to the given public channel. Slack has no API for per-channel invite links,
+// but workspace members who open the channel's link are offered to join it.
+func (s *SlackClient) channelLink(ctx context.Context, channelID string) (string, error) {
+	info, err := s.fetchChatInfoWithCache(ctx, channelID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch channel info: %w", err)
+	} else if info.IsIM || info.IsMpIM || info.IsPrivate || info.IsGroup {
+		return "", errNoChannelLink
+	}
+	if s.TeamPortal != nil {
+		if teamDomain := s.TeamPortal.Metadata.(*slackid.PortalMetadata).TeamDomain; teamDomain != "" {
+			return fmt.Sprintf("https://%s.slack.com/archives/%s", teamDomain, channelID), nil
+		}
+	}
+	return fmt.Sprintf("https://app.slack.com/client/%s/%s", s.TeamID, channelID), nil
+}
+
+func fnChannelLink(ce *commands.Event) {
+	_, channelID := slackid.ParsePortalID(ce.Portal.ID)
+	if channelID == "" {
+		ce.Reply("This command can only be used in channel portals")
+		return
+	}
+	client := portalLogin(ce)
+	if client == nil {
+		ce.Reply("You're not logged into the team of this chat")
+		return
+	}
+	link, err := client.channelLink(ce.Ctx, channelID)
+	if errors.Is(err, errNoChannelLink) {
+		ce.Reply("Private channels and DMs can't be joined via a link, invite members directly on Slack instead.")
+	} else if err != nil {
+		ce.Log.Err(err).Msg("Failed to get channel link")
+		ce.Reply("Failed to get channel link: %v", err)
+	} else {
+		ce.Reply("Workspace members can open %s to view and join this channel", link)
+	}
+}
+
+var cmdReloadConfig = &commands.FullHandler{
+	Func: fnReloadConfig,
+	Name: "reload-config",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Reload the Slack connector config without restarting the bridge.",
+	},
+	RequiresAdmin: true,
+}
+
+func fnReloadConfig(ce *commands.Event) {
+	changed, err := ce.Bridge.Network.(*SlackConnector).ReloadConfig(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to reload config")
+		ce.Reply("Failed to reload config: %v", err)
+	} else if len(changed) == 0 {
+		ce.Reply("Config reloaded, no changes found")
+	} else {
+		ce.Reply("Config reloaded, changed options: `%s`", strings.Join(changed, "`, `"))
+	}
+}
+
+var cmdLoginSettings = &commands.FullHandler{
+	Func: fnLoginSettings,
+	Name: "login-settings",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAuth,
+		Description: "View or change settings of a Slack login, overriding the bridge defaults.",
+		Args:        "[_login ID_] [<_setting_> <_value_ | `default`>]",
+	},
+	RequiresLogin: true,
+}
+
+func fnLoginSettings(ce *commands.Event) {
+	args := ce.Args
+	login := ce.User.GetDefaultLogin()
+	if len(args) > 0 {
+		if specifiedLogin := ce.Bridge.GetCachedUserLoginByID(networkid.UserLoginID(args[0])); specifiedLogin != nil && specifiedLogin.UserMXID == ce.User.MXID {
+			login = specifiedLogin
+			args = args[1:]
+		}
+	}
+	client, ok := login.Client.(*SlackClient)
+	if !ok {
+		ce.Reply("Login `%s` is not a Slack login", login.ID)
+		return
+	}
+	if len(args) == 0 {
+		var out strings.Builder
+		_, _ = fmt.Fprintf(&out, "Settings of `%s`:\n\n", login.ID)
+		for _, setting := range loginSettings {
+			_, _ = fmt.Fprintf(&out, "* `%s`: `%s` - %s\n", setting.Name, setting.Get(client), setting.Description)
+		}
+		ce.Reply(out.String())
+		return
+	} else if len(args) != 2 {
+		ce.Reply("Usage: `$cmdprefix login-settings [login ID] [<setting> <value|default>]`")
+		return
+	}
+	setting := getLoginSetting(strings.ToLower(args[0]))
+	if setting == nil {
+		ce.Reply("Unknown setting `%s`", args[0])
+		return
+	}
+	value := strings.ToLower(args[1])
+	if value == "default" {
+		value = ""
+	}
+	err := setting.Set(client.settings(), value)
+	if err != nil {
+		ce.Reply("Failed to change setting: %v", err)
+		return
+	}
+	err = login.Save(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to save login after changing settings")
+		ce.Reply("Failed to save login: %v", err)
+		return
+	}
+	ce.Reply("`%s` is now `%s`", setting.Name, setting.Get(client))
+}
+
+var cmdFixPortals = &commands.FullHandler{
+	Func: fnFixPortals,
+	Name: "fix-portals",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Find portals whose Matrix room was deleted or no longer contains the bridge bot, and clear them so that a new room is created.",
+		Args:        "[`--dry-run`]",
+	},
+	RequiresAdmin: true,
+}
+
+func fnFixPortals(ce *commands.Event) {
+	dryRun := len(ce.Args) > 0 && ce.Args[0] == "--dry-run"
+	stale, err := ce.Bridge.Network.(*SlackConnector).FixPortals(ce.Ctx, dryRun)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to fix portals")
+		ce.Reply("Failed to fix portals: %v", err)
+		return
+	} else if len(stale) == 0 {
+		ce.Reply("All portal rooms are fine")
+		return
+	}
+	var out strings.Builder
+	if dryRun {
+		_, _ = fmt.Fprintf(&out, "Found %d portals with missing rooms:\n\n", len(stale))
+	} else {
+		_, _ = fmt.Fprintf(&out, "Cleared %d portals with missing rooms, they will get a new room on the next activity:\n\n", len(stale))
+	}
+	for _, portal := range stale {
+		_, _ = fmt.Fprintf(&out, "* %s (`%s`)\n", portal.Name, portal.ID)
+	}
+	ce.Reply(out.String())
+}
+
+var cmdAuditLog = &commands.FullHandler{
+	Func: fnAuditLog,
+	Name: "audit-log",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Show the most recent entries in the audit log of bridged actions.",
+		Args:        "[_count_]",
+	},
+	RequiresAdmin: true,
+}
+
+const defaultAuditLogCount = 20
+
+func fnAuditLog(ce *commands.Event) {
+	slackConn := ce.Bridge.Network.(*SlackConnector)
+	if !slackConn.currentConfig().AuditLog.Enabled {
+		ce.Reply("The audit log is not enabled in the config")
+		return
+	}
+	count := defaultAuditLogCount
+	if len(ce.Args) > 0 {
+		var err error
+		count, err = strconv.Atoi(ce.Args[0])
+		if err != nil || count <= 0 {
+			ce.Reply("Usage: `$cmdprefix audit-log [count]`")
+			return
+		}
+	}
+	entries, err := slackConn.DB.AuditLog.GetRecent(ce.Ctx, count)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get audit log entries")
+		ce.Reply("Failed to get audit log entries: %v", err)
+		return
+	} else if len(entries) == 0 {
+		ce.Reply("The audit log is empty")
+		return
+	}
+	var out strings.Builder
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(&out, "* %s `%s` by %s via `%s`", entry.Timestamp.UTC().Format(time.RFC3339), entry.Action, entry.UserMXID, entry.LoginID)
+		if entry.ChannelID != "" {
+			_, _ = fmt.Fprintf(&out, " in `%s`", entry.ChannelID)
+		}
+		if entry.Sender != "" {
+			_, _ = fmt.Fprintf(&out, ", sender `%s`", entry.Sender)
+		}
+		if entry.Target != "" {
+			_, _ = fmt.Fprintf(&out, ", message `%s`", entry.Target)
+		}
+		out.WriteByte('\n')
+	}
+	ce.Reply(out.String())
+}
+
+var cmdPurge = &commands.FullHandler{
+	Func: fnPurge,
+	Name: "purge",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Delete all data of a Slack team or user from the bridge database, e.g. for data removal requests. Matrix rooms and profiles are not affected.",
+		Args:        "[`--dry-run`] <_team ID_> [_user ID_]",
+	},
+	RequiresAdmin: true,
+}
+
+func fnPurge(ce *commands.Event) {
+	args := ce.Args
+	dryRun := len(args) > 0 && args[0] == "--dry-run"
+	if dryRun {
+		args = args[1:]
+	}
+	if len(args) == 0 || len(args) > 2 {
+		ce.Reply("Usage: `$cmdprefix purge [--dry-run] <team ID> [user ID]`")
+		return
+	}
+	slackConn := ce.Bridge.Network.(*SlackConnector)
+	var report PurgeReport
+	var err error
+	if len(args) == 1 {
+		report, err = slackConn.PurgeTeam(ce.Ctx, args[0], dryRun)
+	} else {
+		report, err = slackConn.PurgeUser(ce.Ctx, args[0], args[1], dryRun)
+	}
+	if errors.Is(err, ErrPurgeActiveLogin) {
+		ce.Reply("Can't purge data while there are logins for it, log them out first")
+		return
+	} else if err != nil {
+		ce.Log.Err(err).Msg("Failed to purge data")
+		ce.Reply("Failed to purge data: %v", err)
+		return
+	}
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	ce.Reply(
+		"%s %d portals, %d ghosts, %d messages, %d reactions, %d emojis and %d audit log entries",
+		verb, report.Portals, report.Ghosts, report.Messages, report.Reactions, report.Emojis, report.AuditEntries,
+	)
+	if !dryRun {
+		ce.Reply("Restart the bridge to make sure no purged data remains in memory")
+	}
+}
+
+var cmdPruneEmojis = &commands.FullHandler{
+	Func: fnPruneEmojis,
+	Name: "prune-emojis",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Delete custom emojis of teams that no longer have any logins and vacuum the database.",
+		Args:        "[`--dry-run`]",
+	},
+	RequiresAdmin: true,
+}
+
+const maxListedOrphanedMXCs = 50
+
+func fnPruneEmojis(ce *commands.Event) {
+	dryRun := len(ce.Args) > 0 && ce.Args[0] == "--dry-run"
+	report, err := ce.Bridge.Network.(*SlackConnector).PruneEmojis(ce.Ctx, dryRun)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to prune emojis")
+		ce.Reply("Failed to prune emojis: %v", err)
+		return
+	} else if len(report.Teams) == 0 {
+		ce.Reply("All stored emojis belong to teams with logins, nothing to prune")
+		return
+	}
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, "%s %d emojis of %d teams without logins (`%s`)", verb, report.Emojis, len(report.Teams), strings.Join(report.Teams, "`, `"))
+	if len(report.OrphanedMXCs) > 0 {
+		_, _ = fmt.Fprintf(&out, "\n\n%d uploaded emoji images are no longer used and can be deleted from the media repository:\n\n", len(report.OrphanedMXCs))
+		for i, mxc := range report.OrphanedMXCs {
+			if i == maxListedOrphanedMXCs {
+				_, _ = fmt.Fprintf(&out, "* ...and %d more (see logs)\n", len(report.OrphanedMXCs)-i)
+				break
+			}
+			_, _ = fmt.Fprintf(&out, "* `%s`\n", mxc)
+		}
+	}
+	ce.Reply(out.String())
+}
+
+var cmdSlackAdmin = &commands.FullHandler{
+	Func: fnSlackAdmin,
+	Name: "slack-admin",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Manage the Slack workspace of this room using Slack's admin APIs. Requires an owner or admin account in an Enterprise Grid organization.",
+		Args:        "<`rename` _channel ID_ _name_ | `retention` _channel ID_ <_days_ | `default`> | `invite` _email_ _channel IDs..._>",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+const slackAdminUsage = "Usage:\n\n" +
+	"* `$cmdprefix slack-admin rename <channel ID> <new name>`\n" +
+	"* `$cmdprefix slack-admin retention <channel ID> <days|default>`\n" +
+	"* `$cmdprefix slack-admin invite <email> <channel ID> [channel ID...]`"
+
+func fnSlackAdmin(ce *commands.Event) {
+	if len(ce.Args) < 3 {
+		ce.Reply(slackAdminUsage)
+		return
+	}
+	teamID, _ := slackid.ParsePortalID(ce.Portal.ID)
+	client := findLoginInTeam(ce.User, teamID)
+	if client == nil {
+		ce.Reply("You're not logged into the team of this room")
+		return
+	} else if client.isReadOnly() {
+		ce.Reply(readOnlyCommandReply)
+		return
+	}
+	admin, err := client.workspaceAdmin()
+	if err != nil {
+		ce.Reply("Can't use admin commands: %v", err)
+		return
+	}
+	switch strings.ToLower(ce.Args[0]) {
+	case "rename":
+		channelID, err := parseChannelArg(ce.Args[1])
+		if err != nil {
+			ce.Reply("%v", err)
+			return
+		}
+		name := strings.Join(ce.Args[2:], "-")
+		err = admin.RenameChannel(ce.Ctx, channelID, name)
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to rename channel")
+			ce.Reply("Failed to rename channel: %v", err)
+			return
+		}
+		ce.Reply("Renamed `%s` to `%s`", channelID, name)
+	case "retention":
+		channelID, err := parseChannelArg(ce.Args[1])
+		if err != nil {
+			ce.Reply("%v", err)
+			return
+		}
+		days, err := parseRetentionDays(ce.Args[2])
+		if err != nil {
+			ce.Reply("%v", err)
+			return
+		}
+		if days == 0 {
+			err = admin.RemoveChannelRetention(ce.Ctx, channelID)
+		} else {
+			err = admin.SetChannelRetention(ce.Ctx, channelID, days)
+		}
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to change channel retention")
+			ce.Reply("Failed to change retention: %v", err)
+		} else if days == 0 {
+			ce.Reply("`%s` now uses the workspace's default retention", channelID)
+		} else {
+			ce.Reply("Messages in `%s` will now be kept for %d days", channelID, days)
+		}
+	case "invite":
+		email := ce.Args[1]
+		if !strings.Contains(email, "@") {
+			ce.Reply("Invalid email address `%s`", email)
+			return
+		}
+		channelIDs := make([]string, len(ce.Args)-2)
+		for i, arg := range ce.Args[2:] {
+			channelIDs[i], err = parseChannelArg(arg)
+			if err != nil {
+				ce.Reply("%v", err)
+				return
+			}
+		}
+		err = admin.InviteUser(ce.Ctx, teamID, email, channelIDs)
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to invite user")
+			ce.Reply("Failed to invite `%s`: %v", email, err)
+			return
+		}
+		ce.Reply("Invited `%s` to the workspace", email)
+	default:
+		ce.Reply(slackAdminUsage)
+	}
+}
+
+var cmdBridgeChannel = &commands.FullHandler{
+	Func: fnBridgeChannel,
+	Name: "bridge-channel",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionGeneral,
+		Description: "Create a portal room for a Slack channel that isn't bridged yet.",
+		Args:        "<_channel ID_> [_team ID_]",
+	},
+	RequiresLogin: true,
+}
+
+func fnBridgeChannel(ce *commands.Event) {
+	if len(ce.Args) < 1 || len(ce.Args) > 2 {
+		ce.Reply("Usage: `$cmdprefix bridge-channel <channel ID> [team ID]`")
+		return
+	}
+	channelID, err := parseChannelArg(ce.Args[0])
+	if err != nil {
+		ce.Reply("%v", err)
+		return
+	}
+	var client *SlackClient
+	if len(ce.Args) == 2 {
+		client = findLoginInTeam(ce.User, strings.ToUpper(ce.Args[1]))
+	} else {
+		client = lookupLogin(ce)
+	}
+	if client == nil {
+		ce.Reply("You're not logged into that team")
+		return
+	}
+	info, err := client.fetchChatInfoWithCache(ce.Ctx, channelID)
+	if err != nil {
+		ce.Log.Err(err).Str("channel_id", channelID).Msg("Failed to fetch channel info")
+		ce.Reply("Failed to fetch channel info: %v", err)
+		return
+	}
+	portal, err := ce.Bridge.GetExistingPortalByKey(ce.Ctx, client.makePortalKey(info))
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get portal")
+		ce.Reply("Failed to get portal: %v", err)
+		return
+	} else if portal != nil && portal.MXID != "" {
+		ce.Reply("`%s` is already bridged to [%s](%s)", channelID, portal.Name, portal.MXID.URI(ce.Bridge.Matrix.ServerName()).MatrixToURL())
+		return
+	}
+	evt, err := client.wrapCreatePortal(ce.Ctx, channelID, client.UserID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to prepare portal creation")
+		ce.Reply("Failed to bridge channel: %v", err)
+		return
+	}
+	ce.Bridge.QueueRemoteEvent(client.UserLogin, evt)
+	ce.Reply("Creating a portal room for `%s`, you'll be invited shortly", channelID)
+}
@@ -17,13 +17,23 @@
 package connector
 
 import (
+	"cmp"
 	_ "embed"
+	"fmt"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/slack-go/slack"
 	up "go.mau.fi/util/configupgrade"
 	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/msgconv"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
 //go:embed example-config.yaml
@@ -31,6 +41,7 @@ var ExampleConfig string
 
 type Config struct {
 	DisplaynameTemplate string `yaml:"displayname_template"`
+	DisplaynameSource   string `yaml:"displayname_source"`
 	ChannelNameTemplate string `yaml:"channel_name_template"`
 	TeamNameTemplate    string `yaml:"team_name_template"`
 
@@ -39,17 +50,188 @@ type Config struct {
 	ParticipantSyncCount        int  `yaml:"participant_sync_count"`
 	ParticipantSyncOnlyOnCreate bool `yaml:"participant_sync_only_on_create"`
 	MuteChannelsByDefault       bool `yaml:"mute_channels_by_default"`
+	SlackbotRemindersInThreads  bool `yaml:"slackbot_reminders_in_threads"`
+	ThreadSummaries             bool `yaml:"thread_summaries"`
+	EmojiRoomPack               bool `yaml:"emoji_room_pack"`
+	UploadMatrixEmojis          bool `yaml:"upload_matrix_emojis"`
+	ChannelAliases              bool `yaml:"channel_aliases"`
+	BridgeOwnMessages           bool `yaml:"bridge_own_messages"`
+	SyncDrafts                  bool `yaml:"sync_drafts"`
+	EncryptConvertedChannels    bool `yaml:"encrypt_converted_channels"`
+	EditConflictCheck           bool `yaml:"edit_conflict_check"`
+	TypingInChannels            bool `yaml:"typing_in_channels"`
+	UnbridgedNotifications      bool `yaml:"unbridged_notifications"`
+
+	LeavePortalBehavior string `yaml:"leave_portal_behavior"`
+	ClosedDMBehavior    string `yaml:"closed_dm_behavior"`
+	EncryptionPolicy    string `yaml:"encryption_policy"`
+	NewChannelPortals   string `yaml:"new_channel_portals"`
+	Timezone            string `yaml:"timezone"`
+	TokenEncryptionKey  string `yaml:"token_encryption_key"`
 
-	Backfill BackfillConfig `yaml:"backfill"`
+	SyncWorkers             int           `yaml:"sync_workers"`
+	EmojiSyncWorkers        int           `yaml:"emoji_sync_workers"`
+	MetadataRefreshInterval time.Duration `yaml:"metadata_refresh_interval"`
+	EventQueueSize          int           `yaml:"event_queue_size"`
+	PortalCheckInterval     time.Duration `yaml:"portal_check_interval"`
+
+	Backfill        BackfillConfig          `yaml:"backfill"`
+	Translation     TranslationConfig       `yaml:"translation"`
+	ImageProcessing msgconv.ImageProcessing `yaml:"image_processing"`
+	StartupSync     StartupSyncConfig       `yaml:"startup_sync"`
+	GhostSync       GhostSyncConfig         `yaml:"ghost_sync"`
+	PowerLevels     PowerLevelsConfig       `yaml:"power_levels"`
+	Tracing         TracingConfig           `yaml:"tracing"`
+	AuditLog        AuditLogConfig          `yaml:"audit_log"`
+	EventsAPI       EventsAPIConfig         `yaml:"events_api"`
+	Sharding        ShardingConfig          `yaml:"sharding"`
+	SecretBackend   SecretBackendConfig     `yaml:"secret_backend"`
 
 	displaynameTemplate *template.Template `yaml:"-"`
 	channelNameTemplate *template.Template `yaml:"-"`
 	teamNameTemplate    *template.Template `yaml:"-"`
+	timezone            *time.Location     `yaml:"-"`
 }
 
 type BackfillConfig struct {
 	ConversationCount int  `yaml:"conversation_count"`
 	Enabled           bool `yaml:"enabled"`
+
+	CatchupBeforeLive bool          `yaml:"catchup_before_live"`
+	CatchupTimeout    time.Duration `yaml:"catchup_timeout"`
+	ReplayWindow      time.Duration `yaml:"replay_window"`
+
+	InitialMessages BackfillInitialMessagesConfig `yaml:"initial_messages"`
+
+	MaxConcurrency    int                    `yaml:"max_concurrency"`
+	MessagesPerSecond float64                `yaml:"messages_per_second"`
+	Schedule          BackfillScheduleConfig `yaml:"schedule"`
+}
+
+type BackfillInitialMessagesConfig struct {
+	DM      int `yaml:"dm"`
+	GroupDM int `yaml:"group_dm"`
+	Channel int `yaml:"channel"`
+}
+
+// Limit returns the number of messages to backfill when creating a portal of the given type.
+// Zero means the bridge-wide max_initial_messages is used, negative values disable the initial backfill.
+func (bim *BackfillInitialMessagesConfig) Limit(roomType database.RoomType, defaultLimit int) int {
+	var limit int
+	switch roomType {
+	case database.RoomTypeDM:
+		limit = bim.DM
+	case database.RoomTypeGroupDM:
+		limit = bim.GroupDM
+	case database.RoomTypeDefault:
+		limit = bim.Channel
+	}
+	if limit == 0 {
+		return defaultLimit
+	}
+	return max(limit, 0)
+}
+
+type BackfillScheduleConfig struct {
+	Start    string `yaml:"start"`
+	End      string `yaml:"end"`
+	Timezone string `yaml:"timezone"`
+}
+
+type SecretBackendConfig struct {
+	Type  string      `yaml:"type"`
+	Vault VaultConfig `yaml:"vault"`
+}
+
+type VaultConfig struct {
+	Address    string `yaml:"address"`
+	Token      string `yaml:"token"`
+	Namespace  string `yaml:"namespace"`
+	Mount      string `yaml:"mount"`
+	PathPrefix string `yaml:"path_prefix"`
+}
+
+type TranslationConfig struct {
+	Backend        string `yaml:"backend"`
+	URL            string `yaml:"url"`
+	APIKey         string `yaml:"api_key"`
+	TargetLanguage string `yaml:"target_language"`
+}
+
+type StartupSyncConfig struct {
+	MaxJitter      time.Duration `yaml:"max_jitter"`
+	MaxConcurrency int           `yaml:"max_concurrency"`
+}
+
+type GhostSyncConfig struct {
+	BatchSize        int     `yaml:"batch_size"`
+	MaxConcurrency   int     `yaml:"max_concurrency"`
+	UpdatesPerSecond float64 `yaml:"updates_per_second"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type AuditLogConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+type EventsAPIConfig struct {
+	SigningSecret string `yaml:"signing_secret"`
+}
+
+type ShardingConfig struct {
+	Shards   int           `yaml:"shards"`
+	LeaseTTL time.Duration `yaml:"lease_ttl"`
+}
+
+type PowerLevelsConfig struct {
+	UsersDefault  *int              `yaml:"users_default"`
+	EventsDefault *int              `yaml:"events_default"`
+	StateDefault  *int              `yaml:"state_default"`
+	Invite        *int              `yaml:"invite"`
+	Kick          *int              `yaml:"kick"`
+	Ban           *int              `yaml:"ban"`
+	Redact        *int              `yaml:"redact"`
+	Events        map[string]int    `yaml:"events"`
+	Users         map[id.UserID]int `yaml:"users"`
+}
+
+// Overrides converts the config into power level overrides for new portal rooms.
+// Returns nil if nothing is configured, in which case the bridgev2 defaults are used.
+func (plc *PowerLevelsConfig) Overrides() *bridgev2.PowerLevelOverrides {
+	if plc.UsersDefault == nil && plc.EventsDefault == nil && plc.StateDefault == nil && plc.Invite == nil &&
+		plc.Kick == nil && plc.Ban == nil && plc.Redact == nil && len(plc.Events) == 0 && len(plc.Users) == 0 {
+		return nil
+	}
+	overrides := &bridgev2.PowerLevelOverrides{
+		UsersDefault:  plc.UsersDefault,
+		EventsDefault: plc.EventsDefault,
+		StateDefault:  plc.StateDefault,
+		Invite:        plc.Invite,
+		Kick:          plc.Kick,
+		Ban:           plc.Ban,
+		Redact:        plc.Redact,
+	}
+	if len(plc.Events) > 0 {
+		overrides.Events = make(map[event.Type]int, len(plc.Events))
+		for evtType, level := range plc.Events {
+			overrides.Events[event.Type{Type: evtType, Class: event.StateEventType}] = level
+		}
+	}
+	if len(plc.Users) > 0 {
+		overrides.Custom = func(content *event.PowerLevelsEventContent) (changed bool) {
+			for userID, level := range plc.Users {
+				changed = content.EnsureUserLevel(userID, level) || changed
+			}
+			return
+		}
+	}
+	return overrides
 }
 
 type umConfig Config
@@ -72,6 +254,51 @@ func (c *Config) UnmarshalYAML(node *yaml.Node) error {
 	if err != nil {
 		return err
 	}
+	c.timezone = nil
+	if c.Timezone != "" {
+		c.timezone, err = time.LoadLocation(c.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	switch c.DisplaynameSource {
+	case "", DisplaynameSourceDisplayName, DisplaynameSourceRealName, DisplaynameSourceDisplayAndReal:
+	default:
+		return fmt.Errorf("invalid displayname_source %q", c.DisplaynameSource)
+	}
+	if c.TokenEncryptionKey != "" {
+		if _, err = slackid.ParseTokenEncryptionKey(c.TokenEncryptionKey); err != nil {
+			return fmt.Errorf("invalid token_encryption_key: %w", err)
+		}
+	}
+	switch c.SecretBackend.Type {
+	case "", SecretBackendDatabase, SecretBackendVault:
+	default:
+		return fmt.Errorf("invalid secret_backend.type %q", c.SecretBackend.Type)
+	}
+	switch c.EncryptionPolicy {
+	case "", EncryptionPolicyDefault, EncryptionPolicyPrivate, EncryptionPolicyAll:
+	default:
+		return fmt.Errorf("invalid encryption_policy %q", c.EncryptionPolicy)
+	}
+	switch c.LeavePortalBehavior {
+	case "", LeaveBehaviorNothing, LeaveBehaviorLeave, LeaveBehaviorArchive:
+	default:
+		return fmt.Errorf("invalid leave_portal_behavior %q", c.LeavePortalBehavior)
+	}
+	switch c.ClosedDMBehavior {
+	case "", LeaveBehaviorNothing, LeaveBehaviorLeave, LeaveBehaviorArchive:
+	default:
+		return fmt.Errorf("invalid closed_dm_behavior %q", c.ClosedDMBehavior)
+	}
+	switch c.NewChannelPortals {
+	case "", NewChannelPortalsOwn, NewChannelPortalsAll, NewChannelPortalsNone:
+	default:
+		return fmt.Errorf("invalid new_channel_portals %q", c.NewChannelPortals)
+	}
+	if c.Sharding.Shards > 1 && c.Sharding.LeaseTTL < 3*time.Second {
+		return fmt.Errorf("sharding.lease_ttl must be at least 3 seconds")
+	}
 	return nil
 }
 
@@ -81,12 +308,39 @@ func executeTemplate(tpl *template.Template, data any) string {
 	return strings.TrimSpace(buffer.String())
 }
 
+const (
+	DisplaynameSourceDisplayName    = "display_name"
+	DisplaynameSourceRealName       = "real_name"
+	DisplaynameSourceDisplayAndReal = "display_and_real"
+)
+
+// legacyDefaultDisplaynameTemplate is the default displayname template from before displayname_source existed.
+// Configs still using it are migrated to the new default, which respects displayname_source.
+const legacyDefaultDisplaynameTemplate = `{{or .Profile.DisplayName .Profile.RealName .Name}}{{if .IsBot}} (bot){{end}}`
+
 type DisplaynameParams struct {
 	*slack.User
 	Team *slack.TeamInfo
+	// PreferredName is the display name and/or real name of the user, depending on the displayname_source option.
+	PreferredName string
+}
+
+// preferredName picks the name of the user to use in displaynames based on the given displayname source.
+func preferredName(user *slack.User, source string) string {
+	displayName, realName := user.Profile.DisplayName, user.Profile.RealName
+	switch source {
+	case DisplaynameSourceRealName:
+		return cmp.Or(realName, displayName, user.Name)
+	case DisplaynameSourceDisplayAndReal:
+		if displayName != "" && realName != "" && displayName != realName {
+			return fmt.Sprintf("%s (%s)", displayName, realName)
+		}
+	}
+	return cmp.Or(displayName, realName, user.Name)
 }
 
 func (c *Config) FormatDisplayname(user *DisplaynameParams) string {
+	user.PreferredName = preferredName(user.User, c.DisplaynameSource)
 	return executeTemplate(c.displaynameTemplate, user)
 }
 
@@ -120,12 +374,25 @@ func (c *Config) FormatTeamName(params *slack.TeamInfo) string {
 	return executeTemplate(c.teamNameTemplate, params)
 }
 
+// converterOptions returns the message converter settings from the config.
+func (c *Config) converterOptions() *msgconv.Options {
+	return &msgconv.Options{
+		ChannelAliases:    c.ChannelAliases,
+		TranslationTarget: c.Translation.TargetLanguage,
+		ImageProcessing:   c.ImageProcessing,
+		Timezone:          c.timezone,
+	}
+}
+
 func (s *SlackConnector) GetConfig() (example string, data any, upgrader up.Upgrader) {
 	return ExampleConfig, &s.Config, up.SimpleUpgrader(upgradeConfig)
 }
 
 func upgradeConfig(helper up.Helper) {
-	helper.Copy(up.Str, "displayname_template")
+	if tpl, ok := helper.Get(up.Str, "displayname_template"); ok && tpl != legacyDefaultDisplaynameTemplate {
+		helper.Copy(up.Str, "displayname_template")
+	}
+	helper.Copy(up.Str, "displayname_source")
 	helper.Copy(up.Str, "channel_name_template")
 	helper.Copy(up.Str, "team_name_template")
 	helper.Copy(up.Bool, "custom_emoji_reactions")
@@ -133,5 +400,73 @@ func upgradeConfig(helper up.Helper) {
 	helper.Copy(up.Int, "participant_sync_count")
 	helper.Copy(up.Bool, "participant_sync_only_on_create")
 	helper.Copy(up.Bool, "mute_channels_by_default")
+	helper.Copy(up.Bool, "slackbot_reminders_in_threads")
+	helper.Copy(up.Bool, "thread_summaries")
+	helper.Copy(up.Bool, "emoji_room_pack")
+	helper.Copy(up.Bool, "upload_matrix_emojis")
+	helper.Copy(up.Bool, "channel_aliases")
+	helper.Copy(up.Bool, "bridge_own_messages")
+	helper.Copy(up.Bool, "sync_drafts")
+	helper.Copy(up.Bool, "encrypt_converted_channels")
+	helper.Copy(up.Bool, "edit_conflict_check")
+	helper.Copy(up.Bool, "typing_in_channels")
+	helper.Copy(up.Bool, "unbridged_notifications")
+	helper.Copy(up.Str, "encryption_policy")
+	helper.Copy(up.Str, "leave_portal_behavior")
+	helper.Copy(up.Str, "closed_dm_behavior")
+	helper.Copy(up.Str, "new_channel_portals")
+	helper.Copy(up.Str|up.Null, "timezone")
+	helper.Copy(up.Str|up.Null, "token_encryption_key")
+	helper.Copy(up.Str, "secret_backend", "type")
+	helper.Copy(up.Str|up.Null, "secret_backend", "vault", "address")
+	helper.Copy(up.Str|up.Null, "secret_backend", "vault", "token")
+	helper.Copy(up.Str|up.Null, "secret_backend", "vault", "namespace")
+	helper.Copy(up.Str, "secret_backend", "vault", "mount")
+	helper.Copy(up.Str|up.Null, "secret_backend", "vault", "path_prefix")
+	helper.Copy(up.Int, "sync_workers")
+	helper.Copy(up.Int, "emoji_sync_workers")
+	helper.Copy(up.Str, "metadata_refresh_interval")
+	helper.Copy(up.Int, "event_queue_size")
+	helper.Copy(up.Str, "portal_check_interval")
 	helper.Copy(up.Int, "backfill", "conversation_count")
+	helper.Copy(up.Bool, "backfill", "catchup_before_live")
+	helper.Copy(up.Str, "backfill", "catchup_timeout")
+	helper.Copy(up.Str, "backfill", "replay_window")
+	helper.Copy(up.Int, "backfill", "initial_messages", "dm")
+	helper.Copy(up.Int, "backfill", "initial_messages", "group_dm")
+	helper.Copy(up.Int, "backfill", "initial_messages", "channel")
+	helper.Copy(up.Int, "backfill", "max_concurrency")
+	helper.Copy(up.Float|up.Int, "backfill", "messages_per_second")
+	helper.Copy(up.Str|up.Null, "backfill", "schedule", "start")
+	helper.Copy(up.Str|up.Null, "backfill", "schedule", "end")
+	helper.Copy(up.Str|up.Null, "backfill", "schedule", "timezone")
+	helper.Copy(up.Str|up.Null, "translation", "backend")
+	helper.Copy(up.Str|up.Null, "translation", "url")
+	helper.Copy(up.Str|up.Null, "translation", "api_key")
+	helper.Copy(up.Str|up.Null, "translation", "target_language")
+	helper.Copy(up.Bool, "image_processing", "strip_metadata")
+	helper.Copy(up.Bool, "image_processing", "convert_heic")
+	helper.Copy(up.Int, "image_processing", "max_dimension")
+	helper.Copy(up.Str, "startup_sync", "max_jitter")
+	helper.Copy(up.Int, "startup_sync", "max_concurrency")
+	helper.Copy(up.Int, "ghost_sync", "batch_size")
+	helper.Copy(up.Int, "ghost_sync", "max_concurrency")
+	helper.Copy(up.Float|up.Int, "ghost_sync", "updates_per_second")
+	helper.Copy(up.Int|up.Null, "power_levels", "users_default")
+	helper.Copy(up.Int|up.Null, "power_levels", "events_default")
+	helper.Copy(up.Int|up.Null, "power_levels", "state_default")
+	helper.Copy(up.Int|up.Null, "power_levels", "invite")
+	helper.Copy(up.Int|up.Null, "power_levels", "kick")
+	helper.Copy(up.Int|up.Null, "power_levels", "ban")
+	helper.Copy(up.Int|up.Null, "power_levels", "redact")
+	helper.Copy(up.Map, "power_levels", "events")
+	helper.Copy(up.Map, "power_levels", "users")
+	helper.Copy(up.Str|up.Null, "tracing", "exporter")
+	helper.Copy(up.Str|up.Null, "tracing", "endpoint")
+	helper.Copy(up.Float|up.Int, "tracing", "sample_ratio")
+	helper.Copy(up.Bool, "audit_log", "enabled")
+	helper.Copy(up.Str, "audit_log", "retention")
+	helper.Copy(up.Str, "events_api", "signing_secret")
+	helper.Copy(up.Int, "sharding", "shards")
+	helper.Copy(up.Str, "sharding", "lease_ttl")
 }
@@ -0,0 +1,92 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	up "go.mau.fi/util/configupgrade"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPreferredName(t *testing.T) {
+	makeUser := func(name, displayName, realName string) *slack.User {
+		return &slack.User{Name: name, Profile: slack.UserProfile{DisplayName: displayName, RealName: realName}}
+	}
+	type testCase struct {
+		name     string
+		source   string
+		user     *slack.User
+		expected string
+	}
+	testCases := []testCase{
+		{"DefaultDisplayName", "", makeUser("alice", "Ali", "Alice Smith"), "Ali"},
+		{"DisplayName", DisplaynameSourceDisplayName, makeUser("alice", "Ali", "Alice Smith"), "Ali"},
+		{"DisplayNameFallback", DisplaynameSourceDisplayName, makeUser("alice", "", "Alice Smith"), "Alice Smith"},
+		{"RealName", DisplaynameSourceRealName, makeUser("alice", "Ali", "Alice Smith"), "Alice Smith"},
+		{"RealNameFallback", DisplaynameSourceRealName, makeUser("alice", "Ali", ""), "Ali"},
+		{"UsernameFallback", DisplaynameSourceRealName, makeUser("alice", "", ""), "alice"},
+		{"Both", DisplaynameSourceDisplayAndReal, makeUser("alice", "Ali", "Alice Smith"), "Ali (Alice Smith)"},
+		{"BothSame", DisplaynameSourceDisplayAndReal, makeUser("alice", "Alice", "Alice"), "Alice"},
+		{"BothMissingDisplay", DisplaynameSourceDisplayAndReal, makeUser("alice", "", "Alice Smith"), "Alice Smith"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, preferredName(tc.user, tc.source))
+		})
+	}
+}
+
+func TestConfig_InvalidDisplaynameSource(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte("displayname_template: '{{.PreferredName}}'\ndisplayname_source: nickname\n"), &cfg)
+	assert.ErrorContains(t, err, "invalid displayname_source")
+}
+
+func TestUpgradeConfig_LegacyDisplaynameTemplate(t *testing.T) {
+	upgrade := func(userConfig string) string {
+		var base, cfg yaml.Node
+		require.NoError(t, yaml.Unmarshal([]byte(ExampleConfig), &base))
+		require.NoError(t, yaml.Unmarshal([]byte(userConfig), &cfg))
+		helper := up.NewHelper(&base, &cfg)
+		upgradeConfig(helper)
+		return helper.GetBase("displayname_template")
+	}
+	assert.Equal(t, "{{.PreferredName}}{{if .IsBot}} (bot){{end}}", upgrade("displayname_template: '"+legacyDefaultDisplaynameTemplate+"'\n"))
+	assert.Equal(t, "{{.Name}}", upgrade("displayname_template: '{{.Name}}'\n"))
+}
+
+func TestConfig_InvalidTokenEncryptionKey(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte("displayname_template: '{{.PreferredName}}'\ntoken_encryption_key: c2hvcnQ=\n"), &cfg)
+	assert.ErrorContains(t, err, "invalid token_encryption_key")
+}
+
+func TestConfig_InvalidSecretBackend(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte("displayname_template: '{{.PreferredName}}'\nsecret_backend:\n  type: aws\n"), &cfg)
+	assert.ErrorContains(t, err, "invalid secret_backend.type")
+}
+
+func TestConfig_InvalidLeavePortalBehavior(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte("displayname_template: '{{.PreferredName}}'\nleave_portal_behavior: archvie\n"), &cfg)
+	assert.ErrorContains(t, err, "invalid leave_portal_behavior")
+}
@@ -18,11 +18,18 @@ package connector
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/commands"
 
 	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
 	"go.mau.fi/mautrix-slack/pkg/msgconv"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
 type SlackConnector struct {
@@ -30,26 +37,154 @@ type SlackConnector struct {
 	Config  Config
 	DB      *slackdb.SlackDB
 	MsgConv *msgconv.MessageConverter
+
+	// ConfigPath is the path of the config file, used for reloading the config at runtime.
+	ConfigPath string
+
+	// reloadedConfig is the config after the last ReloadConfig call. Config itself isn't changed after
+	// startup, reloads make a fresh copy instead, so readers never see partially applied changes.
+	reloadedConfig atomic.Pointer[Config]
+
+	startupSyncSema    chan struct{}
+	backfillThrottle   atomic.Pointer[BackfillThrottle]
+	ghostUpdateLimiter atomic.Pointer[GhostUpdateLimiter]
+	tracerProvider     *sdktrace.TracerProvider
+	stopPortalCheck    context.CancelFunc
+	stopAuditPrune     context.CancelFunc
+	stopInventory      context.CancelFunc
+
+	shardOwner   string
+	shardLock    sync.RWMutex
+	ownedShards  map[int]struct{}
+	stopSharding context.CancelFunc
+
+	inventory     map[string]*TeamInventory
+	inventoryLock sync.RWMutex
+
+	rateLimits     RateLimitTracker
+	secrets        SecretBackend
+	eventsAPIDedup eventsAPIDedup
 }
 
 var (
 	_ bridgev2.NetworkConnector      = (*SlackConnector)(nil)
 	_ bridgev2.MaxFileSizeingNetwork = (*SlackConnector)(nil)
+	_ bridgev2.StoppableNetwork      = (*SlackConnector)(nil)
 )
 
 func (s *SlackConnector) Init(bridge *bridgev2.Bridge) {
 	s.br = bridge
 	s.DB = slackdb.New(bridge.DB.Database, bridge.Log.With().Str("db_section", "slack").Logger())
 	s.MsgConv = msgconv.New(bridge, s.DB)
+	var err error
+	s.MsgConv.Translator, err = msgconv.NewTranslator(&s.MsgConv.HTTP, s.Config.Translation.Backend, s.Config.Translation.URL, s.Config.Translation.APIKey)
+	if err != nil {
+		bridge.Log.Err(err).Msg("Failed to initialize translator, translation will be disabled")
+	}
+	s.MsgConv.SetOptions(s.Config.converterOptions())
+	if s.Config.StartupSync.MaxConcurrency > 0 {
+		s.startupSyncSema = make(chan struct{}, s.Config.StartupSync.MaxConcurrency)
+	}
+	s.ghostUpdateLimiter.Store(NewGhostUpdateLimiter(s.Config.GhostSync))
+	throttle, err := NewBackfillThrottle(s.Config.Backfill)
+	s.backfillThrottle.Store(throttle)
+	if err != nil {
+		bridge.Log.Err(err).Msg("Invalid backfill schedule, backfills won't be throttled")
+	}
+	if s.Config.Tracing.Exporter != "" {
+		err = s.setupTracing(context.Background())
+		if err != nil {
+			bridge.Log.Err(err).Msg("Failed to set up tracing")
+		}
+	}
 	bridge.Config.PersonalFilteringSpaces = false
+	if !bridge.Config.BridgeMatrixLeave && s.Config.LeavePortalBehavior != "" && s.Config.LeavePortalBehavior != LeaveBehaviorNothing {
+		bridge.Log.Warn().
+			Str("leave_portal_behavior", s.Config.LeavePortalBehavior).
+			Msg("bridge_matrix_leave is disabled in the bridge config, so leave_portal_behavior won't have any effect")
+	}
+	bridge.Commands.(*commands.Processor).AddHandlers(
+		cmdSetTranslation,
+		cmdSetBotIdentity,
+		cmdEncrypt,
+		cmdRefreshGhost,
+		cmdResyncGhosts,
+		cmdHistory,
+		cmdWhoami,
+		cmdSearch,
+		cmdPing,
+		cmdPortalInfo,
+		cmdLookup,
+		cmdChannelLink,
+		cmdReloadConfig,
+		cmdLoginSettings,
+		cmdFixPortals,
+		cmdAuditLog,
+		cmdPurge,
+		cmdPruneEmojis,
+		cmdSlackAdmin,
+		cmdBridgeChannel,
+		cmdInteract,
+	)
 }
 
 func (s *SlackConnector) SetMaxFileSize(maxSize int64) {
 	s.MsgConv.MaxFileSize = int(maxSize)
 }
 
+// TokenEncryptionKeyEnv is the environment variable that overrides the token_encryption_key config option.
+const TokenEncryptionKeyEnv = "MAUTRIX_SLACK_TOKEN_ENCRYPTION_KEY"
+
 func (s *SlackConnector) Start(ctx context.Context) error {
-	return s.DB.Upgrade(ctx)
+	tokenKey := s.Config.TokenEncryptionKey
+	if envKey := os.Getenv(TokenEncryptionKeyEnv); envKey != "" {
+		tokenKey = envKey
+	}
+	err := slackid.SetTokenEncryptionKey(tokenKey)
+	if err != nil {
+		return fmt.Errorf("invalid token encryption key: %w", err)
+	}
+	s.secrets, err = newSecretBackend(s.Config.SecretBackend)
+	if err != nil {
+		return fmt.Errorf("failed to initialize secret backend: %w", err)
+	}
+	slackid.SetSecretsStoredExternally(s.secrets != nil)
+	err = s.DB.Upgrade(ctx)
+	if err != nil {
+		return err
+	}
+	if s.Config.PortalCheckInterval > 0 {
+		var checkCtx context.Context
+		checkCtx, s.stopPortalCheck = context.WithCancel(context.Background())
+		go s.runPortalCheckLoop(checkCtx, s.Config.PortalCheckInterval)
+	}
+	var pruneCtx context.Context
+	pruneCtx, s.stopAuditPrune = context.WithCancel(context.Background())
+	go s.runAuditLogPruneLoop(pruneCtx)
+	var inventoryCtx context.Context
+	inventoryCtx, s.stopInventory = context.WithCancel(context.Background())
+	go s.runInventoryRefreshLoop(inventoryCtx)
+	if s.shardingEnabled() {
+		err = s.startSharding(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to acquire shard leases: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SlackConnector) Stop() {
+	if s.stopPortalCheck != nil {
+		s.stopPortalCheck()
+	}
+	if s.stopAuditPrune != nil {
+		s.stopAuditPrune()
+	}
+	if s.stopInventory != nil {
+		s.stopInventory()
+	}
+	s.stopShardingAndRelease()
+	s.stopTracing()
 }
 
 func (s *SlackConnector) GetName() bridgev2.BridgeName {
@@ -0,0 +1,114 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+)
+
+// channelConversionEvent is sent when a channel is converted between public and private,
+// or when it's shared with or unshared from another organization. slack-go doesn't have types for these.
+type channelConversionEvent struct {
+	Type      string
+	ChannelID string
+}
+
+var channelConversionEventTypes = map[string]struct{}{
+	"channel_convert_to_private": {},
+	"channel_convert_to_public":  {},
+	"channel_shared":             {},
+	"channel_unshared":           {},
+}
+
+// parseChannelConversionEvent parses a channel conversion event from the raw data of an event that slack-go
+// doesn't know about. Returns nil if the event isn't a channel conversion event.
+func parseChannelConversionEvent(raw json.RawMessage) *channelConversionEvent {
+	var evt struct {
+		Type    string          `json:"type"`
+		Channel json.RawMessage `json:"channel"`
+	}
+	if json.Unmarshal(raw, &evt) != nil {
+		return nil
+	} else if _, ok := channelConversionEventTypes[evt.Type]; !ok {
+		return nil
+	}
+	var channelID string
+	if json.Unmarshal(evt.Channel, &channelID) != nil {
+		var channel struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(evt.Channel, &channel) != nil {
+			return nil
+		}
+		channelID = channel.ID
+	}
+	if channelID == "" {
+		return nil
+	}
+	return &channelConversionEvent{Type: evt.Type, ChannelID: channelID}
+}
+
+// channelJoinRule returns the join rule for the room of a channel. Public channels can be joined by anyone
+// in the team space, while private channels are invite-only.
+func (s *SlackClient) channelJoinRule(isPrivate bool) *event.JoinRulesEventContent {
+	if isPrivate || s.TeamPortal == nil || s.TeamPortal.MXID == "" {
+		return &event.JoinRulesEventContent{JoinRule: event.JoinRuleInvite}
+	}
+	return &event.JoinRulesEventContent{
+		JoinRule: event.JoinRuleRestricted,
+		Allow: []event.JoinRuleAllow{{
+			Type:   event.JoinRuleAllowRoomMembership,
+			RoomID: s.TeamPortal.MXID,
+		}},
+	}
+}
+
+// describeVisibilityChange returns a notice describing how the visibility of a channel changed,
+// or an empty string if it didn't change.
+func describeVisibilityChange(wasPrivate, isPrivate, wasShared, isShared bool) string {
+	switch {
+	case !wasPrivate && isPrivate:
+		return "This channel was converted to a private channel on Slack."
+	case wasPrivate && !isPrivate:
+		return "This channel was converted to a public channel on Slack."
+	case !wasShared && isShared:
+		return "This channel is now shared with another organization on Slack."
+	case wasShared && !isShared:
+		return "This channel is no longer shared with other organizations on Slack."
+	default:
+		return ""
+	}
+}
+
+// handleVisibilityChange notifies the room about a channel conversion, and enables encryption if configured
+// to do so for channels that became private or shared.
+func (s *SlackClient) handleVisibilityChange(ctx context.Context, portal *bridgev2.Portal, notice string, becameRestricted bool) {
+	s.sendPortalNotice(ctx, portal, notice)
+	if becameRestricted && s.Main.currentConfig().EncryptConvertedChannels {
+		if err := s.Main.enablePortalEncryption(ctx, portal); errors.Is(err, errEncryptionNotAllowed) {
+			zerolog.Ctx(ctx).Debug().Msg("Encryption isn't allowed, not enabling it after channel conversion")
+		} else if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to enable encryption after channel conversion")
+		}
+	}
+}
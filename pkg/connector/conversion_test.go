@@ -0,0 +1,67 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestParseChannelConversionEvent(t *testing.T) {
+	type testCase struct {
+		name     string
+		raw      string
+		expected *channelConversionEvent
+	}
+	testCases := []testCase{
+		{"ConvertToPrivate", `{"type":"channel_convert_to_private","channel":"C1"}`, &channelConversionEvent{Type: "channel_convert_to_private", ChannelID: "C1"}},
+		{"ConvertToPublic", `{"type":"channel_convert_to_public","channel":"C1"}`, &channelConversionEvent{Type: "channel_convert_to_public", ChannelID: "C1"}},
+		{"Shared", `{"type":"channel_shared","connected_team_id":"T2","channel":"C1"}`, &channelConversionEvent{Type: "channel_shared", ChannelID: "C1"}},
+		{"ChannelObject", `{"type":"channel_unshared","channel":{"id":"C1"}}`, &channelConversionEvent{Type: "channel_unshared", ChannelID: "C1"}},
+		{"MissingChannel", `{"type":"channel_shared"}`, nil},
+		{"OtherEvent", `{"type":"team_icon_change","channel":"C1"}`, nil},
+		{"Invalid", `{`, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseChannelConversionEvent([]byte(tc.raw)))
+		})
+	}
+}
+
+func TestDescribeVisibilityChange(t *testing.T) {
+	assert.Equal(t, "", describeVisibilityChange(false, false, false, false))
+	assert.Contains(t, describeVisibilityChange(false, true, false, false), "private")
+	assert.Contains(t, describeVisibilityChange(true, false, false, false), "public")
+	assert.Contains(t, describeVisibilityChange(false, false, false, true), "now shared")
+	assert.Contains(t, describeVisibilityChange(false, false, true, false), "no longer shared")
+}
+
+func TestChannelJoinRule(t *testing.T) {
+	s := newTestSlackClient(nil)
+	assert.Equal(t, event.JoinRuleInvite, s.channelJoinRule(false).JoinRule, "no team space means invite-only")
+
+	s.TeamPortal = &bridgev2.Portal{Portal: &database.Portal{MXID: "!space:example.com"}}
+	assert.Equal(t, event.JoinRuleInvite, s.channelJoinRule(true).JoinRule)
+	public := s.channelJoinRule(false)
+	assert.Equal(t, event.JoinRuleRestricted, public.JoinRule)
+	assert.Equal(t, []event.JoinRuleAllow{{Type: event.JoinRuleAllowRoomMembership, RoomID: "!space:example.com"}}, public.Allow)
+}
@@ -0,0 +1,87 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// parseGroupDMEvent converts the mpim_open and mpim_close events, which slack-go doesn't know about,
+// into the equivalent events of normal DMs. Returns nil if the raw data isn't a group DM event.
+func parseGroupDMEvent(raw json.RawMessage) any {
+	var evt slack.ChannelInfoEvent
+	if json.Unmarshal(raw, &evt) != nil || evt.Channel == "" {
+		return nil
+	}
+	switch evt.Type {
+	case "mpim_open":
+		return (*slack.IMOpenEvent)(&evt)
+	case "mpim_close":
+		return (*slack.IMCloseEvent)(&evt)
+	default:
+		return nil
+	}
+}
+
+// handleDMClosed applies closed_dm_behavior after a DM or group DM was closed in the Slack client.
+// Portals that were left are marked as closed, so they're reopened when Slack sends an open event.
+func (s *SlackClient) handleDMClosed(ctx context.Context, channelID string) {
+	behavior := s.Main.currentConfig().ClosedDMBehavior
+	if behavior == "" || behavior == LeaveBehaviorNothing {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Str("channel_id", channelID).Logger()
+	portal, err := s.Main.br.GetExistingPortalByKey(ctx, slackid.MakePortalKey(s.TeamID, channelID, s.UserLogin.ID, true))
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal of closed DM")
+		return
+	} else if portal == nil || (portal.RoomType != database.RoomTypeDM && portal.RoomType != database.RoomTypeGroupDM) {
+		return
+	}
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	if meta.DMClosed {
+		return
+	}
+	// Both behaviors keep the portal and its message history, so that reopening the DM on Slack
+	// can bring the same room back. Archiving also stops bridging the room for this login.
+	if behavior == LeaveBehaviorArchive {
+		err = s.removeUserPortal(ctx, portal)
+		if err != nil {
+			log.Err(err).Msg("Failed to remove user portal after DM was closed on Slack")
+			return
+		}
+	}
+	meta.DMClosed = true
+	// Make sure the next resync updates the member list and invites the user back
+	meta.InfoHash = ""
+	err = portal.Save(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to save portal after marking DM as closed")
+		return
+	}
+	evtMeta := &SlackEventMeta{PortalKey: portal.PortalKey, Sender: s.makeEventSender(s.UserID)}
+	s.UserLogin.Bridge.QueueRemoteEvent(s.UserLogin, wrapMemberChange(evtMeta, evtMeta.Sender, event.MembershipLeave, event.MembershipJoin))
+	log.Debug().Str("behavior", behavior).Msg("Leaving portal after DM was closed on Slack")
+}
@@ -0,0 +1,45 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseGroupDMEvent(t *testing.T) {
+	assert.Equal(t,
+		&slack.IMOpenEvent{Type: "mpim_open", Channel: "G1", User: "U1"},
+		parseGroupDMEvent([]byte(`{"type":"mpim_open","channel":"G1","user":"U1"}`)),
+	)
+	assert.Equal(t,
+		&slack.IMCloseEvent{Type: "mpim_close", Channel: "G1", User: "U1"},
+		parseGroupDMEvent([]byte(`{"type":"mpim_close","channel":"G1","user":"U1"}`)),
+	)
+	assert.Nil(t, parseGroupDMEvent([]byte(`{"type":"mpim_open"}`)))
+	assert.Nil(t, parseGroupDMEvent([]byte(`{"type":"team_icon_change","channel":"G1"}`)))
+	assert.Nil(t, parseGroupDMEvent([]byte(`not json`)))
+}
+
+func TestConfig_ClosedDMBehaviorValidation(t *testing.T) {
+	var cfg Config
+	assert.NoError(t, yaml.Unmarshal([]byte("closed_dm_behavior: archive"), &cfg))
+	assert.ErrorContains(t, yaml.Unmarshal([]byte("closed_dm_behavior: delete"), &cfg), "invalid closed_dm_behavior")
+}
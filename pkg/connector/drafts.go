@@ -0,0 +1,142 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// DraftEventType is the room account data type used for storing Slack drafts in the user's Matrix account.
+const DraftEventType = "fi.mau.slack.draft"
+
+type slackDraftDestination struct {
+	ChannelID string `json:"channel_id"`
+	ThreadTS  string `json:"thread_ts,omitempty"`
+}
+
+type slackDraft struct {
+	ID            string                  `json:"id"`
+	Blocks        slack.Blocks            `json:"blocks"`
+	Destinations  []slackDraftDestination `json:"destinations"`
+	IsDeleted     bool                    `json:"is_deleted"`
+	IsSent        bool                    `json:"is_sent"`
+	LastUpdatedTS string                  `json:"last_updated_ts"`
+}
+
+type slackDraftEvent struct {
+	Type  string      `json:"type"`
+	Draft *slackDraft `json:"draft"`
+}
+
+// isActive returns false if the draft should be removed from Matrix rather than stored.
+func (d *slackDraft) isActive(eventType string) bool {
+	return eventType != "draft_delete" && eventType != "draft_sent" && !d.IsDeleted && !d.IsSent
+}
+
+// parseDraftEvent parses a draft event from the raw data of an event that slack-go doesn't know about.
+// Returns nil if the event isn't a draft event or doesn't target a single conversation.
+func parseDraftEvent(raw json.RawMessage) (*slackDraftEvent, error) {
+	var evt slackDraftEvent
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		return nil, err
+	} else if !strings.HasPrefix(evt.Type, "draft_") || evt.Draft == nil || len(evt.Draft.Destinations) != 1 {
+		return nil, nil
+	}
+	return &evt, nil
+}
+
+// matrixDraftContent is the content of the draft room account data event. An empty DraftID means there's no draft.
+type matrixDraftContent struct {
+	*event.MessageEventContent
+	DraftID   string `json:"fi.mau.slack.draft_id,omitempty"`
+	UpdatedTS string `json:"fi.mau.slack.updated_ts,omitempty"`
+}
+
+// handleDraftEvent copies a Slack draft into the user's room account data, so that Matrix clients (or
+// integrations) can pick it up. This is one way: drafts written on Matrix are never sent to Slack.
+func (s *SlackClient) handleDraftEvent(ctx context.Context, evt *slackDraftEvent) {
+	dest := evt.Draft.Destinations[0]
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "sync draft").
+		Str("draft_id", evt.Draft.ID).
+		Str("channel_id", dest.ChannelID).
+		Logger()
+	intent, ok := s.UserLogin.User.DoublePuppet(ctx).(*matrix.ASIntent)
+	if !ok {
+		log.Debug().Msg("Double puppeting isn't enabled, not syncing draft")
+		return
+	}
+	portalKey, err := s.UserLogin.Bridge.FindPortalReceiver(ctx, slackid.MakePortalID(s.TeamID, dest.ChannelID), s.UserLogin.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to find portal receiver")
+		return
+	} else if portalKey.IsEmpty() {
+		return
+	}
+	portal, err := s.Main.br.GetExistingPortalByKey(ctx, portalKey)
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal")
+		return
+	} else if portal == nil || portal.MXID == "" {
+		return
+	}
+	s.draftLock.Lock()
+	defer s.draftLock.Unlock()
+	content := &matrixDraftContent{}
+	if evt.Draft.isActive(evt.Type) {
+		content.MessageEventContent = s.Main.MsgConv.DraftToMatrix(ctx, portal, s.UserLogin, evt.Draft.Blocks)
+	}
+	if content.MessageEventContent != nil {
+		content.DraftID = evt.Draft.ID
+		content.UpdatedTS = evt.Draft.LastUpdatedTS
+		if dest.ThreadTS != "" {
+			threadRoot, err := s.Main.br.DB.Message.GetFirstPartByID(ctx, s.UserLogin.ID, slackid.MakeMessageID(s.TeamID, dest.ChannelID, dest.ThreadTS))
+			if err != nil {
+				log.Err(err).Msg("Failed to get thread root from database")
+			} else if threadRoot != nil {
+				content.RelatesTo = (&event.RelatesTo{}).SetThread(threadRoot.MXID, threadRoot.MXID)
+			}
+		}
+	} else {
+		// Each room only has space for one draft, so only clear the account data if it contains this draft.
+		var existing matrixDraftContent
+		err = intent.Matrix.GetRoomAccountData(ctx, portal.MXID, DraftEventType, &existing)
+		if err != nil && !errors.Is(err, mautrix.MNotFound) {
+			log.Err(err).Msg("Failed to get existing draft room account data")
+			return
+		} else if existing.DraftID != evt.Draft.ID {
+			return
+		}
+	}
+	err = intent.Matrix.SetRoomAccountData(ctx, portal.MXID, DraftEventType, content)
+	if err != nil {
+		log.Err(err).Msg("Failed to set draft room account data")
+		return
+	}
+	log.Debug().Bool("cleared", content.DraftID == "").Msg("Synced draft to Matrix")
+}
@@ -0,0 +1,53 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDraftEvent(t *testing.T) {
+	evt, err := parseDraftEvent([]byte(`{"type":"draft_update","draft":{"id":"Dr1","destinations":[{"channel_id":"C1","thread_ts":"1234567890.123456"}],"blocks":[{"type":"rich_text","block_id":"b1","elements":[]}],"last_updated_ts":"1234567891.000000"}}`))
+	require.NoError(t, err)
+	require.NotNil(t, evt)
+	assert.Equal(t, "Dr1", evt.Draft.ID)
+	assert.Equal(t, slackDraftDestination{ChannelID: "C1", ThreadTS: "1234567890.123456"}, evt.Draft.Destinations[0])
+	assert.Len(t, evt.Draft.Blocks.BlockSet, 1)
+	assert.True(t, evt.Draft.isActive(evt.Type))
+
+	evt, err = parseDraftEvent([]byte(`{"type":"draft_update","draft":{"id":"Dr1","destinations":[{"channel_id":"C1"},{"channel_id":"C2"}]}}`))
+	require.NoError(t, err)
+	assert.Nil(t, evt, "drafts with multiple destinations should be ignored")
+
+	evt, err = parseDraftEvent([]byte(`{"type":"team_icon_change"}`))
+	require.NoError(t, err)
+	assert.Nil(t, evt)
+
+	_, err = parseDraftEvent([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestSlackDraft_IsActive(t *testing.T) {
+	assert.True(t, (&slackDraft{}).isActive("draft_create"))
+	assert.False(t, (&slackDraft{}).isActive("draft_delete"))
+	assert.False(t, (&slackDraft{}).isActive("draft_sent"))
+	assert.False(t, (&slackDraft{IsDeleted: true}).isActive("draft_update"))
+	assert.False(t, (&slackDraft{IsSent: true}).isActive("draft_update"))
+}
@@ -0,0 +1,130 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var errEditConflict = errors.New("message was edited on Slack after it was last bridged")
+
+var errEditConflictStatus = bridgev2.WrapErrorInStatus(errEditConflict).
+	WithStatus(event.MessageStatusFail).
+	WithErrorReason(event.MessageStatusGenericError).
+	WithMessage("The message was edited on Slack after your last copy of it. The Slack version has been bridged, edit it again to overwrite it.").
+	WithIsCertain(true).
+	WithSendNotice(true)
+
+// fetchSlackMessage fetches the current version of a single message from Slack.
+// threadTS must be set for thread replies, as they aren't returned by conversations.history.
+func (s *SlackClient) fetchSlackMessage(ctx context.Context, channelID, ts, threadTS string) (*slack.Message, error) {
+	params := slack.GetConversationHistoryParameters{
+		ChannelID: channelID,
+		Latest:    ts,
+		Oldest:    ts,
+		Inclusive: true,
+		Limit:     1,
+	}
+	var resp *slack.GetConversationHistoryResponse
+	var err error
+	if threadTS != "" && threadTS != ts {
+		resp, err = s.Client.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
+			GetConversationHistoryParameters: params,
+			Timestamp:                        threadTS,
+		})
+	} else {
+		resp, err = s.Client.GetConversationHistoryContext(ctx, &params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Messages {
+		// conversations.replies always includes the thread root
+		if resp.Messages[i].Timestamp == ts {
+			return &resp.Messages[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// checkEditConflict compares the latest edit of the target message on Slack with the last edit
+// the bridge knows about. If Slack has a newer edit, it's queued to Matrix so the room catches up,
+// and an error is returned so the Matrix edit doesn't silently overwrite it.
+func (s *SlackClient) checkEditConflict(ctx context.Context, portal *bridgev2.Portal, target *database.Message) error {
+	_, channelID, ts, ok := slackid.ParseMessageID(target.ID)
+	if !ok {
+		return nil
+	}
+	var threadTS string
+	if target.ThreadRoot != "" {
+		_, _, threadTS, _ = slackid.ParseMessageID(target.ThreadRoot)
+	}
+	log := zerolog.Ctx(ctx)
+	current, err := s.fetchSlackMessage(ctx, channelID, ts, threadTS)
+	if err != nil {
+		// Don't block edits if the check itself fails
+		log.Warn().Err(err).Msg("Failed to fetch current message to check for edit conflicts")
+		return nil
+	} else if current == nil || current.Edited == nil {
+		return nil
+	}
+	lastEditTS := target.Metadata.(*slackid.MessageMetadata).LastEditTS
+	if current.Edited.Timestamp <= lastEditTS {
+		return nil
+	}
+	log.Info().
+		Str("last_bridged_edit_ts", lastEditTS).
+		Str("slack_edit_ts", current.Edited.Timestamp).
+		Msg("Message was edited on Slack after the last bridged edit, rejecting Matrix edit")
+	s.queueSlackEdit(portal.PortalKey, channelID, &current.Msg)
+	return errEditConflictStatus
+}
+
+// queueSlackEdit queues the current content of an edited Slack message as an edit event.
+func (s *SlackClient) queueSlackEdit(portalKey networkid.PortalKey, channelID string, current *slack.Msg) {
+	sender := current.User
+	if sender == "" {
+		sender = current.BotID
+	}
+	evt := &slack.MessageEvent{}
+	evt.Type = slack.TYPE_MESSAGE
+	evt.Channel = channelID
+	evt.SubType = slack.MsgSubTypeMessageChanged
+	evt.Timestamp = current.Edited.Timestamp
+	evt.EventTimestamp = current.Edited.Timestamp
+	evt.SubMessage = current
+	s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackMessage{
+		SlackEventMeta: &SlackEventMeta{
+			Type:         bridgev2.RemoteEventEdit,
+			PortalKey:    portalKey,
+			Sender:       s.makeEventSender(sender),
+			RawTimestamp: current.Edited.Timestamp,
+		},
+		Data:   evt,
+		Client: s,
+	})
+}
@@ -0,0 +1,105 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2/database"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestFetchSlackMessage(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.history", func(form url.Values) (any, error) {
+		return map[string]any{"messages": []any{
+			map[string]any{"ts": form.Get("latest"), "text": "top level"},
+		}}, nil
+	})
+	srv.Handle("conversations.replies", func(form url.Values) (any, error) {
+		return map[string]any{"messages": []any{
+			map[string]any{"ts": form.Get("ts"), "text": "root"},
+			map[string]any{"ts": form.Get("latest"), "text": "reply"},
+		}}, nil
+	})
+	s := newTestSlackClient(srv.Client())
+	ctx := context.Background()
+
+	msg, err := s.fetchSlackMessage(ctx, "C1", "1700000000.000100", "")
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, "top level", msg.Text)
+
+	msg, err = s.fetchSlackMessage(ctx, "C1", "1700000000.000200", "1700000000.000100")
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, "reply", msg.Text)
+	calls := srv.Calls("conversations.replies")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "1700000000.000100", calls[0].Get("ts"))
+	assert.Equal(t, "1700000000.000200", calls[0].Get("oldest"))
+}
+
+func TestCheckEditConflict_NoConflict(t *testing.T) {
+	tests := []struct {
+		name       string
+		edited     map[string]any
+		lastEditTS string
+	}{
+		{"NeverEdited", nil, ""},
+		{"EditAlreadyBridged", map[string]any{"user": "U2", "ts": "1700000100.000000"}, "1700000100.000000"},
+		{"OwnEditNewer", map[string]any{"user": "U1", "ts": "1700000100.000000"}, "1700000100.500000"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := slackapitest.NewServer(t)
+			srv.Handle("conversations.history", func(form url.Values) (any, error) {
+				msg := map[string]any{"ts": form.Get("latest"), "text": "hello"}
+				if test.edited != nil {
+					msg["edited"] = test.edited
+				}
+				return map[string]any{"messages": []any{msg}}, nil
+			})
+			s := newTestSlackClient(srv.Client())
+			target := &database.Message{
+				ID:       slackid.MakeMessageID("T1", "C1", "1700000000.000100"),
+				Metadata: &slackid.MessageMetadata{LastEditTS: test.lastEditTS},
+			}
+			assert.NoError(t, s.checkEditConflict(context.Background(), nil, target))
+			assert.Len(t, srv.Calls("conversations.history"), 1)
+		})
+	}
+}
+
+func TestCheckEditConflict_FetchFailure(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.history", func(form url.Values) (any, error) {
+		return nil, slackapitest.Error("ratelimited")
+	})
+	s := newTestSlackClient(srv.Client())
+	target := &database.Message{
+		ID:       slackid.MakeMessageID("T1", "C1", "1700000000.000100"),
+		Metadata: &slackid.MessageMetadata{},
+	}
+	assert.NoError(t, s.checkEditConflict(context.Background(), nil, target))
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/slack-go/slack"
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
@@ -68,6 +69,7 @@ func (s *SlackClient) handleEmojiChange(ctx context.Context, evt *slack.EmojiCha
 			log.Err(err).Msg("Failed to resync emojis")
 		}
 	}
+	s.publishEmojiPack(ctx)
 }
 
 func (s *SlackClient) addEmoji(ctx context.Context, emojiName, emojiValue string) *slackdb.Emoji {
@@ -169,6 +171,10 @@ func (s *SlackClient) SyncEmojis(ctx context.Context) {
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to sync emojis")
 	}
+	stats := s.publishEmojiPack(ctx)
+	if stats.Uploaded > 0 || stats.Failed > 0 {
+		s.sendEmojiSyncNotice(ctx, stats)
+	}
 }
 
 func (s *SlackClient) syncEmojis(ctx context.Context, onlyIfCountMismatch bool) error {
@@ -267,8 +273,8 @@ func (s *SlackClient) tryGetEmoji(ctx context.Context, shortcode string, ensureU
 		}
 		val, isImage, _ = s.tryGetEmoji(ctx, strings.TrimPrefix(dbEmoji.Value, "alias:"), ensureUploaded, false)
 	} else if ensureUploaded {
-		defer s.Main.DB.Emoji.WithLock(s.TeamID)()
-		dbEmoji.ImageMXC, err = reuploadEmoji(ctx, s.Main.br.Bot, dbEmoji.Value)
+		var mxc id.ContentURIString
+		mxc, err = s.uploadEmojiOnFirstUse(ctx, dbEmoji)
 		if err != nil {
 			zerolog.Ctx(ctx).Err(err).
 				Str("shortcode", shortcode).
@@ -276,20 +282,52 @@ func (s *SlackClient) tryGetEmoji(ctx context.Context, shortcode string, ensureU
 				Msg("Failed to reupload emoji")
 			return
 		}
-		err = s.Main.DB.Emoji.SaveMXC(ctx, dbEmoji)
-		if err != nil {
-			zerolog.Ctx(ctx).Err(err).
-				Str("shortcode", shortcode).
-				Str("url", dbEmoji.Value).
-				Str("mxc", string(dbEmoji.ImageMXC)).
-				Msg("Failed to save reuploaded emoji")
-		}
-		val = string(dbEmoji.ImageMXC)
+		val = string(mxc)
 		isImage = true
 	}
 	return
 }
 
+// uploadEmojiOnFirstUse reuploads the image of a custom emoji to Matrix when it's first used in a message
+// or reaction. Emoji syncs only store the Slack URL, so images of emojis that are never used aren't downloaded.
+func (s *SlackClient) uploadEmojiOnFirstUse(ctx context.Context, dbEmoji *slackdb.Emoji) (id.ContentURIString, error) {
+	defer s.Main.DB.Emoji.WithLock(s.TeamID)()
+	// Another message may have used the same emoji while waiting for the lock
+	current, err := s.Main.DB.Emoji.GetBySlackID(ctx, s.TeamID, dbEmoji.EmojiID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get emoji from database: %w", err)
+	} else if current != nil && current.Value == dbEmoji.Value && current.ImageMXC != "" {
+		return current.ImageMXC, nil
+	}
+	dbEmoji.ImageMXC, err = reuploadEmoji(ctx, s.Main.br.Bot, dbEmoji.Value)
+	if err != nil {
+		return "", err
+	}
+	err = s.Main.DB.Emoji.SaveMXC(ctx, dbEmoji)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Str("emoji_id", dbEmoji.EmojiID).
+			Str("mxc", string(dbEmoji.ImageMXC)).
+			Msg("Failed to save reuploaded emoji")
+	}
+	return dbEmoji.ImageMXC, nil
+}
+
+// reactionEmojiID normalizes a Slack reaction name, so that reactions using different aliases of the same emoji
+// get the same emoji ID as each other and as reactions sent from Matrix.
+func (s *SlackClient) reactionEmojiID(ctx context.Context, name string) networkid.EmojiID {
+	name = emoji.CanonicalShortcode(name)
+	if emoji.GetUnicode(name) == "" {
+		dbEmoji, err := s.Main.DB.Emoji.GetBySlackID(ctx, s.TeamID, name)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("shortcode", name).Msg("Failed to get emoji from database")
+		} else if dbEmoji != nil && dbEmoji.Alias != "" {
+			name = emoji.CanonicalShortcode(dbEmoji.Alias)
+		}
+	}
+	return networkid.EmojiID(name)
+}
+
 func (s *SlackClient) GetEmoji(ctx context.Context, shortcode string) (string, bool) {
 	emojiVal, isImage, found := s.tryGetEmoji(ctx, shortcode, true, true)
 	if !found && s.ResyncEmojisDueToNotFound(ctx) {
@@ -301,3 +339,13 @@ func (s *SlackClient) GetEmoji(ctx context.Context, shortcode string) (string, b
 	}
 	return emojiVal, isImage
 }
+
+// GetCustomEmoji returns the mxc URI of a custom emoji in this workspace. Unlike GetEmoji, it doesn't resync the
+// emoji list when the shortcode is unknown, as it's called for anything in message text that looks like a shortcode.
+func (s *SlackClient) GetCustomEmoji(ctx context.Context, shortcode string) (id.ContentURIString, bool) {
+	emojiVal, isImage, _ := s.tryGetEmoji(ctx, shortcode, true, true)
+	if !isImage || emojiVal == "" {
+		return "", false
+	}
+	return id.ContentURIString(emojiVal), true
+}
@@ -0,0 +1,52 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+)
+
+func TestUploadEmojiOnFirstUse_AlreadyUploaded(t *testing.T) {
+	rawDB, err := dbutil.NewWithDialect("file::memory:", "sqlite3")
+	require.NoError(t, err)
+	rawDB.RawDB.SetMaxOpenConns(1)
+	defer rawDB.Close()
+	db := slackdb.New(rawDB, zerolog.Nop())
+	ctx := context.Background()
+	require.NoError(t, db.Upgrade(ctx))
+
+	s := newTestSlackClient(nil)
+	s.Main = &SlackConnector{DB: db}
+	stale := &slackdb.Emoji{TeamID: "T1", EmojiID: "party", Value: "https://emoji.slack-edge.com/T1/party/abc.gif"}
+	require.NoError(t, db.Emoji.Put(ctx, stale))
+	uploaded := *stale
+	uploaded.ImageMXC = "mxc://example.com/party"
+	require.NoError(t, db.Emoji.SaveMXC(ctx, &uploaded))
+
+	// The emoji was uploaded by someone else after it was read, so it must not be uploaded again
+	mxc, err := s.uploadEmojiOnFirstUse(ctx, stale)
+	require.NoError(t, err)
+	assert.EqualValues(t, "mxc://example.com/party", mxc)
+}
@@ -0,0 +1,132 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var StateRoomEmotes = event.Type{Type: "im.ponies.room_emotes", Class: event.StateEventType}
+
+type emotePackImage struct {
+	URL  id.ContentURIString `json:"url"`
+	Body string              `json:"body,omitempty"`
+}
+
+type emotePackInfo struct {
+	DisplayName string   `json:"display_name,omitempty"`
+	Usage       []string `json:"usage,omitempty"`
+}
+
+type emotePack struct {
+	Pack   emotePackInfo             `json:"pack"`
+	Images map[string]emotePackImage `json:"images"`
+}
+
+// ensureEmojiUploaded reuploads the image of the given emoji to Matrix if it hasn't been uploaded yet.
+// The caller must hold the emoji lock of the team.
+func (s *SlackClient) ensureEmojiUploaded(ctx context.Context, dbEmoji *slackdb.Emoji) error {
+	if !needsEmojiUpload(dbEmoji) {
+		return nil
+	}
+	var err error
+	dbEmoji.ImageMXC, err = reuploadEmoji(ctx, s.Main.br.Bot, dbEmoji.Value)
+	if err != nil {
+		return err
+	}
+	return s.Main.DB.Emoji.SaveMXC(ctx, dbEmoji)
+}
+
+// publishEmojiPack sends the custom emojis of the team as an image pack state event in the team space.
+// The caller must hold the emoji lock of the team.
+func (s *SlackClient) publishEmojiPack(ctx context.Context) (stats emojiUploadStats) {
+	if !s.Main.currentConfig().EmojiRoomPack || s.TeamPortal.MXID == "" {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Str("action", "publish emoji pack").Logger()
+	ctx = log.WithContext(ctx)
+	emojis, err := s.Main.DB.Emoji.GetAllInTeam(ctx, s.TeamID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get emojis from database")
+		return
+	}
+	stats = s.uploadTeamEmojis(ctx, emojis)
+	mxcs := make(map[string]id.ContentURIString, len(emojis))
+	for _, dbEmoji := range emojis {
+		if dbEmoji.ImageMXC != "" {
+			mxcs[dbEmoji.EmojiID] = dbEmoji.ImageMXC
+		}
+	}
+	pack := &emotePack{
+		Pack: emotePackInfo{
+			DisplayName: s.TeamPortal.Name,
+			Usage:       []string{"emoticon"},
+		},
+		Images: make(map[string]emotePackImage, len(emojis)),
+	}
+	for _, dbEmoji := range emojis {
+		mxc := mxcs[dbEmoji.EmojiID]
+		if dbEmoji.Alias != "" && mxc == "" {
+			mxc = mxcs[dbEmoji.Alias]
+		}
+		if mxc == "" {
+			continue
+		}
+		pack.Images[dbEmoji.EmojiID] = emotePackImage{
+			URL:  mxc,
+			Body: fmt.Sprintf(":%s:", dbEmoji.EmojiID),
+		}
+	}
+	packJSON, err := json.Marshal(pack)
+	if err != nil {
+		log.Err(err).Msg("Failed to marshal emoji pack")
+		return
+	}
+	packHash := sha256.Sum256(packJSON)
+	packHashStr := base64.RawStdEncoding.EncodeToString(packHash[:])
+	meta := s.TeamPortal.Metadata.(*slackid.PortalMetadata)
+	if meta.EmojiPackHash == packHashStr {
+		log.Debug().Msg("Emoji pack hasn't changed, not republishing")
+		return
+	}
+	_, err = s.Main.br.Bot.SendState(ctx, s.TeamPortal.MXID, StateRoomEmotes, "", &event.Content{
+		VeryRaw: packJSON,
+	}, time.Time{})
+	if err != nil {
+		log.Err(err).Msg("Failed to send emoji pack state event")
+		return
+	}
+	meta.EmojiPackHash = packHashStr
+	err = s.TeamPortal.Save(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to save team portal after publishing emoji pack")
+	}
+	log.Debug().Int("emoji_count", len(pack.Images)).Msg("Published emoji pack")
+	return
+}
@@ -0,0 +1,90 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+)
+
+// EmojiPruneReport contains the results of [SlackConnector.PruneEmojis].
+type EmojiPruneReport struct {
+	Teams  []string
+	Emojis int
+	// OrphanedMXCs are the uploaded emoji images which are no longer referenced by any remaining emoji.
+	// The bridge can't delete media, so they have to be removed from the homeserver's media store manually.
+	OrphanedMXCs []id.ContentURIString
+}
+
+// PruneEmojis deletes the custom emojis of all teams which don't have any logins left, as they won't be kept up
+// to date. If dryRun is false, the database is vacuumed afterward to reclaim the space.
+func (s *SlackConnector) PruneEmojis(ctx context.Context, dryRun bool) (report EmojiPruneReport, err error) {
+	counts, err := s.DB.Emoji.GetCountPerTeam(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to count emojis: %w", err)
+	}
+	for teamID, count := range counts {
+		if !slackIDRegex.MatchString(teamID) {
+			continue
+		}
+		logins, err := s.countRows(ctx, countTeamLoginsQuery, teamID+"-%")
+		if err != nil {
+			return report, fmt.Errorf("failed to count logins in %s: %w", teamID, err)
+		} else if logins > 0 {
+			continue
+		}
+		mxcs, err := s.DB.Emoji.GetUnsharedMXCsInTeam(ctx, teamID)
+		if err != nil {
+			return report, fmt.Errorf("failed to get emoji uploads of %s: %w", teamID, err)
+		}
+		if !dryRun {
+			unlock := s.DB.Emoji.WithLock(teamID)
+			err = s.DB.Emoji.DeleteAllInTeam(ctx, teamID)
+			unlock()
+			if err != nil {
+				return report, fmt.Errorf("failed to delete emojis of %s: %w", teamID, err)
+			}
+		}
+		report.Teams = append(report.Teams, teamID)
+		report.Emojis += count
+		report.OrphanedMXCs = append(report.OrphanedMXCs, mxcs...)
+	}
+	slices.Sort(report.Teams)
+	slices.Sort(report.OrphanedMXCs)
+	if len(report.Teams) > 0 {
+		orphans := make([]string, len(report.OrphanedMXCs))
+		for i, mxc := range report.OrphanedMXCs {
+			orphans[i] = string(mxc)
+		}
+		zerolog.Ctx(ctx).Info().
+			Bool("dry_run", dryRun).
+			Strs("team_ids", report.Teams).
+			Int("emoji_count", report.Emojis).
+			Strs("orphaned_uploads", orphans).
+			Msg("Pruning emojis of teams without logins")
+	}
+	if !dryRun && report.Emojis > 0 {
+		if err = s.DB.Emoji.Vacuum(ctx); err != nil {
+			return report, fmt.Errorf("failed to vacuum database: %w", err)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,128 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+)
+
+// DefaultEmojiSyncWorkers is the number of emojis reuploaded in parallel if emoji_sync_workers isn't set.
+const DefaultEmojiSyncWorkers = 4
+
+type emojiUploadStats struct {
+	Uploaded int
+	Failed   int
+	Duration time.Duration
+}
+
+func needsEmojiUpload(dbEmoji *slackdb.Emoji) bool {
+	return dbEmoji.ImageMXC == "" && dbEmoji.Alias == "" && strings.HasPrefix(dbEmoji.Value, "https://")
+}
+
+// uploadTeamEmojis reuploads the images of the given emojis to Matrix using a bounded number of workers.
+// Each emoji is saved as soon as it's uploaded, so an interrupted sync continues where it left off next time.
+// The caller must hold the emoji lock of the team.
+func (s *SlackClient) uploadTeamEmojis(ctx context.Context, emojis []*slackdb.Emoji) emojiUploadStats {
+	log := zerolog.Ctx(ctx)
+	workers := s.Main.currentConfig().EmojiSyncWorkers
+	if workers <= 0 {
+		workers = DefaultEmojiSyncWorkers
+	}
+	start := time.Now()
+	sema := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	var uploaded, failed atomic.Int64
+Loop:
+	for _, dbEmoji := range emojis {
+		if !needsEmojiUpload(dbEmoji) {
+			continue
+		}
+		select {
+		case sema <- struct{}{}:
+		case <-ctx.Done():
+			break Loop
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			err := s.ensureEmojiUploaded(ctx, dbEmoji)
+			if err != nil {
+				log.Err(err).Str("emoji_id", dbEmoji.EmojiID).Msg("Failed to reupload emoji")
+				failed.Add(1)
+			} else {
+				uploaded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	stats := emojiUploadStats{
+		Uploaded: int(uploaded.Load()),
+		Failed:   int(failed.Load()),
+		Duration: time.Since(start),
+	}
+	if stats.Uploaded > 0 || stats.Failed > 0 {
+		log.Info().
+			Int("uploaded_count", stats.Uploaded).
+			Int("failed_count", stats.Failed).
+			Int("workers", workers).
+			Dur("duration", stats.Duration).
+			Msg("Finished reuploading team emojis")
+	}
+	return stats
+}
+
+// sendEmojiSyncNotice tells the user in their management room that a bulk emoji sync has finished.
+func (s *SlackClient) sendEmojiSyncNotice(ctx context.Context, stats emojiUploadStats) {
+	roomID, err := s.UserLogin.User.GetManagementRoom(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get management room to send emoji sync notice")
+		return
+	}
+	_, err = s.Main.br.Bot.SendMessage(ctx, roomID, event.EventMessage, &event.Content{
+		Parsed: &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    formatEmojiSyncNotice(s.TeamPortal.Name, stats),
+		},
+	}, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to send emoji sync notice")
+	}
+}
+
+func formatEmojiSyncNotice(teamName string, stats emojiUploadStats) string {
+	if teamName == "" {
+		teamName = "the workspace"
+	}
+	msg := fmt.Sprintf("Finished syncing custom emojis of %s: uploaded %d in %s", teamName, stats.Uploaded, stats.Duration.Round(time.Second))
+	if stats.Failed > 0 {
+		msg += fmt.Sprintf(", %d failed and will be retried on the next sync", stats.Failed)
+	}
+	return msg + "."
+}
@@ -0,0 +1,44 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+)
+
+func TestNeedsEmojiUpload(t *testing.T) {
+	assert.True(t, needsEmojiUpload(&slackdb.Emoji{Value: "https://emoji.slack-edge.com/T1/party/abc.gif"}))
+	assert.False(t, needsEmojiUpload(&slackdb.Emoji{Value: "https://emoji.slack-edge.com/T1/party/abc.gif", ImageMXC: "mxc://example.com/party"}))
+	assert.False(t, needsEmojiUpload(&slackdb.Emoji{Value: "alias:party", Alias: "party"}))
+	assert.False(t, needsEmojiUpload(&slackdb.Emoji{Value: "unicode"}))
+}
+
+func TestFormatEmojiSyncNotice(t *testing.T) {
+	assert.Equal(t,
+		"Finished syncing custom emojis of Acme: uploaded 120 in 35s.",
+		formatEmojiSyncNotice("Acme", emojiUploadStats{Uploaded: 120, Duration: 35200 * time.Millisecond}),
+	)
+	assert.Equal(t,
+		"Finished syncing custom emojis of the workspace: uploaded 3 in 2s, 2 failed and will be retried on the next sync.",
+		formatEmojiSyncNotice("", emojiUploadStats{Uploaded: 3, Failed: 2, Duration: 2 * time.Second}),
+	)
+}
@@ -0,0 +1,139 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+	"go.mau.fi/mautrix-slack/pkg/msgconv/matrixfmt"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var _ matrixfmt.EmojiUploader = (*SlackClient)(nil)
+
+var invalidEmojiNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// maxEmojiUploadSize is the maximum size of custom emoji images that Slack accepts.
+const maxEmojiUploadSize = 128 * 1024
+
+// makeSlackEmojiName converts a Matrix emoticon shortcode into a valid Slack emoji name.
+func makeSlackEmojiName(shortcode string) string {
+	name := strings.ToLower(strings.Trim(shortcode, ":"))
+	name = invalidEmojiNameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, "_-")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
+
+// withEmojiUploader returns a context that allows the Matrix HTML parser to upload unknown custom emoticons,
+// if that's enabled in the config.
+func (s *SlackClient) withEmojiUploader(ctx context.Context) context.Context {
+	if !s.Main.currentConfig().UploadMatrixEmojis || !s.IsRealUser {
+		return ctx
+	}
+	return matrixfmt.WithEmojiUploader(ctx, s)
+}
+
+func (s *SlackClient) UploadEmoji(ctx context.Context, mxc id.ContentURIString, shortcode string) (string, error) {
+	name := makeSlackEmojiName(shortcode)
+	if name == "" {
+		return "", errors.New("emoticon doesn't have a valid shortcode")
+	}
+	defer s.Main.DB.Emoji.WithLock(s.TeamID)()
+	existing, err := s.Main.DB.Emoji.GetBySlackID(ctx, s.TeamID, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to check if emoji exists: %w", err)
+	} else if existing != nil {
+		if existing.ImageMXC == mxc {
+			return existing.EmojiID, nil
+		}
+		return "", fmt.Errorf("a different emoji named %q already exists", name)
+	}
+	data, err := s.Main.br.Bot.DownloadMedia(ctx, mxc, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to download emoticon: %w", err)
+	} else if len(data) > maxEmojiUploadSize {
+		return "", fmt.Errorf("emoticon is too large (%d bytes)", len(data))
+	}
+	err = s.addSlackEmoji(ctx, name, data)
+	if err != nil {
+		return "", err
+	}
+	err = s.Main.DB.Emoji.Put(ctx, &slackdb.Emoji{
+		TeamID:   s.TeamID,
+		EmojiID:  name,
+		ImageMXC: mxc,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("emoji_id", name).Msg("Failed to save uploaded emoji to database")
+	}
+	zerolog.Ctx(ctx).Debug().Str("emoji_id", name).Str("mxc", string(mxc)).Msg("Uploaded Matrix emoticon to Slack")
+	return name, nil
+}
+
+// addSlackEmoji calls the emoji.add method, which isn't supported by slack-go.
+func (s *SlackClient) addSlackEmoji(ctx context.Context, name string, data []byte) error {
+	meta := s.UserLogin.Metadata.(*slackid.UserLoginMetadata)
+	var buf bytes.Buffer
+	mp := multipart.NewWriter(&buf)
+	_ = mp.WriteField("token", meta.Token)
+	_ = mp.WriteField("mode", "data")
+	_ = mp.WriteField("name", name)
+	part, err := mp.CreateFormFile("image", name)
+	if err != nil {
+		return err
+	}
+	_, _ = part.Write(data)
+	err = mp.Close()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slack.APIURL+"emoji.add", &buf)
+	if err != nil {
+		return fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", mp.FormDataContentType())
+	if meta.CookieToken != "" {
+		req.AddCookie(&http.Cookie{Name: "d", Value: meta.CookieToken})
+	}
+	resp, err := s.Main.MsgConv.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	var respData slack.SlackResponse
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		return fmt.Errorf("failed to parse response (status %d): %w", resp.StatusCode, err)
+	}
+	return respData.Err()
+}
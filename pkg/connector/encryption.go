@@ -0,0 +1,137 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+const (
+	// EncryptionPolicyDefault leaves encrypting new rooms to the bridge-wide encryption config.
+	EncryptionPolicyDefault = "default"
+	// EncryptionPolicyPrivate encrypts new rooms for private channels, DMs and group DMs.
+	EncryptionPolicyPrivate = "private"
+	// EncryptionPolicyAll encrypts all new rooms.
+	EncryptionPolicyAll = "all"
+)
+
+var errEncryptionNotAllowed = errors.New("encryption isn't allowed in the bridge config")
+
+// shouldEncryptNewPortal returns true if the encryption policy requires a new room to be encrypted.
+func (s *SlackConnector) shouldEncryptNewPortal(isPrivate bool) bool {
+	switch s.currentConfig().EncryptionPolicy {
+	case EncryptionPolicyAll:
+		return true
+	case EncryptionPolicyPrivate:
+		return isPrivate
+	default:
+		return false
+	}
+}
+
+// enablePortalEncryption enables encryption in the portal room if it isn't already enabled.
+//
+// The state store is updated when the encryption event is sent, so media uploads in the message converter
+// start encrypting files immediately. The member list is marked as stale, because the bridge needs to know
+// every Matrix member of the room to share keys with them, so the next sync should fetch the full list.
+func (s *SlackConnector) enablePortalEncryption(ctx context.Context, portal *bridgev2.Portal) error {
+	mc, ok := s.br.Matrix.(*matrix.Connector)
+	if !ok || !mc.Config.Encryption.Allow {
+		return errEncryptionNotAllowed
+	}
+	if encrypted, err := mc.StateStore.IsEncrypted(ctx, portal.MXID); err != nil {
+		return fmt.Errorf("failed to check if room is encrypted: %w", err)
+	} else if !encrypted {
+		_, err = mc.Bot.SendStateEvent(ctx, portal.MXID, event.StateEncryption, "", &event.Content{
+			Parsed: &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send encryption event: %w", err)
+		}
+		zerolog.Ctx(ctx).Info().Msg("Enabled encryption in portal room")
+	}
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	meta.EncryptionPending = false
+	meta.MembersSyncedAt = jsontime.Unix{}
+	return portal.Save(ctx)
+}
+
+// applyPendingEncryption enables encryption in a room that was created while the encryption policy required it.
+// It's called before the initial forward backfill (which bridgev2 runs directly after creating the room)
+// and before handling live messages, so no history is bridged into the room unencrypted.
+func (s *SlackClient) applyPendingEncryption(ctx context.Context, portal *bridgev2.Portal) {
+	if portal.MXID == "" || !portal.Metadata.(*slackid.PortalMetadata).EncryptionPending {
+		return
+	}
+	err := s.Main.enablePortalEncryption(ctx, portal)
+	if errors.Is(err, errEncryptionNotAllowed) {
+		zerolog.Ctx(ctx).Warn().Msg("Encryption policy requires encrypting room, but encryption isn't allowed")
+		portal.Metadata.(*slackid.PortalMetadata).EncryptionPending = false
+		err = portal.Save(ctx)
+	}
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to enable encryption in new portal room")
+	}
+}
+
+func (s *SlackMessage) PreHandle(ctx context.Context, portal *bridgev2.Portal) {
+	s.Client.applyPendingEncryption(ctx, portal)
+}
+
+func (s *SlackChatResync) PostHandle(ctx context.Context, portal *bridgev2.Portal) {
+	s.Client.applyPendingEncryption(ctx, portal)
+}
+
+var (
+	_ bridgev2.RemotePreHandler  = (*SlackMessage)(nil)
+	_ bridgev2.RemotePostHandler = (*SlackChatResync)(nil)
+)
+
+var cmdEncrypt = &commands.FullHandler{
+	Func: fnEncrypt,
+	Name: "encrypt",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Enable end-to-bridge encryption in this room. Encryption can't be disabled afterwards.",
+	},
+	RequiresPortal:     true,
+	RequiresEventLevel: event.StateEncryption,
+}
+
+func fnEncrypt(ce *commands.Event) {
+	err := ce.Bridge.Network.(*SlackConnector).enablePortalEncryption(ce.Ctx, ce.Portal)
+	if errors.Is(err, errEncryptionNotAllowed) {
+		ce.Reply("Encryption is not allowed on this bridge")
+	} else if err != nil {
+		ce.Log.Err(err).Msg("Failed to enable encryption")
+		ce.Reply("Failed to enable encryption: %v", err)
+	} else {
+		ce.Reply("Encryption is enabled in this room")
+	}
+}
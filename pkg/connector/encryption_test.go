@@ -0,0 +1,49 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestShouldEncryptNewPortal(t *testing.T) {
+	tests := []struct {
+		policy    string
+		isPrivate bool
+		expected  bool
+	}{
+		{"", true, false},
+		{EncryptionPolicyDefault, true, false},
+		{EncryptionPolicyPrivate, true, true},
+		{EncryptionPolicyPrivate, false, false},
+		{EncryptionPolicyAll, false, true},
+	}
+	for _, test := range tests {
+		s := &SlackConnector{Config: Config{EncryptionPolicy: test.policy}}
+		assert.Equal(t, test.expected, s.shouldEncryptNewPortal(test.isPrivate), "policy %q, private %t", test.policy, test.isPrivate)
+	}
+}
+
+func TestConfigEncryptionPolicyValidation(t *testing.T) {
+	var cfg Config
+	assert.NoError(t, yaml.Unmarshal([]byte("encryption_policy: private"), &cfg))
+	assert.Equal(t, EncryptionPolicyPrivate, cfg.EncryptionPolicy)
+	assert.ErrorContains(t, yaml.Unmarshal([]byte("encryption_policy: sometimes"), &cfg), "invalid encryption_policy")
+}
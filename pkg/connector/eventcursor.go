@@ -0,0 +1,332 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+const (
+	// EventCursorFlushInterval is how often handled event timestamps are saved to the database.
+	EventCursorFlushInterval = 30 * time.Second
+	// ThreadReplayLookback is how far back before the last connection threads must have been active
+	// for their missed replies to be replayed.
+	ThreadReplayLookback = 24 * time.Hour
+	// MaxReplayThreadsPerChannel limits the number of threads checked for missed replies in a single channel.
+	MaxReplayThreadsPerChannel = 20
+)
+
+// eventCursorTracker remembers the timestamp of the last handled Slack event in each channel.
+//
+// The cursors loaded on connect are kept separately from the ones updated by live events,
+// so that live events handled before the startup sync can't hide events that were missed while the bridge was down.
+type eventCursorTracker struct {
+	lock     sync.Mutex
+	loaded   map[string]string
+	lastSeen time.Time
+	live     map[string]string
+	dirty    map[string]struct{}
+}
+
+func (ect *eventCursorTracker) reset(loaded map[string]string, lastSeen time.Time) {
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+	ect.loaded = loaded
+	ect.lastSeen = lastSeen
+	ect.live = maps.Clone(loaded)
+	ect.dirty = make(map[string]struct{})
+}
+
+// getLoaded returns the cursor of the given channel as it was when the login connected.
+func (ect *eventCursorTracker) getLoaded(channelID string) string {
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+	return ect.loaded[channelID]
+}
+
+func (ect *eventCursorTracker) getLastSeen() time.Time {
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+	return ect.lastSeen
+}
+
+func (ect *eventCursorTracker) record(channelID, ts string) {
+	if channelID == "" || ts == "" {
+		return
+	}
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+	if ect.live == nil {
+		ect.live = make(map[string]string)
+		ect.dirty = make(map[string]struct{})
+	}
+	if ect.live[channelID] < ts {
+		ect.live[channelID] = ts
+		ect.dirty[channelID] = struct{}{}
+	}
+}
+
+// takeDirty returns the cursors that have changed since the last call.
+func (ect *eventCursorTracker) takeDirty() map[string]string {
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+	if len(ect.dirty) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(ect.dirty))
+	for channelID := range ect.dirty {
+		out[channelID] = ect.live[channelID]
+	}
+	clear(ect.dirty)
+	return out
+}
+
+func (ect *eventCursorTracker) markDirty(channelIDs []string) {
+	ect.lock.Lock()
+	defer ect.lock.Unlock()
+	for _, channelID := range channelIDs {
+		ect.dirty[channelID] = struct{}{}
+	}
+}
+
+func (s *SlackClient) loadEventCursors(ctx context.Context) {
+	cursors, err := s.Main.DB.Cursors.GetAllForLogin(ctx, string(s.UserLogin.ID))
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to load event cursors")
+	}
+	loaded := make(map[string]string, len(cursors))
+	var lastSeen time.Time
+	for _, cursor := range cursors {
+		loaded[cursor.ChannelID] = cursor.LastTS
+		if cursor.UpdatedAt.After(lastSeen) {
+			lastSeen = cursor.UpdatedAt
+		}
+	}
+	s.eventCursors.reset(loaded, lastSeen)
+}
+
+// flushEventCursors saves the cursors that have changed since the last flush.
+func (s *SlackClient) flushEventCursors(ctx context.Context) error {
+	dirty := s.eventCursors.takeDirty()
+	if len(dirty) == 0 {
+		return nil
+	}
+	now := time.Now()
+	err := s.Main.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for channelID, ts := range dirty {
+			err := s.Main.DB.Cursors.Put(ctx, &slackdb.EventCursor{
+				LoginID:   string(s.UserLogin.ID),
+				ChannelID: channelID,
+				LastTS:    ts,
+				UpdatedAt: now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.eventCursors.markDirty(slices.Collect(maps.Keys(dirty)))
+	}
+	return err
+}
+
+func (s *SlackClient) startEventCursorFlushLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = s.UserLogin.Log.With().Str("component", "event cursor flush loop").Logger().WithContext(ctx)
+	if cancelOld := s.stopCursorFlush.Swap(&cancel); cancelOld != nil {
+		(*cancelOld)()
+	}
+	go s.runEventCursorFlushLoop(ctx)
+}
+
+func (s *SlackClient) runEventCursorFlushLoop(ctx context.Context) {
+	ticker := time.NewTicker(EventCursorFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.flushEventCursors(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Msg("Failed to save event cursors")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// saveEventCursors stops the flush loop and saves the cursors before disconnecting,
+// marking the login as having been connected until now.
+func (s *SlackClient) saveEventCursors() {
+	cancel := s.stopCursorFlush.Swap(nil)
+	if cancel == nil {
+		// The login wasn't connected, so there's nothing to save
+		return
+	}
+	(*cancel)()
+	ctx := s.UserLogin.Log.WithContext(context.Background())
+	err := s.flushEventCursors(ctx)
+	if err == nil {
+		err = s.Main.DB.Cursors.Touch(ctx, string(s.UserLogin.ID), time.Now())
+	}
+	if err != nil {
+		s.UserLogin.Log.Err(err).Msg("Failed to save event cursors before disconnecting")
+	}
+}
+
+// getReplayStart returns the timestamp after which events in the given channel may have been missed,
+// or an empty string if the bridge was down for too long for events to be replayed.
+func (s *SlackClient) getReplayStart(channelID string) string {
+	window := s.Main.currentConfig().Backfill.ReplayWindow
+	lastSeen := s.eventCursors.getLastSeen()
+	if window <= 0 || lastSeen.IsZero() || time.Since(lastSeen) > window {
+		return ""
+	} else if cursor := s.eventCursors.getLoaded(channelID); cursor != "" {
+		return cursor
+	}
+	// Cursors are only saved periodically, so anything after the last save may have been missed
+	return formatSlackTimestamp(lastSeen.Add(-EventCursorFlushInterval))
+}
+
+// replayMissedThreadReplies queues thread replies that were sent in the given channel while the bridge was down.
+//
+// Top-level messages are caught up on by the forward backfill of the chat resync, which also backfills
+// threads started on them, but replies in older threads aren't. Those are fetched for threads that had
+// bridged replies shortly before the bridge stopped. Replies that are already bridged are skipped,
+// so events received just before a restart aren't duplicated.
+func (s *SlackClient) replayMissedThreadReplies(ctx context.Context, portalKey networkid.PortalKey, channelID string) {
+	oldest := s.getReplayStart(channelID)
+	if oldest == "" {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "replay missed thread replies").
+		Str("channel_id", channelID).
+		Str("oldest", oldest).
+		Logger()
+	lastSeen := s.eventCursors.getLastSeen()
+	recent, err := s.Main.br.DB.Message.GetMessagesBetweenTimeQuery(ctx, portalKey, lastSeen.Add(-ThreadReplayLookback), time.Now())
+	if err != nil {
+		log.Err(err).Msg("Failed to get recent messages")
+		return
+	}
+	var threadRoots []networkid.MessageID
+	// Newest threads first, so the most active ones are checked if there are too many
+	for _, msg := range slices.Backward(recent) {
+		if msg.ThreadRoot != "" && !slices.Contains(threadRoots, msg.ThreadRoot) {
+			threadRoots = append(threadRoots, msg.ThreadRoot)
+			if len(threadRoots) >= MaxReplayThreadsPerChannel {
+				break
+			}
+		}
+	}
+	queued := 0
+	for _, threadRoot := range threadRoots {
+		_, _, threadTS, ok := slackid.ParseMessageID(threadRoot)
+		if !ok {
+			continue
+		}
+		n, err := s.replayThread(ctx, portalKey, channelID, threadTS, oldest)
+		if err != nil {
+			log.Err(err).Str("thread_ts", threadTS).Msg("Failed to fetch missed thread replies")
+			continue
+		}
+		queued += n
+	}
+	if queued > 0 {
+		log.Debug().
+			Int("thread_count", len(threadRoots)).
+			Int("queued_count", queued).
+			Msg("Queued missed thread replies")
+	}
+}
+
+func (s *SlackClient) replayThread(ctx context.Context, portalKey networkid.PortalKey, channelID, threadTS, oldest string) (int, error) {
+	params := &slack.GetConversationRepliesParameters{
+		GetConversationHistoryParameters: slack.GetConversationHistoryParameters{
+			ChannelID: channelID,
+			Oldest:    oldest,
+			Limit:     200,
+		},
+		Timestamp: threadTS,
+	}
+	var messages []slack.Message
+	for len(messages) < MaxHistoryRangeMessages {
+		chunk, err := s.Client.GetConversationRepliesContext(ctx, params)
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, chunk.Messages...)
+		if !chunk.HasMore || chunk.ResponseMetadata.Cursor == "" {
+			break
+		}
+		params.Cursor = chunk.ResponseMetadata.Cursor
+	}
+	queued := 0
+	for _, msg := range messages {
+		// The thread root is always included in the response
+		if msg.Timestamp == threadTS || msg.Timestamp <= oldest {
+			continue
+		}
+		msgID := slackid.MakeMessageID(s.TeamID, channelID, msg.Timestamp)
+		existing, err := s.Main.br.DB.Message.GetFirstPartByID(ctx, portalKey.Receiver, msgID)
+		if err != nil {
+			return queued, err
+		} else if existing != nil {
+			continue
+		}
+		s.queueHistoryMessage(portalKey, channelID, &msg.Msg)
+		queued++
+	}
+	return queued, nil
+}
+
+// PostHandle records the message as the latest handled one in the channel after bridgev2 has processed it.
+//
+// bridgev2 doesn't report whether handling succeeded, so only new messages that were saved to the database
+// are recorded. Other events are skipped, as a cursor that lags behind only means more messages are checked
+// when backfilling, while one that skips a failed message would lose it.
+func (s *SlackMessage) PostHandle(ctx context.Context, portal *bridgev2.Portal) {
+	if s.GetType() != bridgev2.RemoteEventMessage {
+		return
+	}
+	msg, err := s.Client.Main.br.DB.Message.GetFirstPartByID(ctx, portal.Receiver, s.GetID())
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to check if message was saved before recording event cursor")
+		return
+	} else if msg == nil {
+		zerolog.Ctx(ctx).Debug().Msg("Not recording event cursor as message wasn't saved")
+		return
+	}
+	s.Client.eventCursors.record(s.Data.Channel, s.Data.Timestamp)
+}
+
+var _ bridgev2.RemotePostHandler = (*SlackMessage)(nil)
@@ -0,0 +1,131 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestEventCursorTracker(t *testing.T) {
+	var ect eventCursorTracker
+	ect.reset(map[string]string{"C1": "100.000000"}, time.UnixMilli(1))
+	assert.Nil(t, ect.takeDirty())
+
+	ect.record("C1", "099.000000")
+	ect.record("C1", "101.000000")
+	ect.record("C2", "050.000000")
+	ect.record("", "200.000000")
+	assert.Equal(t, map[string]string{"C1": "101.000000", "C2": "050.000000"}, ect.takeDirty())
+	assert.Nil(t, ect.takeDirty())
+	// Live events don't change the cursors loaded on connect
+	assert.Equal(t, "100.000000", ect.getLoaded("C1"))
+	assert.Equal(t, "", ect.getLoaded("C2"))
+
+	ect.markDirty([]string{"C2"})
+	assert.Equal(t, map[string]string{"C2": "050.000000"}, ect.takeDirty())
+}
+
+func TestSlackChatResync_CheckNeedsBackfillWithCursor(t *testing.T) {
+	s := newTestSlackClient(nil)
+	s.eventCursors.reset(map[string]string{"C1": "1700000000.000200"}, time.Now())
+	latestBridged := &database.Message{ID: slackid.MakeMessageID("T1", "C1", "1700000000.000100")}
+	testCases := []struct {
+		name      string
+		channelID string
+		latest    string
+		expected  bool
+	}{
+		{"CursorAfterLatest", "C1", "1700000000.000150", false},
+		{"CursorAtLatest", "C1", "1700000000.000200", false},
+		{"NewerMessage", "C1", "1700000000.000300", true},
+		{"NoCursor", "C2", "1700000000.000150", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resync := &SlackChatResync{
+				SlackEventMeta: &SlackEventMeta{
+					PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", tc.channelID)},
+				},
+				Client:        s,
+				LatestMessage: tc.latest,
+			}
+			needsBackfill, err := resync.CheckNeedsBackfill(context.Background(), latestBridged)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, needsBackfill)
+		})
+	}
+}
+
+func TestGetReplayStart(t *testing.T) {
+	s := newTestSlackClient(nil)
+	s.Main = &SlackConnector{Config: Config{Backfill: BackfillConfig{ReplayWindow: 15 * time.Minute}}}
+	lastSeen := time.Now().Add(-5 * time.Minute)
+	s.eventCursors.reset(map[string]string{"C1": "1700000000.000200"}, lastSeen)
+	assert.Equal(t, "1700000000.000200", s.getReplayStart("C1"))
+	assert.Equal(t, formatSlackTimestamp(lastSeen.Add(-EventCursorFlushInterval)), s.getReplayStart("C2"))
+
+	s.eventCursors.reset(map[string]string{"C1": "1700000000.000200"}, time.Now().Add(-time.Hour))
+	assert.Equal(t, "", s.getReplayStart("C1"))
+
+	s.eventCursors.reset(nil, time.Time{})
+	assert.Equal(t, "", s.getReplayStart("C1"))
+}
+
+func TestSlackMessage_PostHandleOnlyRecordsSavedMessages(t *testing.T) {
+	br := newTestBridgeDB(t)
+	ctx := context.Background()
+	s := newTestSlackClient(nil)
+	s.Main = &SlackConnector{br: br}
+	s.eventCursors.reset(nil, time.Time{})
+	portal := &bridgev2.Portal{Portal: &database.Portal{
+		PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", "C1")},
+		Metadata:  &slackid.PortalMetadata{},
+	}}
+	require.NoError(t, br.DB.Portal.Insert(ctx, portal.Portal))
+	makeMessage := func(ts string) *SlackMessage {
+		return &SlackMessage{
+			SlackEventMeta: &SlackEventMeta{PortalKey: portal.PortalKey},
+			Data:           &slack.MessageEvent{Msg: slack.Msg{Channel: "C1", Timestamp: ts}},
+			Client:         s,
+		}
+	}
+
+	// Handling failed, so the message wasn't saved
+	makeMessage("1700000000.000100").PostHandle(ctx, portal)
+	assert.Nil(t, s.eventCursors.takeDirty())
+
+	require.NoError(t, br.DB.Message.Insert(ctx, &database.Message{
+		ID:        slackid.MakeMessageID("T1", "C1", "1700000000.000200"),
+		MXID:      "$msg",
+		Room:      portal.PortalKey,
+		Timestamp: time.Unix(1700000000, 0),
+		Metadata:  &slackid.MessageMetadata{},
+	}))
+	makeMessage("1700000000.000200").PostHandle(ctx, portal)
+	assert.Equal(t, map[string]string{"C1": "1700000000.000200"}, s.eventCursors.takeDirty())
+}
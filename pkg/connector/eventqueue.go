@@ -0,0 +1,147 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+)
+
+const DefaultEventQueueSize = 512
+
+// EventQueue is a bounded buffer between the Slack websocket and the bridge event handlers.
+//
+// When the queue is full, low priority events (typing notifications and read markers) are dropped,
+// while everything else blocks until there's space, which applies backpressure to the websocket reader.
+// Events pushed after the queue is closed are dropped, as they may come from HTTP callbacks that race
+// with the disconnect.
+type EventQueue struct {
+	ch  chan any
+	log zerolog.Logger
+
+	// lock is held for reading while pushing, so that the channel isn't closed during a send
+	lock      sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+
+	dropped  atomic.Uint64
+	maxDepth atomic.Int64
+}
+
+type EventQueueStats struct {
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	MaxDepth int    `json:"max_depth"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+func NewEventQueue(size int, log zerolog.Logger) *EventQueue {
+	if size <= 0 {
+		size = DefaultEventQueueSize
+	}
+	return &EventQueue{
+		ch:   make(chan any, size),
+		log:  log,
+		done: make(chan struct{}),
+	}
+}
+
+func isLowPriorityEvent(evt any) bool {
+	switch evt.(type) {
+	case *slack.UserTypingEvent, *slack.ChannelMarkedEvent, *slack.IMMarkedEvent, *slack.GroupMarkedEvent,
+		*slack.LatencyReport:
+		return true
+	default:
+		return false
+	}
+}
+
+// Push adds an event to the queue. Low priority events are dropped if the queue is full,
+// and all events are dropped if the queue is closed.
+func (eq *EventQueue) Push(evt any) {
+	eq.lock.RLock()
+	defer eq.lock.RUnlock()
+	if eq.closed {
+		eq.log.Debug().Type("event_type", evt).Msg("Dropping event pushed to closed queue")
+		return
+	} else if isLowPriorityEvent(evt) {
+		select {
+		case eq.ch <- evt:
+		default:
+			dropped := eq.dropped.Add(1)
+			if dropped == 1 || dropped%100 == 0 {
+				eq.log.Warn().
+					Uint64("total_dropped", dropped).
+					Type("event_type", evt).
+					Msg("Event queue is full, dropping low priority events")
+			}
+			return
+		}
+	} else {
+		select {
+		case eq.ch <- evt:
+		default:
+			eq.log.Warn().Int("capacity", cap(eq.ch)).Msg("Event queue is full, blocking websocket reader")
+			select {
+			case eq.ch <- evt:
+			case <-eq.done:
+				eq.log.Debug().Type("event_type", evt).Msg("Dropping event as queue was closed while waiting for space")
+				return
+			}
+		}
+	}
+	depth := int64(len(eq.ch))
+	for {
+		prevMax := eq.maxDepth.Load()
+		if depth <= prevMax || eq.maxDepth.CompareAndSwap(prevMax, depth) {
+			break
+		}
+	}
+}
+
+// Close stops the queue. Consume will return after all remaining events have been handled.
+// It's safe to call Close multiple times and concurrently with Push.
+func (eq *EventQueue) Close() {
+	eq.closeOnce.Do(func() {
+		// Wake up blocked pushers first, as they hold the read lock
+		close(eq.done)
+		eq.lock.Lock()
+		eq.closed = true
+		close(eq.ch)
+		eq.lock.Unlock()
+	})
+}
+
+// Consume calls the given handler for each event in the queue until the queue is closed.
+func (eq *EventQueue) Consume(handler func(evt any)) {
+	for evt := range eq.ch {
+		handler(evt)
+	}
+}
+
+func (eq *EventQueue) Stats() EventQueueStats {
+	return EventQueueStats{
+		Depth:    len(eq.ch),
+		Capacity: cap(eq.ch),
+		MaxDepth: int(eq.maxDepth.Load()),
+		Dropped:  eq.dropped.Load(),
+	}
+}
@@ -0,0 +1,51 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventQueue_CloseWhilePushBlocked(t *testing.T) {
+	queue := NewEventQueue(1, zerolog.Nop())
+	queue.Push(&slack.MessageEvent{})
+	pushed := make(chan struct{})
+	go func() {
+		// The queue is full, so this blocks until the queue is closed
+		queue.Push(&slack.MessageEvent{})
+		close(pushed)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	queue.Close()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("blocked push didn't return after closing queue")
+	}
+	queue.Close()
+	// Pushing to a closed queue is a no-op instead of a panic
+	queue.Push(&slack.MessageEvent{})
+
+	var received int
+	queue.Consume(func(evt any) { received++ })
+	assert.Equal(t, 1, received)
+}
@@ -0,0 +1,205 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// maxEventsAPIBodySize is the maximum size of an Events API request body. Slack's event payloads are far smaller.
+const maxEventsAPIBodySize = 1 << 20
+
+// eventsAPIDedupTTL is how long delivered event IDs are remembered. Slack gives up retrying well before this.
+const eventsAPIDedupTTL = 1 * time.Hour
+
+// eventsAPIEnvelope contains the fields of an Events API callback that slackevents doesn't parse.
+type eventsAPIEnvelope struct {
+	EventID        string          `json:"event_id"`
+	Event          json.RawMessage `json:"event"`
+	Authorizations []struct {
+		TeamID string `json:"team_id"`
+		UserID string `json:"user_id"`
+	} `json:"authorizations"`
+}
+
+// usesEventsAPI returns true if the login receives events through ServeEventsAPI instead of a websocket.
+func (s *SlackClient) usesEventsAPI() bool {
+	return !s.IsRealUser && s.SocketMode == nil && s.Client != nil && s.Main.currentConfig().EventsAPI.SigningSecret != ""
+}
+
+// eventsAPIDedup remembers the IDs of recently delivered Events API callbacks,
+// so that retries of events that were already received aren't bridged twice.
+type eventsAPIDedup struct {
+	seen      map[string]time.Time
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+// markNew returns true if the event ID hasn't been seen within eventsAPIDedupTTL.
+func (d *eventsAPIDedup) markNew(eventID string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	} else if now.Sub(d.lastPrune) > time.Minute {
+		for id, ts := range d.seen {
+			if now.Sub(ts) > eventsAPIDedupTTL {
+				delete(d.seen, id)
+			}
+		}
+		d.lastPrune = now
+	}
+	if ts, ok := d.seen[eventID]; ok && now.Sub(ts) <= eventsAPIDedupTTL {
+		return false
+	}
+	d.seen[eventID] = now
+	return true
+}
+
+// parseEventsAPIInnerEvent converts the inner event of a callback into the same type that the RTM connection
+// would produce, so that HandleSlackEvent can handle both. Events that RTM doesn't know about are returned as
+// parsed by slackevents.
+func parseEventsAPIInnerEvent(raw json.RawMessage, fallback any) any {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(raw, &typed) != nil {
+		return fallback
+	}
+	mapped, ok := slack.EventMapping[typed.Type]
+	if !ok {
+		return fallback
+	}
+	evt := reflect.New(reflect.TypeOf(mapped)).Interface()
+	if json.Unmarshal(raw, evt) != nil {
+		return fallback
+	}
+	return evt
+}
+
+// queueEventsAPIEvent passes an event received through ServeEventsAPI to the event handlers of the login.
+func (s *SlackClient) queueEventsAPIEvent(evt any) {
+	queue := s.eventsAPIQueue.Load()
+	if queue == nil {
+		s.UserLogin.Log.Warn().Type("event_type", evt).Msg("Dropping Events API event for disconnected login")
+		return
+	}
+	queue.Push(evt)
+}
+
+// findEventsAPIClients returns the connected bot logins that an Events API callback was delivered for.
+func (s *SlackConnector) findEventsAPIClients(envelope *eventsAPIEnvelope) []*SlackClient {
+	var clients []*SlackClient
+	for _, auth := range envelope.Authorizations {
+		login := s.br.GetCachedUserLoginByID(slackid.MakeUserLoginID(auth.TeamID, auth.UserID))
+		if login == nil {
+			continue
+		}
+		client, ok := login.Client.(*SlackClient)
+		if ok && client.usesEventsAPI() && s.ownsTeam(client.TeamID) {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// ServeEventsAPI receives events of bot logins from Slack's HTTP Events API.
+// Requests are authenticated with the app's signing secret. Callbacks are acknowledged before they're handled,
+// as Slack retries deliveries that aren't acknowledged within three seconds, and retries are deduplicated
+// using the event ID.
+func (s *SlackConnector) ServeEventsAPI(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	secret := s.currentConfig().EventsAPI.SigningSecret
+	if secret == "" {
+		http.Error(w, "Events API is not enabled", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEventsAPIBodySize))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	verifier, err := slack.NewSecretsVerifier(r.Header, secret)
+	if err == nil {
+		_, _ = verifier.Write(body)
+		err = verifier.Ensure()
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("Rejecting Events API request with invalid signature")
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	evt, err := slackevents.ParseEvent(body, slackevents.OptionNoVerifyToken())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to parse Events API request")
+		http.Error(w, "Failed to parse event", http.StatusBadRequest)
+		return
+	}
+	switch evt.Type {
+	case slackevents.URLVerification:
+		challenge, ok := evt.Data.(*slackevents.EventsAPIURLVerificationEvent)
+		if !ok {
+			http.Error(w, "Invalid URL verification event", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(challenge.Challenge))
+		return
+	case slackevents.CallbackEvent:
+		var envelope eventsAPIEnvelope
+		if err = json.Unmarshal(body, &envelope); err != nil {
+			log.Warn().Err(err).Msg("Failed to parse Events API callback envelope")
+			http.Error(w, "Failed to parse event", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if envelope.EventID != "" && !s.eventsAPIDedup.markNew(envelope.EventID) {
+			log.Debug().
+				Str("event_id", envelope.EventID).
+				Str("retry_num", r.Header.Get("X-Slack-Retry-Num")).
+				Str("retry_reason", r.Header.Get("X-Slack-Retry-Reason")).
+				Msg("Dropping duplicate Events API callback")
+			return
+		}
+		clients := s.findEventsAPIClients(&envelope)
+		if len(clients) == 0 {
+			log.Debug().Str("event_id", envelope.EventID).Msg("Dropping Events API callback without matching bot login")
+			return
+		}
+		innerEvt := parseEventsAPIInnerEvent(envelope.Event, evt.InnerEvent.Data)
+		for _, client := range clients {
+			ctx := client.UserLogin.Log.With().Str("action", "queue events api event").Logger().WithContext(context.Background())
+			client.goWithRecover(ctx, innerEvt, func() { client.queueEventsAPIEvent(innerEvt) })
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
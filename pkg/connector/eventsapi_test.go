@@ -0,0 +1,128 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+)
+
+func makeEventsAPIRequest(secret, body string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+	req := httptest.NewRequest(http.MethodPost, "/_slack/events", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestServeEventsAPI(t *testing.T) {
+	const challengeBody = `{"type":"url_verification","token":"x","challenge":"abc123"}`
+	tests := []struct {
+		name         string
+		configSecret string
+		signSecret   string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"disabled", "", "secret", challengeBody, http.StatusNotFound, ""},
+		{"invalid signature", "secret", "wrong", challengeBody, http.StatusUnauthorized, ""},
+		{"url verification", "secret", "secret", challengeBody, http.StatusOK, "abc123"},
+		{"unparseable", "secret", "secret", `{"type":`, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &SlackConnector{}
+			s.Config.EventsAPI.SigningSecret = test.configSecret
+			rec := httptest.NewRecorder()
+			s.ServeEventsAPI(rec, makeEventsAPIRequest(test.signSecret, test.body))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			if test.expectedBody != "" {
+				assert.Equal(t, test.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeEventsAPI_MessageCallback(t *testing.T) {
+	const body = `{"type":"event_callback","team_id":"T1","event_id":"Ev1",` +
+		`"event":{"type":"message","channel":"C1","user":"U2","text":"hello","ts":"1700000000.000100"}}`
+	s := &SlackConnector{}
+	s.Config.EventsAPI.SigningSecret = "secret"
+
+	rec := httptest.NewRecorder()
+	s.ServeEventsAPI(rec, makeEventsAPIRequest("secret", body))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	// Retries of the same event are acknowledged, but not handled again
+	assert.False(t, s.eventsAPIDedup.markNew("Ev1"))
+	rec = httptest.NewRecorder()
+	req := makeEventsAPIRequest("secret", body)
+	req.Header.Set("X-Slack-Retry-Num", "1")
+	s.ServeEventsAPI(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestQueueEventsAPIEvent_Message(t *testing.T) {
+	const inner = `{"type":"message","channel":"C1","user":"U2","text":"hello","ts":"1700000000.000100"}`
+	evt := parseEventsAPIInnerEvent([]byte(inner), nil)
+	msg, ok := evt.(*slack.MessageEvent)
+	require.True(t, ok, "expected *slack.MessageEvent, got %T", evt)
+	assert.Equal(t, "C1", msg.Channel)
+	assert.Equal(t, "hello", msg.Text)
+
+	client := &SlackClient{UserLogin: &bridgev2.UserLogin{UserLogin: &database.UserLogin{}, Log: zerolog.Nop()}}
+	// Events for disconnected logins are dropped instead of blocking
+	client.queueEventsAPIEvent(evt)
+
+	queue := NewEventQueue(1, zerolog.Nop())
+	client.eventsAPIQueue.Store(queue)
+	client.queueEventsAPIEvent(evt)
+	queue.Close()
+	var received []any
+	queue.Consume(func(evt any) {
+		received = append(received, evt)
+	})
+	require.Len(t, received, 1)
+	assert.Same(t, msg, received[0])
+}
+
+func TestParseEventsAPIInnerEvent_Reaction(t *testing.T) {
+	const inner = `{"type":"reaction_added","user":"U2","reaction":"thumbsup",` +
+		`"item":{"type":"message","channel":"C1","ts":"1700000000.000100"},"event_ts":"1700000001.000100"}`
+	evt, ok := parseEventsAPIInnerEvent([]byte(inner), nil).(*slack.ReactionAddedEvent)
+	require.True(t, ok)
+	assert.Equal(t, "C1", evt.Item.Channel)
+	assert.Equal(t, "thumbsup", evt.Reaction)
+}
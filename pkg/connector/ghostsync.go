@@ -0,0 +1,195 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// DefaultGhostSyncBatchSize is the number of users fetched from Slack at once in bulk syncs if no batch size is configured.
+const DefaultGhostSyncBatchSize = 100
+
+// GhostUpdateLimiter limits how fast profile changes found in bulk user syncs are applied to ghosts,
+// so that syncing large workspaces doesn't flood the homeserver with avatar uploads and profile changes.
+type GhostUpdateLimiter struct {
+	sema     chan struct{}
+	interval time.Duration
+
+	lock sync.Mutex
+	next time.Time
+}
+
+func NewGhostUpdateLimiter(cfg GhostSyncConfig) *GhostUpdateLimiter {
+	gl := &GhostUpdateLimiter{}
+	if cfg.MaxConcurrency > 0 {
+		gl.sema = make(chan struct{}, cfg.MaxConcurrency)
+	}
+	if cfg.UpdatesPerSecond > 0 {
+		gl.interval = time.Duration(float64(time.Second) / cfg.UpdatesPerSecond)
+	}
+	return gl
+}
+
+// Acquire waits until a ghost update is allowed to run. The returned function must be called when the update is done.
+func (gl *GhostUpdateLimiter) Acquire(ctx context.Context) (func(), error) {
+	if gl == nil {
+		return func() {}, nil
+	}
+	if gl.interval > 0 {
+		gl.lock.Lock()
+		now := time.Now()
+		if gl.next.Before(now) {
+			gl.next = now
+		}
+		wait := gl.next.Sub(now)
+		gl.next = gl.next.Add(gl.interval)
+		gl.lock.Unlock()
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if gl.sema != nil {
+		select {
+		case gl.sema <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return func() { <-gl.sema }, nil
+	}
+	return func() {}, nil
+}
+
+// ghostInfoChanged returns true if applying the given info to the ghost would make any requests to the homeserver.
+// The checks mirror the ones in the bridgev2 Ghost.Update* methods.
+func ghostInfoChanged(ghost *bridgev2.Ghost, info *bridgev2.UserInfo) bool {
+	if info.Name != nil && (*info.Name != ghost.Name || !ghost.NameSet) {
+		return true
+	}
+	if info.Avatar != nil && (info.Avatar.ID != ghost.AvatarID || !ghost.AvatarSet) {
+		return true
+	}
+	if info.Identifiers != nil || info.IsBot != nil {
+		if !ghost.ContactInfoSet {
+			return true
+		} else if info.IsBot != nil && *info.IsBot != ghost.IsBot {
+			return true
+		} else if info.Identifiers != nil {
+			identifiers := slices.Clone(info.Identifiers)
+			slices.Sort(identifiers)
+			return !slices.Equal(identifiers, ghost.Identifiers)
+		}
+	}
+	return false
+}
+
+type ghostUpdate struct {
+	ghost *bridgev2.Ghost
+	info  *bridgev2.UserInfo
+}
+
+// applyGhostUpdates applies user info fetched in a bulk sync to ghosts. Updates that don't change anything on Matrix
+// are only saved to the database, the rest are rate limited by the ghost update limiter.
+func (s *SlackClient) applyGhostUpdates(ctx context.Context, updates []ghostUpdate) {
+	limiter := s.Main.ghostUpdateLimiter.Load()
+	var wg sync.WaitGroup
+	var unchanged int
+	for _, update := range updates {
+		if !ghostInfoChanged(update.ghost, update.info) {
+			update.ghost.UpdateInfo(ctx, update.info)
+			unchanged++
+			continue
+		}
+		done, err := limiter.Acquire(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Stopped applying ghost updates")
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer done()
+			update.ghost.UpdateInfo(ctx, update.info)
+		}()
+	}
+	wg.Wait()
+	zerolog.Ctx(ctx).Debug().
+		Int("update_count", len(updates)).
+		Int("unchanged_count", unchanged).
+		Msg("Applied ghost updates")
+}
+
+// getTeamGhostIDsQuery lists all ghosts of a team. Ghost IDs are lowercase versions of team-user ID pairs.
+const getTeamGhostIDsQuery = `SELECT id FROM ghost WHERE bridge_id=$1 AND id LIKE $2`
+
+var scanGhostID = dbutil.ConvertRowFn[networkid.UserID](dbutil.ScanSingleColumn[networkid.UserID])
+
+// resyncTeamGhosts fetches the profiles of all known ghosts in the login's team and applies them,
+// even if Slack says they haven't changed. It's used to apply changes to the displayname config.
+func (s *SlackClient) resyncTeamGhosts(ctx context.Context) (int, error) {
+	ghostIDs, err := scanGhostID.NewRowIter(s.Main.br.DB.Query(ctx, getTeamGhostIDsQuery, s.Main.br.ID, strings.ToLower(s.TeamID)+"-%")).AsList()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get ghost IDs: %w", err)
+	}
+	users := make(map[string]*bridgev2.Ghost, len(ghostIDs))
+	var bots []*bridgev2.Ghost
+	for _, ghostID := range ghostIDs {
+		ghost, err := s.Main.br.GetExistingGhostByID(ctx, ghostID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get ghost %s: %w", ghostID, err)
+		} else if ghost == nil {
+			continue
+		}
+		ghost.Metadata.(*slackid.GhostMetadata).SlackUpdatedTS = 0
+		_, userID := slackid.ParseUserID(ghostID)
+		if s.IsRealUser && !strings.HasPrefix(userID, "B") {
+			users[userID] = ghost
+		} else {
+			bots = append(bots, ghost)
+		}
+	}
+	if len(users) > 0 {
+		s.syncManyUsers(ctx, users)
+	}
+	updates := make([]ghostUpdate, 0, len(bots))
+	for _, ghost := range bots {
+		_, userID := slackid.ParseUserID(ghost.ID)
+		info, err := s.fetchUserInfo(ctx, userID, 0, ghost)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("user_id", userID).Msg("Failed to fetch user info for resync")
+		} else if info != nil {
+			updates = append(updates, ghostUpdate{ghost: ghost, info: info})
+		}
+	}
+	s.applyGhostUpdates(ctx, updates)
+	return len(users) + len(bots), nil
+}
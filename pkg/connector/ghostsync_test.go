@@ -0,0 +1,99 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+)
+
+func TestGhostInfoChanged(t *testing.T) {
+	makeGhost := func() *bridgev2.Ghost {
+		return &bridgev2.Ghost{Ghost: &database.Ghost{
+			Name:           "Alice",
+			NameSet:        true,
+			AvatarID:       "hash1",
+			AvatarSet:      true,
+			Identifiers:    []string{"slack-internal:U1"},
+			ContactInfoSet: true,
+		}}
+	}
+	type testCase struct {
+		name     string
+		modify   func(ghost *bridgev2.Ghost)
+		info     *bridgev2.UserInfo
+		expected bool
+	}
+	testCases := []testCase{
+		{"Unchanged", nil, &bridgev2.UserInfo{
+			Name:        ptr.Ptr("Alice"),
+			Avatar:      &bridgev2.Avatar{ID: "hash1"},
+			Identifiers: []string{"slack-internal:U1"},
+			IsBot:       ptr.Ptr(false),
+		}, false},
+		{"NoAvatarInfo", nil, &bridgev2.UserInfo{Name: ptr.Ptr("Alice")}, false},
+		{"NameChanged", nil, &bridgev2.UserInfo{Name: ptr.Ptr("Bob")}, true},
+		{"NameNotSet", func(ghost *bridgev2.Ghost) { ghost.NameSet = false }, &bridgev2.UserInfo{Name: ptr.Ptr("Alice")}, true},
+		{"AvatarChanged", nil, &bridgev2.UserInfo{Avatar: &bridgev2.Avatar{ID: "hash2"}}, true},
+		{"BecameBot", nil, &bridgev2.UserInfo{IsBot: ptr.Ptr(true)}, true},
+		{"IdentifiersChanged", nil, &bridgev2.UserInfo{Identifiers: []string{"slack-internal:U2"}}, true},
+		{"ContactInfoNotSet", func(ghost *bridgev2.Ghost) { ghost.ContactInfoSet = false }, &bridgev2.UserInfo{IsBot: ptr.Ptr(false)}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ghost := makeGhost()
+			if tc.modify != nil {
+				tc.modify(ghost)
+			}
+			assert.Equal(t, tc.expected, ghostInfoChanged(ghost, tc.info))
+		})
+	}
+}
+
+func TestGhostUpdateLimiter_Acquire(t *testing.T) {
+	gl := NewGhostUpdateLimiter(GhostSyncConfig{MaxConcurrency: 2, UpdatesPerSecond: 50})
+	start := time.Now()
+	for range 3 {
+		done, err := gl.Acquire(context.Background())
+		require.NoError(t, err)
+		done()
+	}
+	// The first update starts immediately and each following one waits for 20ms
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gl = NewGhostUpdateLimiter(GhostSyncConfig{MaxConcurrency: 1})
+	_, err := gl.Acquire(ctx)
+	require.NoError(t, err)
+	cancel()
+	_, err = gl.Acquire(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGhostUpdateLimiter_Nil(t *testing.T) {
+	var gl *GhostUpdateLimiter
+	done, err := gl.Acquire(context.Background())
+	require.NoError(t, err)
+	done()
+}
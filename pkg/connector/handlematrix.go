@@ -20,17 +20,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
 
 	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
 	"go.mau.fi/mautrix-slack/pkg/emoji"
 	"go.mau.fi/mautrix-slack/pkg/msgconv"
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
@@ -42,23 +47,46 @@ var (
 	_ bridgev2.TypingHandlingNetworkAPI      = (*SlackClient)(nil)
 	_ bridgev2.RoomNameHandlingNetworkAPI    = (*SlackClient)(nil)
 	_ bridgev2.RoomTopicHandlingNetworkAPI   = (*SlackClient)(nil)
+	_ bridgev2.MembershipHandlingNetworkAPI  = (*SlackClient)(nil)
 )
 
-func (s *SlackClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.MatrixMessage) (*bridgev2.MatrixMessageResponse, error) {
-	if s.Client == nil {
-		return nil, bridgev2.ErrNotLoggedIn
+func (s *SlackClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.MatrixMessage) (resp *bridgev2.MatrixMessageResponse, err error) {
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
+	} else if s.isReadOnly() {
+		return nil, errReadOnlyLogin
+	}
+	ctx, span := tracer.Start(ctx, "HandleMatrixMessage", trace.WithAttributes(attribute.String("matrix.event_id", msg.Event.ID.String())))
+	defer func() { endSpan(span, err) }()
+	done, err := s.sends.start()
+	if err != nil {
+		return nil, err
 	}
+	defer done()
 	_, channelID := slackid.ParsePortalID(msg.Portal.ID)
 	if channelID == "" {
 		return nil, errors.New("invalid channel ID")
 	}
-	conv, err := s.Main.MsgConv.ToSlack(ctx, s.Client, msg.Portal, msg.Content, msg.Event, msg.ThreadRoot, nil, msg.OrigSender, s.IsRealUser)
+	if resp, handled, err := s.handleBlockActionReply(ctx, msg); handled {
+		return resp, err
+	}
+	convCtx, convSpan := tracer.Start(ctx, "ToSlack")
+	conv, err := s.Main.MsgConv.ToSlack(s.withEmojiUploader(convCtx), s.Client, msg.Portal, msg.Content, msg.Event, msg.ThreadRoot, nil, msg.OrigSender, s.IsRealUser)
+	endSpan(convSpan, err)
 	if err != nil {
 		return nil, err
 	}
 	timestamp, err := s.sendToSlack(ctx, channelID, conv, msg)
-	if err != nil {
-		return nil, err
+	if err != nil && conv.FileUpload != nil {
+		return nil, wrapSlackUploadError(err, conv.FileUpload.Filename)
+	} else if err != nil && conv.FileShare != nil {
+		return nil, wrapSlackUploadError(err, "")
+	} else if err != nil {
+		return nil, wrapSlackError(err)
+	}
+	s.auditLog(ctx, slackdb.AuditActionSendToSlack, channelID, msg.Event.Sender.String(), timestamp)
+	if len(conv.FailedParts) > 0 {
+		s.sendFailedPartsNotice(ctx, msg, conv.FailedParts)
 	}
 	if timestamp == "" {
 		return &bridgev2.MatrixMessageResponse{Pending: true}, nil
@@ -77,15 +105,23 @@ func (s *SlackClient) sendToSlack(
 	channelID string,
 	conv *msgconv.ConvertedSlackMessage,
 	msg *bridgev2.MatrixMessage,
-) (string, error) {
+) (timestamp string, err error) {
+	ctx, span := tracer.Start(ctx, "sendToSlack")
+	defer func() { endSpan(span, err) }()
 	log := zerolog.Ctx(ctx)
 	if conv.SendReq != nil {
 		log.Debug().Msg("Sending message to Slack")
 		_, timestamp, err := s.Client.PostMessageContext(ctx, channelID, conv.SendReq)
 		return timestamp, err
 	} else if conv.FileUpload != nil {
-		log.Debug().Msg("Uploading attachment to Slack")
-		file, err := s.Client.UploadFileV2Context(ctx, *conv.FileUpload)
+		var file *slack.File
+		if conv.FileUpload.FileSize > slackapi.ResumableUploadThreshold {
+			log.Debug().Int("file_size", conv.FileUpload.FileSize).Msg("Uploading large attachment to Slack in chunks")
+			file, err = slackapi.UploadFileResumable(ctx, s.Client, slackapi.UploadHTTPClient, *conv.FileUpload)
+		} else {
+			log.Debug().Msg("Uploading attachment to Slack")
+			file, err = s.Client.UploadFileV2Context(ctx, *conv.FileUpload)
+		}
 		if err != nil {
 			log.Err(err).Msg("Failed to upload attachment to Slack")
 			return "", err
@@ -101,7 +137,9 @@ func (s *SlackClient) sendToSlack(
 			return shareInfo.Ts, nil
 		}
 		if msg != nil {
-			msg.AddPendingToSave(nil, networkid.TransactionID(fmt.Sprintf("%s:%s", s.UserID, file.ID)), nil)
+			txnID := networkid.TransactionID(fmt.Sprintf("%s:%s", s.UserID, file.ID))
+			s.addPendingUpload(txnID)
+			msg.AddPendingToSave(nil, txnID, nil)
 		}
 		return "", nil
 	} else if conv.FileShare != nil {
@@ -117,35 +155,75 @@ func (s *SlackClient) sendToSlack(
 	}
 }
 
-func (s *SlackClient) HandleMatrixEdit(ctx context.Context, msg *bridgev2.MatrixEdit) error {
-	if s.Client == nil {
-		return bridgev2.ErrNotLoggedIn
+func (s *SlackClient) HandleMatrixEdit(ctx context.Context, msg *bridgev2.MatrixEdit) (err error) {
+	if err := s.checkLoggedIn(); err != nil {
+		return err
+	} else if s.isReadOnly() {
+		return errReadOnlyLogin
 	}
+	ctx, span := tracer.Start(ctx, "HandleMatrixEdit", trace.WithAttributes(attribute.String("matrix.event_id", msg.Event.ID.String())))
+	defer func() { endSpan(span, err) }()
+	done, err := s.sends.start()
+	if err != nil {
+		return err
+	}
+	defer done()
 	_, channelID := slackid.ParsePortalID(msg.Portal.ID)
 	if channelID == "" {
 		return errors.New("invalid channel ID")
 	}
-	conv, err := s.Main.MsgConv.ToSlack(ctx, s.Client, msg.Portal, msg.Content, msg.Event, nil, msg.EditTarget, msg.OrigSender, s.IsRealUser)
+	convCtx, convSpan := tracer.Start(ctx, "ToSlack")
+	conv, err := s.Main.MsgConv.ToSlack(s.withEmojiUploader(convCtx), s.Client, msg.Portal, msg.Content, msg.Event, nil, msg.EditTarget, msg.OrigSender, s.IsRealUser)
+	endSpan(convSpan, err)
 	if err != nil {
 		return err
 	}
-	msg.EditTarget.Metadata.(*slackid.MessageMetadata).LastEditTS, err = s.sendToSlack(ctx, channelID, conv, nil)
-	return err
+	if s.Main.currentConfig().EditConflictCheck {
+		if err = s.checkEditConflict(ctx, msg.Portal, msg.EditTarget); err != nil {
+			return err
+		}
+	}
+	editTS, err := s.sendToSlack(ctx, channelID, conv, nil)
+	if err != nil {
+		return wrapSlackError(err)
+	}
+	_, _, targetTS, _ := slackid.ParseMessageID(msg.EditTarget.ID)
+	// slack-go returns the edited.ts of the updated message if chat.update included it, which also makes
+	// the echo of the edit get ignored. Otherwise the echo will record the edit timestamp when it comes back.
+	if editTS != "" && editTS != targetTS {
+		msg.EditTarget.Metadata.(*slackid.MessageMetadata).LastEditTS = editTS
+	}
+	s.auditLog(ctx, slackdb.AuditActionEditToSlack, channelID, msg.Event.Sender.String(), targetTS)
+	return nil
 }
 
 func (s *SlackClient) HandleMatrixMessageRemove(ctx context.Context, msg *bridgev2.MatrixMessageRemove) error {
-	if s.Client == nil {
-		return bridgev2.ErrNotLoggedIn
+	if err := s.checkLoggedIn(); err != nil {
+		return err
+	} else if s.isReadOnly() {
+		return errReadOnlyLogin
+	}
+	done, err := s.sends.start()
+	if err != nil {
+		return err
 	}
+	defer done()
 	_, channelID, messageID, ok := slackid.ParseMessageID(msg.TargetMessage.ID)
 	if !ok {
 		return errors.New("invalid message ID")
 	}
-	_, _, err := s.Client.DeleteMessageContext(ctx, channelID, messageID)
-	return err
+	_, _, err = s.Client.DeleteMessageContext(ctx, channelID, messageID)
+	if err != nil {
+		return wrapSlackError(err)
+	}
+	s.auditLog(ctx, slackdb.AuditActionDeleteToSlack, channelID, msg.Event.Sender.String(), messageID)
+	return nil
 }
 
 func (s *SlackClient) PreHandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (resp bridgev2.MatrixReactionPreResponse, err error) {
+	if s.isReadOnly() {
+		return resp, errReadOnlyLogin
+	}
 	key := msg.Content.RelatesTo.Key
 	var emojiID string
 	if strings.ContainsRune(key, ':') {
@@ -171,9 +249,16 @@ func (s *SlackClient) PreHandleMatrixReaction(ctx context.Context, msg *bridgev2
 }
 
 func (s *SlackClient) HandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (reaction *database.Reaction, err error) {
-	if s.Client == nil {
-		return nil, bridgev2.ErrNotLoggedIn
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
+	} else if s.isReadOnly() {
+		return nil, errReadOnlyLogin
+	}
+	done, err := s.sends.start()
+	if err != nil {
+		return nil, err
 	}
+	defer done()
 	_, channelID, messageID, ok := slackid.ParseMessageID(msg.TargetMessage.ID)
 	if !ok {
 		return nil, errors.New("invalid message ID")
@@ -182,31 +267,51 @@ func (s *SlackClient) HandleMatrixReaction(ctx context.Context, msg *bridgev2.Ma
 		Channel:   channelID,
 		Timestamp: messageID,
 	})
+	if isSlackError(err, "already_reacted") {
+		// The reaction already exists on Slack, so it only needs to be saved in the database
+		zerolog.Ctx(ctx).Debug().Msg("Reaction already exists on Slack")
+		return nil, nil
+	}
+	err = wrapSlackError(err)
 	return
 }
 
 func (s *SlackClient) HandleMatrixReactionRemove(ctx context.Context, msg *bridgev2.MatrixReactionRemove) error {
-	if s.Client == nil {
-		return bridgev2.ErrNotLoggedIn
+	if err := s.checkLoggedIn(); err != nil {
+		return err
+	} else if s.isReadOnly() {
+		return errReadOnlyLogin
+	}
+	done, err := s.sends.start()
+	if err != nil {
+		return err
 	}
+	defer done()
 	_, channelID, messageID, ok := slackid.ParseMessageID(msg.TargetReaction.MessageID)
 	if !ok {
 		return errors.New("invalid message ID")
 	}
-	err := s.Client.RemoveReactionContext(ctx, string(msg.TargetReaction.EmojiID), slack.ItemRef{
+	err = s.Client.RemoveReactionContext(ctx, string(msg.TargetReaction.EmojiID), slack.ItemRef{
 		Channel:   channelID,
 		Timestamp: messageID,
 	})
-	if err != nil && err.Error() != "reaction" {
-		return err
+	if isSlackError(err, "no_reaction", "message_not_found") {
+		// Slack won't send a removal event for reactions that are already gone, so clean up the database here
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("Reaction was already removed on Slack, deleting it from the database")
+		if err = s.UserLogin.Bridge.DB.Reaction.Delete(ctx, msg.TargetReaction); err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to delete reaction from database")
+		}
+		return nil
+	} else if err != nil {
+		return wrapSlackError(err)
 	}
 	return nil
 }
 
 func (s *SlackClient) HandleMatrixReadReceipt(ctx context.Context, msg *bridgev2.MatrixReadReceipt) error {
-	if s.Client == nil {
-		return bridgev2.ErrNotLoggedIn
-	} else if !s.IsRealUser {
+	if err := s.checkLoggedIn(); err != nil {
+		return err
+	} else if !s.canActAsUser() || s.isReadOnly() || !s.bridgeReceipts() {
 		return nil
 	}
 	if msg.ExactMessage != nil {
@@ -214,7 +319,7 @@ func (s *SlackClient) HandleMatrixReadReceipt(ctx context.Context, msg *bridgev2
 		if !ok {
 			return errors.New("invalid message ID")
 		}
-		return s.Client.MarkConversationContext(ctx, channelID, messageTS)
+		return s.userClient().MarkConversationContext(ctx, channelID, messageTS)
 	}
 	lastMessage, err := s.UserLogin.Bridge.DB.Message.GetLastPartAtOrBeforeTime(ctx, msg.Portal.PortalKey, msg.ReadUpTo)
 	if err != nil {
@@ -224,15 +329,16 @@ func (s *SlackClient) HandleMatrixReadReceipt(ctx context.Context, msg *bridgev2
 		if !ok {
 			return errors.New("invalid message ID")
 		}
-		return s.Client.MarkConversationContext(ctx, channelID, messageTS)
+		return s.userClient().MarkConversationContext(ctx, channelID, messageTS)
 	}
 	return nil
 }
 
 func (s *SlackClient) HandleMatrixTyping(ctx context.Context, msg *bridgev2.MatrixTyping) error {
-	if s.Client == nil {
-		return bridgev2.ErrNotLoggedIn
-	} else if !s.IsRealUser {
+	if err := s.checkLoggedIn(); err != nil {
+		return err
+	} else if s.RTM == nil || s.isReadOnly() || !s.bridgeTyping() {
+		// Typing notifications can only be sent over RTM, which isn't available for bot or OAuth user tokens
 		return nil
 	}
 	_, channelID := slackid.ParsePortalID(msg.Portal.ID)
@@ -244,21 +350,230 @@ func (s *SlackClient) HandleMatrixTyping(ctx context.Context, msg *bridgev2.Matr
 }
 
 func (s *SlackClient) HandleMatrixRoomName(ctx context.Context, msg *bridgev2.MatrixRoomName) (bool, error) {
+	if s.isReadOnly() {
+		return false, errReadOnlyLogin
+	}
 	_, channelID := slackid.ParsePortalID(msg.Portal.ID)
 	if channelID == "" {
 		return false, errors.New("invalid channel ID")
 	}
 	resp, err := s.Client.RenameConversationContext(ctx, channelID, msg.Content.Name)
 	zerolog.Ctx(ctx).Trace().Any("resp_data", resp).Msg("Renamed conversation")
-	return err == nil, err
+	return err == nil, wrapSlackError(err)
 }
 
 func (s *SlackClient) HandleMatrixRoomTopic(ctx context.Context, msg *bridgev2.MatrixRoomTopic) (bool, error) {
+	if s.isReadOnly() {
+		return false, errReadOnlyLogin
+	}
 	_, channelID := slackid.ParsePortalID(msg.Portal.ID)
 	if channelID == "" {
 		return false, errors.New("invalid channel ID")
 	}
-	resp, err := s.Client.SetTopicOfConversationContext(ctx, channelID, msg.Content.Topic)
+	topic, truncated := normalizeSlackTopic(msg.Content.Topic)
+	resp, err := s.Client.SetTopicOfConversationContext(ctx, channelID, topic)
 	zerolog.Ctx(ctx).Trace().Any("resp_data", resp).Msg("Changed conversation topic")
-	return err == nil, err
+	if err != nil {
+		return false, wrapSlackError(err)
+	}
+	if truncated {
+		s.sendPortalNotice(ctx, msg.Portal, fmt.Sprintf(
+			"The topic was truncated to %d characters, as that's the maximum length Slack allows.", maxSlackTopicLength,
+		))
+	}
+	return true, nil
+}
+
+// maxSlackTopicLength is the maximum number of characters Slack allows in channel topics.
+const maxSlackTopicLength = 250
+
+// normalizeSlackTopic converts a Matrix topic into something Slack accepts as a channel topic.
+// Slack topics are a single line of plain text, so line breaks are collapsed into spaces,
+// and the result is truncated to the maximum length.
+func normalizeSlackTopic(topic string) (string, bool) {
+	topic = strings.Join(strings.Fields(topic), " ")
+	runes := []rune(topic)
+	if len(runes) <= maxSlackTopicLength {
+		return topic, false
+	}
+	return strings.TrimSpace(string(runes[:maxSlackTopicLength-1])) + "…", true
+}
+
+func (s *SlackClient) sendPortalNotice(ctx context.Context, portal *bridgev2.Portal, text string) {
+	if portal.MXID == "" {
+		return
+	}
+	_, err := s.Main.br.Bot.SendMessage(ctx, portal.MXID, event.EventMessage, &event.Content{
+		Parsed: &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    text,
+		},
+	}, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to send notice to portal")
+	}
+}
+
+// sendFailedPartsNotice tells the sender which parts of their message couldn't be bridged to Slack,
+// in a thread under the message so that the notice doesn't get lost in the main timeline.
+func (s *SlackClient) sendFailedPartsNotice(ctx context.Context, msg *bridgev2.MatrixMessage, failedParts []string) {
+	if msg.Portal.MXID == "" {
+		return
+	}
+	threadRoot := msg.Event.ID
+	if msg.ThreadRoot != nil {
+		threadRoot = msg.ThreadRoot.MXID
+	}
+	_, err := s.Main.br.Bot.SendMessage(ctx, msg.Portal.MXID, event.EventMessage, &event.Content{
+		Parsed: &event.MessageEventContent{
+			MsgType:   event.MsgNotice,
+			Body:      formatFailedPartsNotice(failedParts),
+			RelatesTo: (&event.RelatesTo{}).SetThread(threadRoot, msg.Event.ID),
+		},
+	}, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to send notice about failed message parts")
+	}
+}
+
+func formatFailedPartsNotice(failedParts []string) string {
+	if len(failedParts) == 1 {
+		return fmt.Sprintf("The message was sent to Slack without the image %q, as it couldn't be uploaded.", failedParts[0])
+	}
+	quoted := make([]string, len(failedParts))
+	for i, part := range failedParts {
+		quoted[i] = strconv.Quote(part)
+	}
+	return fmt.Sprintf(
+		"The message was sent to Slack without %d images, as they couldn't be uploaded: %s",
+		len(failedParts), strings.Join(quoted, ", "),
+	)
+}
+
+func (s *SlackClient) HandleMatrixMembership(ctx context.Context, msg *bridgev2.MatrixMembershipChange) (bool, error) {
+	if s.isReadOnly() {
+		// Let Matrix users leave mirrored rooms without touching the Slack side
+		if msg.Type == bridgev2.Leave {
+			return false, nil
+		}
+		return false, errReadOnlyLogin
+	}
+	isDM := msg.Portal.RoomType == database.RoomTypeDM || msg.Portal.RoomType == database.RoomTypeGroupDM
+	if isDM && msg.Type.IsSelf && msg.Type.To == event.MembershipJoin && msg.Type.From != event.MembershipJoin {
+		return s.reopenDM(ctx, msg.Portal)
+	} else if msg.Type != bridgev2.Leave {
+		return false, bridgev2.ErrMembershipNotSupported
+	}
+	log := zerolog.Ctx(ctx)
+	if isDM {
+		err := s.closeDM(ctx, msg.Portal)
+		if err != nil {
+			return false, err
+		}
+	}
+	switch behavior := s.leaveBehavior(); behavior {
+	case LeaveBehaviorLeave:
+		if err := s.checkLoggedIn(); err != nil {
+			return false, err
+		}
+		_, channelID := slackid.ParsePortalID(msg.Portal.ID)
+		if channelID == "" || msg.Portal.RoomType == database.RoomTypeSpace || isDM {
+			// DMs can't be left, they were already closed above
+			return isDM, nil
+		}
+		_, err := s.Client.LeaveConversationContext(ctx, channelID)
+		if err != nil && !isSlackError(err, "not_in_channel") {
+			return false, wrapSlackError(err)
+		}
+		log.Debug().Str("channel_id", channelID).Msg("Left Slack channel after Matrix user left portal")
+		return true, nil
+	case LeaveBehaviorArchive:
+		return true, s.archivePortal(ctx, msg.Portal)
+	default:
+		return isDM, nil
+	}
+}
+
+// closeDM closes a Slack DM after the Matrix user left the portal, so that it isn't synced again.
+// The DM is reopened when Slack sends an im_open event or when the user rejoins the portal.
+func (s *SlackClient) closeDM(ctx context.Context, portal *bridgev2.Portal) error {
+	if err := s.checkLoggedIn(); err != nil {
+		return err
+	}
+	_, channelID := slackid.ParsePortalID(portal.ID)
+	if channelID == "" {
+		return nil
+	}
+	_, _, err := s.Client.CloseConversationContext(ctx, channelID)
+	if err != nil {
+		return wrapSlackError(err)
+	}
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	meta.DMClosed = true
+	// Make sure the next resync updates the member list and invites the user back
+	meta.InfoHash = ""
+	err = portal.Save(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to save portal: %w", err)
+	}
+	zerolog.Ctx(ctx).Debug().Str("channel_id", channelID).Msg("Closed Slack DM after Matrix user left portal")
+	return nil
+}
+
+func (s *SlackClient) reopenDM(ctx context.Context, portal *bridgev2.Portal) (bool, error) {
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	if !meta.DMClosed {
+		return false, nil
+	} else if err := s.checkLoggedIn(); err != nil {
+		return false, err
+	}
+	_, channelID := slackid.ParsePortalID(portal.ID)
+	_, _, _, err := s.Client.OpenConversationContext(ctx, &slack.OpenConversationParameters{ChannelID: channelID})
+	if err != nil {
+		return false, wrapSlackError(err)
+	}
+	meta.DMClosed = false
+	err = portal.Save(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to save portal: %w", err)
+	}
+	zerolog.Ctx(ctx).Debug().Str("channel_id", channelID).Msg("Reopened Slack DM after Matrix user rejoined portal")
+	return true, nil
+}
+
+// removeUserPortal removes the link between this login and the portal, so the portal isn't bridged for it anymore.
+func (s *SlackClient) removeUserPortal(ctx context.Context, portal *bridgev2.Portal) error {
+	userPortal, err := s.Main.br.DB.UserPortal.Get(ctx, s.UserLogin.UserLogin, portal.PortalKey)
+	if err != nil {
+		return fmt.Errorf("failed to get user portal: %w", err)
+	} else if userPortal != nil {
+		err = s.Main.br.DB.UserPortal.Delete(ctx, userPortal)
+		if err != nil {
+			return fmt.Errorf("failed to delete user portal: %w", err)
+		}
+	}
+	return nil
+}
+
+// archivePortal stops bridging the portal for this login.
+// If no other logins are using the portal, the Matrix room is unbridged and left as an archive.
+func (s *SlackClient) archivePortal(ctx context.Context, portal *bridgev2.Portal) error {
+	log := zerolog.Ctx(ctx)
+	err := s.removeUserPortal(ctx, portal)
+	if err != nil {
+		return err
+	}
+	otherLogins, err := s.Main.br.DB.UserPortal.GetAllInPortal(ctx, portal.PortalKey)
+	if err != nil {
+		return fmt.Errorf("failed to get other logins in portal: %w", err)
+	} else if len(otherLogins) > 0 {
+		log.Debug().Int("other_logins", len(otherLogins)).Msg("Not unbridging portal as other logins are still using it")
+		return nil
+	}
+	err = portal.Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete portal: %w", err)
+	}
+	log.Info().Msg("Unbridged portal after Matrix user left")
+	return nil
 }
@@ -0,0 +1,175 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func makeTestMembershipChange(roomType database.RoomType, changeType bridgev2.MembershipChangeType) *bridgev2.MatrixMembershipChange {
+	msg := &bridgev2.MatrixMembershipChange{Type: changeType}
+	msg.Portal = &bridgev2.Portal{Portal: &database.Portal{
+		PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", "C1")},
+		RoomType:  roomType,
+	}}
+	return msg
+}
+
+func TestHandleMatrixMembership_Leave(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.leave", func(form url.Values) (any, error) {
+		if form.Get("channel") != "C1" {
+			return nil, slackapitest.Error("channel_not_found")
+		}
+		return nil, nil
+	})
+	s := newTestSlackClient(srv.Client())
+	s.Main = &SlackConnector{Config: Config{LeavePortalBehavior: LeaveBehaviorLeave}}
+	s.UserLogin = &bridgev2.UserLogin{UserLogin: &database.UserLogin{Metadata: &slackid.UserLoginMetadata{}}}
+	ctx := context.Background()
+
+	synced, err := s.HandleMatrixMembership(ctx, makeTestMembershipChange(database.RoomTypeDefault, bridgev2.Leave))
+	require.NoError(t, err)
+	assert.True(t, synced)
+	require.Len(t, srv.Calls("conversations.leave"), 1)
+
+	_, err = s.HandleMatrixMembership(ctx, makeTestMembershipChange(database.RoomTypeDefault, bridgev2.Invite))
+	assert.Equal(t, bridgev2.ErrMembershipNotSupported, err)
+
+	s.settings().LeaveBehavior = LeaveBehaviorNothing
+	synced, err = s.HandleMatrixMembership(ctx, makeTestMembershipChange(database.RoomTypeDefault, bridgev2.Leave))
+	require.NoError(t, err)
+	assert.False(t, synced)
+	assert.Len(t, srv.Calls("conversations.leave"), 1)
+}
+
+func TestHandleMatrix_ReadOnlyLogin(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	s := newTestSlackClient(srv.Client())
+	s.UserLogin = &bridgev2.UserLogin{UserLogin: &database.UserLogin{
+		Metadata: &slackid.UserLoginMetadata{ReadOnly: true},
+	}}
+	ctx := context.Background()
+
+	_, err := s.HandleMatrixMessage(ctx, &bridgev2.MatrixMessage{})
+	assert.ErrorIs(t, err, errReadOnly)
+	assert.ErrorIs(t, s.HandleMatrixEdit(ctx, &bridgev2.MatrixEdit{}), errReadOnly)
+	assert.ErrorIs(t, s.HandleMatrixMessageRemove(ctx, &bridgev2.MatrixMessageRemove{}), errReadOnly)
+	_, err = s.PreHandleMatrixReaction(ctx, &bridgev2.MatrixReaction{})
+	assert.ErrorIs(t, err, errReadOnly)
+	assert.ErrorIs(t, s.HandleMatrixReactionRemove(ctx, &bridgev2.MatrixReactionRemove{}), errReadOnly)
+	_, err = s.HandleMatrixPollStart(ctx, &bridgev2.MatrixPollStart{})
+	assert.ErrorIs(t, err, errReadOnly)
+	_, err = s.HandleMatrixRoomName(ctx, &bridgev2.MatrixRoomName{})
+	assert.ErrorIs(t, err, errReadOnly)
+	assert.NoError(t, s.HandleMatrixTyping(ctx, &bridgev2.MatrixTyping{}))
+	assert.NoError(t, s.HandleMatrixReadReceipt(ctx, &bridgev2.MatrixReadReceipt{}))
+
+	handled, err := s.HandleMatrixMembership(ctx, makeTestMembershipChange(database.RoomTypeDM, bridgev2.Leave))
+	assert.NoError(t, err)
+	assert.False(t, handled)
+	_, err = s.HandleMatrixMembership(ctx, makeTestMembershipChange(database.RoomTypeDefault, bridgev2.Invite))
+	assert.ErrorIs(t, err, errReadOnly)
+	_, err = s.CreateGroup(ctx, "test", slackid.MakeUserID("T1", "U2"))
+	assert.ErrorIs(t, err, errReadOnly)
+	_, err = s.ResolveIdentifier(ctx, "U2", true)
+	assert.ErrorIs(t, err, errReadOnly)
+	assert.Empty(t, srv.Calls("conversations.create"))
+	assert.Empty(t, srv.Calls("conversations.open"))
+
+	assert.Same(t, readOnlyRoomCaps, s.GetCapabilities(ctx, nil))
+}
+
+func TestHandleMatrixReadReceipt_Hybrid(t *testing.T) {
+	botSrv := slackapitest.NewServer(t)
+	userSrv := slackapitest.NewServer(t)
+	userSrv.Handle("conversations.mark", func(form url.Values) (any, error) {
+		return nil, nil
+	})
+	s := newTestSlackClient(botSrv.Client())
+	s.IsRealUser = false
+	s.UserLogin = &bridgev2.UserLogin{UserLogin: &database.UserLogin{Metadata: &slackid.UserLoginMetadata{}}}
+	ctx := context.Background()
+	receipt := &bridgev2.MatrixReadReceipt{
+		ExactMessage: &database.Message{ID: slackid.MakeMessageID("T1", "C1", "1700000000.000100")},
+	}
+
+	// Bot logins without a user token can't mark messages as read
+	require.NoError(t, s.HandleMatrixReadReceipt(ctx, receipt))
+	assert.Empty(t, botSrv.Calls("conversations.mark"))
+
+	s.UserClient = userSrv.Client()
+	require.NoError(t, s.HandleMatrixReadReceipt(ctx, receipt))
+	assert.Empty(t, botSrv.Calls("conversations.mark"))
+	calls := userSrv.Calls("conversations.mark")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "C1", calls[0].Get("channel"))
+	assert.Equal(t, "1700000000.000100", calls[0].Get("ts"))
+}
+
+func TestHandleMatrixReaction_SlackErrors(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("reactions.add", func(form url.Values) (any, error) {
+		switch form.Get("timestamp") {
+		case "1700000000.000100":
+			return nil, slackapitest.Error("already_reacted")
+		default:
+			return nil, slackapitest.Error("message_not_found")
+		}
+	})
+	s := newTestSlackClient(srv.Client())
+	s.UserLogin = &bridgev2.UserLogin{UserLogin: &database.UserLogin{Metadata: &slackid.UserLoginMetadata{}}}
+	ctx := context.Background()
+	makeReaction := func(ts string) *bridgev2.MatrixReaction {
+		return &bridgev2.MatrixReaction{
+			TargetMessage: &database.Message{ID: slackid.MakeMessageID("T1", "C1", ts)},
+			PreHandleResp: &bridgev2.MatrixReactionPreResponse{EmojiID: "thumbsup"},
+		}
+	}
+
+	reaction, err := s.HandleMatrixReaction(ctx, makeReaction("1700000000.000100"))
+	assert.NoError(t, err)
+	assert.Nil(t, reaction)
+
+	_, err = s.HandleMatrixReaction(ctx, makeReaction("1700000000.000200"))
+	var ms bridgev2.MessageStatus
+	require.ErrorAs(t, err, &ms)
+	assert.Equal(t, "The message was not found on Slack", ms.Message)
+	assert.Len(t, srv.Calls("reactions.add"), 2)
+}
+
+func TestFormatFailedPartsNotice(t *testing.T) {
+	assert.Equal(t,
+		`The message was sent to Slack without the image "cat.png", as it couldn't be uploaded.`,
+		formatFailedPartsNotice([]string{"cat.png"}),
+	)
+	assert.Equal(t,
+		`The message was sent to Slack without 2 images, as they couldn't be uploaded: "cat.png", "image2"`,
+		formatFailedPartsNotice([]string{"cat.png", "image2"}),
+	)
+}
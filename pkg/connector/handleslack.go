@@ -17,8 +17,11 @@
 package connector
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
 	"strings"
 	"time"
 
@@ -26,12 +29,16 @@ import (
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
+	"go.mau.fi/util/jsontime"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/bridgev2/status"
 	"maunium.net/go/mautrix/event"
 
+	"go.mau.fi/mautrix-slack/pkg/msgconv"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
@@ -41,6 +48,13 @@ func (s *SlackClient) HandleSlackEvent(rawEvt any) {
 		Type("event_type", rawEvt).
 		Logger()
 	ctx := log.WithContext(context.TODO())
+	defer s.recoverEventPanic(ctx, rawEvt)
+	ctx, span := tracer.Start(ctx, "HandleSlackEvent", trace.WithAttributes(
+		attribute.String("slack.event_type", fmt.Sprintf("%T", rawEvt)),
+		attribute.String("slack.team_id", s.TeamID),
+	))
+	defer span.End()
+	prevEventAt := s.lastEventAt.Swap(time.Now().UnixMilli())
 	switch evt := rawEvt.(type) {
 	case *slack.ConnectingEvent:
 		omitBridgeState := s.UserLogin.BridgeState.GetPrevUnsent().StateEvent == status.StateTransientDisconnect
@@ -53,8 +67,15 @@ func (s *SlackClient) HandleSlackEvent(rawEvt any) {
 			s.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnecting})
 		}
 	case *slack.ConnectedEvent:
-		log.Debug().Msg("Connected to websocket, waiting for hello event")
+		log.Debug().Int("connection_count", evt.ConnectionCount).Msg("Connected to websocket, waiting for hello event")
+		if isRTMReconnect(evt) {
+			lostAt := unixMilliOrZero(s.connectionLostAt.Load())
+			s.goWithRecover(ctx, evt, func() { s.catchUpAfterReconnect(ctx, lostAt) })
+		}
 	case *slack.DisconnectedEvent:
+		if prevEventAt != 0 {
+			s.connectionLostAt.CompareAndSwap(0, prevEventAt)
+		}
 		if evt.Intentional {
 			log.Debug().Bool("intentional", evt.Intentional).Err(evt.Cause).Msg("Disconnected from Slack")
 		} else {
@@ -65,16 +86,39 @@ func (s *SlackClient) HandleSlackEvent(rawEvt any) {
 		log.Warn().Err(evt.ErrorObj).Msg("Incoming event error")
 	case *slack.UnmarshallingErrorEvent:
 		logEvt := log.Debug().Err(evt.ErrorObj)
-		if log.GetLevel() == zerolog.TraceLevel || strings.Contains(evt.ErrorObj.Error(), `Received unmapped event "error"`) {
+		unmappedType := unmappedEventType(evt.ErrorObj)
+		if log.GetLevel() == zerolog.TraceLevel || unmappedType == "error" {
 			logEvt = logEvt.RawJSON("raw_data", evt.Raw)
 		}
 		logEvt.Msg("Unmarshalling error")
+		// slack-go doesn't have a type for team icon changes, so detect them from the unmapped event error
+		if unmappedType == "team_icon_change" {
+			s.goWithRecover(ctx, evt, func() { s.handleTeamChange(ctx, nil) })
+		} else if dmEvt := parseGroupDMEvent(evt.Raw); dmEvt != nil {
+			s.HandleSlackEvent(dmEvt)
+		} else if convEvt := parseChannelConversionEvent(evt.Raw); convEvt != nil {
+			wrapped, err := s.wrapEvent(ctx, convEvt)
+			if err != nil {
+				log.Err(err).Msg("Failed to wrap channel conversion event")
+			} else if wrapped != nil {
+				s.UserLogin.Bridge.QueueRemoteEvent(s.UserLogin, wrapped)
+			}
+		} else if s.Main.currentConfig().SyncDrafts && strings.HasPrefix(unmappedType, "draft_") {
+			// Drafts aren't supported by slack-go either
+			draftEvt, err := parseDraftEvent(evt.Raw)
+			if err != nil {
+				log.Err(err).Msg("Failed to parse draft event")
+			} else if draftEvt != nil {
+				s.goWithRecover(ctx, evt, func() { s.handleDraftEvent(ctx, draftEvt) })
+			}
+		}
 	case *slack.RTMErrorEvent:
 		log.Error().
 			Str(zerolog.ErrorFieldName, evt.Error.Msg).
 			Int("error_code", evt.Error.Code).
 			Msg("Got RTM error")
 	case *slack.HelloEvent:
+		s.connectionLostAt.Store(0)
 		log.Debug().Msg("Received hello event from websocket (now really connected)")
 		s.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
 	case *slack.InvalidAuthEvent:
@@ -93,23 +137,50 @@ func (s *SlackClient) HandleSlackEvent(rawEvt any) {
 		*slack.UserTypingEvent, *slack.ChannelMarkedEvent, *slack.IMMarkedEvent, *slack.GroupMarkedEvent,
 		*slack.ChannelJoinedEvent, *slack.ChannelLeftEvent, *slack.GroupJoinedEvent, *slack.GroupLeftEvent,
 		*slack.MemberJoinedChannelEvent, *slack.MemberLeftChannelEvent,
-		*slack.ChannelUpdateEvent:
+		*slack.IMOpenEvent, *slack.ChannelCreatedEvent, *slackevents.ChannelCreatedEvent,
+		*slack.StarAddedEvent, *slack.StarRemovedEvent:
 		wrapped, err := s.wrapEvent(ctx, evt)
 		if err != nil {
 			log.Err(err).Msg("Failed to wrap Slack event")
 		} else if wrapped != nil {
 			s.UserLogin.Bridge.QueueRemoteEvent(s.UserLogin, wrapped)
 		}
+	case *slack.ChannelUpdateEvent:
+		s.queueChannelUpdate(evt)
+	case *slack.IMCloseEvent:
+		s.goWithRecover(ctx, evt, func() { s.handleDMClosed(ctx, evt.Channel) })
 	case *slack.EmojiChangedEvent:
-		go s.handleEmojiChange(ctx, evt)
+		s.goWithRecover(ctx, evt, func() { s.handleEmojiChange(ctx, evt) })
 	case *slack.FileSharedEvent, *slack.FilePublicEvent, *slack.FilePrivateEvent,
 		*slack.FileCreatedEvent, *slack.FileChangeEvent, *slack.FileDeletedEvent,
-		*slack.DesktopNotificationEvent, *slack.ReconnectUrlEvent, *slack.LatencyReport:
+		*slack.ReconnectUrlEvent, *slack.LatencyReport:
 		// ignored intentionally, these are duplicates or do not contain useful information
+	case *slack.DesktopNotificationEvent:
+		s.goWithRecover(ctx, evt, func() { s.handleDesktopNotification(ctx, evt) })
+	case *slack.TeamRenameEvent:
+		s.goWithRecover(ctx, evt, func() {
+			s.handleTeamChange(ctx, func(team *slack.TeamInfo) {
+				team.Name = evt.Name
+			})
+		})
+	case *slack.TeamDomainChangeEvent:
+		s.goWithRecover(ctx, evt, func() {
+			s.handleTeamChange(ctx, func(team *slack.TeamInfo) {
+				team.Domain = evt.Domain
+				team.URL = evt.URL
+			})
+		})
 	case *slack.UserChangeEvent:
-		go s.handleUserChange(ctx, &evt.User)
+		s.goWithRecover(ctx, evt, func() { s.handleUserChange(ctx, &evt.User) })
+	case *slackevents.UserProfileChangedEvent:
+		if evt.User != nil {
+			s.goWithRecover(ctx, evt, func() { s.handleUserChange(ctx, evt.User) })
+		}
+	case *slackevents.UserChangeEvent:
+		// The Events API user object is a different type, so just refetch the user
+		s.goWithRecover(ctx, evt, func() { s.handleUserInvalidated(ctx, evt.User.ID) })
 	case *slack.UserInvalidatedEvent:
-		go s.handleUserInvalidated(ctx, evt.User.ID)
+		s.goWithRecover(ctx, evt, func() { s.handleUserInvalidated(ctx, evt.User.ID) })
 	default:
 		logEvt := log.Debug()
 		if log.GetLevel() == zerolog.TraceLevel {
@@ -119,6 +190,38 @@ func (s *SlackClient) HandleSlackEvent(rawEvt any) {
 	}
 }
 
+// recoverEventPanic recovers from panics while handling a Slack event, so that a single malformed event
+// doesn't take down the whole connection. It must be called directly with defer.
+func (s *SlackClient) recoverEventPanic(ctx context.Context, rawEvt any) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	logEvt := zerolog.Ctx(ctx).Error()
+	if realErr, ok := err.(error); ok {
+		logEvt = logEvt.Err(realErr)
+	} else {
+		logEvt = logEvt.Any(zerolog.ErrorFieldName, err)
+	}
+	logEvt.
+		Bytes("stack", debug.Stack()).
+		Any("event_data", rawEvt).
+		Msg("Slack event handler panicked")
+	s.UserLogin.BridgeState.Send(status.BridgeState{
+		StateEvent: status.StateUnknownError,
+		Error:      "slack-event-panic",
+		Message:    fmt.Sprintf("Panic while handling %T event: %v", rawEvt, err),
+	})
+}
+
+// goWithRecover runs the given function in a new goroutine with panic recovery.
+func (s *SlackClient) goWithRecover(ctx context.Context, rawEvt any, fn func()) {
+	go func() {
+		defer s.recoverEventPanic(ctx, rawEvt)
+		fn()
+	}()
+}
+
 func (s *SlackClient) HandleSocketModeEvent(evt socketmode.Event) {
 	switch evt.Type {
 	case socketmode.EventTypeConnecting:
@@ -126,7 +229,14 @@ func (s *SlackClient) HandleSocketModeEvent(evt socketmode.Event) {
 	case socketmode.EventTypeConnectionError:
 		s.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateTransientDisconnect, Error: "slack-socketmode-connection-error"})
 	case socketmode.EventTypeConnected:
+		s.socketModeAttempts.Store(0)
 		s.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
+		if s.socketModeConnections.Add(1) > 1 {
+			// Socket mode connection events don't go through HandleSlackEvent, so the last event time is from before the reconnect
+			ctx := s.UserLogin.Log.With().Str("action", "handle socket mode reconnect").Logger().WithContext(context.TODO())
+			lostAt := unixMilliOrZero(s.lastEventAt.Load())
+			s.goWithRecover(ctx, evt, func() { s.catchUpAfterReconnect(ctx, lostAt) })
+		}
 	case socketmode.EventTypeEventsAPI:
 		eaEvt, ok := evt.Data.(slackevents.EventsAPIEvent)
 		if !ok {
@@ -146,13 +256,144 @@ func (s *SlackClient) HandleSocketModeEvent(evt socketmode.Event) {
 	}
 }
 
+// unmappedEventType returns the type of an event slack-go doesn't have a struct for,
+// or an empty string if the error isn't about an unmapped event.
+func unmappedEventType(err error) string {
+	var unmappedErr *slack.UnmappedError
+	if errors.As(err, &unmappedErr) {
+		return unmappedErr.EventType
+	}
+	return ""
+}
+
+// teamInfo returns a copy of the cached team info, which may be updated by team change events at any time.
+func (s *SlackClient) teamInfo() *slack.TeamInfo {
+	s.bootRespLock.RLock()
+	defer s.bootRespLock.RUnlock()
+	if s.BootResp == nil {
+		return &slack.TeamInfo{}
+	}
+	info := s.BootResp.Team.TeamInfo
+	return &info
+}
+
+// selfInfo returns a copy of the cached info of the user's own account, whose profile may be updated by
+// user change events at any time.
+func (s *SlackClient) selfInfo() *slack.User {
+	s.bootRespLock.RLock()
+	defer s.bootRespLock.RUnlock()
+	if s.BootResp == nil {
+		return nil
+	}
+	self := s.BootResp.Self
+	return &self
+}
+
+// handleTeamChange updates the cached team info and resyncs the team portal as well as all channel portals,
+// as their names and avatars may be derived from the team info. If applyChange is nil, the team info is refetched.
+func (s *SlackClient) handleTeamChange(ctx context.Context, applyChange func(team *slack.TeamInfo)) {
+	log := zerolog.Ctx(ctx)
+	if applyChange != nil {
+		s.bootRespLock.Lock()
+		applyChange(&s.BootResp.Team.TeamInfo)
+		s.bootRespLock.Unlock()
+	} else {
+		info, err := s.Client.GetTeamInfoContext(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to fetch team info after team change")
+			return
+		}
+		s.bootRespLock.Lock()
+		s.BootResp.Team.TeamInfo = *info
+		s.bootRespLock.Unlock()
+	}
+	err := s.syncTeamPortal(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to sync team portal after team change")
+		return
+	}
+	s.chatInfoCacheLock.Lock()
+	clear(s.chatInfoCache)
+	s.chatInfoCacheLock.Unlock()
+	userPortals, err := s.UserLogin.Bridge.DB.UserPortal.GetAllForLogin(ctx, s.UserLogin.UserLogin)
+	if err != nil {
+		log.Err(err).Msg("Failed to get user portals to resync after team change")
+		return
+	}
+	for _, up := range userPortals {
+		teamID, channelID := slackid.ParsePortalID(up.Portal.ID)
+		if teamID != s.TeamID || channelID == "" {
+			continue
+		}
+		s.Main.br.QueueRemoteEvent(s.UserLogin, &SlackChatResync{
+			SlackEventMeta: &SlackEventMeta{
+				Type:      bridgev2.RemoteEventChatResync,
+				PortalKey: up.Portal,
+			},
+			Client:         s,
+			ShouldSyncInfo: true,
+		})
+	}
+	log.Debug().Int("portal_count", len(userPortals)).Msg("Queued portal resyncs after team change")
+}
+
 func (s *SlackClient) handleUserChange(ctx context.Context, user *slack.User) {
 	ghost, err := s.Main.br.GetGhostByID(ctx, slackid.MakeUserID(s.TeamID, user.ID))
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to get ghost")
 		return
+	} else if user.Updated != 0 && ghost.Name != "" && ghost.Metadata.(*slackid.GhostMetadata).SlackUpdatedTS >= int64(user.Updated) {
+		zerolog.Ctx(ctx).Debug().Str("user_id", user.ID).Msg("Ignoring user change event with no new profile version")
+		return
 	}
 	ghost.UpdateInfo(ctx, s.wrapUserInfo(user.ID, user, nil, ghost))
+	if user.ID == s.UserID {
+		s.updateRemoteProfile(ctx, user)
+	}
+}
+
+// updateRemoteProfile updates the remote profile of the user login to match the user's own ghost.
+// If self is nil, the previous phone number and email are kept.
+func (s *SlackClient) updateRemoteProfile(ctx context.Context, self *slack.User) {
+	if s.Ghost == nil {
+		return
+	}
+	newProfile := s.UserLogin.RemoteProfile
+	if self != nil {
+		newProfile.Phone = self.Profile.Phone
+		newProfile.Email = self.Profile.Email
+		s.bootRespLock.Lock()
+		s.BootResp.Self.Profile = self.Profile
+		s.bootRespLock.Unlock()
+	}
+	newProfile.Name = s.Ghost.Name
+	newProfile.Avatar = s.Ghost.AvatarMXC
+	if newProfile == s.UserLogin.RemoteProfile {
+		return
+	}
+	s.UserLogin.RemoteProfile = newProfile
+	err := s.UserLogin.Save(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to save user login after updating remote profile")
+	}
+}
+
+// forceRefreshGhost discards all cached state of the given ghost and fetches the profile from Slack.
+func (s *SlackClient) forceRefreshGhost(ctx context.Context, ghost *bridgev2.Ghost) error {
+	meta := ghost.Metadata.(*slackid.GhostMetadata)
+	meta.SlackUpdatedTS = 0
+	meta.AvatarHash = ""
+	meta.LastSync = jsontime.Unix{}
+	ghost.AvatarID = ""
+	_, userID := slackid.ParseUserID(ghost.ID)
+	info, err := s.fetchUserInfo(ctx, userID, 0, ghost)
+	if err != nil {
+		return err
+	} else if info == nil {
+		return fmt.Errorf("no user info returned for %s", userID)
+	}
+	ghost.UpdateInfo(ctx, info)
+	return nil
 }
 
 func (s *SlackClient) handleUserInvalidated(ctx context.Context, userID string) {
@@ -166,11 +407,17 @@ func (s *SlackClient) handleUserInvalidated(ctx context.Context, userID string)
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to fetch user info after user invalidated event")
 	} else if info != nil {
 		ghost.UpdateInfo(ctx, info)
+		if userID == s.UserID {
+			s.updateRemoteProfile(ctx, nil)
+		}
 	}
 }
 
 func (s *SlackClient) wrapEvent(ctx context.Context, rawEvt any) (bridgev2.RemoteEvent, error) {
+	ctx, span := tracer.Start(ctx, "wrapEvent")
+	defer span.End()
 	var meta SlackEventMeta
+	meta.SpanContext = span.SpanContext()
 	var metaErr error
 	var wrapped bridgev2.RemoteEvent
 	switch evt := rawEvt.(type) {
@@ -189,7 +436,7 @@ func (s *SlackClient) wrapEvent(ctx context.Context, rawEvt any) (bridgev2.Remot
 			}
 		}
 		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, sender, "")
-		meta.CreatePortal = true
+		meta.CreatePortal = s.shouldCreatePortal(ctx, evt.Channel)
 		meta.LogContext = func(c zerolog.Context) zerolog.Context {
 			return c.
 				Str("message_ts", evt.Timestamp).
@@ -198,14 +445,38 @@ func (s *SlackClient) wrapEvent(ctx context.Context, rawEvt any) (bridgev2.Remot
 				Str("subtype", evt.SubType).
 				Bool("hidden", evt.Hidden)
 		}
-		wrapped = &SlackMessage{
+		msg := &SlackMessage{
 			SlackEventMeta: &meta,
 			Data:           evt,
 			Client:         s,
 		}
+		if metaErr == nil {
+			s.rerouteSlackbotReference(ctx, msg)
+		}
+		if s.isSkippedOwnMessage(msg) {
+			zerolog.Ctx(ctx).Debug().
+				Str("message_ts", evt.Timestamp).
+				Msg("Ignoring own message sent from another Slack client")
+			return nil, nil
+		}
+		if metaErr == nil {
+			s.auditSlackMessage(ctx, msg, sender)
+		}
+		if s.Main.currentConfig().ThreadSummaries && evt.SubType == slack.MsgSubTypeMessageChanged &&
+			evt.SubMessage != nil && evt.SubMessage.ReplyCount > 0 && evt.SubMessage.Edited == nil {
+			s.goWithRecover(ctx, evt, func() { s.updateThreadSummary(ctx, evt.Channel, evt.SubMessage) })
+		}
+		wrapped = msg
 
 	case *slack.ReactionAddedEvent:
 		meta, metaErr = s.makeEventMeta(ctx, evt.Item.Channel, nil, evt.User, evt.EventTimestamp)
+		if vote, isVote := s.wrapPollVote(ctx, &meta, evt.User, evt.Reaction, true, evt.Item); isVote {
+			if vote == nil {
+				return nil, nil
+			}
+			wrapped = vote
+			break
+		}
 		var err error
 		wrapped, err = s.wrapReaction(ctx, &meta, evt.Reaction, true, evt.Item)
 		if err != nil {
@@ -213,23 +484,42 @@ func (s *SlackClient) wrapEvent(ctx context.Context, rawEvt any) (bridgev2.Remot
 		}
 	case *slack.ReactionRemovedEvent:
 		meta, metaErr = s.makeEventMeta(ctx, evt.Item.Channel, nil, evt.User, evt.EventTimestamp)
+		if vote, isVote := s.wrapPollVote(ctx, &meta, evt.User, evt.Reaction, false, evt.Item); isVote {
+			if vote == nil {
+				return nil, nil
+			}
+			wrapped = vote
+			break
+		}
 		wrapped, _ = s.wrapReaction(ctx, &meta, evt.Reaction, false, evt.Item)
 
 	case *slack.UserTypingEvent:
+		if !s.bridgeTyping() || !s.shouldBridgeTyping(ctx, evt.Channel, evt.User) {
+			return nil, nil
+		}
 		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, evt.User, "")
 		wrapped = wrapTyping(&meta)
 
 	case *slack.ChannelMarkedEvent:
-		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, s.UserID, evt.Timestamp)
 		s.setLastReadCache(evt.Channel, evt.Timestamp)
+		if !s.bridgeReceipts() {
+			return nil, nil
+		}
+		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, s.UserID, evt.Timestamp)
 		wrapped = wrapReadReceipt(&meta)
 	case *slack.IMMarkedEvent:
-		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, s.UserID, evt.Timestamp)
 		s.setLastReadCache(evt.Channel, evt.Timestamp)
+		if !s.bridgeReceipts() {
+			return nil, nil
+		}
+		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, s.UserID, evt.Timestamp)
 		wrapped = wrapReadReceipt(&meta)
 	case *slack.GroupMarkedEvent:
-		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, s.UserID, evt.Timestamp)
 		s.setLastReadCache(evt.Channel, evt.Timestamp)
+		if !s.bridgeReceipts() {
+			return nil, nil
+		}
+		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, s.UserID, evt.Timestamp)
 		wrapped = wrapReadReceipt(&meta)
 
 	case *slack.ChannelJoinedEvent:
@@ -247,17 +537,40 @@ func (s *SlackClient) wrapEvent(ctx context.Context, rawEvt any) (bridgev2.Remot
 		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, s.UserID, evt.Timestamp)
 		wrapped = wrapMemberChange(&meta, meta.Sender, event.MembershipLeave, event.MembershipJoin)
 	case *slack.MemberJoinedChannelEvent:
-		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, evt.User, evt.EventTimestamp)
-		wrapped = wrapMemberChange(&meta, meta.Sender, event.MembershipJoin, "")
+		// Users added by someone else are invited by the inviter's ghost, which makes Matrix show who added them
+		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, cmp.Or(evt.Inviter, evt.User), evt.EventTimestamp)
+		wrapped = wrapMemberChange(&meta, s.makeEventSender(evt.User), event.MembershipJoin, "")
 	case *slack.MemberLeftChannelEvent:
 		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, evt.User, evt.EventTimestamp)
 		wrapped = wrapMemberChange(&meta, meta.Sender, event.MembershipLeave, event.MembershipJoin)
 
-	case *slack.ChannelUpdateEvent:
-		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, "", evt.Timestamp)
+	case *slack.IMOpenEvent:
+		meta, metaErr = s.makeEventMeta(ctx, evt.Channel, nil, "", "")
+		if metaErr != nil {
+			break
+		}
 		meta.Type = bridgev2.RemoteEventChatResync
-		//meta.CreatePortal = true
-		wrapped = &meta
+		meta.CreatePortal = true
+		s.markDMReopened(ctx, meta.PortalKey)
+		wrapped = &SlackChatResync{SlackEventMeta: &meta, Client: s, ShouldSyncInfo: true}
+
+	case *slack.ChannelCreatedEvent:
+		return s.wrapChannelCreated(ctx, evt.Channel.ID, evt.Channel.Creator)
+	case *slackevents.ChannelCreatedEvent:
+		return s.wrapChannelCreated(ctx, evt.Channel.ID, evt.Channel.Creator)
+	case *slack.StarAddedEvent:
+		return s.wrapStarChange(ctx, evt.Item, true)
+	case *slack.StarRemovedEvent:
+		return s.wrapStarChange(ctx, evt.Item, false)
+
+	case *channelConversionEvent:
+		meta, metaErr = s.makeEventMeta(ctx, evt.ChannelID, nil, "", "")
+		meta.Type = bridgev2.RemoteEventChatResync
+		meta.LogContext = func(c zerolog.Context) zerolog.Context {
+			return c.Str("conversion_type", evt.Type)
+		}
+		s.invalidateChatInfoCache(evt.ChannelID)
+		wrapped = &SlackChatResync{SlackEventMeta: &meta, Client: s, ShouldSyncInfo: true, ForceInfoUpdate: true}
 	}
 	return wrapped, metaErr
 }
@@ -271,7 +584,7 @@ func (s *SlackClient) getReactionInfo(ctx context.Context, reaction string) (emo
 	emoji, isImage = s.GetEmoji(ctx, reaction)
 	if isImage {
 		slackReactionInfo["mxc"] = emoji
-		if !s.Main.Config.CustomEmojiReactions {
+		if !s.Main.currentConfig().CustomEmojiReactions {
 			emoji = shortcode
 		}
 	}
@@ -292,7 +605,7 @@ func (s *SlackClient) wrapReaction(ctx context.Context, meta *SlackEventMeta, re
 	return &SlackReaction{
 		SlackEventMeta: meta,
 		Emoji:          emoji,
-		EmojiID:        networkid.EmojiID(reaction),
+		EmojiID:        s.reactionEmojiID(ctx, reaction),
 		Meta:           extraContent,
 		TargetID:       slackid.MakeMessageID(s.TeamID, target.Channel, target.Timestamp),
 	}, nil
@@ -369,6 +682,8 @@ type SlackEventMeta struct {
 	LogContext   func(zerolog.Context) zerolog.Context
 	CreatePortal bool
 	RawTimestamp string
+	// The span in which the event was received, used to continue the trace when the event is converted
+	SpanContext trace.SpanContext
 }
 
 func (s *SlackEventMeta) GetType() bridgev2.RemoteEventType {
@@ -406,9 +721,14 @@ func (s *SlackEventMeta) GetID() networkid.MessageID {
 	return s.ID
 }
 
+func (s *SlackEventMeta) GetStreamOrder() int64 {
+	return slackid.ParseSlackStreamOrder(s.RawTimestamp)
+}
+
 var (
 	_ bridgev2.RemoteEvent                    = (*SlackEventMeta)(nil)
 	_ bridgev2.RemoteEventWithTimestamp       = (*SlackEventMeta)(nil)
+	_ bridgev2.RemoteEventWithStreamOrder     = (*SlackEventMeta)(nil)
 	_ bridgev2.RemoteEventThatMayCreatePortal = (*SlackEventMeta)(nil)
 )
 
@@ -448,7 +768,7 @@ type SlackTyping struct {
 var _ bridgev2.RemoteTyping = (*SlackTyping)(nil)
 
 func (s *SlackTyping) GetTimeout() time.Duration {
-	return 5 * time.Second
+	return TypingTimeout
 }
 
 type SlackReaction struct {
@@ -485,6 +805,47 @@ type SlackMessage struct {
 	*SlackEventMeta
 	Data   *slack.MessageEvent
 	Client *SlackClient
+
+	// ThreadRootOverride is set when a message is rerouted into a thread in another portal,
+	// e.g. Slackbot reminders about a specific message.
+	ThreadRootOverride networkid.MessageID
+}
+
+// isSkippedOwnMessage returns true for new messages sent by the logged-in user from other Slack clients
+// when bridging them is disabled. Echoes of messages sent from Matrix are already deduplicated by bridgev2
+// using the message ID, so skipping them is harmless. Echoes of file uploads that are still pending are
+// never skipped, as bridgev2 needs them to save the message. Bot logins are excluded, as they can't send
+// from other clients. Edits and deletions are still bridged so that messages sent from Matrix stay in sync.
+func (s *SlackClient) isSkippedOwnMessage(msg *SlackMessage) bool {
+	if msg.Data.User != s.UserID || msg.GetType() != bridgev2.RemoteEventMessage {
+		return false
+	} else if s.takePendingUpload(msg.GetTransactionID()) {
+		return false
+	}
+	return !s.Main.currentConfig().BridgeOwnMessages && s.IsRealUser
+}
+
+// addPendingUpload remembers the transaction ID of a file upload whose message will only be saved
+// when the echo is received.
+func (s *SlackClient) addPendingUpload(txnID networkid.TransactionID) {
+	s.pendingUploadsLock.Lock()
+	defer s.pendingUploadsLock.Unlock()
+	if s.pendingUploads == nil {
+		s.pendingUploads = make(map[networkid.TransactionID]struct{})
+	}
+	s.pendingUploads[txnID] = struct{}{}
+}
+
+// takePendingUpload returns true and forgets the transaction ID if it belongs to a pending file upload.
+func (s *SlackClient) takePendingUpload(txnID networkid.TransactionID) bool {
+	if txnID == "" {
+		return false
+	}
+	s.pendingUploadsLock.Lock()
+	defer s.pendingUploadsLock.Unlock()
+	_, ok := s.pendingUploads[txnID]
+	delete(s.pendingUploads, txnID)
+	return ok
 }
 
 func (s *SlackMessage) GetTransactionID() networkid.TransactionID {
@@ -508,23 +869,40 @@ type SlackChatResync struct {
 	LatestMessage  string
 	PreFetchedInfo *slack.Channel
 	ShouldSyncInfo bool
+	// ForceInfoUpdate disables skipping unchanged info, e.g. for visibility changes that aren't reflected in the hash
+	ForceInfoUpdate bool
 }
 
 func (s *SlackChatResync) GetChatInfo(ctx context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
-	if s.PreFetchedInfo != nil {
-		wrappedInfo, err := s.Client.wrapChatInfo(ctx, s.PreFetchedInfo, portal.MXID == "")
+	if s.PreFetchedInfo != nil && (s.ShouldSyncInfo || s.Client.needsInfoRefresh(portal)) {
+		wrappedInfo, err := s.Client.wrapChatInfo(ctx, s.PreFetchedInfo, portal)
 		if err != nil {
 			return nil, fmt.Errorf("failed to wrap chat info: %w", err)
 		}
-		return wrappedInfo, nil
+		return s.skipUnchangedChatInfo(portal, wrappedInfo), nil
 	} else if !s.ShouldSyncInfo {
 		return nil, nil
 	}
-	return s.Client.GetChatInfo(ctx, portal)
+	info, err := s.Client.GetChatInfo(ctx, portal)
+	if err != nil {
+		return nil, err
+	}
+	return s.skipUnchangedChatInfo(portal, info), nil
+}
+
+func (s *SlackChatResync) skipUnchangedChatInfo(portal *bridgev2.Portal, info *bridgev2.ChatInfo) *bridgev2.ChatInfo {
+	if s.ForceInfoUpdate {
+		return info
+	}
+	return skipUnchangedChatInfo(portal, info)
 }
 
 func (s *SlackChatResync) CheckNeedsBackfill(ctx context.Context, latestBridgedMessage *database.Message) (bool, error) {
-	if latestBridgedMessage == nil {
+	_, channelID := slackid.ParsePortalID(s.PortalKey.ID)
+	if cursor := s.Client.eventCursors.getLoaded(channelID); cursor != "" && cursor >= s.LatestMessage {
+		// Every event up to the latest message was already handled before the bridge was restarted
+		return false, nil
+	} else if latestBridgedMessage == nil {
 		return s.LatestMessage != "" && s.LatestMessage != "0000000000.000000", nil
 	}
 	_, _, latestBridgedID, _ := slackid.ParseMessageID(latestBridgedMessage.ID)
@@ -538,17 +916,27 @@ var (
 func (s *SlackMessage) GetType() bridgev2.RemoteEventType {
 	switch s.Data.SubType {
 	case slack.MsgSubTypeMessageChanged:
+		if s.Data.SubMessage == nil {
+			return bridgev2.RemoteEventUnknown
+		} else if s.Data.SubMessage.SubType == "tombstone" {
+			// Thread roots that are deleted while they still have replies are replaced with a tombstone
+			return bridgev2.RemoteEventMessageRemove
+		} else if s.Data.SubMessage.Edited == nil {
+			// Changes without an edit marker are metadata updates (e.g. reply counts on thread roots
+			// and channel copies of thread broadcasts), which shouldn't be bridged as edits.
+			return bridgev2.RemoteEventUnknown
+		}
 		return bridgev2.RemoteEventEdit
 	case slack.MsgSubTypeMessageDeleted:
 		return bridgev2.RemoteEventMessageRemove
 	case slack.MsgSubTypeChannelTopic, slack.MsgSubTypeChannelPurpose, slack.MsgSubTypeChannelName,
 		slack.MsgSubTypeGroupTopic, slack.MsgSubTypeGroupPurpose, slack.MsgSubTypeGroupName:
-		// TODO implement deltas instead of full resync
-		return bridgev2.RemoteEventChatResync
+		return bridgev2.RemoteEventChatInfoChange
 	case slack.MsgSubTypeMessageReplied, slack.MsgSubTypeGroupJoin, slack.MsgSubTypeGroupLeave,
 		slack.MsgSubTypeChannelJoin, slack.MsgSubTypeChannelLeave:
 		return bridgev2.RemoteEventUnknown
-	case "", slack.MsgSubTypeMeMessage, slack.MsgSubTypeBotMessage, slack.MsgSubTypeThreadBroadcast, "huddle_thread":
+	case "", slack.MsgSubTypeMeMessage, slack.MsgSubTypeBotMessage, slack.MsgSubTypeThreadBroadcast,
+		slack.MsgSubTypeReplyBroadcast, "huddle_thread":
 		// Known types
 		return bridgev2.RemoteEventMessage
 	default:
@@ -560,11 +948,43 @@ func (s *SlackMessage) GetType() bridgev2.RemoteEventType {
 	}
 }
 
+// GetChatInfoChange converts channel name and topic change messages into chat info changes,
+// so that the change is sent to Matrix by the ghost of the Slack user who made it.
+func (s *SlackMessage) GetChatInfoChange(ctx context.Context) (*bridgev2.ChatInfoChange, error) {
+	_, channelID := slackid.ParsePortalID(s.PortalKey.ID)
+	s.Client.invalidateChatInfoCache(channelID)
+	switch s.Data.SubType {
+	case slack.MsgSubTypeChannelTopic, slack.MsgSubTypeGroupTopic:
+		return &bridgev2.ChatInfoChange{ChatInfo: &bridgev2.ChatInfo{Topic: &s.Data.Topic}}, nil
+	case slack.MsgSubTypeChannelName, slack.MsgSubTypeGroupName:
+		info, err := s.Client.fetchChatInfoWithCache(ctx, channelID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch channel info: %w", err)
+		}
+		name := s.Client.Main.currentConfig().FormatChannelName(&ChannelNameParams{
+			Channel: info,
+			Team:    s.Client.teamInfo(),
+		})
+		return &bridgev2.ChatInfoChange{ChatInfo: &bridgev2.ChatInfo{Name: &name}}, nil
+	default:
+		// Channel purposes aren't bridged to Matrix
+		return &bridgev2.ChatInfoChange{}, nil
+	}
+}
+
 func (s *SlackMessage) ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI) (*bridgev2.ConvertedMessage, error) {
-	return s.Client.Main.MsgConv.ToMatrix(ctx, portal, intent, s.Client.UserLogin, &s.Data.Msg), nil
+	ctx, span := s.startRemoteSpan(ctx, "ConvertMessage", attribute.String("slack.message_id", string(s.GetID())))
+	defer span.End()
+	converted := s.Client.Main.MsgConv.ToMatrix(ctx, portal, intent, s.Client.UserLogin, &s.Data.Msg)
+	if s.ThreadRootOverride != "" {
+		converted.ThreadRoot = &s.ThreadRootOverride
+	}
+	return converted, nil
 }
 
 func (s *SlackMessage) ConvertEdit(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message) (*bridgev2.ConvertedEdit, error) {
+	ctx, span := s.startRemoteSpan(ctx, "ConvertEdit", attribute.String("slack.message_id", string(s.GetTargetMessage())))
+	defer span.End()
 	meta := existing[0].Metadata.(*slackid.MessageMetadata)
 	if meta.LastEditTS >= s.Data.SubMessage.Edited.Timestamp {
 		return nil, fmt.Errorf(
@@ -572,20 +992,36 @@ func (s *SlackMessage) ConvertEdit(ctx context.Context, portal *bridgev2.Portal,
 			bridgev2.ErrIgnoringRemoteEvent, meta.LastEditTS, s.Data.SubMessage.Edited.Timestamp,
 		)
 	}
+	if !msgconv.EditChangesContent(s.Data.SubMessage, s.Data.PreviousMessage) {
+		return nil, fmt.Errorf("%w: edit doesn't change the text, files or attachments", bridgev2.ErrIgnoringRemoteEvent)
+	}
 	return s.Client.Main.MsgConv.EditToMatrix(ctx, portal, intent, s.Client.UserLogin, s.Data.SubMessage, s.Data.PreviousMessage, existing), nil
 }
 
-func (s *SlackMessage) GetTimestamp() time.Time {
+func (s *SlackMessage) getRawTimestamp() string {
 	switch s.Data.SubType {
 	case slack.MsgSubTypeMessageChanged:
-		return slackid.ParseSlackTimestamp(s.Data.EventTimestamp)
+		return s.Data.EventTimestamp
 	default:
-		return slackid.ParseSlackTimestamp(s.Data.Timestamp)
+		return s.Data.Timestamp
 	}
 }
 
+func (s *SlackMessage) GetTimestamp() time.Time {
+	return slackid.ParseSlackTimestamp(s.getRawTimestamp())
+}
+
+func (s *SlackMessage) GetStreamOrder() int64 {
+	return slackid.ParseSlackStreamOrder(s.getRawTimestamp())
+}
+
 func (s *SlackMessage) GetID() networkid.MessageID {
-	return slackid.MakeMessageID(s.Client.TeamID, s.Data.Channel, s.Data.Timestamp)
+	channelID := s.Data.Channel
+	if s.ThreadRootOverride != "" {
+		// Rerouted messages are stored in the portal they were moved to, so the ID has to use its channel
+		_, channelID = slackid.ParsePortalID(s.PortalKey.ID)
+	}
+	return slackid.MakeMessageID(s.Client.TeamID, channelID, s.Data.Timestamp)
 }
 
 func (s *SlackMessage) GetTargetMessage() networkid.MessageID {
@@ -593,6 +1029,9 @@ func (s *SlackMessage) GetTargetMessage() networkid.MessageID {
 	case slack.MsgSubTypeMessageDeleted:
 		return slackid.MakeMessageID(s.Client.TeamID, s.Data.Channel, s.Data.DeletedTimestamp)
 	case slack.MsgSubTypeMessageChanged:
+		if s.Data.SubMessage.SubType == "tombstone" {
+			return slackid.MakeMessageID(s.Client.TeamID, s.Data.Channel, s.Data.SubMessage.Timestamp)
+		}
 		// Socket mode events don't have the target timestamp at the top level
 		// TODO always just use the submessage timestamp?
 		if s.Data.EventTimestamp == s.Data.Timestamp {
@@ -0,0 +1,197 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestSlackMessage_GetType(t *testing.T) {
+	type testCase struct {
+		name       string
+		subType    string
+		hidden     bool
+		subMessage *slack.Msg
+		expected   bridgev2.RemoteEventType
+	}
+	testCases := []testCase{
+		{"Normal", "", false, nil, bridgev2.RemoteEventMessage},
+		{"MeMessage", slack.MsgSubTypeMeMessage, false, nil, bridgev2.RemoteEventMessage},
+		{"BotMessage", slack.MsgSubTypeBotMessage, false, nil, bridgev2.RemoteEventMessage},
+		{"ThreadBroadcast", slack.MsgSubTypeThreadBroadcast, false, nil, bridgev2.RemoteEventMessage},
+		{"ReplyBroadcast", slack.MsgSubTypeReplyBroadcast, false, nil, bridgev2.RemoteEventMessage},
+		{"HuddleThread", "huddle_thread", false, nil, bridgev2.RemoteEventMessage},
+		{"Edit", slack.MsgSubTypeMessageChanged, false, &slack.Msg{Edited: &slack.Edited{Timestamp: "1234567890.123456"}}, bridgev2.RemoteEventEdit},
+		{"MetadataChange", slack.MsgSubTypeMessageChanged, true, &slack.Msg{ReplyCount: 2}, bridgev2.RemoteEventUnknown},
+		{"BroadcastRootChange", slack.MsgSubTypeMessageChanged, true, &slack.Msg{SubType: slack.MsgSubTypeThreadBroadcast}, bridgev2.RemoteEventUnknown},
+		{"ChangeWithoutSubMessage", slack.MsgSubTypeMessageChanged, true, nil, bridgev2.RemoteEventUnknown},
+		{"Tombstone", slack.MsgSubTypeMessageChanged, true, &slack.Msg{SubType: "tombstone"}, bridgev2.RemoteEventMessageRemove},
+		{"Delete", slack.MsgSubTypeMessageDeleted, true, nil, bridgev2.RemoteEventMessageRemove},
+		{"ChannelTopic", slack.MsgSubTypeChannelTopic, false, nil, bridgev2.RemoteEventChatInfoChange},
+		{"ChannelPurpose", slack.MsgSubTypeChannelPurpose, false, nil, bridgev2.RemoteEventChatInfoChange},
+		{"ChannelName", slack.MsgSubTypeChannelName, false, nil, bridgev2.RemoteEventChatInfoChange},
+		{"GroupTopic", slack.MsgSubTypeGroupTopic, false, nil, bridgev2.RemoteEventChatInfoChange},
+		{"GroupPurpose", slack.MsgSubTypeGroupPurpose, false, nil, bridgev2.RemoteEventChatInfoChange},
+		{"GroupName", slack.MsgSubTypeGroupName, false, nil, bridgev2.RemoteEventChatInfoChange},
+		{"MessageReplied", slack.MsgSubTypeMessageReplied, true, nil, bridgev2.RemoteEventUnknown},
+		{"ChannelJoin", slack.MsgSubTypeChannelJoin, false, nil, bridgev2.RemoteEventUnknown},
+		{"ChannelLeave", slack.MsgSubTypeChannelLeave, false, nil, bridgev2.RemoteEventUnknown},
+		{"GroupJoin", slack.MsgSubTypeGroupJoin, false, nil, bridgev2.RemoteEventUnknown},
+		{"GroupLeave", slack.MsgSubTypeGroupLeave, false, nil, bridgev2.RemoteEventUnknown},
+		{"UnknownVisible", slack.MsgSubTypePinnedItem, false, nil, bridgev2.RemoteEventMessage},
+		{"UnknownHidden", "some_future_subtype", true, nil, bridgev2.RemoteEventUnknown},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := &SlackMessage{Data: &slack.MessageEvent{
+				Msg:        slack.Msg{SubType: tc.subType, Hidden: tc.hidden},
+				SubMessage: tc.subMessage,
+			}}
+			assert.Equal(t, tc.expected, msg.GetType())
+		})
+	}
+}
+
+func TestSlackMessage_GetChatInfoChange(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.info", func(form url.Values) (any, error) {
+		return map[string]any{"channel": map[string]any{"id": form.Get("channel"), "name": "renamed"}}, nil
+	})
+	s := newTestSlackClient(srv.Client())
+	s.Main = &SlackConnector{}
+	require.NoError(t, yaml.Unmarshal([]byte("channel_name_template: '#{{.Name}}'"), &s.Main.Config))
+	s.BootResp = &slack.ClientUserBootResponse{}
+	ctx := context.Background()
+	makeMsg := func(subType string, msg slack.Msg) *SlackMessage {
+		msg.SubType = subType
+		return &SlackMessage{
+			SlackEventMeta: &SlackEventMeta{PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", "C1")}},
+			Data:           &slack.MessageEvent{Msg: msg},
+			Client:         s,
+		}
+	}
+
+	change, err := makeMsg(slack.MsgSubTypeChannelTopic, slack.Msg{Topic: "New topic"}).GetChatInfoChange(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, change.ChatInfo)
+	assert.Equal(t, "New topic", *change.ChatInfo.Topic)
+	assert.Nil(t, change.ChatInfo.Name)
+
+	change, err = makeMsg(slack.MsgSubTypeChannelName, slack.Msg{Name: "renamed", OldName: "general"}).GetChatInfoChange(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, change.ChatInfo)
+	assert.Equal(t, "#renamed", *change.ChatInfo.Name)
+
+	change, err = makeMsg(slack.MsgSubTypeChannelPurpose, slack.Msg{Purpose: "Purpose"}).GetChatInfoChange(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, change.ChatInfo)
+}
+
+func TestSlackClient_IsSkippedOwnMessage(t *testing.T) {
+	type testCase struct {
+		name         string
+		bridgeOwn    bool
+		pending      bool
+		user         string
+		subType      string
+		files        []slack.File
+		subMessage   *slack.Msg
+		expectedSkip bool
+	}
+	testCases := []testCase{
+		{"OwnMessageBridged", true, false, "U1", "", nil, nil, false},
+		{"OwnMessageSkipped", false, false, "U1", "", nil, nil, true},
+		{"OtherUserMessage", false, false, "U2", "", nil, nil, false},
+		{"OwnMeMessage", false, false, "U1", slack.MsgSubTypeMeMessage, nil, nil, true},
+		{"OwnEdit", false, false, "U1", slack.MsgSubTypeMessageChanged, nil, &slack.Msg{Edited: &slack.Edited{Timestamp: "1234567890.123456"}}, false},
+		{"OwnDelete", false, false, "U1", slack.MsgSubTypeMessageDeleted, nil, nil, false},
+		{"OwnFileMessage", false, false, "U1", "", []slack.File{{ID: "F1"}}, nil, true},
+		{"PendingUploadEcho", false, true, "U1", "", []slack.File{{ID: "F1"}}, nil, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newTestSlackClient(nil)
+			client.Main = &SlackConnector{Config: Config{BridgeOwnMessages: tc.bridgeOwn}}
+			if tc.pending {
+				client.addPendingUpload("U1:F1")
+			}
+			msg := &SlackMessage{Data: &slack.MessageEvent{
+				Msg:        slack.Msg{User: tc.user, SubType: tc.subType, Files: tc.files},
+				SubMessage: tc.subMessage,
+			}}
+			assert.Equal(t, tc.expectedSkip, client.isSkippedOwnMessage(msg))
+			if tc.pending {
+				// The pending upload is only matched once
+				assert.True(t, client.isSkippedOwnMessage(msg))
+			}
+		})
+	}
+}
+
+func TestUnmappedEventType(t *testing.T) {
+	assert.Equal(t, "team_icon_change", unmappedEventType(slack.NewUnmappedError("RTM Error", "team_icon_change", nil)))
+	assert.Equal(t, "", unmappedEventType(fmt.Errorf(`Received unmapped event "team_icon_change"`)))
+	assert.Equal(t, "", unmappedEventType(nil))
+}
+
+func TestRerouteSlackbotReference(t *testing.T) {
+	br := newTestBridgeDB(t)
+	ctx := context.Background()
+	s := newTestSlackClient(nil)
+	s.Main = &SlackConnector{br: br, Config: Config{SlackbotRemindersInThreads: true}}
+	s.UserLogin = &bridgev2.UserLogin{UserLogin: &database.UserLogin{ID: slackid.MakeUserLoginID("T1", "U1")}}
+	channelKey := networkid.PortalKey{ID: slackid.MakePortalID("T1", "C1")}
+	require.NoError(t, br.DB.Portal.Insert(ctx, &database.Portal{PortalKey: channelKey, Metadata: &slackid.PortalMetadata{}}))
+	targetID := slackid.MakeMessageID("T1", "C1", "1700000000.000100")
+	require.NoError(t, br.DB.Message.Insert(ctx, &database.Message{
+		ID:        targetID,
+		MXID:      "$target",
+		Room:      channelKey,
+		Timestamp: time.Unix(1700000000, 0),
+		Metadata:  &slackid.MessageMetadata{},
+	}))
+
+	msg := &SlackMessage{
+		SlackEventMeta: &SlackEventMeta{PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", "D1")}},
+		Data: &slack.MessageEvent{Msg: slack.Msg{
+			Channel:   "D1",
+			User:      SlackbotUserID,
+			Text:      "Reminder: https://example.slack.com/archives/C1/p1700000000000100",
+			Timestamp: "1700000500.000200",
+		}},
+		Client: s,
+	}
+	s.rerouteSlackbotReference(ctx, msg)
+	assert.Equal(t, channelKey, msg.PortalKey)
+	assert.Equal(t, targetID, msg.ThreadRootOverride)
+	assert.Equal(t, slackid.MakeMessageID("T1", "C1", "1700000500.000200"), msg.GetID())
+}
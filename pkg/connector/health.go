@@ -0,0 +1,107 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/status"
+	"maunium.net/go/mautrix/id"
+)
+
+type LoginHealth struct {
+	LoginID    networkid.UserLoginID       `json:"login_id"`
+	UserMXID   id.UserID                   `json:"user_mxid"`
+	TeamID     string                      `json:"team_id"`
+	State      status.BridgeStateEvent     `json:"state"`
+	StateError status.BridgeStateErrorCode `json:"state_error,omitempty"`
+	Connection string                      `json:"connection"`
+	// Seconds since the last event was received from Slack, or null if no events have been received.
+	LastEventAge *float64         `json:"last_event_age"`
+	EventQueue   *EventQueueStats `json:"event_queue,omitempty"`
+}
+
+type HealthResponse struct {
+	OK     bool           `json:"ok"`
+	Logins []*LoginHealth `json:"logins"`
+}
+
+func (s *SlackClient) getHealth() *LoginHealth {
+	state := s.UserLogin.BridgeState.GetPrev()
+	health := &LoginHealth{
+		LoginID:    s.UserLogin.ID,
+		UserMXID:   s.UserLogin.UserMXID,
+		TeamID:     s.TeamID,
+		State:      state.StateEvent,
+		StateError: state.Error,
+		Connection: s.connectionType(),
+	}
+	if lastEvent := s.lastEventAt.Load(); lastEvent != 0 {
+		age := time.Since(time.UnixMilli(lastEvent)).Seconds()
+		health.LastEventAge = &age
+	}
+	if queue := s.EventQueue.Load(); queue != nil {
+		stats := queue.Stats()
+		health.EventQueue = &stats
+	}
+	return health
+}
+
+// ServeHealth is an HTTP handler that reports the connection state of every Slack login on the bridge.
+// The response status is 200 if all logins are connected and 503 otherwise.
+func (s *SlackConnector) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := zerolog.Ctx(ctx)
+	resp := &HealthResponse{OK: true, Logins: []*LoginHealth{}}
+	userIDs, err := s.br.DB.UserLogin.GetAllUserIDsWithLogins(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get users with logins for health check")
+		http.Error(w, "Failed to get logins", http.StatusInternalServerError)
+		return
+	}
+	for _, userID := range userIDs {
+		user, err := s.br.GetExistingUserByMXID(ctx, userID)
+		if err != nil {
+			log.Err(err).Stringer("user_id", userID).Msg("Failed to get user for health check")
+			continue
+		} else if user == nil {
+			continue
+		}
+		for _, login := range user.GetUserLogins() {
+			client, ok := login.Client.(*SlackClient)
+			if !ok {
+				continue
+			}
+			health := client.getHealth()
+			if health.State != status.StateConnected {
+				resp.OK = false
+			}
+			resp.Logins = append(resp.Logins, health)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp.OK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
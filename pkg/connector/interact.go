@@ -0,0 +1,183 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/msgconv"
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var cmdInteract = &commands.FullHandler{
+	Func: fnInteract,
+	Name: "interact",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Click a button or choose a menu option in the Slack message you're replying to. Lists the options if no label is given.",
+		Args:        "[_label_]",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+var (
+	errBlockActionsNeedRealUser = errors.New("buttons can only be used when logged in with a Slack user account")
+	errNoBlockActions           = errors.New("the message doesn't have any buttons or menu options")
+	errBlockActionNotFound      = errors.New("the message doesn't have an option with that label")
+	errBlockActionsNeedApp      = errors.New("the message wasn't sent by an app, so its buttons can only be used in Slack")
+)
+
+// messageBlockActions fetches the current version of a Slack message and returns its interactive options
+// along with the bot ID of the app that posted it.
+func (s *SlackClient) messageBlockActions(ctx context.Context, target *database.Message) ([]msgconv.BlockActionOption, string, error) {
+	if s.WebAPI == nil {
+		return nil, "", errBlockActionsNeedRealUser
+	}
+	_, channelID, ts, ok := slackid.ParseMessageID(target.ID)
+	if !ok {
+		return nil, "", errors.New("invalid message ID")
+	}
+	var threadTS string
+	if target.ThreadRoot != "" {
+		_, _, threadTS, _ = slackid.ParseMessageID(target.ThreadRoot)
+	}
+	current, err := s.fetchSlackMessage(ctx, channelID, ts, threadTS)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch message: %w", err)
+	} else if current == nil {
+		return nil, "", errors.New("message not found on Slack")
+	}
+	options := msgconv.BlockActionOptions(current.Blocks)
+	if len(options) == 0 {
+		return nil, "", errNoBlockActions
+	} else if current.BotID == "" {
+		return nil, "", errBlockActionsNeedApp
+	}
+	return options, current.BotID, nil
+}
+
+// triggerBlockAction clicks the button or chooses the select menu option with the given label in a Slack message.
+func (s *SlackClient) triggerBlockAction(ctx context.Context, target *database.Message, label string) (*msgconv.BlockActionOption, error) {
+	options, botID, err := s.messageBlockActions(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	opt := msgconv.FindBlockActionOption(options, label)
+	if opt == nil {
+		return nil, errBlockActionNotFound
+	}
+	_, channelID, ts, _ := slackid.ParseMessageID(target.ID)
+	err = s.WebAPI.PostBlockActions(ctx, channelID, ts, botID, slackapi.BlockAction{
+		ActionID:       opt.ActionID,
+		BlockID:        opt.BlockID,
+		Type:           opt.Type,
+		Value:          opt.Value,
+		SelectedOption: opt.Option,
+	})
+	if err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Debug().
+		Str("message_id", string(target.ID)).
+		Str("action_id", opt.ActionID).
+		Str("label", opt.Label).
+		Msg("Triggered Slack block action")
+	return opt, nil
+}
+
+// handleBlockActionReply triggers a block action if the Matrix message is a reply to a Slack message with
+// buttons or select menus, and the text of the reply matches one of the option labels. Replies that don't
+// match any option are bridged normally.
+func (s *SlackClient) handleBlockActionReply(ctx context.Context, msg *bridgev2.MatrixMessage) (*bridgev2.MatrixMessageResponse, bool, error) {
+	if msg.ReplyTo == nil || s.WebAPI == nil || msg.Content.MsgType != event.MsgText {
+		return nil, false, nil
+	} else if meta, ok := msg.ReplyTo.Metadata.(*slackid.MessageMetadata); !ok || !meta.HasBlockActions {
+		return nil, false, nil
+	}
+	label := event.TrimReplyFallbackText(msg.Content.Body)
+	_, err := s.triggerBlockAction(ctx, msg.ReplyTo, label)
+	if errors.Is(err, errBlockActionNotFound) || errors.Is(err, errNoBlockActions) || errors.Is(err, errBlockActionsNeedApp) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, true, wrapSlackError(err)
+	}
+	return &bridgev2.MatrixMessageResponse{
+		DB: &database.Message{
+			ID:        slackid.MakeBlockActionID(msg.ReplyTo.ID, msg.Event.ID),
+			SenderID:  slackid.MakeUserID(s.TeamID, s.UserID),
+			Timestamp: time.UnixMilli(msg.Event.Timestamp),
+		},
+	}, true, nil
+}
+
+func fnInteract(ce *commands.Event) {
+	if ce.ReplyTo == "" {
+		ce.Reply("Reply to a Slack message with buttons to use this command: `$cmdprefix interact [label]`")
+		return
+	}
+	target, err := ce.Bridge.DB.Message.GetPartByMXID(ce.Ctx, ce.ReplyTo)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get reply target message")
+		ce.Reply("Failed to get message: %v", err)
+		return
+	} else if target == nil || target.Room != ce.Portal.PortalKey {
+		ce.Reply("That message isn't a bridged Slack message in this room")
+		return
+	}
+	client := portalLogin(ce)
+	if client == nil {
+		ce.Reply("You're not logged into the team of this chat")
+		return
+	} else if client.isReadOnly() {
+		ce.Reply(readOnlyCommandReply)
+		return
+	}
+	if len(ce.Args) == 0 {
+		options, _, err := client.messageBlockActions(ce.Ctx, target)
+		if err != nil {
+			ce.Reply("Failed to get options: %v", err)
+			return
+		}
+		labels := make([]string, len(options))
+		for i, opt := range options {
+			labels[i] = fmt.Sprintf("* %s", opt.Label)
+		}
+		ce.Reply("Available options:\n\n%s", strings.Join(labels, "\n"))
+		return
+	}
+	opt, err := client.triggerBlockAction(ce.Ctx, target, ce.RawArgs)
+	if errors.Is(err, errBlockActionNotFound) {
+		ce.Reply("The message doesn't have an option called %q, use `$cmdprefix interact` without a label to list them", ce.RawArgs)
+	} else if err != nil {
+		ce.Log.Err(err).Msg("Failed to trigger Slack block action")
+		ce.Reply("Failed to send interaction to Slack: %v", err)
+	} else {
+		ce.Reply("Chose **%s**", opt.Label)
+	}
+}
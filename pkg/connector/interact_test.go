@@ -0,0 +1,117 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func newBlockActionTestServer(t *testing.T) (*slackapitest.Server, *SlackClient) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.history", func(form url.Values) (any, error) {
+		return map[string]any{"messages": []any{map[string]any{
+			"ts":     form.Get("latest"),
+			"bot_id": "B1",
+			"blocks": []any{map[string]any{
+				"type":     "actions",
+				"block_id": "b1",
+				"elements": []any{
+					map[string]any{"type": "button", "action_id": "approve", "value": "req-1", "text": map[string]any{"type": "plain_text", "text": "Approve"}},
+					map[string]any{"type": "button", "action_id": "deny", "value": "req-1", "text": map[string]any{"type": "plain_text", "text": "Deny"}},
+				},
+			}},
+		}}}, nil
+	})
+	srv.Respond("blocks.actions", nil)
+	s := newTestSlackClient(srv.Client())
+	s.WebAPI = slackapi.NewWebClient("xoxc-test", "cookie")
+	s.WebAPI.APIURL = srv.URL + "/api/"
+	return srv, s
+}
+
+func TestTriggerBlockAction(t *testing.T) {
+	srv, s := newBlockActionTestServer(t)
+	ctx := context.Background()
+	target := &database.Message{ID: slackid.MakeMessageID("T1", "C1", "1700000000.000100")}
+
+	opt, err := s.triggerBlockAction(ctx, target, "deny")
+	require.NoError(t, err)
+	assert.Equal(t, "Deny", opt.Label)
+	calls := srv.Calls("blocks.actions")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "B1", calls[0].Get("service_id"))
+	var actions []slackapi.BlockAction
+	require.NoError(t, json.Unmarshal([]byte(calls[0].Get("actions")), &actions))
+	require.Len(t, actions, 1)
+	assert.Equal(t, "deny", actions[0].ActionID)
+	assert.Equal(t, "b1", actions[0].BlockID)
+	assert.Equal(t, "req-1", actions[0].Value)
+	assert.NotEmpty(t, actions[0].ActionTS)
+	assert.JSONEq(t, `{"type":"message","message_ts":"1700000000.000100","channel_id":"C1","is_ephemeral":false}`, calls[0].Get("container"))
+
+	_, err = s.triggerBlockAction(ctx, target, "Maybe")
+	assert.ErrorIs(t, err, errBlockActionNotFound)
+
+	s.WebAPI = nil
+	_, err = s.triggerBlockAction(ctx, target, "Approve")
+	assert.ErrorIs(t, err, errBlockActionsNeedRealUser)
+}
+
+func TestHandleBlockActionReply(t *testing.T) {
+	srv, s := newBlockActionTestServer(t)
+	ctx := context.Background()
+	target := &database.Message{
+		ID:       slackid.MakeMessageID("T1", "C1", "1700000000.000100"),
+		Metadata: &slackid.MessageMetadata{HasBlockActions: true},
+	}
+	makeMsg := func(body string, replyTo *database.Message) *bridgev2.MatrixMessage {
+		msg := &bridgev2.MatrixMessage{}
+		msg.Event = &event.Event{ID: "$reply", Timestamp: 1700000000000}
+		msg.Content = &event.MessageEventContent{MsgType: event.MsgText, Body: body}
+		msg.ReplyTo = replyTo
+		return msg
+	}
+
+	resp, handled, err := s.handleBlockActionReply(ctx, makeMsg("> <@bot:example.com> Approve?\n\napprove", target))
+	require.NoError(t, err)
+	require.True(t, handled)
+	assert.Equal(t, slackid.MakeBlockActionID(target.ID, "$reply"), resp.DB.ID)
+	assert.Empty(t, resp.DB.PartID)
+	assert.Len(t, srv.Calls("blocks.actions"), 1)
+
+	_, handled, err = s.handleBlockActionReply(ctx, makeMsg("thanks!", target))
+	require.NoError(t, err)
+	assert.False(t, handled)
+
+	_, handled, _ = s.handleBlockActionReply(ctx, makeMsg("Approve", &database.Message{ID: target.ID, Metadata: &slackid.MessageMetadata{}}))
+	assert.False(t, handled)
+	assert.Len(t, srv.Calls("blocks.actions"), 1)
+	assert.Len(t, srv.Calls("conversations.history"), 2)
+}
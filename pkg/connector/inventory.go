@@ -0,0 +1,196 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// InventoryRefreshInterval is how often the inventory gauges exposed by ServeMetrics are recounted from the database.
+const InventoryRefreshInterval = 5 * time.Minute
+
+// TeamInventory contains the number of bridged entities of a single Slack team.
+type TeamInventory struct {
+	Portals      map[string]int
+	Ghosts       int
+	Logins       int
+	ActiveLogins int
+	Emojis       int
+}
+
+const (
+	getLoginIDsQuery          = `SELECT id FROM user_login WHERE bridge_id=$1`
+	getTeamPortalIDsQuery     = `SELECT id FROM portal WHERE bridge_id=$1 AND id NOT LIKE '%-%'`
+	countTeamPortalTypesQuery = `
+		SELECT room_type, COUNT(*) FROM portal
+		WHERE bridge_id=$1 AND (id=$2 OR id LIKE $3) AND mxid IS NOT NULL
+		GROUP BY room_type
+	`
+)
+
+var scanLoginID = dbutil.ConvertRowFn[networkid.UserLoginID](dbutil.ScanSingleColumn[networkid.UserLoginID])
+var scanPortalID = dbutil.ConvertRowFn[networkid.PortalID](dbutil.ScanSingleColumn[networkid.PortalID])
+
+func portalTypeLabel(roomType database.RoomType) string {
+	switch roomType {
+	case database.RoomTypeDefault:
+		return "channel"
+	case database.RoomTypeGroupDM:
+		return "group_dm"
+	default:
+		return string(roomType)
+	}
+}
+
+// CollectInventory counts the portals, ghosts, logins and cached emojis of every team that has a login or a space.
+func (s *SlackConnector) CollectInventory(ctx context.Context) (map[string]*TeamInventory, error) {
+	inventory := make(map[string]*TeamInventory)
+	getTeam := func(teamID string) *TeamInventory {
+		team, ok := inventory[teamID]
+		if !ok {
+			team = &TeamInventory{Portals: make(map[string]int)}
+			inventory[teamID] = team
+		}
+		return team
+	}
+	loginIDs, err := scanLoginID.NewRowIter(s.br.DB.Query(ctx, getLoginIDsQuery, s.br.ID)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logins: %w", err)
+	}
+	for _, loginID := range loginIDs {
+		teamID, _ := slackid.ParseUserLoginID(loginID)
+		team := getTeam(teamID)
+		team.Logins++
+		if login := s.br.GetCachedUserLoginByID(loginID); login != nil {
+			if client, ok := login.Client.(*SlackClient); ok && client.IsLoggedIn() {
+				team.ActiveLogins++
+			}
+		}
+	}
+	teamPortalIDs, err := scanPortalID.NewRowIter(s.br.DB.Query(ctx, getTeamPortalIDsQuery, s.br.ID)).AsList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get team portals: %w", err)
+	}
+	for _, portalID := range teamPortalIDs {
+		teamID, _ := slackid.ParsePortalID(portalID)
+		getTeam(teamID)
+	}
+	for teamID, team := range inventory {
+		rows, err := s.br.DB.Query(ctx, countTeamPortalTypesQuery, s.br.ID, teamID, teamID+"-%")
+		if err != nil {
+			return nil, fmt.Errorf("failed to count portals of %s: %w", teamID, err)
+		}
+		for rows.Next() {
+			var roomType database.RoomType
+			var count int
+			if err = rows.Scan(&roomType, &count); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("failed to scan portal count: %w", err)
+			}
+			team.Portals[portalTypeLabel(roomType)] = count
+		}
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to count portals of %s: %w", teamID, err)
+		} else if team.Ghosts, err = s.countRows(ctx, countTeamGhostsQuery, strings.ToLower(teamID)+"-%"); err != nil {
+			return nil, fmt.Errorf("failed to count ghosts of %s: %w", teamID, err)
+		} else if team.Emojis, err = s.DB.Emoji.GetEmojiCount(ctx, teamID); err != nil {
+			return nil, fmt.Errorf("failed to count emojis of %s: %w", teamID, err)
+		}
+	}
+	return inventory, nil
+}
+
+func (s *SlackConnector) refreshInventory(ctx context.Context) {
+	inventory, err := s.CollectInventory(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to collect inventory")
+		return
+	}
+	s.inventoryLock.Lock()
+	s.inventory = inventory
+	s.inventoryLock.Unlock()
+}
+
+func (s *SlackConnector) runInventoryRefreshLoop(ctx context.Context) {
+	log := s.br.Log.With().Str("action", "refresh inventory").Logger()
+	ctx = log.WithContext(ctx)
+	ticker := time.NewTicker(InventoryRefreshInterval)
+	defer ticker.Stop()
+	s.refreshInventory(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			s.refreshInventory(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ServeMetrics exposes the inventory gauges and Slack API rate limit budgets in the Prometheus text format.
+// The inventory values are refreshed every InventoryRefreshInterval rather than on every scrape.
+func (s *SlackConnector) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	s.inventoryLock.RLock()
+	inventory := s.inventory
+	s.inventoryLock.RUnlock()
+	if inventory == nil {
+		http.Error(w, "Inventory hasn't been collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	writeInventoryMetrics(w, inventory)
+	writeRateLimitMetrics(w, s.rateLimits.snapshot())
+}
+
+func writeInventoryMetrics(w io.Writer, inventory map[string]*TeamInventory) {
+	teamIDs := slices.Sorted(maps.Keys(inventory))
+	writeGauge := func(name, help string, value func(teamID string, team *TeamInventory)) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, teamID := range teamIDs {
+			value(teamID, inventory[teamID])
+		}
+	}
+	writeGauge("slack_bridge_portals", "Number of portal rooms by type.", func(teamID string, team *TeamInventory) {
+		for _, portalType := range slices.Sorted(maps.Keys(team.Portals)) {
+			_, _ = fmt.Fprintf(w, "slack_bridge_portals{team_id=%q,type=%q} %d\n", teamID, portalType, team.Portals[portalType])
+		}
+	})
+	simpleGauge := func(name, help string, get func(team *TeamInventory) int) {
+		writeGauge(name, help, func(teamID string, team *TeamInventory) {
+			_, _ = fmt.Fprintf(w, "%s{team_id=%q} %d\n", name, teamID, get(team))
+		})
+	}
+	simpleGauge("slack_bridge_ghosts", "Number of ghost users.", func(team *TeamInventory) int { return team.Ghosts })
+	simpleGauge("slack_bridge_logins", "Number of logins.", func(team *TeamInventory) int { return team.Logins })
+	simpleGauge("slack_bridge_active_logins", "Number of logins that are currently connected.", func(team *TeamInventory) int { return team.ActiveLogins })
+	simpleGauge("slack_bridge_emojis", "Number of cached custom emojis.", func(team *TeamInventory) int { return team.Emojis })
+}
@@ -0,0 +1,63 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteInventoryMetrics(t *testing.T) {
+	var out strings.Builder
+	writeInventoryMetrics(&out, map[string]*TeamInventory{
+		"T2": {Portals: map[string]int{}, Logins: 1},
+		"T1": {Portals: map[string]int{"dm": 2, "channel": 5}, Ghosts: 10, Logins: 2, ActiveLogins: 1, Emojis: 3},
+	})
+	expected := `# HELP slack_bridge_portals Number of portal rooms by type.
+# TYPE slack_bridge_portals gauge
+slack_bridge_portals{team_id="T1",type="channel"} 5
+slack_bridge_portals{team_id="T1",type="dm"} 2
+# HELP slack_bridge_ghosts Number of ghost users.
+# TYPE slack_bridge_ghosts gauge
+slack_bridge_ghosts{team_id="T1"} 10
+slack_bridge_ghosts{team_id="T2"} 0
+# HELP slack_bridge_logins Number of logins.
+# TYPE slack_bridge_logins gauge
+slack_bridge_logins{team_id="T1"} 2
+slack_bridge_logins{team_id="T2"} 1
+# HELP slack_bridge_active_logins Number of logins that are currently connected.
+# TYPE slack_bridge_active_logins gauge
+slack_bridge_active_logins{team_id="T1"} 1
+slack_bridge_active_logins{team_id="T2"} 0
+# HELP slack_bridge_emojis Number of cached custom emojis.
+# TYPE slack_bridge_emojis gauge
+slack_bridge_emojis{team_id="T1"} 3
+slack_bridge_emojis{team_id="T2"} 0
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestServeMetrics_NotCollected(t *testing.T) {
+	s := &SlackConnector{}
+	rec := httptest.NewRecorder()
+	s.ServeMetrics(rec, httptest.NewRequest(http.MethodGet, "/_slack/metrics", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
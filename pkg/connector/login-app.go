@@ -23,36 +23,73 @@ import (
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
 
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
 const LoginFlowIDApp = "app"
+const LoginFlowIDMirror = "mirror"
+const LoginFlowIDHybrid = "hybrid"
 const LoginStepIDAppToken = "fi.mau.slack.login.enter_app_tokens"
 
 type SlackAppLogin struct {
 	User *bridgev2.User
+	// ReadOnly creates a mirror login, which only bridges Slack messages to Matrix.
+	ReadOnly bool
+	// Hybrid additionally asks for a user token, which is used for actions that bots can't perform.
+	Hybrid bool
+	// Override is the existing login being re-authenticated with the relogin command
+	Override *bridgev2.UserLogin
 }
 
-var _ bridgev2.LoginProcessUserInput = (*SlackAppLogin)(nil)
+var (
+	_ bridgev2.LoginProcessUserInput    = (*SlackAppLogin)(nil)
+	_ bridgev2.LoginProcessWithOverride = (*SlackAppLogin)(nil)
+)
+
+func (s *SlackAppLogin) StartWithOverride(ctx context.Context, override *bridgev2.UserLogin) (*bridgev2.LoginStep, error) {
+	s.Override = override
+	return s.Start(ctx)
+}
 
 func (s *SlackAppLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
+	tokenField := bridgev2.LoginInputDataField{
+		Type:        bridgev2.LoginInputFieldTypeToken,
+		ID:          "bot_token",
+		Name:        "Bot token",
+		Description: "Slack bot token for the workspace (starts with `xoxb-`)",
+		Pattern:     "^xoxb-.+$",
+	}
+	var instructions string
+	if s.ReadOnly {
+		tokenField.Name = "Bot or user token"
+		tokenField.Description = "Slack bot token or OAuth user token with read access (starts with `xoxb-` or `xoxp-`)"
+		tokenField.Pattern = "^xox[bp]-.+$"
+		instructions = "Messages from Slack will be bridged to Matrix, but nothing sent on Matrix will be bridged to Slack."
+	}
+	fields := []bridgev2.LoginInputDataField{tokenField, {
+		Type:        bridgev2.LoginInputFieldTypeToken,
+		ID:          "app_token",
+		Name:        "App token",
+		Description: "Slack app-level token (starts with `xapp-`)",
+		Pattern:     "^xapp-.+$",
+	}}
+	if s.Hybrid {
+		fields = append(fields, bridgev2.LoginInputDataField{
+			Type:        bridgev2.LoginInputFieldTypeToken,
+			ID:          "user_token",
+			Name:        "User token",
+			Description: "Slack OAuth user token of the same app (starts with `xoxp-`)",
+			Pattern:     "^xoxp-.+$",
+		})
+		instructions = "Events will be received through the app, while read receipts and user search use the user token."
+	}
 	return &bridgev2.LoginStep{
-		Type:   bridgev2.LoginStepTypeUserInput,
-		StepID: LoginStepIDAppToken,
+		Type:         bridgev2.LoginStepTypeUserInput,
+		StepID:       LoginStepIDAppToken,
+		Instructions: instructions,
 		UserInputParams: &bridgev2.LoginUserInputParams{
-			Fields: []bridgev2.LoginInputDataField{{
-				Type:        bridgev2.LoginInputFieldTypeToken,
-				ID:          "bot_token",
-				Name:        "Bot token",
-				Description: "Slack bot token for the workspace (starts with `xoxb-`)",
-				Pattern:     "^xoxb-.+$",
-			}, {
-				Type:        bridgev2.LoginInputFieldTypeToken,
-				ID:          "app_token",
-				Name:        "App token",
-				Description: "Slack app-level token (starts with `xapp-`)",
-				Pattern:     "^xapp-.+$",
-			}},
+			Fields: fields,
 		},
 	}, nil
 }
@@ -61,17 +98,35 @@ func (s *SlackAppLogin) Cancel() {}
 
 func (s *SlackAppLogin) SubmitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
 	token, appToken := input["bot_token"], input["app_token"]
-	client := makeSlackClient(&s.User.Log, token, "", appToken)
+	rateLimitOption := s.User.Bridge.Network.(*SlackConnector).rateLimits.HTTPClientOption(RateLimitLoginTeamID, &s.User.Log)
+	client := makeSlackClient(&s.User.Log, token, "", appToken, rateLimitOption)
 	info, err := client.AuthTestContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("auth.test failed: %w", err)
 	}
+	var userToken string
+	if s.Hybrid {
+		userToken = input["user_token"]
+		userInfo, err := makeSlackClient(&s.User.Log, userToken, "", "", rateLimitOption).AuthTestContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("auth.test with user token failed: %w", err)
+		} else if userInfo.TeamID != info.TeamID {
+			return nil, fmt.Errorf("user token is for a different workspace (%s) than the bot token (%s)", userInfo.Team, info.Team)
+		}
+	}
+	loginID := slackid.MakeUserLoginID(info.TeamID, info.UserID)
+	if err = checkReloginTarget(s.Override, loginID); err != nil {
+		return nil, err
+	}
+	oldClient := replacedClient(s.User, loginID)
 	ul, err := s.User.NewLogin(ctx, &database.UserLogin{
-		ID:         slackid.MakeUserLoginID(info.TeamID, info.UserID),
+		ID:         loginID,
 		RemoteName: fmt.Sprintf("%s - %s", info.Team, info.User),
 		Metadata: &slackid.UserLoginMetadata{
-			Token:    token,
-			AppToken: appToken,
+			Token:     token,
+			AppToken:  appToken,
+			UserToken: userToken,
+			ReadOnly:  s.ReadOnly,
 		},
 	}, &bridgev2.NewLoginParams{
 		DeleteOnConflict:  true,
@@ -80,12 +135,20 @@ func (s *SlackAppLogin) SubmitUserInput(ctx context.Context, input map[string]st
 	if err != nil {
 		return nil, err
 	}
+	disconnectReplacedClient(oldClient)
 	sc := ul.Client.(*SlackClient)
+	sc.auditLog(ctx, slackdb.AuditActionLogin, "", "", "")
 	go sc.Connect(ul.Log.WithContext(context.Background()))
+	instructions := fmt.Sprintf("Successfully logged into %s as %s", info.Team, info.User)
+	if s.ReadOnly {
+		instructions += " (read-only mirror)"
+	} else if s.Hybrid {
+		instructions += " (with user token)"
+	}
 	return &bridgev2.LoginStep{
 		Type:         bridgev2.LoginStepTypeComplete,
 		StepID:       LoginStepIDComplete,
-		Instructions: fmt.Sprintf("Successfully logged into %s as %s", info.Team, info.User),
+		Instructions: instructions,
 		CompleteParams: &bridgev2.LoginCompleteParams{
 			UserLoginID: ul.ID,
 			UserLogin:   ul,
@@ -18,20 +18,28 @@ package connector
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
 
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
 const LoginFlowIDAuthToken = "token"
 const LoginStepIDAuthToken = "fi.mau.slack.login.enter_auth_token"
+const LoginStepIDConfirm = "fi.mau.slack.login.confirm"
 const LoginStepIDComplete = "fi.mau.slack.login.complete"
 
+var errLoginAborted = errors.New("login aborted")
+
 func (s *SlackConnector) GetLoginFlows() []bridgev2.LoginFlow {
 	return []bridgev2.LoginFlow{{
 		Name:        "Auth token & cookie",
@@ -41,6 +49,14 @@ func (s *SlackConnector) GetLoginFlows() []bridgev2.LoginFlow {
 		Name:        "Slack app",
 		Description: "Log in with a Slack app",
 		ID:          LoginFlowIDApp,
+	}, {
+		Name:        "Read-only mirror",
+		Description: "Mirror a workspace to Matrix with a Slack app, without bridging anything back to Slack",
+		ID:          LoginFlowIDMirror,
+	}, {
+		Name:        "Slack app with user token",
+		Description: "Log in with a Slack app for receiving events, plus a user token for read receipts and user search",
+		ID:          LoginFlowIDHybrid,
 	}}
 }
 
@@ -54,6 +70,16 @@ func (s *SlackConnector) CreateLogin(ctx context.Context, user *bridgev2.User, f
 		return &SlackAppLogin{
 			User: user,
 		}, nil
+	case LoginFlowIDMirror:
+		return &SlackAppLogin{
+			User:     user,
+			ReadOnly: true,
+		}, nil
+	case LoginFlowIDHybrid:
+		return &SlackAppLogin{
+			User:   user,
+			Hybrid: true,
+		}, nil
 	default:
 		return nil, fmt.Errorf("unknown login flow %s", flowID)
 	}
@@ -61,9 +87,19 @@ func (s *SlackConnector) CreateLogin(ctx context.Context, user *bridgev2.User, f
 
 type SlackTokenLogin struct {
 	User *bridgev2.User
+	// Override is the existing login being re-authenticated with the relogin command
+	Override *bridgev2.UserLogin
+
+	token       string
+	cookieToken string
+	bootResp    *slack.ClientUserBootResponse
 }
 
-var _ bridgev2.LoginProcessCookies = (*SlackTokenLogin)(nil)
+var (
+	_ bridgev2.LoginProcessCookies      = (*SlackTokenLogin)(nil)
+	_ bridgev2.LoginProcessUserInput    = (*SlackTokenLogin)(nil)
+	_ bridgev2.LoginProcessWithOverride = (*SlackTokenLogin)(nil)
+)
 
 const ExtractSlackTokenJS = `
 new Promise(resolve => {
@@ -124,28 +160,104 @@ func (s *SlackTokenLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error
 	}, nil
 }
 
+func (s *SlackTokenLogin) StartWithOverride(ctx context.Context, override *bridgev2.UserLogin) (*bridgev2.LoginStep, error) {
+	s.Override = override
+	return s.Start(ctx)
+}
+
 func (s *SlackTokenLogin) Cancel() {}
 
 func (s *SlackTokenLogin) SubmitCookies(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
 	token, cookieToken := input["auth_token"], input["cookie_token"]
-	client := makeSlackClient(&s.User.Log, token, cookieToken, "")
+	rateLimitOption := s.User.Bridge.Network.(*SlackConnector).rateLimits.HTTPClientOption(RateLimitLoginTeamID, &s.User.Log)
+	client := makeSlackClient(&s.User.Log, token, cookieToken, "", rateLimitOption)
 	err := client.FetchVersionData(ctx)
 	if err != nil {
 		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to fetch version data")
 		return nil, err
 	}
+	authInfo, err := client.AuthTestContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("auth.test failed: %w", err)
+	}
 	info, err := client.ClientUserBootContext(ctx, time.Time{})
 	if err != nil {
 		return nil, fmt.Errorf("client.boot failed: %w", err)
+	} else if authInfo.TeamID != info.Team.ID || authInfo.UserID != info.Self.ID {
+		return nil, fmt.Errorf("auth.test and client.boot returned different users (%s/%s and %s/%s)", authInfo.TeamID, authInfo.UserID, info.Team.ID, info.Self.ID)
+	}
+	loginID := slackid.MakeUserLoginID(info.Team.ID, info.Self.ID)
+	if err = checkReloginTarget(s.Override, loginID); err != nil {
+		return nil, err
+	}
+	s.token, s.cookieToken, s.bootResp = token, cookieToken, info
+	conversationCount := s.User.Bridge.Network.(*SlackConnector).currentConfig().Backfill.ConversationCount
+	return &bridgev2.LoginStep{
+		Type:         bridgev2.LoginStepTypeUserInput,
+		StepID:       LoginStepIDConfirm,
+		Instructions: formatLoginPreview(info, conversationCount, slices.Contains(s.User.GetUserLoginIDs(), loginID)),
+		UserInputParams: &bridgev2.LoginUserInputParams{
+			Fields: []bridgev2.LoginInputDataField{{
+				Type:        bridgev2.LoginInputFieldTypeUsername,
+				ID:          "confirm",
+				Name:        "Confirm",
+				Description: "Type `yes` to start bridging or `no` to abort",
+				Pattern:     "^(?i)(yes|no)$",
+			}},
+		},
+	}, nil
+}
+
+// formatLoginPreview describes the workspace and user of a login and how many conversations will be synced,
+// so that the user can check they're bridging the right account before any portals are created.
+func formatLoginPreview(info *slack.ClientUserBootResponse, conversationCount int, replacesExisting bool) string {
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, "You're about to bridge the following Slack account:\n\n")
+	_, _ = fmt.Fprintf(&out, "* Workspace: %s (`%s`)", info.Team.Name, info.Team.ID)
+	if info.Team.Domain != "" {
+		_, _ = fmt.Fprintf(&out, ", %s.slack.com", info.Team.Domain)
+	}
+	name := info.Self.RealName
+	if name == "" {
+		name = info.Self.Name
+	}
+	_, _ = fmt.Fprintf(&out, "\n* User: %s (`%s`)", name, info.Self.ID)
+	if info.Self.Profile.Email != "" {
+		_, _ = fmt.Fprintf(&out, ", %s", info.Self.Profile.Email)
+	}
+	if conversationCount < 0 {
+		_, _ = fmt.Fprintf(&out, "\n* Conversations to sync: %d channels and %d direct messages", len(info.Channels), len(info.IMs))
+	} else {
+		_, _ = fmt.Fprintf(&out, "\n* Conversations to sync: up to %d of the most recent ones", conversationCount)
+	}
+	if replacesExisting {
+		out.WriteString("\n\nYou're already logged into this account, so the tokens of the existing login will be updated and all bridged rooms will be kept.")
+	}
+	return out.String()
+}
+
+func (s *SlackTokenLogin) SubmitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
+	if s.bootResp == nil {
+		return nil, fmt.Errorf("unexpected login step")
+	} else if !strings.EqualFold(strings.TrimSpace(input["confirm"]), "yes") {
+		return nil, errLoginAborted
+	}
+	info := s.bootResp
+	loginID := slackid.MakeUserLoginID(info.Team.ID, info.Self.ID)
+	meta := &slackid.UserLoginMetadata{
+		Email:       info.Self.Profile.Email,
+		Token:       s.token,
+		CookieToken: s.cookieToken,
+	}
+	oldClient := replacedClient(s.User, loginID)
+	if existing := existingLogin(s.User, loginID); existing != nil {
+		// This flow doesn't ask for a login mode, so a relogin keeps the existing one
+		meta.ReadOnly = existing.Metadata.(*slackid.UserLoginMetadata).ReadOnly
 	}
 	ul, err := s.User.NewLogin(ctx, &database.UserLogin{
-		ID:         slackid.MakeUserLoginID(info.Team.ID, info.Self.ID),
+		ID:         loginID,
 		RemoteName: fmt.Sprintf("%s - %s", info.Team.Name, info.Self.Profile.Email),
-		Metadata: &slackid.UserLoginMetadata{
-			Email:       info.Self.Profile.Email,
-			Token:       token,
-			CookieToken: cookieToken,
-		},
+		Metadata:   meta,
 	}, &bridgev2.NewLoginParams{
 		DeleteOnConflict:  true,
 		DontReuseExisting: false,
@@ -153,7 +265,9 @@ func (s *SlackTokenLogin) SubmitCookies(ctx context.Context, input map[string]st
 	if err != nil {
 		return nil, err
 	}
+	disconnectReplacedClient(oldClient)
 	sc := ul.Client.(*SlackClient)
+	sc.auditLog(ctx, slackdb.AuditActionLogin, "", "", "")
 	err = sc.connect(ul.Log.WithContext(context.Background()), info)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect after login: %w", err)
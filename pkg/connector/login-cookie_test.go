@@ -0,0 +1,65 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+)
+
+func TestFormatLoginPreview(t *testing.T) {
+	info := &slack.ClientUserBootResponse{
+		Self:     slack.User{ID: "U1", Name: "alice", RealName: "Alice", Profile: slack.UserProfile{Email: "alice@example.com"}},
+		Channels: make([]slack.BootChannel, 3),
+		IMs:      make([]slack.BootChannel, 2),
+	}
+	info.Team.ID = "T1"
+	info.Team.Name = "Example"
+	info.Team.Domain = "example"
+
+	preview := formatLoginPreview(info, -1, false)
+	assert.Contains(t, preview, "Workspace: Example (`T1`), example.slack.com")
+	assert.Contains(t, preview, "User: Alice (`U1`), alice@example.com")
+	assert.Contains(t, preview, "3 channels and 2 direct messages")
+	assert.NotContains(t, preview, "already logged in")
+
+	preview = formatLoginPreview(info, 50, true)
+	assert.Contains(t, preview, "up to 50 of the most recent ones")
+	assert.Contains(t, preview, "all bridged rooms will be kept")
+}
+
+func TestSlackTokenLogin_Abort(t *testing.T) {
+	login := &SlackTokenLogin{bootResp: &slack.ClientUserBootResponse{}}
+	_, err := login.SubmitUserInput(context.Background(), map[string]string{"confirm": "no"})
+	assert.ErrorIs(t, err, errLoginAborted)
+
+	_, err = (&SlackTokenLogin{}).SubmitUserInput(context.Background(), map[string]string{"confirm": "yes"})
+	require.Error(t, err)
+}
+
+func TestCheckReloginTarget(t *testing.T) {
+	override := &bridgev2.UserLogin{UserLogin: &database.UserLogin{ID: "T1-U1"}}
+	assert.NoError(t, checkReloginTarget(nil, "T2-U2"))
+	assert.NoError(t, checkReloginTarget(override, "T1-U1"))
+	assert.ErrorIs(t, checkReloginTarget(override, "T1-U2"), errReloginMismatch)
+}
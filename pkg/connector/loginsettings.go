@@ -0,0 +1,197 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+const (
+	// DMPolicyAll bridges all DMs and group DMs (the default).
+	DMPolicyAll = "all"
+	// DMPolicyExisting only bridges DMs that already have a portal room, new DMs are ignored.
+	DMPolicyExisting = "existing"
+)
+
+const (
+	LeaveBehaviorNothing = "nothing"
+	LeaveBehaviorLeave   = "leave"
+	LeaveBehaviorArchive = "archive"
+)
+
+func (s *SlackClient) settings() *slackid.LoginSettings {
+	return &s.UserLogin.Metadata.(*slackid.UserLoginMetadata).Settings
+}
+
+func (s *SlackClient) conversationCount() int {
+	if count := s.settings().ConversationCount; count != nil {
+		return *count
+	}
+	return s.Main.currentConfig().Backfill.ConversationCount
+}
+
+func (s *SlackClient) dmPolicy() string {
+	if policy := s.settings().DMPolicy; policy != "" {
+		return policy
+	}
+	return DMPolicyAll
+}
+
+// shouldCreatePortal checks whether an incoming message in the given channel may create a new portal room.
+func (s *SlackClient) shouldCreatePortal(ctx context.Context, channelID string) bool {
+	if s.dmPolicy() == DMPolicyAll {
+		return true
+	}
+	info, err := s.fetchChatInfoWithCache(ctx, channelID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("channel_id", channelID).Msg("Failed to fetch channel info to check DM policy")
+		return true
+	}
+	return !info.IsIM && !info.IsMpIM
+}
+
+func (s *SlackClient) leaveBehavior() string {
+	if behavior := s.settings().LeaveBehavior; behavior != "" {
+		return behavior
+	} else if s.Main.currentConfig().LeavePortalBehavior != "" {
+		return s.Main.currentConfig().LeavePortalBehavior
+	}
+	return LeaveBehaviorNothing
+}
+
+func (s *SlackClient) bridgeTyping() bool {
+	if val := s.settings().BridgeTyping; val != nil {
+		return *val
+	}
+	return true
+}
+
+func (s *SlackClient) bridgeReceipts() bool {
+	if val := s.settings().BridgeReceipts; val != nil {
+		return *val
+	}
+	return true
+}
+
+type loginSetting struct {
+	Name        string
+	Description string
+	Get         func(*SlackClient) string
+	// Set parses and stores the given value. An empty value resets the setting to the default.
+	Set func(settings *slackid.LoginSettings, value string) error
+}
+
+func parseOptionalBool(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boolean %q", value)
+	}
+	return &parsed, nil
+}
+
+var loginSettings = []loginSetting{{
+	Name:        "conversation_count",
+	Description: "Number of conversations to sync on startup (-1 for all)",
+	Get: func(s *SlackClient) string {
+		return strconv.Itoa(s.conversationCount())
+	},
+	Set: func(settings *slackid.LoginSettings, value string) error {
+		if value == "" {
+			settings.ConversationCount = nil
+			return nil
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count < -1 {
+			return fmt.Errorf("invalid conversation count %q", value)
+		}
+		settings.ConversationCount = &count
+		return nil
+	},
+}, {
+	Name:        "dm_policy",
+	Description: "Which DMs to bridge: `all` or only `existing` portals",
+	Get: func(s *SlackClient) string {
+		return s.dmPolicy()
+	},
+	Set: func(settings *slackid.LoginSettings, value string) error {
+		if value != "" && !slices.Contains([]string{DMPolicyAll, DMPolicyExisting}, value) {
+			return fmt.Errorf("invalid DM policy %q", value)
+		}
+		settings.DMPolicy = value
+		return nil
+	},
+}, {
+	Name:        "bridge_typing",
+	Description: "Whether typing notifications are bridged",
+	Get: func(s *SlackClient) string {
+		return strconv.FormatBool(s.bridgeTyping())
+	},
+	Set: func(settings *slackid.LoginSettings, value string) error {
+		parsed, err := parseOptionalBool(value)
+		if err != nil {
+			return err
+		}
+		settings.BridgeTyping = parsed
+		return nil
+	},
+}, {
+	Name:        "bridge_receipts",
+	Description: "Whether read receipts are bridged",
+	Get: func(s *SlackClient) string {
+		return strconv.FormatBool(s.bridgeReceipts())
+	},
+	Set: func(settings *slackid.LoginSettings, value string) error {
+		parsed, err := parseOptionalBool(value)
+		if err != nil {
+			return err
+		}
+		settings.BridgeReceipts = parsed
+		return nil
+	},
+}, {
+	Name:        "leave_behavior",
+	Description: "What to do when leaving a portal room: `nothing`, `leave` the Slack channel or `archive` the portal",
+	Get: func(s *SlackClient) string {
+		return s.leaveBehavior()
+	},
+	Set: func(settings *slackid.LoginSettings, value string) error {
+		if value != "" && !slices.Contains([]string{LeaveBehaviorNothing, LeaveBehaviorLeave, LeaveBehaviorArchive}, value) {
+			return fmt.Errorf("invalid leave behavior %q", value)
+		}
+		settings.LeaveBehavior = value
+		return nil
+	},
+}}
+
+func getLoginSetting(name string) *loginSetting {
+	for i := range loginSettings {
+		if loginSettings[i].Name == name {
+			return &loginSettings[i]
+		}
+	}
+	return nil
+}
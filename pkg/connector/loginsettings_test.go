@@ -0,0 +1,80 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestLoginSettings(t *testing.T) {
+	meta := &slackid.UserLoginMetadata{}
+	s := &SlackClient{
+		Main:      &SlackConnector{Config: Config{Backfill: BackfillConfig{ConversationCount: 50}}},
+		UserLogin: &bridgev2.UserLogin{UserLogin: &database.UserLogin{Metadata: meta}},
+	}
+	assert.Equal(t, 50, s.conversationCount())
+	assert.Equal(t, DMPolicyAll, s.dmPolicy())
+	assert.True(t, s.bridgeTyping())
+	assert.True(t, s.bridgeReceipts())
+	assert.Equal(t, LeaveBehaviorNothing, s.leaveBehavior())
+
+	type testCase struct {
+		setting  string
+		value    string
+		expected string
+		err      bool
+	}
+	testCases := []testCase{
+		{"conversation_count", "10", "10", false},
+		{"conversation_count", "-1", "-1", false},
+		{"conversation_count", "-2", "-1", true},
+		{"conversation_count", "many", "-1", true},
+		{"conversation_count", "", "50", false},
+		{"dm_policy", "existing", "existing", false},
+		{"dm_policy", "none", "existing", true},
+		{"dm_policy", "", "all", false},
+		{"bridge_typing", "false", "false", false},
+		{"bridge_typing", "maybe", "false", true},
+		{"bridge_typing", "", "true", false},
+		{"bridge_receipts", "0", "false", false},
+		{"leave_behavior", "leave", "leave", false},
+		{"leave_behavior", "delete", "leave", true},
+		{"leave_behavior", "", "nothing", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.setting+"="+tc.value, func(t *testing.T) {
+			setting := getLoginSetting(tc.setting)
+			require.NotNil(t, setting)
+			err := setting.Set(&meta.Settings, tc.value)
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, setting.Get(s))
+		})
+	}
+	assert.False(t, s.bridgeReceipts())
+	assert.Nil(t, getLoginSetting("invalid"))
+}
@@ -0,0 +1,232 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/emoji"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var cmdLookup = &commands.FullHandler{
+	Func: fnLookup,
+	Name: "lookup",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionGeneral,
+		Description: "Show the Slack IDs, Matrix ghost or room and cached metadata of a user, channel or emoji.",
+		Args:        "<_@user_ | _#channel_ | _:emoji:_>",
+	},
+	RequiresLogin: true,
+}
+
+type lookupKind int
+
+const (
+	lookupInvalid lookupKind = iota
+	lookupSlackUser
+	lookupMatrixUser
+	lookupSlackChannel
+	lookupMatrixRoom
+	lookupEmoji
+)
+
+// parseLookupTarget parses the argument of the lookup command. Slack user and channel IDs may be given as-is
+// (@U123, #C123) or in Slack's mention syntax (<@U123>, <#C123|name>), Matrix users and rooms by their IDs.
+func parseLookupTarget(arg string) (lookupKind, string) {
+	if strings.HasPrefix(arg, "<") && strings.HasSuffix(arg, ">") {
+		arg, _, _ = strings.Cut(arg[1:len(arg)-1], "|")
+	}
+	switch {
+	case len(arg) > 2 && strings.HasPrefix(arg, ":") && strings.HasSuffix(arg, ":"):
+		return lookupEmoji, arg[1 : len(arg)-1]
+	case len(arg) < 2:
+		return lookupInvalid, ""
+	case arg[0] == '@' && strings.ContainsRune(arg, ':'):
+		return lookupMatrixUser, arg
+	case arg[0] == '@':
+		return lookupSlackUser, strings.ToUpper(arg[1:])
+	case arg[0] == '!' && strings.ContainsRune(arg, ':'):
+		return lookupMatrixRoom, arg
+	case arg[0] == '#' && !strings.ContainsRune(arg, ':'):
+		return lookupSlackChannel, strings.ToUpper(arg[1:])
+	default:
+		return lookupInvalid, ""
+	}
+}
+
+// lookupLogin returns the login to use for lookups without an explicit team, preferring the current portal's team.
+func lookupLogin(ce *commands.Event) *SlackClient {
+	if ce.Portal != nil {
+		if client := portalLogin(ce); client != nil {
+			return client
+		}
+	}
+	for _, login := range ce.User.GetUserLogins() {
+		if client, ok := login.Client.(*SlackClient); ok && client.IsLoggedIn() {
+			return client
+		}
+	}
+	return nil
+}
+
+func fnLookup(ce *commands.Event) {
+	if len(ce.Args) != 1 {
+		ce.Reply("Usage: `$cmdprefix lookup <@user|#channel|:emoji:>`")
+		return
+	}
+	kind, value := parseLookupTarget(ce.Args[0])
+	var client *SlackClient
+	switch kind {
+	case lookupMatrixUser:
+		ghostID, ok := ce.Bridge.Matrix.ParseGhostMXID(id.UserID(value))
+		if !ok {
+			ce.Reply("`%s` is not a Slack user", value)
+			return
+		}
+		var teamID string
+		teamID, value = slackid.ParseUserID(ghostID)
+		client = findLoginInTeam(ce.User, teamID)
+		kind = lookupSlackUser
+	case lookupMatrixRoom:
+		portal, err := ce.Bridge.GetPortalByMXID(ce.Ctx, id.RoomID(value))
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to get portal")
+			ce.Reply("Failed to get portal: %v", err)
+			return
+		} else if portal == nil {
+			ce.Reply("`%s` is not a Slack portal", value)
+			return
+		}
+		var teamID string
+		teamID, value = slackid.ParsePortalID(portal.ID)
+		if value == "" {
+			ce.Reply("`%s` is the space of team `%s`", portal.MXID, teamID)
+			return
+		}
+		client = findLoginInTeam(ce.User, teamID)
+		kind = lookupSlackChannel
+	case lookupInvalid:
+		ce.Reply("Usage: `$cmdprefix lookup <@user|#channel|:emoji:>`")
+		return
+	default:
+		client = lookupLogin(ce)
+	}
+	if client == nil {
+		ce.Reply("You're not logged into the team of that entity")
+		return
+	}
+	switch kind {
+	case lookupSlackUser:
+		lookupUser(ce, client, value)
+	case lookupSlackChannel:
+		lookupChannel(ce, client, value)
+	case lookupEmoji:
+		lookupEmojiShortcode(ce, client, value)
+	}
+}
+
+func lookupUser(ce *commands.Event, client *SlackClient, userID string) {
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, "* Slack user ID: `%s` in team `%s`\n", userID, client.TeamID)
+	info, err := client.Client.GetUserInfoContext(ce.Ctx, userID)
+	if err != nil {
+		ce.Log.Err(err).Str("user_id", userID).Msg("Failed to fetch user info")
+		_, _ = fmt.Fprintf(&out, "* Slack profile: failed to fetch: %v\n", err)
+	} else {
+		_, _ = fmt.Fprintf(&out, "* Slack name: %s (display name: %s)\n", info.RealName, info.Profile.DisplayName)
+		_, _ = fmt.Fprintf(&out, "* Bot: %t, deleted: %t\n", info.IsBot, info.Deleted)
+	}
+	ghostID := slackid.MakeUserID(client.TeamID, userID)
+	ghost, err := ce.Bridge.GetExistingGhostByID(ce.Ctx, ghostID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get ghost")
+		_, _ = fmt.Fprintf(&out, "* Matrix ghost: failed to get: %v\n", err)
+	} else if ghost == nil {
+		_, _ = fmt.Fprintf(&out, "* Matrix ghost: `%s` (not created yet)\n", ce.Bridge.Matrix.GhostIntent(ghostID).GetMXID())
+	} else {
+		meta := ghost.Metadata.(*slackid.GhostMetadata)
+		_, _ = fmt.Fprintf(&out, "* Matrix ghost: [%s](%s) (`%s`)\n", ghost.Name, ghost.Intent.GetMXID().URI().MatrixToURL(), ghost.Intent.GetMXID())
+		_, _ = fmt.Fprintf(&out, "* Profile last synced: %s\n", formatSyncTime(meta.LastSync.Time))
+	}
+	if login := ce.Bridge.GetCachedUserLoginByID(slackid.MakeUserLoginID(client.TeamID, userID)); login != nil {
+		_, _ = fmt.Fprintf(&out, "* Logged into the bridge as `%s`\n", login.UserMXID)
+	}
+	ce.Reply(strings.TrimSpace(out.String()))
+}
+
+func lookupChannel(ce *commands.Event, client *SlackClient, channelID string) {
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, "* Slack channel ID: `%s` in team `%s`\n", channelID, client.TeamID)
+	info, err := client.fetchChatInfoWithCache(ce.Ctx, channelID)
+	if err != nil {
+		ce.Log.Err(err).Str("channel_id", channelID).Msg("Failed to fetch channel info")
+		_, _ = fmt.Fprintf(&out, "* Slack channel: failed to fetch: %v\n", err)
+		ce.Reply(strings.TrimSpace(out.String()))
+		return
+	}
+	if info.Name != "" {
+		_, _ = fmt.Fprintf(&out, "* Slack name: #%s\n", info.Name)
+	}
+	_, _ = fmt.Fprintf(&out, "* Type: %s (private: %t, shared: %t, archived: %t)\n", getChannelType(info), info.IsPrivate, info.IsExtShared, info.IsArchived)
+	portal, err := ce.Bridge.GetExistingPortalByKey(ce.Ctx, client.makePortalKey(info))
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get portal")
+		_, _ = fmt.Fprintf(&out, "* Matrix room: failed to get portal: %v\n", err)
+	} else if portal == nil || portal.MXID == "" {
+		out.WriteString("* Matrix room: not created yet\n")
+	} else {
+		meta := portal.Metadata.(*slackid.PortalMetadata)
+		_, _ = fmt.Fprintf(&out, "* Matrix room: [%s](%s) (`%s`)\n", portal.Name, portal.MXID.URI(ce.Bridge.Matrix.ServerName()).MatrixToURL(), portal.MXID)
+		_, _ = fmt.Fprintf(&out, "* Portal ID: `%s`\n", portal.ID)
+		_, _ = fmt.Fprintf(&out, "* Info last synced: %s\n", formatSyncTime(meta.InfoSyncedAt.Time))
+	}
+	ce.Reply(strings.TrimSpace(out.String()))
+}
+
+func lookupEmojiShortcode(ce *commands.Event, client *SlackClient, shortcode string) {
+	if unicode := emoji.GetUnicode(shortcode); unicode != "" {
+		ce.Reply("* Built-in emoji: %s\n* Canonical shortcode: `:%s:`", unicode, emoji.CanonicalShortcode(shortcode))
+		return
+	}
+	dbEmoji, err := client.Main.DB.Emoji.GetBySlackID(ce.Ctx, client.TeamID, shortcode)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get emoji from database")
+		ce.Reply("Failed to get emoji from database: %v", err)
+		return
+	} else if dbEmoji == nil {
+		ce.Reply("No emoji `:%s:` in team `%s`", shortcode, client.TeamID)
+		return
+	}
+	var out strings.Builder
+	_, _ = fmt.Fprintf(&out, "* Custom emoji: `:%s:` in team `%s`\n", dbEmoji.EmojiID, client.TeamID)
+	if dbEmoji.Alias != "" {
+		_, _ = fmt.Fprintf(&out, "* Alias of: `:%s:`\n", dbEmoji.Alias)
+	} else {
+		_, _ = fmt.Fprintf(&out, "* Slack URL: %s\n", dbEmoji.Value)
+	}
+	if dbEmoji.ImageMXC != "" {
+		_, _ = fmt.Fprintf(&out, "* Matrix URI: `%s`\n", dbEmoji.ImageMXC)
+	} else {
+		out.WriteString("* Matrix URI: not uploaded yet\n")
+	}
+	ce.Reply(strings.TrimSpace(out.String()))
+}
@@ -0,0 +1,51 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLookupTarget(t *testing.T) {
+	tests := []struct {
+		arg   string
+		kind  lookupKind
+		value string
+	}{
+		{"@U123", lookupSlackUser, "U123"},
+		{"@u123", lookupSlackUser, "U123"},
+		{"<@U123>", lookupSlackUser, "U123"},
+		{"@slack_t1-u2:example.com", lookupMatrixUser, "@slack_t1-u2:example.com"},
+		{"#C123", lookupSlackChannel, "C123"},
+		{"<#C123|general>", lookupSlackChannel, "C123"},
+		{"!room:example.com", lookupMatrixRoom, "!room:example.com"},
+		{"#alias:example.com", lookupInvalid, ""},
+		{":partyparrot:", lookupEmoji, "partyparrot"},
+		{"::", lookupInvalid, ""},
+		{"@", lookupInvalid, ""},
+		{"U123", lookupInvalid, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.arg, func(t *testing.T) {
+			kind, value := parseLookupTarget(test.arg)
+			assert.Equal(t, test.kind, kind)
+			assert.Equal(t, test.value, value)
+		})
+	}
+}
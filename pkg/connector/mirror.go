@@ -0,0 +1,47 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"errors"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var errReadOnly = errors.New("login is a read-only mirror")
+
+var errReadOnlyLogin = bridgev2.WrapErrorInStatus(errReadOnly).
+	WithStatus(event.MessageStatusFail).
+	WithErrorReason(event.MessageStatusUnsupported).
+	WithMessage("This Slack workspace is mirrored read-only, nothing sent on Matrix is bridged to Slack").
+	WithIsCertain(true).
+	WithSendNotice(true)
+
+const readOnlyCommandReply = "This Slack workspace is mirrored read-only, commands can't make changes on Slack"
+
+// isReadOnly returns true if the login was created with the mirror login flow,
+// which means events from Matrix must not be bridged to Slack.
+func (s *SlackClient) isReadOnly() bool {
+	if s.UserLogin == nil {
+		return false
+	}
+	meta, ok := s.UserLogin.Metadata.(*slackid.UserLoginMetadata)
+	return ok && meta.ReadOnly
+}
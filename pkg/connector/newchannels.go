@@ -0,0 +1,61 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+const (
+	// NewChannelPortalsOwn creates portals immediately for channels created by the logged-in user (the default).
+	NewChannelPortalsOwn = "own"
+	// NewChannelPortalsAll creates portals immediately for all new public channels in the workspace.
+	NewChannelPortalsAll = "all"
+	// NewChannelPortalsNone waits for the first message or the next sync to create portals for new channels.
+	NewChannelPortalsNone = "none"
+)
+
+func (s *SlackClient) shouldCreateNewChannelPortal(creator string) bool {
+	switch s.Main.currentConfig().NewChannelPortals {
+	case NewChannelPortalsAll:
+		return true
+	case NewChannelPortalsNone:
+		return false
+	default:
+		return creator == s.UserID
+	}
+}
+
+func (s *SlackClient) wrapChannelCreated(ctx context.Context, channelID, creator string) (bridgev2.RemoteEvent, error) {
+	if !s.shouldCreateNewChannelPortal(creator) {
+		return nil, nil
+	}
+	return s.wrapCreatePortal(ctx, channelID, creator)
+}
+
+// wrapCreatePortal returns a chat resync event that creates the portal room of the given channel if it doesn't exist.
+func (s *SlackClient) wrapCreatePortal(ctx context.Context, channelID, sender string) (bridgev2.RemoteEvent, error) {
+	meta, err := s.makeEventMeta(ctx, channelID, nil, sender, "")
+	if err != nil {
+		return nil, err
+	}
+	meta.Type = bridgev2.RemoteEventChatResync
+	meta.CreatePortal = true
+	return &SlackChatResync{SlackEventMeta: &meta, Client: s, ShouldSyncInfo: true}, nil
+}
@@ -0,0 +1,52 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestShouldCreateNewChannelPortal(t *testing.T) {
+	type testCase struct {
+		policy   string
+		creator  string
+		expected bool
+	}
+	testCases := []testCase{
+		{"", "U1", true},
+		{"", "U2", false},
+		{NewChannelPortalsOwn, "U1", true},
+		{NewChannelPortalsOwn, "U2", false},
+		{NewChannelPortalsAll, "U2", true},
+		{NewChannelPortalsNone, "U1", false},
+	}
+	s := newTestSlackClient(nil)
+	s.Main = &SlackConnector{}
+	for _, tc := range testCases {
+		s.Main.Config.NewChannelPortals = tc.policy
+		assert.Equal(t, tc.expected, s.shouldCreateNewChannelPortal(tc.creator), "policy %q, creator %s", tc.policy, tc.creator)
+	}
+}
+
+func TestConfig_NewChannelPortalsValidation(t *testing.T) {
+	var cfg Config
+	assert.NoError(t, yaml.Unmarshal([]byte("new_channel_portals: all"), &cfg))
+	assert.ErrorContains(t, yaml.Unmarshal([]byte("new_channel_portals: some"), &cfg), "invalid new_channel_portals")
+}
@@ -0,0 +1,81 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/event"
+)
+
+// handleDesktopNotification forwards Slack desktop notifications for channels without a portal room to the
+// management room, so that mentions in unbridged channels aren't missed. Notifications for bridged channels
+// are ignored, as the message itself is bridged normally, and so are notifications for channels where the
+// message that triggered the notification will create the portal.
+func (s *SlackClient) handleDesktopNotification(ctx context.Context, evt *slack.DesktopNotificationEvent) {
+	if !s.Main.currentConfig().UnbridgedNotifications || evt.Channel == "" || s.shouldCreatePortal(ctx, evt.Channel) {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Str("channel_id", evt.Channel).Logger()
+	info, err := s.fetchChatInfoWithCache(ctx, evt.Channel)
+	if err != nil {
+		log.Err(err).Msg("Failed to fetch channel info for desktop notification")
+		return
+	}
+	portal, err := s.Main.br.GetExistingPortalByKey(ctx, s.makePortalKey(info))
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal for desktop notification")
+		return
+	} else if portal != nil && portal.MXID != "" {
+		return
+	}
+	roomID, err := s.UserLogin.User.GetManagementRoom(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get management room to send desktop notification")
+		return
+	}
+	_, err = s.Main.br.Bot.SendMessage(ctx, roomID, event.EventMessage, &event.Content{
+		Parsed: &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    formatUnbridgedNotification(s.TeamID, evt),
+		},
+	}, nil)
+	if err != nil {
+		log.Err(err).Msg("Failed to send desktop notification notice")
+	}
+}
+
+func formatUnbridgedNotification(teamID string, evt *slack.DesktopNotificationEvent) string {
+	var out strings.Builder
+	out.WriteString(evt.Title)
+	if evt.Subtitle != "" && evt.Subtitle != evt.Title {
+		_, _ = fmt.Fprintf(&out, " (%s)", evt.Subtitle)
+	}
+	content := evt.Content
+	if content == "" {
+		content = evt.Message
+	}
+	if content != "" {
+		_, _ = fmt.Fprintf(&out, ": %s", content)
+	}
+	_, _ = fmt.Fprintf(&out, "\n\nThis channel isn't bridged. Send `bridge-channel %s %s` to bridge it.", evt.Channel, teamID)
+	return strings.TrimSpace(out.String())
+}
@@ -0,0 +1,48 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatUnbridgedNotification(t *testing.T) {
+	tests := []struct {
+		name string
+		evt  slack.DesktopNotificationEvent
+		want string
+	}{
+		{
+			name: "mention",
+			evt:  slack.DesktopNotificationEvent{Title: "#general", Subtitle: "Alice", Content: "hey @bob", Channel: "C123"},
+			want: "#general (Alice): hey @bob\n\nThis channel isn't bridged. Send `bridge-channel C123 T1` to bridge it.",
+		},
+		{
+			name: "falls back to msg",
+			evt:  slack.DesktopNotificationEvent{Title: "Alice", Subtitle: "Alice", Message: "hi", Channel: "D123"},
+			want: "Alice: hi\n\nThis channel isn't bridged. Send `bridge-channel D123 T1` to bridge it.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatUnbridgedNotification("T1", &tt.evt))
+		})
+	}
+}
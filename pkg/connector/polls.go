@@ -0,0 +1,252 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/msgconv"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+var _ bridgev2.PollHandlingNetworkAPI = (*SlackClient)(nil)
+
+// pollOptionEmojis are the Slack emoji shortcodes used for voting on bridged Matrix polls.
+var pollOptionEmojis = []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "keycap_ten"}
+
+func (s *SlackClient) HandleMatrixPollStart(ctx context.Context, msg *bridgev2.MatrixPollStart) (*bridgev2.MatrixMessageResponse, error) {
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
+	} else if s.isReadOnly() {
+		return nil, errReadOnlyLogin
+	}
+	_, channelID := slackid.ParsePortalID(msg.Portal.ID)
+	if channelID == "" {
+		return nil, errors.New("invalid channel ID")
+	}
+	poll := &msg.Content.PollStart
+	if len(poll.Answers) > len(pollOptionEmojis) {
+		return nil, fmt.Errorf("polls can have at most %d options on Slack", len(pollOptionEmojis))
+	}
+	var text strings.Builder
+	_, _ = fmt.Fprintf(&text, ":bar_chart: *%s*\n", poll.Question.Text)
+	optionIDs := make([]string, len(poll.Answers))
+	for i, answer := range poll.Answers {
+		optionIDs[i] = answer.ID
+		_, _ = fmt.Fprintf(&text, ":%s: %s\n", pollOptionEmojis[i], answer.Text)
+	}
+	if poll.MaxSelections > 1 {
+		_, _ = fmt.Fprintf(&text, "_React with up to %d numbers to vote_", poll.MaxSelections)
+	} else {
+		text.WriteString("_React with a number to vote_")
+	}
+	options := []slack.MsgOption{slack.MsgOptionText(text.String(), false)}
+	if !s.IsRealUser {
+		options = append(options, msgconv.BotIdentityOptions(msg.Portal)...)
+	}
+	if msg.ThreadRoot != nil {
+		_, _, threadRootID, ok := slackid.ParseMessageID(msg.ThreadRoot.ID)
+		if ok {
+			options = append(options, slack.MsgOptionTS(threadRootID))
+		}
+	}
+	_, timestamp, err := s.Client.PostMessageContext(ctx, channelID, options...)
+	if err != nil {
+		return nil, err
+	}
+	// Seed the reactions so Slack users can vote with a single click
+	for i := range poll.Answers {
+		err = s.Client.AddReactionContext(ctx, pollOptionEmojis[i], slack.ItemRef{Channel: channelID, Timestamp: timestamp})
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("emoji", pollOptionEmojis[i]).Msg("Failed to seed poll option reaction")
+		}
+	}
+	return &bridgev2.MatrixMessageResponse{
+		DB: &database.Message{
+			ID:        slackid.MakeMessageID(s.TeamID, channelID, timestamp),
+			SenderID:  slackid.MakeUserID(s.TeamID, s.UserID),
+			Timestamp: slackid.ParseSlackTimestamp(timestamp),
+			Metadata: &slackid.MessageMetadata{
+				PollOptionIDs: optionIDs,
+			},
+		},
+	}, nil
+}
+
+func (s *SlackClient) HandleMatrixPollVote(ctx context.Context, msg *bridgev2.MatrixPollVote) (*bridgev2.MatrixMessageResponse, error) {
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
+	} else if s.isReadOnly() {
+		return nil, errReadOnlyLogin
+	}
+	_, channelID, pollTS, ok := slackid.ParseMessageID(msg.VoteTo.ID)
+	if !ok {
+		return nil, errors.New("invalid message ID")
+	}
+	optionIDs := msg.VoteTo.Metadata.(*slackid.MessageMetadata).PollOptionIDs
+	if len(optionIDs) == 0 {
+		return nil, errors.New("target message is not a poll")
+	}
+	target := slack.ItemRef{Channel: channelID, Timestamp: pollTS}
+	var chosen []string
+	for i, optionID := range optionIDs {
+		if slices.Contains(msg.Content.Response.Answers, optionID) {
+			chosen = append(chosen, pollOptionEmojis[i])
+			err := s.Client.AddReactionContext(ctx, pollOptionEmojis[i], target)
+			if err == nil {
+				s.addPendingVoteReaction(channelID, pollTS, pollOptionEmojis[i], true)
+			} else if !isSlackErrorResponse(err, "already_reacted") {
+				return nil, fmt.Errorf("failed to add vote reaction: %w", err)
+			}
+		} else {
+			err := s.Client.RemoveReactionContext(ctx, pollOptionEmojis[i], target)
+			if err == nil {
+				s.addPendingVoteReaction(channelID, pollTS, pollOptionEmojis[i], false)
+			} else if !isSlackErrorResponse(err, "no_reaction") {
+				return nil, fmt.Errorf("failed to remove vote reaction: %w", err)
+			}
+		}
+	}
+	return &bridgev2.MatrixMessageResponse{
+		DB: &database.Message{
+			ID: slackid.MakePollVoteID(
+				msg.VoteTo.ID, s.UserID, strings.Join(chosen, "+"), strconv.FormatInt(msg.Event.Timestamp, 10),
+			),
+			SenderID:  slackid.MakeUserID(s.TeamID, s.UserID),
+			Timestamp: time.UnixMilli(msg.Event.Timestamp),
+		},
+	}, nil
+}
+
+// isSlackErrorResponse returns true if the given error is a Slack API error response with the given code.
+func isSlackErrorResponse(err error, code string) bool {
+	var respErr slack.SlackErrorResponse
+	return errors.As(err, &respErr) && respErr.Err == code
+}
+
+type pendingVoteReaction struct {
+	channelID string
+	pollTS    string
+	emoji     string
+	added     bool
+}
+
+// addPendingVoteReaction remembers a reaction change made for a Matrix vote, so that its echo isn't bridged back.
+func (s *SlackClient) addPendingVoteReaction(channelID, pollTS, emoji string, added bool) {
+	s.pendingVotesLock.Lock()
+	defer s.pendingVotesLock.Unlock()
+	if s.pendingVotes == nil {
+		s.pendingVotes = make(map[pendingVoteReaction]struct{})
+	}
+	s.pendingVotes[pendingVoteReaction{channelID, pollTS, emoji, added}] = struct{}{}
+}
+
+// takePendingVoteReaction returns true and forgets the reaction change if it was made for a Matrix vote.
+func (s *SlackClient) takePendingVoteReaction(channelID, pollTS, emoji string, added bool) bool {
+	key := pendingVoteReaction{channelID, pollTS, emoji, added}
+	s.pendingVotesLock.Lock()
+	defer s.pendingVotesLock.Unlock()
+	_, ok := s.pendingVotes[key]
+	delete(s.pendingVotes, key)
+	return ok
+}
+
+// SlackPollVote is a reaction on a poll message that was created from Matrix, bridged as a poll response.
+type SlackPollVote struct {
+	*SlackEventMeta
+	Client *SlackClient
+	Poll   *database.Message
+	Item   slack.ReactionItem
+	UserID string
+}
+
+var _ bridgev2.RemoteMessage = (*SlackPollVote)(nil)
+
+func (s *SlackPollVote) ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI) (*bridgev2.ConvertedMessage, error) {
+	reactions, err := s.Client.Client.GetReactionsContext(ctx, slack.ItemRef{
+		Channel:   s.Item.Channel,
+		Timestamp: s.Item.Timestamp,
+	}, slack.GetReactionsParameters{Full: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll reactions: %w", err)
+	}
+	optionIDs := s.Poll.Metadata.(*slackid.MessageMetadata).PollOptionIDs
+	answers := make([]string, 0)
+	for _, reaction := range reactions {
+		idx := slices.Index(pollOptionEmojis, reaction.Name)
+		if idx >= 0 && idx < len(optionIDs) && slices.Contains(reaction.Users, s.UserID) {
+			answers = append(answers, optionIDs[idx])
+		}
+	}
+	return &bridgev2.ConvertedMessage{
+		Parts: []*bridgev2.ConvertedMessagePart{{
+			Type: event.EventUnstablePollResponse,
+			Content: &event.MessageEventContent{
+				RelatesTo: &event.RelatesTo{
+					Type:    event.RelReference,
+					EventID: s.Poll.MXID,
+				},
+			},
+			Extra: map[string]any{
+				"org.matrix.msc3381.poll.response": map[string]any{
+					"answers": answers,
+				},
+			},
+		}},
+	}, nil
+}
+
+// wrapPollVote checks if the given reaction is a vote on a poll bridged from Matrix
+// and returns a poll response event if so. The event is nil for echoes of reactions changed by Matrix votes.
+func (s *SlackClient) wrapPollVote(ctx context.Context, meta *SlackEventMeta, userID, reaction string, added bool, item slack.ReactionItem) (vote bridgev2.RemoteEvent, isVote bool) {
+	if !slices.Contains(pollOptionEmojis, reaction) || item.Timestamp == "" {
+		return nil, false
+	}
+	poll, err := s.Main.br.DB.Message.GetFirstPartByID(ctx, s.UserLogin.ID, slackid.MakeMessageID(s.TeamID, item.Channel, item.Timestamp))
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get reaction target to check if it's a poll")
+		return nil, false
+	} else if poll == nil || len(poll.Metadata.(*slackid.MessageMetadata).PollOptionIDs) == 0 {
+		return nil, false
+	}
+	if userID == s.UserID && s.takePendingVoteReaction(item.Channel, item.Timestamp, reaction, added) {
+		// Votes from Matrix are already in the room
+		return nil, true
+	}
+	// Each vote change is a separate Matrix event, keyed by the voter, option and reaction event timestamp
+	meta.Type = bridgev2.RemoteEventMessage
+	meta.ID = slackid.MakePollVoteID(poll.ID, userID, reaction, meta.RawTimestamp)
+	return &SlackPollVote{
+		SlackEventMeta: meta,
+		Client:         s,
+		Poll:           poll,
+		Item:           item,
+		UserID:         userID,
+	}, true
+}
@@ -0,0 +1,67 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestHandleMatrixPollVote(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("reactions.add", func(form url.Values) (any, error) {
+		if form.Get("name") == "one" {
+			return nil, slackapitest.Error("already_reacted")
+		}
+		return nil, nil
+	})
+	srv.Handle("reactions.remove", func(form url.Values) (any, error) {
+		return nil, slackapitest.Error("no_reaction")
+	})
+	s := newTestSlackClient(srv.Client())
+	s.UserLogin = &bridgev2.UserLogin{UserLogin: &database.UserLogin{Metadata: &slackid.UserLoginMetadata{}}}
+	pollID := slackid.MakeMessageID("T1", "C1", "1700000000.000100")
+	msg := &bridgev2.MatrixPollVote{
+		VoteTo: &database.Message{
+			ID:       pollID,
+			Metadata: &slackid.MessageMetadata{PollOptionIDs: []string{"a", "b", "c"}},
+		},
+		Content: &event.PollResponseEventContent{},
+	}
+	msg.Event = &event.Event{ID: "$vote", Timestamp: 1700000001000}
+	msg.Content.Response.Answers = []string{"a", "b"}
+
+	resp, err := s.HandleMatrixPollVote(context.Background(), msg)
+	require.NoError(t, err)
+	assert.Equal(t, slackid.MakePollVoteID(pollID, "U1", "one+two", "1700000001000"), resp.DB.ID)
+	assert.NotEqual(t, pollID, resp.DB.ID)
+	// Only successful reaction changes will be echoed back
+	assert.False(t, s.takePendingVoteReaction("C1", "1700000000.000100", "one", true))
+	assert.True(t, s.takePendingVoteReaction("C1", "1700000000.000100", "two", true))
+	assert.False(t, s.takePendingVoteReaction("C1", "1700000000.000100", "two", true))
+	assert.False(t, s.takePendingVoteReaction("C1", "1700000000.000100", "three", false))
+}
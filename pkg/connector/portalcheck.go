@@ -0,0 +1,146 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// findStalePortals returns portals whose Matrix room doesn't exist anymore or doesn't contain the bridge bot.
+func (s *SlackConnector) findStalePortals(ctx context.Context) ([]*bridgev2.Portal, error) {
+	mc, ok := s.br.Matrix.(*matrix.Connector)
+	if !ok {
+		return nil, errors.New("checking portal rooms is not supported with this Matrix connector")
+	}
+	joined, err := mc.Bot.JoinedRooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get joined rooms: %w", err)
+	}
+	portals, err := s.br.GetAllPortalsWithMXID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get portals: %w", err)
+	}
+	return filterStalePortals(portals, joined.JoinedRooms)
+}
+
+func filterStalePortals(portals []*bridgev2.Portal, joinedRooms []id.RoomID) ([]*bridgev2.Portal, error) {
+	if len(portals) > 0 && len(joinedRooms) == 0 {
+		// Most likely something is wrong with the homeserver, don't drop every room
+		return nil, errors.New("bridge bot isn't in any rooms")
+	}
+	joinedMap := make(map[id.RoomID]struct{}, len(joinedRooms))
+	for _, roomID := range joinedRooms {
+		joinedMap[roomID] = struct{}{}
+	}
+	var stale []*bridgev2.Portal
+	for _, portal := range portals {
+		if _, ok := joinedMap[portal.MXID]; !ok {
+			stale = append(stale, portal)
+		}
+	}
+	return stale, nil
+}
+
+// FixPortals clears the Matrix room ID of portals whose room was deleted or where the bridge bot was removed,
+// so that a new room is created on the next activity. If dryRun is true, the stale portals are only returned.
+func (s *SlackConnector) FixPortals(ctx context.Context, dryRun bool) ([]*bridgev2.Portal, error) {
+	stale, err := s.findStalePortals(ctx)
+	if err != nil || dryRun {
+		return stale, err
+	}
+	return stale, unlinkStalePortals(ctx, stale)
+}
+
+func unlinkStalePortals(ctx context.Context, stale []*bridgev2.Portal) error {
+	log := zerolog.Ctx(ctx)
+	for _, portal := range stale {
+		log.Info().
+			Object("portal_key", portal.PortalKey).
+			Stringer("room_id", portal.MXID).
+			Msg("Clearing Matrix room of portal as the bridge bot isn't in the room")
+		err := portal.RemoveMXID(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to clear room ID of %s: %w", portal.PortalKey, err)
+		}
+	}
+	return nil
+}
+
+// PortalCheckConfirmations is the number of consecutive periodic checks in which a portal room must be
+// found broken before the portal is unlinked from it, so that temporary homeserver issues don't unlink rooms.
+const PortalCheckConfirmations = 3
+
+// confirmStalePortals counts how many consecutive checks each room was found stale in and returns the portals
+// that reached PortalCheckConfirmations. Rooms that aren't stale anymore are forgotten.
+func confirmStalePortals(seen map[id.RoomID]int, stale []*bridgev2.Portal) []*bridgev2.Portal {
+	staleRooms := make(map[id.RoomID]struct{}, len(stale))
+	var confirmed []*bridgev2.Portal
+	for _, portal := range stale {
+		staleRooms[portal.MXID] = struct{}{}
+		seen[portal.MXID]++
+		if seen[portal.MXID] >= PortalCheckConfirmations {
+			confirmed = append(confirmed, portal)
+			delete(seen, portal.MXID)
+		}
+	}
+	for roomID := range seen {
+		if _, ok := staleRooms[roomID]; !ok {
+			delete(seen, roomID)
+		}
+	}
+	return confirmed
+}
+
+func (s *SlackConnector) runPortalCheckLoop(ctx context.Context, interval time.Duration) {
+	log := s.br.Log.With().Str("action", "portal integrity check").Logger()
+	ctx = log.WithContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	seen := make(map[id.RoomID]int)
+	for {
+		select {
+		case <-ticker.C:
+			stale, err := s.findStalePortals(ctx)
+			if err != nil {
+				log.Err(err).Msg("Failed to check portal rooms")
+				continue
+			}
+			confirmed := confirmStalePortals(seen, stale)
+			if len(stale) > len(confirmed) {
+				log.Warn().
+					Int("pending_count", len(stale)-len(confirmed)).
+					Msg("Found portals with missing rooms, waiting for more checks before unlinking them")
+			}
+			err = unlinkStalePortals(ctx, confirmed)
+			if err != nil {
+				log.Err(err).Msg("Failed to fix portal rooms")
+			} else if len(confirmed) > 0 {
+				log.Info().Int("fixed_count", len(confirmed)).Msg("Fixed portals with missing rooms")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestFilterStalePortals(t *testing.T) {
+	makePortal := func(roomID id.RoomID) *bridgev2.Portal {
+		return &bridgev2.Portal{Portal: &database.Portal{MXID: roomID}}
+	}
+	portalA, portalB, portalC := makePortal("!a:example.com"), makePortal("!b:example.com"), makePortal("!c:example.com")
+
+	stale, err := filterStalePortals([]*bridgev2.Portal{portalA, portalB, portalC}, []id.RoomID{"!a:example.com", "!c:example.com", "!other:example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []*bridgev2.Portal{portalB}, stale)
+
+	stale, err = filterStalePortals([]*bridgev2.Portal{portalA}, []id.RoomID{"!a:example.com"})
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+
+	_, err = filterStalePortals([]*bridgev2.Portal{portalA}, nil)
+	assert.Error(t, err)
+
+	stale, err = filterStalePortals(nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+}
+
+func TestConfirmStalePortals(t *testing.T) {
+	portalA := &bridgev2.Portal{Portal: &database.Portal{MXID: "!a:example.com"}}
+	portalB := &bridgev2.Portal{Portal: &database.Portal{MXID: "!b:example.com"}}
+	seen := make(map[id.RoomID]int)
+	for i := 1; i < PortalCheckConfirmations; i++ {
+		assert.Empty(t, confirmStalePortals(seen, []*bridgev2.Portal{portalA, portalB}))
+	}
+	// B recovered before being confirmed, so its count starts over
+	assert.Equal(t, []*bridgev2.Portal{portalA}, confirmStalePortals(seen, []*bridgev2.Portal{portalA}))
+	assert.Empty(t, seen)
+	assert.Empty(t, confirmStalePortals(seen, []*bridgev2.Portal{portalB}))
+	assert.Equal(t, 1, seen["!b:example.com"])
+}
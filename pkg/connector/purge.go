@@ -0,0 +1,203 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// PurgeReport contains the number of rows that a purge deleted, or would delete in dry-run mode.
+type PurgeReport struct {
+	Portals      int `json:"portals"`
+	Ghosts       int `json:"ghosts"`
+	Messages     int `json:"messages"`
+	Reactions    int `json:"reactions"`
+	Emojis       int `json:"emojis"`
+	AuditEntries int `json:"audit_entries"`
+}
+
+var scanPortalKey = dbutil.ConvertRowFn[networkid.PortalKey](func(row dbutil.Scannable) (key networkid.PortalKey, err error) {
+	err = row.Scan(&key.ID, &key.Receiver)
+	return
+})
+
+var slackIDRegex = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+var (
+	ErrPurgeActiveLogin = errors.New("there are active logins, they must be logged out before purging")
+	ErrInvalidTeamID    = errors.New("invalid team ID")
+	ErrInvalidUserID    = errors.New("invalid user ID")
+)
+
+// The bridgev2 tables don't have queries for bulk operations, so they're accessed directly here.
+// Slack IDs only contain letters and numbers, so they're safe to use in LIKE patterns.
+const (
+	countTeamLoginsQuery    = `SELECT COUNT(*) FROM user_login WHERE bridge_id=$1 AND id LIKE $2`
+	getTeamPortalsQuery     = `SELECT id, receiver FROM portal WHERE bridge_id=$1 AND (id=$2 OR id LIKE $3)`
+	countTeamGhostsQuery    = `SELECT COUNT(*) FROM ghost WHERE bridge_id=$1 AND id LIKE $2`
+	deleteTeamGhostsQuery   = `DELETE FROM ghost WHERE bridge_id=$1 AND id LIKE $2`
+	countTeamMessagesQuery  = `SELECT COUNT(*) FROM message WHERE bridge_id=$1 AND (room_id=$2 OR room_id LIKE $3 OR sender_id LIKE $4)`
+	countTeamReactionsQuery = `SELECT COUNT(*) FROM reaction WHERE bridge_id=$1 AND (room_id=$2 OR room_id LIKE $3 OR sender_id LIKE $4)`
+	countGhostQuery         = `SELECT COUNT(*) FROM ghost WHERE bridge_id=$1 AND id=$2`
+	deleteGhostQuery        = `DELETE FROM ghost WHERE bridge_id=$1 AND id=$2`
+	countUserMessagesQuery  = `SELECT COUNT(*) FROM message WHERE bridge_id=$1 AND sender_id=$2`
+	countUserReactionsQuery = `SELECT COUNT(*) FROM reaction WHERE bridge_id=$1 AND sender_id=$2`
+)
+
+func (s *SlackConnector) countRows(ctx context.Context, query string, args ...any) (count int, err error) {
+	err = s.br.DB.QueryRow(ctx, query, append([]any{s.br.ID}, args...)...).Scan(&count)
+	return
+}
+
+// PurgeTeam deletes all data of the given Slack team from the bridge database: portals along with their messages
+// and reactions, ghosts, custom emojis and audit log entries. Matrix rooms and ghost profiles are not touched.
+// The team must not have any logins. If dryRun is true, nothing is deleted and the report contains what would be.
+func (s *SlackConnector) PurgeTeam(ctx context.Context, teamID string, dryRun bool) (report PurgeReport, err error) {
+	teamID = strings.ToUpper(teamID)
+	if !slackIDRegex.MatchString(teamID) {
+		return report, fmt.Errorf("%w %q", ErrInvalidTeamID, teamID)
+	}
+	loginPattern := teamID + "-%"
+	ghostPattern := strings.ToLower(teamID) + "-%"
+	if logins, err := s.countRows(ctx, countTeamLoginsQuery, loginPattern); err != nil {
+		return report, fmt.Errorf("failed to count logins: %w", err)
+	} else if logins > 0 {
+		return report, ErrPurgeActiveLogin
+	}
+	portalKeys, err := s.getTeamPortalKeys(ctx, teamID)
+	if err != nil {
+		return report, fmt.Errorf("failed to get portals: %w", err)
+	}
+	report.Portals = len(portalKeys)
+	if report.Ghosts, err = s.countRows(ctx, countTeamGhostsQuery, ghostPattern); err != nil {
+		return report, fmt.Errorf("failed to count ghosts: %w", err)
+	} else if report.Messages, err = s.countRows(ctx, countTeamMessagesQuery, teamID, loginPattern, ghostPattern); err != nil {
+		return report, fmt.Errorf("failed to count messages: %w", err)
+	} else if report.Reactions, err = s.countRows(ctx, countTeamReactionsQuery, teamID, loginPattern, ghostPattern); err != nil {
+		return report, fmt.Errorf("failed to count reactions: %w", err)
+	} else if report.Emojis, err = s.DB.Emoji.GetEmojiCount(ctx, teamID); err != nil {
+		return report, fmt.Errorf("failed to count emojis: %w", err)
+	} else if report.AuditEntries, err = s.DB.AuditLog.CountTeam(ctx, teamID); err != nil {
+		return report, fmt.Errorf("failed to count audit log entries: %w", err)
+	}
+	if dryRun {
+		return report, nil
+	}
+	// Everything is deleted in one transaction, so that a failure doesn't leave a half-purged team behind
+	err = s.br.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		// Channel portals reference the team portal as their parent, so they have to be deleted first
+		teamPortalIdx := -1
+		for i, key := range portalKeys {
+			if key.ID == slackid.MakeTeamPortalID(teamID) {
+				teamPortalIdx = i
+				continue
+			}
+			if err := s.deletePortal(ctx, key); err != nil {
+				return err
+			}
+		}
+		if teamPortalIdx >= 0 {
+			if err := s.deletePortal(ctx, portalKeys[teamPortalIdx]); err != nil {
+				return err
+			}
+		}
+		if _, err := s.br.DB.Exec(ctx, deleteTeamGhostsQuery, s.br.ID, ghostPattern); err != nil {
+			return fmt.Errorf("failed to delete ghosts: %w", err)
+		} else if err = s.DB.Emoji.DeleteAllInTeam(ctx, teamID); err != nil {
+			return fmt.Errorf("failed to delete emojis: %w", err)
+		} else if err = s.DB.AuditLog.DeleteTeam(ctx, teamID); err != nil {
+			return fmt.Errorf("failed to delete audit log entries: %w", err)
+		} else if err = s.DB.Cursors.DeleteTeam(ctx, teamID); err != nil {
+			return fmt.Errorf("failed to delete event cursors: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return PurgeReport{}, err
+	}
+	return report, nil
+}
+
+// PurgeUser deletes all data of the given Slack user from the bridge database: their ghost, messages and
+// reactions sent by them, and audit log entries of their actions. The user must not be logged into the bridge.
+func (s *SlackConnector) PurgeUser(ctx context.Context, teamID, userID string, dryRun bool) (report PurgeReport, err error) {
+	teamID, userID = strings.ToUpper(teamID), strings.ToUpper(userID)
+	if !slackIDRegex.MatchString(teamID) {
+		return report, fmt.Errorf("%w %q", ErrInvalidTeamID, teamID)
+	} else if !slackIDRegex.MatchString(userID) {
+		return report, fmt.Errorf("%w %q", ErrInvalidUserID, userID)
+	}
+	if login, err := s.br.DB.UserLogin.GetByID(ctx, slackid.MakeUserLoginID(teamID, userID)); err != nil {
+		return report, fmt.Errorf("failed to check for login: %w", err)
+	} else if login != nil {
+		return report, ErrPurgeActiveLogin
+	}
+	ghostID := slackid.MakeUserID(teamID, userID)
+	if report.Ghosts, err = s.countRows(ctx, countGhostQuery, ghostID); err != nil {
+		return report, fmt.Errorf("failed to count ghosts: %w", err)
+	} else if report.Messages, err = s.countRows(ctx, countUserMessagesQuery, ghostID); err != nil {
+		return report, fmt.Errorf("failed to count messages: %w", err)
+	} else if report.Reactions, err = s.countRows(ctx, countUserReactionsQuery, ghostID); err != nil {
+		return report, fmt.Errorf("failed to count reactions: %w", err)
+	} else if report.AuditEntries, err = s.DB.AuditLog.CountSender(ctx, teamID, userID); err != nil {
+		return report, fmt.Errorf("failed to count audit log entries: %w", err)
+	}
+	if dryRun {
+		return report, nil
+	}
+	err = s.br.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		// Messages and reactions are deleted by the foreign key cascade
+		if _, err := s.br.DB.Exec(ctx, deleteGhostQuery, s.br.ID, ghostID); err != nil {
+			return fmt.Errorf("failed to delete ghost: %w", err)
+		} else if err = s.DB.AuditLog.DeleteSender(ctx, teamID, userID); err != nil {
+			return fmt.Errorf("failed to delete audit log entries: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return PurgeReport{}, err
+	}
+	return report, nil
+}
+
+// getTeamPortalKeys returns the keys of the team portal and all channel portals of the given team.
+func (s *SlackConnector) getTeamPortalKeys(ctx context.Context, teamID string) ([]networkid.PortalKey, error) {
+	return scanPortalKey.NewRowIter(s.br.DB.Query(ctx, getTeamPortalsQuery, s.br.ID, teamID, teamID+"-%")).AsList()
+}
+
+func (s *SlackConnector) deletePortal(ctx context.Context, key networkid.PortalKey) error {
+	portal, err := s.br.GetExistingPortalByKey(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to get portal %s: %w", key, err)
+	} else if portal == nil {
+		return nil
+	}
+	// Messages, reactions and backfill tasks are deleted by the foreign key cascade
+	if err = portal.Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete portal %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2/database"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestPurge_InvalidIDs(t *testing.T) {
+	s := &SlackConnector{}
+	ctx := context.Background()
+	_, err := s.PurgeTeam(ctx, "T1%", true)
+	assert.ErrorContains(t, err, "invalid team ID")
+	_, err = s.PurgeTeam(ctx, "", true)
+	assert.ErrorContains(t, err, "invalid team ID")
+	_, err = s.PurgeUser(ctx, "T1", "U_1", true)
+	assert.ErrorContains(t, err, "invalid user ID")
+	_, err = s.PurgeUser(ctx, "T-1", "U1", true)
+	assert.ErrorContains(t, err, "invalid team ID")
+}
+
+func TestPurgeUser_RollsBackOnFailure(t *testing.T) {
+	ctx := context.Background()
+	br := newTestBridgeDB(t)
+	s := &SlackConnector{br: br, DB: slackdb.New(br.DB.Database, zerolog.Nop())}
+	require.NoError(t, s.DB.Upgrade(ctx))
+	ghostID := slackid.MakeUserID("T1", "U1")
+	require.NoError(t, br.DB.Ghost.Insert(ctx, &database.Ghost{ID: ghostID}))
+	_, err := br.DB.Exec(ctx, `CREATE TRIGGER fail_audit_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'fail'); END`)
+	require.NoError(t, err)
+	require.NoError(t, s.DB.AuditLog.Insert(ctx, &slackdb.AuditEntry{TeamID: "T1", Sender: "U1"}))
+
+	_, err = s.PurgeUser(ctx, "T1", "U1", false)
+	assert.ErrorContains(t, err, "failed to delete audit log entries")
+	ghost, err := br.DB.Ghost.GetByID(ctx, ghostID)
+	require.NoError(t, err)
+	assert.NotNil(t, ghost, "ghost deletion should be rolled back")
+
+	_, err = br.DB.Exec(ctx, `DROP TRIGGER fail_audit_delete`)
+	require.NoError(t, err)
+	report, err := s.PurgeUser(ctx, "T1", "U1", false)
+	require.NoError(t, err)
+	assert.Equal(t, PurgeReport{Ghosts: 1, AuditEntries: 1}, report)
+	ghost, err = br.DB.Ghost.GetByID(ctx, ghostID)
+	require.NoError(t, err)
+	assert.Nil(t, ghost)
+}
@@ -0,0 +1,208 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type rateLimitKey struct {
+	TeamID string
+	Family string
+}
+
+// RateLimitBudget is the last observed rate limit state of one API method family in a team.
+// Limit, Remaining and Reset are only set if Slack included X-RateLimit headers in a response.
+type RateLimitBudget struct {
+	Limit       int
+	Remaining   int
+	Reset       time.Time
+	HasHeaders  bool
+	Requests    int
+	RateLimited int
+	RetryAfter  time.Duration
+}
+
+// RateLimitTracker collects the rate limit headers of Slack web API responses per team and API family,
+// where the family is the part of the method name before the first dot (e.g. conversations for conversations.history).
+type RateLimitTracker struct {
+	lock    sync.Mutex
+	budgets map[rateLimitKey]*RateLimitBudget
+}
+
+// apiFamily returns the API method family of a Slack web API request path like /api/conversations.history.
+func apiFamily(path string) string {
+	method := path[strings.LastIndexByte(path, '/')+1:]
+	family, _, _ := strings.Cut(method, ".")
+	if family == "" {
+		return "unknown"
+	}
+	return family
+}
+
+func parseRetryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (rlt *RateLimitTracker) record(teamID, family string, resp *http.Response) *RateLimitBudget {
+	rlt.lock.Lock()
+	defer rlt.lock.Unlock()
+	if rlt.budgets == nil {
+		rlt.budgets = make(map[rateLimitKey]*RateLimitBudget)
+	}
+	key := rateLimitKey{TeamID: teamID, Family: family}
+	budget, ok := rlt.budgets[key]
+	if !ok {
+		budget = &RateLimitBudget{}
+		rlt.budgets[key] = budget
+	}
+	budget.Requests++
+	limit, limitErr := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if limitErr == nil && remainingErr == nil {
+		budget.HasHeaders = true
+		budget.Limit = limit
+		budget.Remaining = remaining
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			budget.Reset = time.Unix(reset, 0)
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		budget.RateLimited++
+		budget.RetryAfter = parseRetryAfter(resp.Header)
+	}
+	copied := *budget
+	return &copied
+}
+
+// snapshot returns a copy of the current budgets.
+func (rlt *RateLimitTracker) snapshot() map[rateLimitKey]RateLimitBudget {
+	rlt.lock.Lock()
+	defer rlt.lock.Unlock()
+	out := make(map[rateLimitKey]RateLimitBudget, len(rlt.budgets))
+	for key, budget := range rlt.budgets {
+		out[key] = *budget
+	}
+	return out
+}
+
+// RateLimitLoginTeamID is the team ID that requests made during login are recorded under,
+// as the team isn't known until the credentials have been checked.
+const RateLimitLoginTeamID = "login"
+
+// HTTPClientOption returns a slack-go option that records the rate limits of all requests made by the client.
+func (rlt *RateLimitTracker) HTTPClientOption(teamID string, log *zerolog.Logger) slack.Option {
+	return slack.OptionHTTPClient(&http.Client{Transport: rlt.Transport(teamID, log)})
+}
+
+// Transport returns an HTTP transport that records the rate limits of all requests made through it.
+// It's used for clients that aren't slack-go clients, like the web and admin API clients.
+func (rlt *RateLimitTracker) Transport(teamID string, log *zerolog.Logger) http.RoundTripper {
+	return &rateLimitTransport{
+		tracker: rlt,
+		teamID:  teamID,
+		log:     log,
+		base:    http.DefaultTransport,
+	}
+}
+
+type rateLimitTransport struct {
+	tracker *RateLimitTracker
+	teamID  string
+	log     *zerolog.Logger
+	base    http.RoundTripper
+}
+
+func (rlt *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rlt.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	family := apiFamily(req.URL.Path)
+	budget := rlt.tracker.record(rlt.teamID, family, resp)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rlt.log.Debug().
+			Str("api_family", family).
+			Str("path", req.URL.Path).
+			Dur("retry_after", budget.RetryAfter).
+			Msg("Slack API request was rate limited")
+		trace.SpanFromContext(req.Context()).AddEvent("slack rate limited", trace.WithAttributes(
+			attribute.String("slack.api_family", family),
+			attribute.Int64("slack.retry_after_ms", budget.RetryAfter.Milliseconds()),
+		))
+	} else if budget.HasHeaders {
+		rlt.log.Trace().
+			Str("api_family", family).
+			Int("limit", budget.Limit).
+			Int("remaining", budget.Remaining).
+			Time("reset", budget.Reset).
+			Msg("Slack API rate limit budget")
+	}
+	return resp, nil
+}
+
+func writeRateLimitMetrics(w io.Writer, budgets map[rateLimitKey]RateLimitBudget) {
+	keys := slices.SortedFunc(maps.Keys(budgets), func(a, b rateLimitKey) int {
+		return cmp.Or(strings.Compare(a.TeamID, b.TeamID), strings.Compare(a.Family, b.Family))
+	})
+	write := func(name, metricType, help string, onlyHeaders bool, value func(budget RateLimitBudget) float64) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+		for _, key := range keys {
+			budget := budgets[key]
+			if onlyHeaders && !budget.HasHeaders {
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "%s{team_id=%q,family=%q} %s\n", name, key.TeamID, key.Family, strconv.FormatFloat(value(budget), 'f', -1, 64))
+		}
+	}
+	write("slack_bridge_api_requests_total", "counter", "Number of Slack web API requests.", false, func(budget RateLimitBudget) float64 {
+		return float64(budget.Requests)
+	})
+	write("slack_bridge_api_rate_limited_total", "counter", "Number of Slack web API requests that were rate limited.", false, func(budget RateLimitBudget) float64 {
+		return float64(budget.RateLimited)
+	})
+	write("slack_bridge_api_retry_after_seconds", "gauge", "Retry-After of the last rate limited Slack web API request.", false, func(budget RateLimitBudget) float64 {
+		return budget.RetryAfter.Seconds()
+	})
+	write("slack_bridge_api_rate_limit", "gauge", "Last X-RateLimit-Limit returned by Slack.", true, func(budget RateLimitBudget) float64 {
+		return float64(budget.Limit)
+	})
+	write("slack_bridge_api_rate_limit_remaining", "gauge", "Last X-RateLimit-Remaining returned by Slack.", true, func(budget RateLimitBudget) float64 {
+		return float64(budget.Remaining)
+	})
+	write("slack_bridge_api_rate_limit_reset_timestamp_seconds", "gauge", "Last X-RateLimit-Reset returned by Slack.", true, func(budget RateLimitBudget) float64 {
+		return float64(budget.Reset.Unix())
+	})
+}
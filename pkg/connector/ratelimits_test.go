@@ -0,0 +1,128 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+)
+
+func TestAPIFamily(t *testing.T) {
+	assert.Equal(t, "conversations", apiFamily("/api/conversations.history"))
+	assert.Equal(t, "users", apiFamily("/api/users.profile.get"))
+	assert.Equal(t, "unknown", apiFamily("/api/"))
+}
+
+func TestRateLimitTracker_Transport(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.history", func(form url.Values) (any, error) {
+		if form.Get("cursor") == "limited" {
+			return nil, slackapitest.RateLimited(30 * time.Second)
+		}
+		return map[string]any{"messages": []any{}}, nil
+	})
+	var tracker RateLimitTracker
+	log := zerolog.Nop()
+	client := slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/api/"), tracker.HTTPClientOption("T1", &log))
+	ctx := context.Background()
+
+	_, err := client.GetConversationHistoryContext(ctx, &slack.GetConversationHistoryParameters{ChannelID: "C1"})
+	require.NoError(t, err)
+	_, err = client.GetConversationHistoryContext(ctx, &slack.GetConversationHistoryParameters{ChannelID: "C1", Cursor: "limited"})
+	var rateLimitErr *slack.RateLimitedError
+	require.ErrorAs(t, err, &rateLimitErr)
+
+	budget := tracker.snapshot()[rateLimitKey{TeamID: "T1", Family: "conversations"}]
+	assert.Equal(t, 2, budget.Requests)
+	assert.Equal(t, 1, budget.RateLimited)
+	assert.Equal(t, 30*time.Second, budget.RetryAfter)
+	assert.False(t, budget.HasHeaders)
+}
+
+func TestRateLimitTracker_WebClientTransport(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Respond("blocks.actions", nil)
+	var tracker RateLimitTracker
+	log := zerolog.Nop()
+	web := slackapi.NewWebClient("xoxc-test", "cookie")
+	web.APIURL = srv.URL + "/api/"
+	web.HTTP.Transport = tracker.Transport("T1", &log)
+
+	require.NoError(t, web.PostBlockActions(context.Background(), "C1", "1700000000.000100", "B1", slackapi.BlockAction{ActionID: "approve"}))
+	assert.Equal(t, 1, tracker.snapshot()[rateLimitKey{TeamID: "T1", Family: "blocks"}].Requests)
+}
+
+func TestRateLimitTracker_Headers(t *testing.T) {
+	var tracker RateLimitTracker
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "50")
+	header.Set("X-RateLimit-Remaining", "12")
+	header.Set("X-RateLimit-Reset", "1700000060")
+	budget := tracker.record("T1", "chat", &http.Response{StatusCode: http.StatusOK, Header: header})
+	assert.True(t, budget.HasHeaders)
+	assert.Equal(t, 50, budget.Limit)
+	assert.Equal(t, 12, budget.Remaining)
+	assert.Equal(t, int64(1700000060), budget.Reset.Unix())
+
+	// Responses without headers keep the last known budget
+	budget = tracker.record("T1", "chat", &http.Response{StatusCode: http.StatusOK, Header: http.Header{}})
+	assert.Equal(t, 12, budget.Remaining)
+	assert.Equal(t, 2, budget.Requests)
+}
+
+func TestWriteRateLimitMetrics(t *testing.T) {
+	var out strings.Builder
+	writeRateLimitMetrics(&out, map[rateLimitKey]RateLimitBudget{
+		{TeamID: "T1", Family: "users"}:         {Requests: 3},
+		{TeamID: "T1", Family: "conversations"}: {Requests: 5, RateLimited: 1, RetryAfter: 30 * time.Second, HasHeaders: true, Limit: 50, Remaining: 0, Reset: time.Unix(1700000060, 0)},
+	})
+	expected := `# HELP slack_bridge_api_requests_total Number of Slack web API requests.
+# TYPE slack_bridge_api_requests_total counter
+slack_bridge_api_requests_total{team_id="T1",family="conversations"} 5
+slack_bridge_api_requests_total{team_id="T1",family="users"} 3
+# HELP slack_bridge_api_rate_limited_total Number of Slack web API requests that were rate limited.
+# TYPE slack_bridge_api_rate_limited_total counter
+slack_bridge_api_rate_limited_total{team_id="T1",family="conversations"} 1
+slack_bridge_api_rate_limited_total{team_id="T1",family="users"} 0
+# HELP slack_bridge_api_retry_after_seconds Retry-After of the last rate limited Slack web API request.
+# TYPE slack_bridge_api_retry_after_seconds gauge
+slack_bridge_api_retry_after_seconds{team_id="T1",family="conversations"} 30
+slack_bridge_api_retry_after_seconds{team_id="T1",family="users"} 0
+# HELP slack_bridge_api_rate_limit Last X-RateLimit-Limit returned by Slack.
+# TYPE slack_bridge_api_rate_limit gauge
+slack_bridge_api_rate_limit{team_id="T1",family="conversations"} 50
+# HELP slack_bridge_api_rate_limit_remaining Last X-RateLimit-Remaining returned by Slack.
+# TYPE slack_bridge_api_rate_limit_remaining gauge
+slack_bridge_api_rate_limit_remaining{team_id="T1",family="conversations"} 0
+# HELP slack_bridge_api_rate_limit_reset_timestamp_seconds Last X-RateLimit-Reset returned by Slack.
+# TYPE slack_bridge_api_rate_limit_reset_timestamp_seconds gauge
+slack_bridge_api_rate_limit_reset_timestamp_seconds{team_id="T1",family="conversations"} 1700000060
+`
+	assert.Equal(t, expected, out.String())
+}
@@ -0,0 +1,181 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/rs/zerolog"
+	up "go.mau.fi/util/configupgrade"
+	"go.mau.fi/util/ptr"
+	"gopkg.in/yaml.v3"
+)
+
+// ReloadConfig re-reads the network section of the config file at ConfigPath and applies the options
+// that can be changed without restarting. Options that need a restart are left as-is and logged.
+//
+// Returns the list of options that were changed.
+func (s *SlackConnector) ReloadConfig(ctx context.Context) ([]string, error) {
+	if s.ConfigPath == "" {
+		return nil, errors.New("config path not known")
+	}
+	data, err := os.ReadFile(s.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var fullConfig struct {
+		Network yaml.Node `yaml:"network"`
+	}
+	err = yaml.Unmarshal(data, &fullConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	newConfig, err := upgradeNetworkConfig(&fullConfig.Network)
+	if err != nil {
+		return nil, err
+	}
+	newThrottle, err := NewBackfillThrottle(newConfig.Backfill)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backfill schedule: %w", err)
+	}
+	return s.applyConfig(ctx, newConfig, newThrottle), nil
+}
+
+// upgradeNetworkConfig runs the network section of the config through the same upgrader that's used at startup,
+// so that options missing from older config files get their default values instead of zero values.
+func upgradeNetworkConfig(network *yaml.Node) (*Config, error) {
+	var base yaml.Node
+	err := yaml.Unmarshal([]byte(ExampleConfig), &base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse example config: %w", err)
+	}
+	if network.Kind == 0 {
+		network = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	up.SimpleUpgrader(upgradeConfig).DoUpgrade(up.NewHelper(&base, network))
+	var cfg Config
+	err = base.Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse network config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// currentConfig returns the current config, taking reloads into account.
+// The returned config must not be modified.
+func (s *SlackConnector) currentConfig() *Config {
+	if cfg := s.reloadedConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &s.Config
+}
+
+func (s *SlackConnector) applyConfig(ctx context.Context, newConfig *Config, newThrottle *BackfillThrottle) []string {
+	log := zerolog.Ctx(ctx)
+	// Apply the changes to a copy of the current config, which replaces it atomically at the end
+	oldConfig := ptr.Clone(s.currentConfig())
+	var changed []string
+	reload := func(name string, dst, src any) {
+		dstVal, srcVal := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+		if !reflect.DeepEqual(dstVal.Interface(), srcVal.Interface()) {
+			dstVal.Set(srcVal)
+			changed = append(changed, name)
+		}
+	}
+	needsRestart := func(name string, oldVal, newVal any) {
+		if !reflect.DeepEqual(oldVal, newVal) {
+			log.Warn().Str("option", name).Msg("Config option changed, but it requires a restart to take effect")
+		}
+	}
+
+	if oldConfig.DisplaynameTemplate != newConfig.DisplaynameTemplate {
+		oldConfig.displaynameTemplate = newConfig.displaynameTemplate
+	}
+	if oldConfig.ChannelNameTemplate != newConfig.ChannelNameTemplate {
+		oldConfig.channelNameTemplate = newConfig.channelNameTemplate
+	}
+	if oldConfig.TeamNameTemplate != newConfig.TeamNameTemplate {
+		oldConfig.teamNameTemplate = newConfig.teamNameTemplate
+	}
+	if oldConfig.Timezone != newConfig.Timezone {
+		oldConfig.timezone = newConfig.timezone
+	}
+	reload("displayname_template", &oldConfig.DisplaynameTemplate, &newConfig.DisplaynameTemplate)
+	reload("displayname_source", &oldConfig.DisplaynameSource, &newConfig.DisplaynameSource)
+	reload("channel_name_template", &oldConfig.ChannelNameTemplate, &newConfig.ChannelNameTemplate)
+	reload("team_name_template", &oldConfig.TeamNameTemplate, &newConfig.TeamNameTemplate)
+	reload("custom_emoji_reactions", &oldConfig.CustomEmojiReactions, &newConfig.CustomEmojiReactions)
+	reload("workspace_avatar_in_rooms", &oldConfig.WorkspaceAvatarInRooms, &newConfig.WorkspaceAvatarInRooms)
+	reload("participant_sync_count", &oldConfig.ParticipantSyncCount, &newConfig.ParticipantSyncCount)
+	reload("participant_sync_only_on_create", &oldConfig.ParticipantSyncOnlyOnCreate, &newConfig.ParticipantSyncOnlyOnCreate)
+	reload("mute_channels_by_default", &oldConfig.MuteChannelsByDefault, &newConfig.MuteChannelsByDefault)
+	reload("slackbot_reminders_in_threads", &oldConfig.SlackbotRemindersInThreads, &newConfig.SlackbotRemindersInThreads)
+	reload("thread_summaries", &oldConfig.ThreadSummaries, &newConfig.ThreadSummaries)
+	reload("emoji_room_pack", &oldConfig.EmojiRoomPack, &newConfig.EmojiRoomPack)
+	reload("upload_matrix_emojis", &oldConfig.UploadMatrixEmojis, &newConfig.UploadMatrixEmojis)
+	reload("channel_aliases", &oldConfig.ChannelAliases, &newConfig.ChannelAliases)
+	reload("bridge_own_messages", &oldConfig.BridgeOwnMessages, &newConfig.BridgeOwnMessages)
+	reload("sync_drafts", &oldConfig.SyncDrafts, &newConfig.SyncDrafts)
+	reload("encrypt_converted_channels", &oldConfig.EncryptConvertedChannels, &newConfig.EncryptConvertedChannels)
+	reload("edit_conflict_check", &oldConfig.EditConflictCheck, &newConfig.EditConflictCheck)
+	reload("typing_in_channels", &oldConfig.TypingInChannels, &newConfig.TypingInChannels)
+	reload("unbridged_notifications", &oldConfig.UnbridgedNotifications, &newConfig.UnbridgedNotifications)
+	reload("emoji_sync_workers", &oldConfig.EmojiSyncWorkers, &newConfig.EmojiSyncWorkers)
+	reload("encryption_policy", &oldConfig.EncryptionPolicy, &newConfig.EncryptionPolicy)
+	reload("leave_portal_behavior", &oldConfig.LeavePortalBehavior, &newConfig.LeavePortalBehavior)
+	reload("closed_dm_behavior", &oldConfig.ClosedDMBehavior, &newConfig.ClosedDMBehavior)
+	reload("new_channel_portals", &oldConfig.NewChannelPortals, &newConfig.NewChannelPortals)
+	reload("timezone", &oldConfig.Timezone, &newConfig.Timezone)
+	reload("metadata_refresh_interval", &oldConfig.MetadataRefreshInterval, &newConfig.MetadataRefreshInterval)
+	reload("power_levels", &oldConfig.PowerLevels, &newConfig.PowerLevels)
+	reload("audit_log", &oldConfig.AuditLog, &newConfig.AuditLog)
+	reload("events_api", &oldConfig.EventsAPI, &newConfig.EventsAPI)
+	reload("translation.target_language", &oldConfig.Translation.TargetLanguage, &newConfig.Translation.TargetLanguage)
+	reload("image_processing", &oldConfig.ImageProcessing, &newConfig.ImageProcessing)
+	if !reflect.DeepEqual(oldConfig.Backfill, newConfig.Backfill) {
+		oldConfig.Backfill = newConfig.Backfill
+		// Backfills that already acquired a slot from the old throttle will finish normally
+		s.backfillThrottle.Store(newThrottle)
+		changed = append(changed, "backfill")
+	}
+	if !reflect.DeepEqual(oldConfig.GhostSync, newConfig.GhostSync) {
+		oldConfig.GhostSync = newConfig.GhostSync
+		s.ghostUpdateLimiter.Store(NewGhostUpdateLimiter(newConfig.GhostSync))
+		changed = append(changed, "ghost_sync")
+	}
+
+	needsRestart("sync_workers", oldConfig.SyncWorkers, newConfig.SyncWorkers)
+	needsRestart("event_queue_size", oldConfig.EventQueueSize, newConfig.EventQueueSize)
+	needsRestart("portal_check_interval", oldConfig.PortalCheckInterval, newConfig.PortalCheckInterval)
+	needsRestart("startup_sync", oldConfig.StartupSync, newConfig.StartupSync)
+	needsRestart("tracing", oldConfig.Tracing, newConfig.Tracing)
+	needsRestart("sharding", oldConfig.Sharding, newConfig.Sharding)
+	needsRestart("token_encryption_key", oldConfig.TokenEncryptionKey, newConfig.TokenEncryptionKey)
+	needsRestart("secret_backend", oldConfig.SecretBackend, newConfig.SecretBackend)
+	needsRestart("translation.backend", oldConfig.Translation.Backend, newConfig.Translation.Backend)
+	needsRestart("translation.url", oldConfig.Translation.URL, newConfig.Translation.URL)
+	needsRestart("translation.api_key", oldConfig.Translation.APIKey, newConfig.Translation.APIKey)
+
+	s.reloadedConfig.Store(oldConfig)
+	s.MsgConv.SetOptions(oldConfig.converterOptions())
+	log.Info().Strs("changed_options", changed).Msg("Reloaded config")
+	return changed
+}
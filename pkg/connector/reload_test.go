@@ -0,0 +1,92 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"go.mau.fi/mautrix-slack/pkg/msgconv"
+)
+
+func TestReloadConfig(t *testing.T) {
+	const oldConfig = `
+network:
+    displayname_template: '{{ .Name }}'
+    channel_name_template: '#{{ .Name }}'
+    team_name_template: '{{ .Name }}'
+    custom_emoji_reactions: true
+    sync_workers: 4
+    backfill:
+        messages_per_second: 0
+`
+	const newConfig = `
+network:
+    displayname_template: '{{ .Name }} (Slack)'
+    channel_name_template: '#{{ .Name }}'
+    team_name_template: '{{ .Name }}'
+    custom_emoji_reactions: false
+    sync_workers: 8
+    backfill:
+        messages_per_second: 5
+`
+	var initial struct {
+		Network yaml.Node `yaml:"network"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(oldConfig), &initial))
+	initialConfig, err := upgradeNetworkConfig(&initial.Network)
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(newConfig), 0600))
+	s := &SlackConnector{Config: *initialConfig, ConfigPath: configPath, MsgConv: &msgconv.MessageConverter{}}
+
+	changed, err := s.ReloadConfig(context.Background())
+	require.NoError(t, err)
+	// Options missing from the file keep their defaults instead of being reset to zero values
+	assert.Equal(t, initialConfig.ImageProcessing, s.currentConfig().ImageProcessing)
+	assert.Equal(t, s.currentConfig().ImageProcessing, s.MsgConv.Options().ImageProcessing)
+	assert.ElementsMatch(t, []string{"displayname_template", "custom_emoji_reactions", "backfill"}, changed)
+	cfg := s.currentConfig()
+	assert.Equal(t, "alice (Slack)", cfg.FormatDisplayname(&DisplaynameParams{User: &slack.User{Name: "alice"}}))
+	assert.False(t, cfg.CustomEmojiReactions)
+	assert.Equal(t, 4, cfg.SyncWorkers)
+	require.NotNil(t, s.backfillThrottle.Load())
+	assert.NotZero(t, s.backfillThrottle.Load().perMessage)
+	// The config that was loaded at startup is never modified
+	assert.True(t, s.Config.CustomEmojiReactions)
+
+	changed, err = s.ReloadConfig(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	require.NoError(t, os.WriteFile(configPath, []byte("network:\n    displayname_template: '{{ .Name'\n"), 0600))
+	_, err = s.ReloadConfig(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, "alice (Slack)", s.currentConfig().FormatDisplayname(&DisplaynameParams{User: &slack.User{Name: "alice"}}))
+
+	require.NoError(t, os.WriteFile(configPath, []byte("network:\n    leave_portal_behavior: archvie\n"), 0600))
+	_, err = s.ReloadConfig(context.Background())
+	assert.ErrorContains(t, err, "invalid leave_portal_behavior")
+	assert.Equal(t, initialConfig.LeavePortalBehavior, s.currentConfig().LeavePortalBehavior)
+}
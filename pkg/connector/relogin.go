@@ -0,0 +1,65 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+var errReloginMismatch = errors.New("the new tokens are for a different account")
+
+// checkReloginTarget makes sure that re-authenticating an existing login doesn't switch it to another account,
+// which would make bridgev2 delete the old login along with its portals.
+func checkReloginTarget(override *bridgev2.UserLogin, newLoginID networkid.UserLoginID) error {
+	if override != nil && override.ID != newLoginID {
+		return fmt.Errorf("%w (got %s, expected %s)", errReloginMismatch, newLoginID, override.ID)
+	}
+	return nil
+}
+
+// existingLogin returns the login of the user with the given ID, which a new login with the same ID will reuse.
+func existingLogin(user *bridgev2.User, loginID networkid.UserLoginID) *bridgev2.UserLogin {
+	existing := user.Bridge.GetCachedUserLoginByID(loginID)
+	if existing == nil || existing.UserMXID != user.MXID {
+		return nil
+	}
+	return existing
+}
+
+// replacedClient returns the client of the existing login with the given ID, if any. bridgev2 replaces the client
+// when the login is reused, so the old one has to be captured before calling NewLogin.
+func replacedClient(user *bridgev2.User, loginID networkid.UserLoginID) *SlackClient {
+	if existing := existingLogin(user, loginID); existing != nil {
+		client, _ := existing.Client.(*SlackClient)
+		return client
+	}
+	return nil
+}
+
+// disconnectReplacedClient disconnects the client of an existing login after it was replaced by a relogin,
+// so that the old connection doesn't keep running alongside the new one. It must only be called after NewLogin
+// succeeded, so that a failed or abandoned relogin leaves the existing login connected.
+func disconnectReplacedClient(client *SlackClient) {
+	if client != nil && client.IsLoggedIn() {
+		client.UserLogin.Log.Debug().Msg("Disconnecting old client after re-login")
+		client.disconnectNow()
+	}
+}
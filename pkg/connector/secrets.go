@@ -0,0 +1,234 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+const (
+	SecretBackendDatabase = "database"
+	SecretBackendVault    = "vault"
+)
+
+// LoginCredentials are the secrets of a login that are kept in the secret backend instead of the bridge database.
+type LoginCredentials struct {
+	Token       string `json:"token"`
+	CookieToken string `json:"cookie_token,omitempty"`
+	AppToken    string `json:"app_token,omitempty"`
+	UserToken   string `json:"user_token,omitempty"`
+}
+
+func credentialsFromMetadata(meta *slackid.UserLoginMetadata) *LoginCredentials {
+	return &LoginCredentials{
+		Token:       meta.Token,
+		CookieToken: meta.CookieToken,
+		AppToken:    meta.AppToken,
+		UserToken:   meta.UserToken,
+	}
+}
+
+func (lc *LoginCredentials) apply(meta *slackid.UserLoginMetadata) {
+	meta.Token = lc.Token
+	meta.CookieToken = lc.CookieToken
+	meta.AppToken = lc.AppToken
+	meta.UserToken = lc.UserToken
+}
+
+func (lc *LoginCredentials) isEmpty() bool {
+	return lc.Token == "" && lc.CookieToken == "" && lc.AppToken == "" && lc.UserToken == ""
+}
+
+// SecretBackend stores the credentials of logins in an external secret manager.
+type SecretBackend interface {
+	// GetCredentials returns the stored credentials of the login, or nil if there are none.
+	GetCredentials(ctx context.Context, loginID networkid.UserLoginID) (*LoginCredentials, error)
+	PutCredentials(ctx context.Context, loginID networkid.UserLoginID, creds *LoginCredentials) error
+	DeleteCredentials(ctx context.Context, loginID networkid.UserLoginID) error
+}
+
+// newSecretBackend creates the secret backend selected in the config, or returns nil if credentials are kept in the database.
+func newSecretBackend(cfg SecretBackendConfig) (SecretBackend, error) {
+	switch cfg.Type {
+	case "", SecretBackendDatabase:
+		return nil, nil
+	case SecretBackendVault:
+		return NewVaultSecretBackend(cfg.Vault)
+	default:
+		return nil, fmt.Errorf("unknown secret backend %q", cfg.Type)
+	}
+}
+
+// loadExternalCredentials fills the credentials of a login from the secret backend.
+//
+// If the metadata already has credentials, they're either from a new login or from the database before
+// the secret backend was enabled. In both cases they're written to the backend instead, and removed from
+// the database by saving the login.
+func (s *SlackConnector) loadExternalCredentials(ctx context.Context, login *bridgev2.UserLogin, meta *slackid.UserLoginMetadata) error {
+	if meta.Token != "" {
+		err := s.secrets.PutCredentials(ctx, login.ID, credentialsFromMetadata(meta))
+		if err != nil {
+			return fmt.Errorf("failed to store credentials in secret backend: %w", err)
+		} else if meta.HasStoredSecrets() {
+			err = login.Save(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to remove credentials from database: %w", err)
+			}
+			login.Log.Info().Msg("Moved credentials from database to secret backend")
+		}
+		return nil
+	}
+	creds, err := s.secrets.GetCredentials(ctx, login.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get credentials from secret backend: %w", err)
+	} else if creds != nil {
+		creds.apply(meta)
+	}
+	return nil
+}
+
+// storeExternalCredentials writes the current credentials of a login to the secret backend, if one is used.
+func (s *SlackConnector) storeExternalCredentials(ctx context.Context, login *bridgev2.UserLogin) error {
+	if s.secrets == nil {
+		return nil
+	}
+	creds := credentialsFromMetadata(login.Metadata.(*slackid.UserLoginMetadata))
+	if creds.isEmpty() {
+		return s.secrets.DeleteCredentials(ctx, login.ID)
+	}
+	return s.secrets.PutCredentials(ctx, login.ID, creds)
+}
+
+// VaultSecretBackend stores credentials in a HashiCorp Vault KV version 2 secrets engine.
+type VaultSecretBackend struct {
+	HTTP       *http.Client
+	Address    string
+	Token      string
+	Namespace  string
+	Mount      string
+	PathPrefix string
+}
+
+var _ SecretBackend = (*VaultSecretBackend)(nil)
+
+// NewVaultSecretBackend creates a Vault secret backend. The token defaults to the VAULT_TOKEN environment variable.
+func NewVaultSecretBackend(cfg VaultConfig) (*VaultSecretBackend, error) {
+	vsb := &VaultSecretBackend{
+		HTTP:       &http.Client{Timeout: 30 * time.Second},
+		Address:    strings.TrimRight(cfg.Address, "/"),
+		Token:      cfg.Token,
+		Namespace:  cfg.Namespace,
+		Mount:      strings.Trim(cfg.Mount, "/"),
+		PathPrefix: strings.Trim(cfg.PathPrefix, "/"),
+	}
+	if vsb.Token == "" {
+		vsb.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if vsb.Address == "" {
+		return nil, fmt.Errorf("vault address is not set")
+	} else if vsb.Token == "" {
+		return nil, fmt.Errorf("vault token is not set")
+	} else if vsb.Mount == "" {
+		vsb.Mount = "secret"
+	}
+	return vsb, nil
+}
+
+func (vsb *VaultSecretBackend) makeURL(kind string, loginID networkid.UserLoginID) string {
+	path := url.PathEscape(string(loginID))
+	if vsb.PathPrefix != "" {
+		path = vsb.PathPrefix + "/" + path
+	}
+	return fmt.Sprintf("%s/v1/%s/%s/%s", vsb.Address, vsb.Mount, kind, path)
+}
+
+func (vsb *VaultSecretBackend) do(ctx context.Context, method, url string, reqData, respData any) (int, error) {
+	var body bytes.Buffer
+	if reqData != nil {
+		if err := json.NewEncoder(&body).Encode(reqData); err != nil {
+			return 0, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Vault-Token", vsb.Token)
+	req.Header.Set("X-Vault-Request", "true")
+	if vsb.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vsb.Namespace)
+	}
+	if reqData != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := vsb.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	} else if resp.StatusCode >= 300 {
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d from vault: %s", resp.StatusCode, strings.Join(errResp.Errors, ", "))
+	} else if respData != nil {
+		if err = json.NewDecoder(resp.Body).Decode(respData); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode vault response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (vsb *VaultSecretBackend) GetCredentials(ctx context.Context, loginID networkid.UserLoginID) (*LoginCredentials, error) {
+	var resp struct {
+		Data struct {
+			Data *LoginCredentials `json:"data"`
+		} `json:"data"`
+	}
+	status, err := vsb.do(ctx, http.MethodGet, vsb.makeURL("data", loginID), nil, &resp)
+	if err != nil || status == http.StatusNotFound {
+		return nil, err
+	}
+	return resp.Data.Data, nil
+}
+
+func (vsb *VaultSecretBackend) PutCredentials(ctx context.Context, loginID networkid.UserLoginID, creds *LoginCredentials) error {
+	_, err := vsb.do(ctx, http.MethodPost, vsb.makeURL("data", loginID), map[string]any{"data": creds}, nil)
+	return err
+}
+
+// DeleteCredentials permanently deletes all versions of the login's credentials.
+func (vsb *VaultSecretBackend) DeleteCredentials(ctx context.Context, loginID networkid.UserLoginID) error {
+	_, err := vsb.do(ctx, http.MethodDelete, vsb.makeURL("metadata", loginID), nil, nil)
+	return err
+}
@@ -0,0 +1,108 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestVault(t *testing.T) (*VaultSecretBackend, map[string]json.RawMessage) {
+	var lock sync.Mutex
+	secrets := make(map[string]json.RawMessage)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "vault-token", r.Header.Get("X-Vault-Token"))
+		assert.Equal(t, "team-ns", r.Header.Get("X-Vault-Namespace"))
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/kv/data/slack/T1-U1":
+			data, ok := secrets["T1-U1"]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"errors":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"data":` + string(data) + `,"metadata":{"version":1}}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/kv/data/slack/T1-U1":
+			var req struct {
+				Data json.RawMessage `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			secrets["T1-U1"] = req.Data
+			_, _ = w.Write([]byte(`{"data":{"version":1}}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/kv/metadata/slack/T1-U1":
+			delete(secrets, "T1-U1")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	vsb, err := NewVaultSecretBackend(VaultConfig{
+		Address:    srv.URL + "/",
+		Token:      "vault-token",
+		Namespace:  "team-ns",
+		Mount:      "kv",
+		PathPrefix: "/slack/",
+	})
+	require.NoError(t, err)
+	return vsb, secrets
+}
+
+func TestVaultSecretBackend(t *testing.T) {
+	vsb, secrets := newTestVault(t)
+	ctx := context.Background()
+
+	creds, err := vsb.GetCredentials(ctx, "T1-U1")
+	require.NoError(t, err)
+	assert.Nil(t, creds)
+
+	require.NoError(t, vsb.PutCredentials(ctx, "T1-U1", &LoginCredentials{Token: "xoxc-secret", CookieToken: "xoxd-secret"}))
+	assert.JSONEq(t, `{"token":"xoxc-secret","cookie_token":"xoxd-secret"}`, string(secrets["T1-U1"]))
+	creds, err = vsb.GetCredentials(ctx, "T1-U1")
+	require.NoError(t, err)
+	assert.Equal(t, &LoginCredentials{Token: "xoxc-secret", CookieToken: "xoxd-secret"}, creds)
+
+	require.NoError(t, vsb.DeleteCredentials(ctx, "T1-U1"))
+	assert.Empty(t, secrets)
+
+	_, err = vsb.GetCredentials(ctx, "T2-U1")
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestNewSecretBackend(t *testing.T) {
+	backend, err := newSecretBackend(SecretBackendConfig{Type: SecretBackendDatabase})
+	assert.NoError(t, err)
+	assert.Nil(t, backend)
+	t.Setenv("VAULT_TOKEN", "")
+	_, err = newSecretBackend(SecretBackendConfig{Type: SecretBackendVault, Vault: VaultConfig{Address: "https://vault.example.com"}})
+	assert.ErrorContains(t, err, "vault token is not set")
+	t.Setenv("VAULT_TOKEN", "env-token")
+	backend, err = newSecretBackend(SecretBackendConfig{Type: SecretBackendVault, Vault: VaultConfig{Address: "https://vault.example.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, "env-token", backend.(*VaultSecretBackend).Token)
+	assert.Equal(t, "secret", backend.(*VaultSecretBackend).Mount)
+}
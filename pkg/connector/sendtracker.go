@@ -0,0 +1,88 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ShutdownDrainTimeout is the maximum time to wait for in-flight Matrix->Slack sends when disconnecting.
+const ShutdownDrainTimeout = 30 * time.Second
+
+var ErrShuttingDown = errors.New("bridge is shutting down")
+
+// sendTracker keeps track of in-flight Matrix->Slack sends (including file uploads),
+// so that disconnecting can wait for them instead of losing them midway.
+type sendTracker struct {
+	lock     sync.Mutex
+	active   int
+	draining bool
+	idle     chan struct{}
+}
+
+// start marks a send as in-flight. The returned function must be called when the send is done.
+// Returns ErrShuttingDown if the tracker is draining and not accepting new sends.
+func (st *sendTracker) start() (func(), error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if st.draining {
+		return nil, ErrShuttingDown
+	}
+	st.active++
+	return st.finish, nil
+}
+
+func (st *sendTracker) finish() {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.active--
+	if st.active == 0 && st.idle != nil {
+		close(st.idle)
+		st.idle = nil
+	}
+}
+
+// drain stops accepting new sends and waits until all in-flight sends are done or the context is canceled.
+func (st *sendTracker) drain(ctx context.Context) error {
+	st.lock.Lock()
+	st.draining = true
+	if st.active == 0 {
+		st.lock.Unlock()
+		return nil
+	}
+	if st.idle == nil {
+		st.idle = make(chan struct{})
+	}
+	idle := st.idle
+	st.lock.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reset allows new sends again after a drain.
+func (st *sendTracker) reset() {
+	st.lock.Lock()
+	st.draining = false
+	st.lock.Unlock()
+}
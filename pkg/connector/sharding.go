@@ -0,0 +1,302 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+)
+
+var errOtherShard = errors.New("team is handled by another bridge instance")
+
+var errNotShardOwner = bridgev2.WrapErrorInStatus(errOtherShard).
+	WithStatus(event.MessageStatusRetriable).
+	WithErrorReason(event.MessageStatusNetworkError).
+	WithMessage("This Slack workspace is currently handled by another bridge instance").
+	WithIsCertain(true).
+	WithSendNotice(true)
+
+// teamShard returns the shard that the logins of the given team belong to.
+func teamShard(teamID string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(teamID))
+	return int(h.Sum32() % uint32(shards))
+}
+
+func (s *SlackConnector) shardingEnabled() bool {
+	return s.Config.Sharding.Shards > 1
+}
+
+// ownsTeam returns true if this bridge instance should connect the logins of the given team.
+func (s *SlackConnector) ownsTeam(teamID string) bool {
+	if !s.shardingEnabled() {
+		return true
+	}
+	s.shardLock.RLock()
+	defer s.shardLock.RUnlock()
+	_, ok := s.ownedShards[teamShard(teamID, s.Config.Sharding.Shards)]
+	return ok
+}
+
+func makeShardOwnerID() string {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "unknown"
+	}
+	return hostname + "-" + random.String(8)
+}
+
+func (s *SlackConnector) startSharding(ctx context.Context) error {
+	s.shardOwner = makeShardOwnerID()
+	s.ownedShards = make(map[int]struct{})
+	log := s.br.Log.With().Str("action", "shard leases").Str("shard_owner", s.shardOwner).Logger()
+	ctx = log.WithContext(ctx)
+	// Logins haven't been loaded yet, so the first round only needs to claim shards
+	if _, _, err := s.updateShardLeases(ctx); err != nil {
+		return err
+	}
+	var loopCtx context.Context
+	loopCtx, s.stopSharding = context.WithCancel(log.WithContext(context.Background()))
+	go s.runShardLeaseLoop(loopCtx)
+	return nil
+}
+
+func (s *SlackConnector) runShardLeaseLoop(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	ticker := time.NewTicker(s.Config.Sharding.LeaseTTL / 3)
+	defer ticker.Stop()
+	lastRenewed := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			gained, lost, err := s.updateShardLeases(ctx)
+			if err != nil {
+				log.Err(err).Msg("Failed to update shard leases")
+				if time.Since(lastRenewed) > s.Config.Sharding.LeaseTTL {
+					// The leases have expired, so another instance may be connecting the logins already
+					_, lost = s.setOwnedShards(make(map[int]struct{}))
+					lastRenewed = time.Now()
+				}
+			} else {
+				lastRenewed = time.Now()
+			}
+			if len(gained) > 0 || len(lost) > 0 {
+				log.Info().Ints("gained_shards", gained).Ints("lost_shards", lost).Msg("Shard ownership changed")
+				s.reconnectShardLogins(ctx, gained, lost)
+				// Other instances can only take over the shards after the logins here have stopped
+				s.releaseShards(ctx, lost)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// updateShardLeases renews the leases of this instance and claims free shards, so that the shards are spread
+// evenly between all instances with a live heartbeat. Shards above the fair share are returned as lost, but their
+// leases are kept until releaseShards is called after the logins of the shards have been disconnected.
+func (s *SlackConnector) updateShardLeases(ctx context.Context) (gained, lost []int, err error) {
+	shards := s.Config.Sharding.Shards
+	now := time.Now()
+	expiresAt := now.Add(s.Config.Sharding.LeaseTTL)
+	if err = s.DB.Shards.Heartbeat(ctx, s.shardOwner, expiresAt); err != nil {
+		return nil, nil, err
+	}
+	instances, err := s.DB.Shards.GetInstances(ctx)
+	if err != nil {
+		return nil, nil, err
+	} else if !slices.Contains(instances, s.shardOwner) {
+		instances = append(instances, s.shardOwner)
+	}
+	leases, err := s.DB.Shards.GetAll(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	currentOwners := make(map[int]string, len(leases))
+	for _, lease := range leases {
+		if lease.ExpiresAt.After(now) || lease.Owner == s.shardOwner {
+			currentOwners[lease.Shard] = lease.Owner
+		}
+	}
+	fairShare := (shards + len(instances) - 1) / len(instances)
+	newOwned := make(map[int]struct{}, fairShare)
+	for shard := 0; shard < shards; shard++ {
+		if currentOwners[shard] != s.shardOwner {
+			continue
+		} else if len(newOwned) >= fairShare {
+			continue
+		}
+		if ok, err := s.DB.Shards.Acquire(ctx, shard, s.shardOwner, expiresAt); err != nil {
+			return nil, nil, err
+		} else if ok {
+			newOwned[shard] = struct{}{}
+		}
+	}
+	for shard := 0; shard < shards && len(newOwned) < fairShare; shard++ {
+		if _, taken := currentOwners[shard]; taken {
+			continue
+		}
+		if ok, err := s.DB.Shards.Acquire(ctx, shard, s.shardOwner, expiresAt); err != nil {
+			return nil, nil, err
+		} else if ok {
+			newOwned[shard] = struct{}{}
+		}
+	}
+	gained, lost = s.setOwnedShards(newOwned)
+	return
+}
+
+// setOwnedShards replaces the set of owned shards and returns the differences to the previous set.
+func (s *SlackConnector) setOwnedShards(newOwned map[int]struct{}) (gained, lost []int) {
+	s.shardLock.Lock()
+	defer s.shardLock.Unlock()
+	for shard := range s.ownedShards {
+		if _, ok := newOwned[shard]; !ok {
+			lost = append(lost, shard)
+		}
+	}
+	for shard := range newOwned {
+		if _, ok := s.ownedShards[shard]; !ok {
+			gained = append(gained, shard)
+		}
+	}
+	slices.Sort(gained)
+	slices.Sort(lost)
+	s.ownedShards = newOwned
+	return
+}
+
+// releaseShards gives up the leases of the given shards, so that other instances can claim them right away.
+func (s *SlackConnector) releaseShards(ctx context.Context, shards []int) {
+	for _, shard := range shards {
+		if err := s.DB.Shards.Release(ctx, shard, s.shardOwner); err != nil {
+			zerolog.Ctx(ctx).Err(err).Int("shard", shard).Msg("Failed to release shard lease")
+		}
+	}
+}
+
+// reconnectShardLogins connects the loaded logins of gained shards and disconnects the ones of lost shards.
+// Disconnecting discards the Slack client, so the logins of lost shards are reloaded to have a fresh client
+// ready for when the shard is gained back. The cached portals and ghosts of the affected teams are refreshed,
+// as another instance handles the teams while this one doesn't own their shards.
+func (s *SlackConnector) reconnectShardLogins(ctx context.Context, gained, lost []int) {
+	userIDs, err := s.br.DB.UserLogin.GetAllUserIDsWithLogins(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get users with logins to apply shard changes")
+		return
+	}
+	changedTeams := make(map[string]struct{})
+	var toConnect []*SlackClient
+	for _, userID := range userIDs {
+		user, err := s.br.GetExistingUserByMXID(ctx, userID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to get user to apply shard changes")
+			continue
+		} else if user == nil {
+			continue
+		}
+		for _, login := range user.GetUserLogins() {
+			client, ok := login.Client.(*SlackClient)
+			if !ok {
+				continue
+			}
+			shard := teamShard(client.TeamID, s.Config.Sharding.Shards)
+			if slices.Contains(lost, shard) {
+				client.disconnectNow()
+				if err = s.LoadUserLogin(ctx, login); err != nil {
+					login.Log.Err(err).Msg("Failed to reload login after losing shard")
+				}
+				changedTeams[client.TeamID] = struct{}{}
+			} else if slices.Contains(gained, shard) {
+				toConnect = append(toConnect, client)
+				changedTeams[client.TeamID] = struct{}{}
+			}
+		}
+	}
+	for teamID := range changedTeams {
+		s.refreshTeamCache(ctx, teamID)
+	}
+	for _, client := range toConnect {
+		go client.Connect(client.UserLogin.Log.WithContext(context.Background()))
+	}
+}
+
+// refreshTeamCache reloads the cached portals and ghosts of the given team from the database, so that changes
+// made by the instance that owned the team in the meantime aren't overwritten with stale data. bridgev2 can't
+// evict single entries from its caches, so the cached structs are updated in place instead.
+func (s *SlackConnector) refreshTeamCache(ctx context.Context, teamID string) {
+	log := zerolog.Ctx(ctx).With().Str("team_id", teamID).Logger()
+	portalKeys, err := s.getTeamPortalKeys(ctx, teamID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get portals to refresh after shard change")
+	}
+	for _, key := range portalKeys {
+		portal, err := s.br.GetExistingPortalByKey(ctx, key)
+		if err != nil || portal == nil {
+			continue
+		}
+		dbPortal, err := s.br.DB.Portal.GetByKey(ctx, key)
+		if err != nil {
+			log.Err(err).Stringer("portal_key", key).Msg("Failed to refresh portal after shard change")
+		} else if dbPortal != nil {
+			*portal.Portal = *dbPortal
+		}
+	}
+	ghostIDs, err := scanGhostID.NewRowIter(s.br.DB.Query(ctx, getTeamGhostIDsQuery, s.br.ID, strings.ToLower(teamID)+"-%")).AsList()
+	if err != nil {
+		log.Err(err).Msg("Failed to get ghosts to refresh after shard change")
+		return
+	}
+	for _, ghostID := range ghostIDs {
+		ghost, err := s.br.GetExistingGhostByID(ctx, ghostID)
+		if err != nil || ghost == nil {
+			continue
+		}
+		dbGhost, err := s.br.DB.Ghost.GetByID(ctx, ghostID)
+		if err != nil {
+			log.Err(err).Str("ghost_id", string(ghostID)).Msg("Failed to refresh ghost after shard change")
+		} else if dbGhost != nil {
+			*ghost.Ghost = *dbGhost
+		}
+	}
+}
+
+// stopShardingAndRelease stops renewing leases and releases the owned shards, so that other instances can
+// take them over without waiting for the leases to expire.
+func (s *SlackConnector) stopShardingAndRelease() {
+	if s.stopSharding == nil {
+		return
+	}
+	s.stopSharding()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, lost := s.setOwnedShards(make(map[int]struct{}))
+	s.releaseShards(s.br.Log.WithContext(ctx), lost)
+	if err := s.DB.Shards.RemoveInstance(ctx, s.shardOwner); err != nil {
+		s.br.Log.Err(err).Msg("Failed to remove shard instance")
+	}
+}
@@ -0,0 +1,125 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/bridgev2"
+
+	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+)
+
+func TestTeamShard(t *testing.T) {
+	assert.Equal(t, teamShard("T0123ABCD", 4), teamShard("T0123ABCD", 4))
+	seen := make(map[int]bool)
+	for _, teamID := range []string{"T1", "T2", "T3", "T4", "T5", "T6", "T7", "T8", "T9", "T10"} {
+		shard := teamShard(teamID, 3)
+		assert.GreaterOrEqual(t, shard, 0)
+		assert.Less(t, shard, 3)
+		seen[shard] = true
+	}
+	assert.Len(t, seen, 3, "ten teams should hit all three shards")
+}
+
+func newTestShardedConnector(db *slackdb.SlackDB, owner string) *SlackConnector {
+	return &SlackConnector{
+		br: &bridgev2.Bridge{Log: zerolog.Nop()},
+		DB: db,
+		Config: Config{Sharding: ShardingConfig{
+			Shards:   4,
+			LeaseTTL: time.Minute,
+		}},
+		shardOwner:  owner,
+		ownedShards: make(map[int]struct{}),
+	}
+}
+
+func TestUpdateShardLeases(t *testing.T) {
+	rawDB, err := dbutil.NewWithDialect("file::memory:", "sqlite3")
+	require.NoError(t, err)
+	rawDB.RawDB.SetMaxOpenConns(1)
+	defer rawDB.Close()
+	db := slackdb.New(rawDB, zerolog.Nop())
+	ctx := context.Background()
+	require.NoError(t, db.Upgrade(ctx))
+
+	a := newTestShardedConnector(db, "a")
+	b := newTestShardedConnector(db, "b")
+
+	gained, lost, err := a.updateShardLeases(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, gained)
+	assert.Empty(t, lost)
+
+	// b only sees taken shards, but its presence makes a give up half of them on the next round
+	gained, _, err = b.updateShardLeases(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, gained)
+	_, lost, err = a.updateShardLeases(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, lost)
+	// The shards aren't handed over until a has stopped the logins and released them
+	gained, _, err = b.updateShardLeases(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, gained)
+	a.releaseShards(ctx, lost)
+	gained, _, err = b.updateShardLeases(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, gained)
+
+	for _, teamID := range []string{"T1", "T2", "T3", "T4", "T5"} {
+		assert.NotEqual(t, a.ownsTeam(teamID), b.ownsTeam(teamID), "team %s must be owned by exactly one instance", teamID)
+	}
+
+	// Renewing is stable
+	gained, lost, err = a.updateShardLeases(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, gained)
+	assert.Empty(t, lost)
+
+	// When b stops, a takes over its shards
+	b.stopSharding = func() {}
+	b.stopShardingAndRelease()
+	gained, _, err = a.updateShardLeases(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, gained)
+}
+
+func TestCheckLoggedIn_OnlyOwnedShards(t *testing.T) {
+	s := newTestSlackClient(slackapitest.NewServer(t).Client())
+	s.Main = newTestShardedConnector(nil, "a")
+	assert.False(t, s.IsLoggedIn())
+	assert.ErrorIs(t, s.checkLoggedIn(), errOtherShard)
+	_, err := s.HandleMatrixMessage(context.Background(), &bridgev2.MatrixMessage{})
+	assert.ErrorIs(t, err, errOtherShard)
+
+	s.Main.setOwnedShards(map[int]struct{}{teamShard(s.TeamID, 4): {}})
+	assert.True(t, s.IsLoggedIn())
+	assert.NoError(t, s.checkLoggedIn())
+
+	s.Client = nil
+	assert.ErrorIs(t, s.checkLoggedIn(), bridgev2.ErrNotLoggedIn)
+}
@@ -0,0 +1,105 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+const SlackbotUserID = "USLACKBOT"
+
+var slackPermalinkRegex = regexp.MustCompile(`https://[a-z0-9-]+\.(?:enterprise\.)?slack\.com/archives/([A-Z0-9]+)/p(\d{10})(\d{6})`)
+
+var slackbotReferencePrefixes = []string{
+	"Reminder: ",
+	"You saved ",
+	"You asked me to remind you",
+}
+
+// parseSlackPermalink finds the first message permalink in the given text and returns the channel ID and message timestamp.
+func parseSlackPermalink(text string) (channelID, timestamp string, ok bool) {
+	match := slackPermalinkRegex.FindStringSubmatch(text)
+	if match == nil {
+		return
+	}
+	return match[1], match[2] + "." + match[3], true
+}
+
+func isSlackbotReference(msg *slack.Msg) bool {
+	if msg.User != SlackbotUserID || (msg.SubType != "" && msg.SubType != slack.MsgSubTypeBotMessage) {
+		return false
+	}
+	for _, prefix := range slackbotReferencePrefixes {
+		if strings.HasPrefix(msg.Text, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func findReferencedMessage(msg *slack.Msg) (channelID, timestamp string, ok bool) {
+	if channelID, timestamp, ok = parseSlackPermalink(msg.Text); ok {
+		return
+	}
+	for _, att := range msg.Attachments {
+		if channelID, timestamp, ok = parseSlackPermalink(att.FromURL); ok {
+			return
+		}
+	}
+	return
+}
+
+// rerouteSlackbotReference checks if the given message is a Slackbot reminder or saved item notice that refers to
+// an already bridged message, and if so, moves the event into the portal of the referenced message as a thread reply.
+func (s *SlackClient) rerouteSlackbotReference(ctx context.Context, msg *SlackMessage) {
+	if !s.Main.currentConfig().SlackbotRemindersInThreads || !isSlackbotReference(&msg.Data.Msg) {
+		return
+	}
+	channelID, timestamp, ok := findReferencedMessage(&msg.Data.Msg)
+	if !ok || channelID == msg.Data.Channel {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().
+		Str("referenced_channel_id", channelID).
+		Str("referenced_message_ts", timestamp).
+		Logger()
+	targetID := slackid.MakeMessageID(s.TeamID, channelID, timestamp)
+	target, err := s.Main.br.DB.Message.GetFirstPartByID(ctx, s.UserLogin.ID, targetID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get message referenced by Slackbot")
+		return
+	} else if target == nil {
+		log.Debug().Msg("Message referenced by Slackbot isn't bridged, not rerouting")
+		return
+	}
+	threadRoot := target.ID
+	if target.ThreadRoot != "" {
+		threadRoot = target.ThreadRoot
+	}
+	log.Debug().
+		Object("target_portal_key", target.Room).
+		Msg("Rerouting Slackbot reference into thread of original message")
+	msg.PortalKey = target.Room
+	msg.ThreadRootOverride = threadRoot
+}
@@ -1,4 +1,4 @@
--- v0 -> v2 (compatible with v1+): Latest schema
+-- v0 -> v5 (compatible with v1+): Latest schema
 CREATE TABLE emoji (
     team_id   TEXT NOT NULL,
     emoji_id  TEXT NOT NULL,
@@ -10,3 +10,36 @@ CREATE TABLE emoji (
 );
 
 CREATE INDEX emoji_alias_idx ON emoji (team_id, alias);
+
+CREATE TABLE audit_log (
+    timestamp  BIGINT NOT NULL,
+    action     TEXT   NOT NULL,
+    team_id    TEXT   NOT NULL,
+    login_id   TEXT   NOT NULL,
+    user_mxid  TEXT   NOT NULL,
+    channel_id TEXT   NOT NULL,
+    sender     TEXT   NOT NULL,
+    target     TEXT   NOT NULL
+);
+
+CREATE INDEX audit_log_timestamp_idx ON audit_log (timestamp);
+
+CREATE TABLE shard_lease (
+    shard      INTEGER PRIMARY KEY,
+    owner      TEXT    NOT NULL,
+    expires_at BIGINT  NOT NULL
+);
+
+CREATE TABLE shard_instance (
+    owner      TEXT   PRIMARY KEY,
+    expires_at BIGINT NOT NULL
+);
+
+CREATE TABLE event_cursor (
+    login_id   TEXT   NOT NULL,
+    channel_id TEXT   NOT NULL,
+    last_ts    TEXT   NOT NULL,
+    updated_at BIGINT NOT NULL,
+
+    PRIMARY KEY (login_id, channel_id)
+);
@@ -0,0 +1,13 @@
+-- v3 (compatible with v1+): Add audit log table
+CREATE TABLE audit_log (
+    timestamp  BIGINT NOT NULL,
+    action     TEXT   NOT NULL,
+    team_id    TEXT   NOT NULL,
+    login_id   TEXT   NOT NULL,
+    user_mxid  TEXT   NOT NULL,
+    channel_id TEXT   NOT NULL,
+    sender     TEXT   NOT NULL,
+    target     TEXT   NOT NULL
+);
+
+CREATE INDEX audit_log_timestamp_idx ON audit_log (timestamp);
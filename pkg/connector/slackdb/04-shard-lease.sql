@@ -0,0 +1,11 @@
+-- v4 (compatible with v1+): Add shard lease tables for running multiple bridge instances
+CREATE TABLE shard_lease (
+    shard      INTEGER PRIMARY KEY,
+    owner      TEXT    NOT NULL,
+    expires_at BIGINT  NOT NULL
+);
+
+CREATE TABLE shard_instance (
+    owner      TEXT   PRIMARY KEY,
+    expires_at BIGINT NOT NULL
+);
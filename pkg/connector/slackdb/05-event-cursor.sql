@@ -0,0 +1,9 @@
+-- v5 (compatible with v1+): Add event cursor table for replaying missed events after restarts
+CREATE TABLE event_cursor (
+    login_id   TEXT   NOT NULL,
+    channel_id TEXT   NOT NULL,
+    last_ts    TEXT   NOT NULL,
+    updated_at BIGINT NOT NULL,
+
+    PRIMARY KEY (login_id, channel_id)
+);
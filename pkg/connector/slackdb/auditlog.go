@@ -0,0 +1,128 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackdb
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+type AuditAction string
+
+const (
+	AuditActionLogin         AuditAction = "login"
+	AuditActionLogout        AuditAction = "logout"
+	AuditActionSendToSlack   AuditAction = "send_to_slack"
+	AuditActionEditToSlack   AuditAction = "edit_to_slack"
+	AuditActionDeleteToSlack AuditAction = "delete_to_slack"
+	AuditActionSlackMessage  AuditAction = "slack_message"
+	AuditActionSlackEdit     AuditAction = "slack_edit"
+	AuditActionSlackDelete   AuditAction = "slack_delete"
+)
+
+type AuditLogQuery struct {
+	*dbutil.QueryHelper[*AuditEntry]
+}
+
+func newAuditEntry(_ *dbutil.QueryHelper[*AuditEntry]) *AuditEntry {
+	return &AuditEntry{}
+}
+
+const (
+	insertAuditEntryQuery = `
+		INSERT INTO audit_log (timestamp, action, team_id, login_id, user_mxid, channel_id, sender, target)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	getRecentAuditEntriesQuery = `
+		SELECT timestamp, action, team_id, login_id, user_mxid, channel_id, sender, target
+		FROM audit_log ORDER BY timestamp DESC LIMIT $1
+	`
+	pruneAuditLogQuery      = `DELETE FROM audit_log WHERE timestamp<$1`
+	countTeamAuditLogQuery  = `SELECT COUNT(*) FROM audit_log WHERE team_id=$1`
+	deleteTeamAuditLogQuery = `DELETE FROM audit_log WHERE team_id=$1`
+	countUserAuditLogQuery  = `SELECT COUNT(*) FROM audit_log WHERE team_id=$1 AND sender=$2`
+	deleteUserAuditLogQuery = `DELETE FROM audit_log WHERE team_id=$1 AND sender=$2`
+)
+
+func (alq *AuditLogQuery) Insert(ctx context.Context, entry *AuditEntry) error {
+	return alq.Exec(ctx, insertAuditEntryQuery, entry.sqlVariables()...)
+}
+
+// GetRecent returns the newest entries in the audit log, newest first.
+func (alq *AuditLogQuery) GetRecent(ctx context.Context, limit int) ([]*AuditEntry, error) {
+	return alq.QueryMany(ctx, getRecentAuditEntriesQuery, limit)
+}
+
+// Prune deletes entries older than the given time and returns the number of deleted entries.
+func (alq *AuditLogQuery) Prune(ctx context.Context, before time.Time) (int64, error) {
+	res, err := alq.GetDB().Exec(ctx, pruneAuditLogQuery, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CountTeam returns the number of entries about the given team.
+func (alq *AuditLogQuery) CountTeam(ctx context.Context, teamID string) (count int, err error) {
+	err = alq.GetDB().QueryRow(ctx, countTeamAuditLogQuery, teamID).Scan(&count)
+	return
+}
+
+func (alq *AuditLogQuery) DeleteTeam(ctx context.Context, teamID string) error {
+	return alq.Exec(ctx, deleteTeamAuditLogQuery, teamID)
+}
+
+// CountSender returns the number of entries about actions sent by the given Slack user.
+func (alq *AuditLogQuery) CountSender(ctx context.Context, teamID, userID string) (count int, err error) {
+	err = alq.GetDB().QueryRow(ctx, countUserAuditLogQuery, teamID, userID).Scan(&count)
+	return
+}
+
+func (alq *AuditLogQuery) DeleteSender(ctx context.Context, teamID, userID string) error {
+	return alq.Exec(ctx, deleteUserAuditLogQuery, teamID, userID)
+}
+
+// AuditEntry is a single bridged action. Message contents are never stored, only IDs.
+type AuditEntry struct {
+	Timestamp time.Time
+	Action    AuditAction
+	TeamID    string
+	LoginID   string
+	UserMXID  id.UserID
+	ChannelID string
+	// Sender is the Matrix user ID for actions from Matrix and the Slack user ID for actions from Slack.
+	Sender string
+	// Target is the Slack message timestamp that the action created or affected.
+	Target string
+}
+
+func (ae *AuditEntry) Scan(row dbutil.Scannable) (*AuditEntry, error) {
+	var ts int64
+	err := row.Scan(&ts, &ae.Action, &ae.TeamID, &ae.LoginID, &ae.UserMXID, &ae.ChannelID, &ae.Sender, &ae.Target)
+	if err != nil {
+		return nil, err
+	}
+	ae.Timestamp = time.UnixMilli(ts)
+	return ae, nil
+}
+
+func (ae *AuditEntry) sqlVariables() []any {
+	return []any{ae.Timestamp.UnixMilli(), ae.Action, ae.TeamID, ae.LoginID, ae.UserMXID, ae.ChannelID, ae.Sender, ae.Target}
+}
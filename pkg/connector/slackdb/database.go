@@ -26,7 +26,10 @@ import (
 
 type SlackDB struct {
 	*dbutil.Database
-	Emoji *EmojiQuery
+	Emoji    *EmojiQuery
+	AuditLog *AuditLogQuery
+	Shards   *ShardLeaseQuery
+	Cursors  *EventCursorQuery
 }
 
 var table dbutil.UpgradeTable
@@ -46,5 +49,14 @@ func New(db *dbutil.Database, log zerolog.Logger) *SlackDB {
 			QueryHelper: dbutil.MakeQueryHelper(db, newEmoji),
 			locks:       make(map[string]*sync.Mutex),
 		},
+		AuditLog: &AuditLogQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, newAuditEntry),
+		},
+		Shards: &ShardLeaseQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, newShardLease),
+		},
+		Cursors: &EventCursorQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, newEventCursor),
+		},
 	}
 }
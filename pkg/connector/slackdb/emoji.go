@@ -50,9 +50,23 @@ const (
 	getEmojiByMXCQuery = `
 		SELECT team_id, emoji_id, value, alias, image_mxc FROM emoji WHERE image_mxc=$1 ORDER BY alias NULLS FIRST
 	`
+	getEmojiByTeamAndMXCQuery = `
+		SELECT team_id, emoji_id, value, alias, image_mxc FROM emoji WHERE team_id=$1 AND image_mxc=$2 ORDER BY alias NULLS FIRST
+	`
+	getAllEmojisInTeamQuery = `
+		SELECT team_id, emoji_id, value, alias, image_mxc FROM emoji WHERE team_id=$1
+	`
 	getEmojiCountInTeamQuery = `
 		SELECT COUNT(*) FROM emoji WHERE team_id=$1
 	`
+	getEmojiCountPerTeamQuery = `
+		SELECT team_id, COUNT(*) FROM emoji GROUP BY team_id
+	`
+	getUnsharedMXCsInTeamQuery = `
+		SELECT DISTINCT image_mxc FROM emoji
+		WHERE team_id=$1 AND image_mxc IS NOT NULL AND image_mxc<>''
+			AND image_mxc NOT IN (SELECT image_mxc FROM emoji WHERE team_id<>$1 AND image_mxc IS NOT NULL)
+	`
 	upsertEmojiQuery = `
 		INSERT INTO emoji (team_id, emoji_id, value, alias, image_mxc)
 		VALUES ($1, $2, $3, $4, $5)
@@ -60,11 +74,14 @@ const (
 			SET value = excluded.value, alias = excluded.alias, image_mxc = excluded.image_mxc
 	`
 	renameEmojiQuery         = `UPDATE emoji SET emoji_id=$3 WHERE team_id=$1 AND emoji_id=$2`
+	deleteAllEmojisQuery     = `DELETE FROM emoji WHERE team_id=$1`
 	saveEmojiMXCQuery        = `UPDATE emoji SET image_mxc=$3 WHERE team_id=$1 AND (emoji_id=$2 OR alias=$2)`
 	deleteEmojiQueryPostgres = `DELETE FROM emoji WHERE team_id=$1 AND emoji_id=ANY($2)`
 	deleteEmojiQuerySQLite   = `DELETE FROM emoji WHERE team_id=? AND emoji_id IN (?)`
 	pruneEmojiQueryPostgres  = `DELETE FROM emoji WHERE team_id=$1 AND emoji_id<>ALL($2)`
 	pruneEmojiQuerySQLite    = `DELETE FROM emoji WHERE team_id=? AND emoji_id NOT IN (?)`
+	vacuumEmojiQueryPostgres = `VACUUM ANALYZE emoji`
+	vacuumQuerySQLite        = `VACUUM`
 )
 
 func (eq *EmojiQuery) WithLock(teamID string) func() {
@@ -89,6 +106,37 @@ func (eq *EmojiQuery) GetEmojiCount(ctx context.Context, teamID string) (count i
 	return
 }
 
+// GetCountPerTeam returns the number of emojis stored for each team.
+func (eq *EmojiQuery) GetCountPerTeam(ctx context.Context) (map[string]int, error) {
+	rows, err := eq.GetDB().Query(ctx, getEmojiCountPerTeamQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var teamID string
+		var count int
+		if err = rows.Scan(&teamID, &count); err != nil {
+			return nil, err
+		}
+		counts[teamID] = count
+	}
+	return counts, rows.Err()
+}
+
+var scanMXC = dbutil.ConvertRowFn[id.ContentURIString](dbutil.ScanSingleColumn[id.ContentURIString])
+
+// GetUnsharedMXCsInTeam returns the uploaded images of the team's emojis which no other team uses,
+// i.e. the uploads that will be orphaned if the team's emojis are deleted.
+func (eq *EmojiQuery) GetUnsharedMXCsInTeam(ctx context.Context, teamID string) ([]id.ContentURIString, error) {
+	return scanMXC.NewRowIter(eq.GetDB().Query(ctx, getUnsharedMXCsInTeamQuery, teamID)).AsList()
+}
+
+func (eq *EmojiQuery) GetAllInTeam(ctx context.Context, teamID string) ([]*Emoji, error) {
+	return eq.QueryMany(ctx, getAllEmojisInTeamQuery, teamID)
+}
+
 func (eq *EmojiQuery) GetBySlackID(ctx context.Context, teamID, emojiID string) (*Emoji, error) {
 	return eq.QueryOne(ctx, getEmojiBySlackIDQuery, teamID, emojiID)
 }
@@ -97,6 +145,10 @@ func (eq *EmojiQuery) GetByMXC(ctx context.Context, mxc string) (*Emoji, error)
 	return eq.QueryOne(ctx, getEmojiByMXCQuery, &mxc)
 }
 
+func (eq *EmojiQuery) GetByTeamAndMXC(ctx context.Context, teamID string, mxc id.ContentURIString) (*Emoji, error) {
+	return eq.QueryOne(ctx, getEmojiByTeamAndMXCQuery, teamID, string(mxc))
+}
+
 func buildSQLiteEmojiDeleteQuery(baseQuery string, teamID string, emojiIDs ...string) (string, []any) {
 	args := make([]any, 1+len(emojiIDs))
 	args[0] = teamID
@@ -119,6 +171,10 @@ func (eq *EmojiQuery) DeleteMany(ctx context.Context, teamID string, emojiIDs ..
 	}
 }
 
+func (eq *EmojiQuery) DeleteAllInTeam(ctx context.Context, teamID string) error {
+	return eq.Exec(ctx, deleteAllEmojisQuery, teamID)
+}
+
 func (eq *EmojiQuery) Prune(ctx context.Context, teamID string, emojiIDs ...string) error {
 	switch eq.GetDB().Dialect {
 	case dbutil.Postgres:
@@ -129,6 +185,16 @@ func (eq *EmojiQuery) Prune(ctx context.Context, teamID string, emojiIDs ...stri
 	}
 }
 
+// Vacuum reclaims the space left behind by deleted emojis. On SQLite, this vacuums the entire database.
+func (eq *EmojiQuery) Vacuum(ctx context.Context) error {
+	switch eq.GetDB().Dialect {
+	case dbutil.Postgres:
+		return eq.Exec(ctx, vacuumEmojiQueryPostgres)
+	default:
+		return eq.Exec(ctx, vacuumQuerySQLite)
+	}
+}
+
 func (eq *EmojiQuery) Put(ctx context.Context, emoji *Emoji) error {
 	return eq.Exec(ctx, upsertEmojiQuery, emoji.sqlVariables()...)
 }
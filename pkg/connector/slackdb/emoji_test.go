@@ -0,0 +1,94 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackdb
+
+import (
+	"context"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+func newTestDB(t *testing.T) *SlackDB {
+	rawDB, err := dbutil.NewWithDialect("file::memory:", "sqlite3")
+	require.NoError(t, err)
+	rawDB.RawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = rawDB.Close() })
+	db := New(rawDB, zerolog.Nop())
+	require.NoError(t, db.Upgrade(context.Background()))
+	return db
+}
+
+func TestEmojiQuery_Maintenance(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	for _, emoji := range []*Emoji{
+		{TeamID: "T1", EmojiID: "shared", Value: "https://example.com/a.png", ImageMXC: "mxc://example.com/shared"},
+		{TeamID: "T1", EmojiID: "own", Value: "https://example.com/b.png", ImageMXC: "mxc://example.com/own"},
+		{TeamID: "T1", EmojiID: "own-alias", Value: "alias:own", Alias: "own", ImageMXC: "mxc://example.com/own"},
+		{TeamID: "T1", EmojiID: "not-uploaded", Value: "https://example.com/c.png"},
+		{TeamID: "T2", EmojiID: "shared", Value: "https://example.com/a.png", ImageMXC: "mxc://example.com/shared"},
+	} {
+		require.NoError(t, db.Emoji.Put(ctx, emoji))
+	}
+
+	counts, err := db.Emoji.GetCountPerTeam(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"T1": 4, "T2": 1}, counts)
+
+	mxcs, err := db.Emoji.GetUnsharedMXCsInTeam(ctx, "T1")
+	require.NoError(t, err)
+	assert.Equal(t, []id.ContentURIString{"mxc://example.com/own"}, mxcs)
+	mxcs, err = db.Emoji.GetUnsharedMXCsInTeam(ctx, "T2")
+	require.NoError(t, err)
+	assert.Empty(t, mxcs)
+
+	require.NoError(t, db.Emoji.DeleteAllInTeam(ctx, "T1"))
+	require.NoError(t, db.Emoji.Vacuum(ctx))
+	counts, err = db.Emoji.GetCountPerTeam(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"T2": 1}, counts)
+	mxcs, err = db.Emoji.GetUnsharedMXCsInTeam(ctx, "T2")
+	require.NoError(t, err)
+	assert.Equal(t, []id.ContentURIString{"mxc://example.com/shared"}, mxcs)
+}
+
+func TestEmojiQuery_GetByTeamAndMXC(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	for _, emoji := range []*Emoji{
+		{TeamID: "T1", EmojiID: "party-alias", Value: "alias:party", Alias: "party", ImageMXC: "mxc://example.com/party"},
+		{TeamID: "T1", EmojiID: "party", Value: "https://example.com/party.gif", ImageMXC: "mxc://example.com/party"},
+		{TeamID: "T2", EmojiID: "other", Value: "https://example.com/other.png", ImageMXC: "mxc://example.com/other"},
+	} {
+		require.NoError(t, db.Emoji.Put(ctx, emoji))
+	}
+
+	emoji, err := db.Emoji.GetByTeamAndMXC(ctx, "T1", "mxc://example.com/party")
+	require.NoError(t, err)
+	require.NotNil(t, emoji)
+	assert.Equal(t, "party", emoji.EmojiID)
+
+	emoji, err = db.Emoji.GetByTeamAndMXC(ctx, "T1", "mxc://example.com/other")
+	require.NoError(t, err)
+	assert.Nil(t, emoji)
+}
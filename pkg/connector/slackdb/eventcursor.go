@@ -0,0 +1,90 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackdb
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+)
+
+type EventCursorQuery struct {
+	*dbutil.QueryHelper[*EventCursor]
+}
+
+func newEventCursor(_ *dbutil.QueryHelper[*EventCursor]) *EventCursor {
+	return &EventCursor{}
+}
+
+const (
+	getEventCursorsQuery = `SELECT login_id, channel_id, last_ts, updated_at FROM event_cursor WHERE login_id=$1`
+	// Cursors only ever move forward, so a slow flush can't overwrite a newer value.
+	upsertEventCursorQuery = `
+		INSERT INTO event_cursor (login_id, channel_id, last_ts, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (login_id, channel_id) DO UPDATE
+			SET last_ts=excluded.last_ts, updated_at=excluded.updated_at
+			WHERE event_cursor.last_ts<=excluded.last_ts
+	`
+	touchEventCursorsQuery      = `UPDATE event_cursor SET updated_at=$2 WHERE login_id=$1`
+	deleteLoginEventCursorQuery = `DELETE FROM event_cursor WHERE login_id=$1`
+	deleteTeamEventCursorQuery  = `DELETE FROM event_cursor WHERE login_id LIKE $1`
+)
+
+func (ecq *EventCursorQuery) GetAllForLogin(ctx context.Context, loginID string) ([]*EventCursor, error) {
+	return ecq.QueryMany(ctx, getEventCursorsQuery, loginID)
+}
+
+func (ecq *EventCursorQuery) Put(ctx context.Context, cursor *EventCursor) error {
+	return ecq.Exec(ctx, upsertEventCursorQuery, cursor.sqlVariables()...)
+}
+
+// Touch marks all cursors of the given login as up to date at the given time,
+// which is used to remember when the login was last connected.
+func (ecq *EventCursorQuery) Touch(ctx context.Context, loginID string, ts time.Time) error {
+	return ecq.Exec(ctx, touchEventCursorsQuery, loginID, ts.UnixMilli())
+}
+
+func (ecq *EventCursorQuery) DeleteAllForLogin(ctx context.Context, loginID string) error {
+	return ecq.Exec(ctx, deleteLoginEventCursorQuery, loginID)
+}
+
+func (ecq *EventCursorQuery) DeleteTeam(ctx context.Context, teamID string) error {
+	return ecq.Exec(ctx, deleteTeamEventCursorQuery, teamID+"-%")
+}
+
+// EventCursor is the timestamp of the last Slack event that was handled in a channel.
+type EventCursor struct {
+	LoginID   string
+	ChannelID string
+	LastTS    string
+	UpdatedAt time.Time
+}
+
+func (ec *EventCursor) Scan(row dbutil.Scannable) (*EventCursor, error) {
+	var updatedAt int64
+	err := row.Scan(&ec.LoginID, &ec.ChannelID, &ec.LastTS, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	ec.UpdatedAt = time.UnixMilli(updatedAt)
+	return ec, nil
+}
+
+func (ec *EventCursor) sqlVariables() []any {
+	return []any{ec.LoginID, ec.ChannelID, ec.LastTS, ec.UpdatedAt.UnixMilli()}
+}
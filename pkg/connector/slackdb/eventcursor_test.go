@@ -0,0 +1,71 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventCursorQuery(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	saved := time.UnixMilli(1700000000000)
+	for _, cursor := range []*EventCursor{
+		{LoginID: "T1-U1", ChannelID: "C1", LastTS: "1700000000.000200", UpdatedAt: saved},
+		// Older cursors must not overwrite newer ones
+		{LoginID: "T1-U1", ChannelID: "C1", LastTS: "1700000000.000100", UpdatedAt: saved},
+		{LoginID: "T1-U1", ChannelID: "C2", LastTS: "1700000000.000300", UpdatedAt: saved},
+		{LoginID: "T2-U1", ChannelID: "C3", LastTS: "1700000000.000400", UpdatedAt: saved},
+	} {
+		require.NoError(t, db.Cursors.Put(ctx, cursor))
+	}
+
+	cursors, err := db.Cursors.GetAllForLogin(ctx, "T1-U1")
+	require.NoError(t, err)
+	byChannel := make(map[string]string)
+	for _, cursor := range cursors {
+		byChannel[cursor.ChannelID] = cursor.LastTS
+		assert.Equal(t, saved, cursor.UpdatedAt)
+	}
+	assert.Equal(t, map[string]string{"C1": "1700000000.000200", "C2": "1700000000.000300"}, byChannel)
+
+	touched := saved.Add(time.Hour)
+	require.NoError(t, db.Cursors.Touch(ctx, "T1-U1", touched))
+	cursors, err = db.Cursors.GetAllForLogin(ctx, "T1-U1")
+	require.NoError(t, err)
+	for _, cursor := range cursors {
+		assert.Equal(t, touched, cursor.UpdatedAt)
+	}
+
+	require.NoError(t, db.Cursors.DeleteTeam(ctx, "T1"))
+	cursors, err = db.Cursors.GetAllForLogin(ctx, "T1-U1")
+	require.NoError(t, err)
+	assert.Empty(t, cursors)
+	cursors, err = db.Cursors.GetAllForLogin(ctx, "T2-U1")
+	require.NoError(t, err)
+	assert.Len(t, cursors, 1)
+
+	require.NoError(t, db.Cursors.DeleteAllForLogin(ctx, "T2-U1"))
+	cursors, err = db.Cursors.GetAllForLogin(ctx, "T2-U1")
+	require.NoError(t, err)
+	assert.Empty(t, cursors)
+}
@@ -0,0 +1,107 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackdb
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+)
+
+type ShardLeaseQuery struct {
+	*dbutil.QueryHelper[*ShardLease]
+}
+
+func newShardLease(_ *dbutil.QueryHelper[*ShardLease]) *ShardLease {
+	return &ShardLease{}
+}
+
+const (
+	getAllShardLeasesQuery = `SELECT shard, owner, expires_at FROM shard_lease ORDER BY shard`
+	// The lease is only taken over if it has expired or already belongs to the same owner.
+	acquireShardLeaseQuery = `
+		INSERT INTO shard_lease (shard, owner, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (shard) DO UPDATE
+			SET owner=excluded.owner, expires_at=excluded.expires_at
+			WHERE shard_lease.owner=excluded.owner OR shard_lease.expires_at<$4
+	`
+	releaseShardLeaseQuery   = `DELETE FROM shard_lease WHERE shard=$1 AND owner=$2`
+	upsertShardInstanceQuery = `
+		INSERT INTO shard_instance (owner, expires_at) VALUES ($1, $2)
+		ON CONFLICT (owner) DO UPDATE SET expires_at=excluded.expires_at
+	`
+	pruneShardInstancesQuery = `DELETE FROM shard_instance WHERE expires_at<$1`
+	getShardInstancesQuery   = `SELECT owner FROM shard_instance WHERE expires_at>=$1`
+	deleteShardInstanceQuery = `DELETE FROM shard_instance WHERE owner=$1`
+)
+
+var scanString = dbutil.ConvertRowFn[string](dbutil.ScanSingleColumn[string])
+
+func (slq *ShardLeaseQuery) GetAll(ctx context.Context) ([]*ShardLease, error) {
+	return slq.QueryMany(ctx, getAllShardLeasesQuery)
+}
+
+// Acquire takes or renews the lease of the given shard until expiresAt.
+// It returns false if the shard is currently leased by another owner.
+func (slq *ShardLeaseQuery) Acquire(ctx context.Context, shard int, owner string, expiresAt time.Time) (bool, error) {
+	res, err := slq.GetDB().Exec(ctx, acquireShardLeaseQuery, shard, owner, expiresAt.UnixMilli(), time.Now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (slq *ShardLeaseQuery) Release(ctx context.Context, shard int, owner string) error {
+	return slq.Exec(ctx, releaseShardLeaseQuery, shard, owner)
+}
+
+// Heartbeat marks the given instance as alive until expiresAt and forgets instances that have expired.
+func (slq *ShardLeaseQuery) Heartbeat(ctx context.Context, owner string, expiresAt time.Time) error {
+	err := slq.Exec(ctx, pruneShardInstancesQuery, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	return slq.Exec(ctx, upsertShardInstanceQuery, owner, expiresAt.UnixMilli())
+}
+
+// GetInstances returns the owner IDs of all instances whose heartbeat hasn't expired.
+func (slq *ShardLeaseQuery) GetInstances(ctx context.Context) ([]string, error) {
+	return scanString.NewRowIter(slq.GetDB().Query(ctx, getShardInstancesQuery, time.Now().UnixMilli())).AsList()
+}
+
+func (slq *ShardLeaseQuery) RemoveInstance(ctx context.Context, owner string) error {
+	return slq.Exec(ctx, deleteShardInstanceQuery, owner)
+}
+
+// ShardLease is a claim of a bridge instance on the logins of one shard.
+type ShardLease struct {
+	Shard     int
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (sl *ShardLease) Scan(row dbutil.Scannable) (*ShardLease, error) {
+	var expiresAt int64
+	err := row.Scan(&sl.Shard, &sl.Owner, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	sl.ExpiresAt = time.UnixMilli(expiresAt)
+	return sl, nil
+}
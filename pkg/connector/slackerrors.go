@@ -0,0 +1,203 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/status"
+	"maunium.net/go/mautrix/event"
+)
+
+type slackErrorInfo struct {
+	// The bridge state to switch to if this error is encountered while connecting.
+	// Empty for errors that don't affect the login as a whole.
+	StateEvent status.BridgeStateEvent
+	// The status to report for Matrix events that failed with this error.
+	Status event.MessageStatus
+	Reason event.MessageStatusReason
+	// Human-readable description shown to the user.
+	Message string
+}
+
+var badCredentialsError = slackErrorInfo{
+	StateEvent: status.StateBadCredentials,
+	Status:     event.MessageStatusFail,
+	Reason:     event.MessageStatusBridgeUnavailable,
+}
+
+var temporaryError = slackErrorInfo{
+	StateEvent: status.StateTransientDisconnect,
+	Status:     event.MessageStatusRetriable,
+	Reason:     event.MessageStatusNetworkError,
+}
+
+var permanentError = slackErrorInfo{
+	Status: event.MessageStatusFail,
+	Reason: event.MessageStatusGenericError,
+}
+
+var noPermissionError = slackErrorInfo{
+	Status: event.MessageStatusFail,
+	Reason: event.MessageStatusNoPermission,
+}
+
+func (sei slackErrorInfo) withMessage(msg string) slackErrorInfo {
+	sei.Message = msg
+	return sei
+}
+
+// slackErrors maps known Slack API error codes to how they should be reported.
+var slackErrors = map[string]slackErrorInfo{
+	"invalid_auth":            badCredentialsError.withMessage("Your Slack session is no longer valid"),
+	"not_authed":              badCredentialsError.withMessage("Your Slack session is no longer valid"),
+	"token_revoked":           badCredentialsError.withMessage("Your Slack token has been revoked"),
+	"token_expired":           badCredentialsError.withMessage("Your Slack token has expired"),
+	"account_inactive":        badCredentialsError.withMessage("Your Slack account has been deactivated"),
+	"user_removed_from_team":  badCredentialsError.withMessage("You have been removed from the Slack workspace"),
+	"team_access_not_granted": badCredentialsError.withMessage("Your Slack token doesn't have access to this workspace"),
+	"ekm_access_denied":       badCredentialsError.withMessage("Access to the Slack workspace was denied by its administrators"),
+
+	"ratelimited":         temporaryError.withMessage("Slack is rate limiting requests, try again later"),
+	"internal_error":      temporaryError.withMessage("Slack had an internal error, try again later"),
+	"fatal_error":         temporaryError.withMessage("Slack had an internal error, try again later"),
+	"service_unavailable": temporaryError.withMessage("Slack is temporarily unavailable, try again later"),
+	"request_timeout":     temporaryError.withMessage("The request to Slack timed out, try again later"),
+
+	"message_not_found":   permanentError.withMessage("The message was not found on Slack"),
+	"thread_not_found":    permanentError.withMessage("The thread was not found on Slack"),
+	"channel_not_found":   permanentError.withMessage("The channel was not found on Slack"),
+	"is_archived":         permanentError.withMessage("The channel has been archived"),
+	"msg_too_long":        permanentError.withMessage("The message is too long for Slack"),
+	"edit_window_closed":  permanentError.withMessage("The message is too old to be edited"),
+	"too_many_reactions":  permanentError.withMessage("The message has too many reactions"),
+	"invalid_name":        permanentError.withMessage("Slack doesn't recognize that emoji"),
+	"not_in_channel":      noPermissionError.withMessage("You're not in the Slack channel"),
+	"cant_update_message": noPermissionError.withMessage("You can't edit that message on Slack"),
+	"cant_delete_message": noPermissionError.withMessage("You can't delete that message on Slack"),
+	"restricted_action":   noPermissionError.withMessage("A workspace preference prevents this action on Slack"),
+	"no_permission":       noPermissionError.withMessage("You don't have permission to do that on Slack"),
+}
+
+// fileUploadErrors maps Slack API error codes returned when uploading files to human-readable messages.
+// Messages containing %s get the extension of the uploaded file.
+var fileUploadErrors = map[string]slackErrorInfo{
+	"file_uploads_disabled":               noPermissionError.withMessage("Workspace admins disabled file uploads on Slack"),
+	"file_uploads_except_images_disabled": noPermissionError.withMessage("Workspace admins disabled uploads of files other than images on Slack"),
+	"blocked_file_type":                   noPermissionError.withMessage("Workspace admins disabled uploads of %s files on Slack"),
+	"restricted_action":                   noPermissionError.withMessage("Workspace admins restricted file sharing in this channel on Slack"),
+	"storage_limit_reached":               permanentError.withMessage("The Slack workspace has run out of file storage"),
+}
+
+var slackErrorCodeRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// slackErrorCode returns the Slack API error code of the given error, or an empty string if it isn't a Slack API error.
+func slackErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var rateLimitErr *slack.RateLimitedError
+	var respErr slack.SlackErrorResponse
+	if errors.As(err, &rateLimitErr) {
+		return "ratelimited"
+	} else if errors.As(err, &respErr) {
+		return respErr.Err
+	}
+	// Some slack-go methods return the error code as a plain string error
+	if msg := err.Error(); slackErrorCodeRegex.MatchString(msg) {
+		return msg
+	}
+	return ""
+}
+
+// isSlackError returns true if the given error is a Slack API error with one of the given codes.
+func isSlackError(err error, codes ...string) bool {
+	code := slackErrorCode(err)
+	for _, target := range codes {
+		if code == target {
+			return true
+		}
+	}
+	return false
+}
+
+func makeBridgeStateErrorCode(code string) status.BridgeStateErrorCode {
+	return status.BridgeStateErrorCode("slack-" + strings.ReplaceAll(code, "_", "-"))
+}
+
+// slackErrorToBridgeState converts an error encountered while connecting into a bridge state.
+func slackErrorToBridgeState(err error) status.BridgeState {
+	code := slackErrorCode(err)
+	info, ok := slackErrors[code]
+	if !ok || info.StateEvent == "" {
+		return status.BridgeState{
+			StateEvent: status.StateUnknownError,
+			Error:      "slack-unknown-fetch-error",
+			Message:    fmt.Sprintf("Unknown error from Slack: %s", err.Error()),
+		}
+	}
+	return status.BridgeState{
+		StateEvent: info.StateEvent,
+		Error:      makeBridgeStateErrorCode(code),
+		Message:    info.Message,
+	}
+}
+
+// wrapSlackError wraps known Slack API errors in a message status with a human-readable message.
+// Other errors are returned as-is.
+func wrapSlackError(err error) error {
+	info, ok := slackErrors[slackErrorCode(err)]
+	if !ok {
+		return err
+	}
+	return bridgev2.WrapErrorInStatus(err).
+		WithStatus(info.Status).
+		WithErrorReason(info.Reason).
+		WithMessage(info.Message).
+		WithIsCertain(true).
+		WithSendNotice(info.Status == event.MessageStatusFail)
+}
+
+// wrapSlackUploadError is like wrapSlackError, but translates errors specific to file uploads,
+// like admins having blocked the type of the uploaded file, into more specific messages.
+func wrapSlackUploadError(err error, filename string) error {
+	info, ok := fileUploadErrors[slackErrorCode(err)]
+	if !ok {
+		return wrapSlackError(err)
+	}
+	msg := info.Message
+	if strings.Contains(msg, "%s") {
+		ext := strings.ToLower(path.Ext(filename))
+		if ext == "" {
+			msg = strings.Replace(msg, "%s files", "this type of file", 1)
+		} else {
+			msg = fmt.Sprintf(msg, ext)
+		}
+	}
+	return bridgev2.WrapErrorInStatus(err).
+		WithStatus(info.Status).
+		WithErrorReason(info.Reason).
+		WithMessage(msg).
+		WithIsCertain(true).
+		WithSendNotice(true)
+}
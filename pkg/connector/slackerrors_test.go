@@ -0,0 +1,90 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/status"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestSlackErrorCode(t *testing.T) {
+	type testCase struct {
+		name     string
+		err      error
+		expected string
+	}
+	testCases := []testCase{
+		{"Nil", nil, ""},
+		{"ErrorResponse", slack.SlackErrorResponse{Err: "channel_not_found"}, "channel_not_found"},
+		{"WrappedErrorResponse", fmt.Errorf("failed to send: %w", slack.SlackErrorResponse{Err: "is_archived"}), "is_archived"},
+		{"PlainString", errors.New("invalid_auth"), "invalid_auth"},
+		{"RateLimited", &slack.RateLimitedError{RetryAfter: time.Second}, "ratelimited"},
+		{"NotSlackError", errors.New("connection reset by peer"), ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, slackErrorCode(tc.err))
+		})
+	}
+}
+
+func TestSlackErrorToBridgeState(t *testing.T) {
+	state := slackErrorToBridgeState(errors.New("account_inactive"))
+	assert.Equal(t, status.StateBadCredentials, state.StateEvent)
+	assert.Equal(t, status.BridgeStateErrorCode("slack-account-inactive"), state.Error)
+
+	state = slackErrorToBridgeState(errors.New("message_not_found"))
+	assert.Equal(t, status.StateUnknownError, state.StateEvent)
+	assert.Equal(t, status.BridgeStateErrorCode("slack-unknown-fetch-error"), state.Error)
+}
+
+func TestWrapSlackUploadError(t *testing.T) {
+	type testCase struct {
+		name     string
+		err      error
+		filename string
+		message  string
+		reason   event.MessageStatusReason
+	}
+	testCases := []testCase{
+		{"BlockedFileType", slack.SlackErrorResponse{Err: "blocked_file_type"}, "setup.EXE", "Workspace admins disabled uploads of .exe files on Slack", event.MessageStatusNoPermission},
+		{"BlockedFileTypeNoExtension", slack.SlackErrorResponse{Err: "blocked_file_type"}, "", "Workspace admins disabled uploads of this type of file on Slack", event.MessageStatusNoPermission},
+		{"UploadsDisabled", errors.New("file_uploads_disabled"), "cat.jpg", "Workspace admins disabled file uploads on Slack", event.MessageStatusNoPermission},
+		{"RestrictedAction", slack.SlackErrorResponse{Err: "restricted_action"}, "doc.pdf", "Workspace admins restricted file sharing in this channel on Slack", event.MessageStatusNoPermission},
+		{"GenericSlackError", slack.SlackErrorResponse{Err: "is_archived"}, "doc.pdf", "The channel has been archived", event.MessageStatusGenericError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ms bridgev2.MessageStatus
+			require.ErrorAs(t, wrapSlackUploadError(tc.err, tc.filename), &ms)
+			assert.Equal(t, tc.message, ms.Message)
+			assert.Equal(t, tc.reason, ms.ErrorReason)
+			assert.Equal(t, event.MessageStatusFail, ms.Status)
+		})
+	}
+	unknown := errors.New("connection reset by peer")
+	assert.Equal(t, unknown, wrapSlackUploadError(unknown, "doc.pdf"))
+}
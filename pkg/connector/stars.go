@@ -0,0 +1,161 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// maxStarPages limits how many pages of stars.list are fetched on startup.
+const maxStarPages = 10
+
+var _ bridgev2.TagHandlingNetworkAPI = (*SlackClient)(nil)
+
+// shouldSyncStars returns true if starred Slack conversations should be synced with the m.favourite room tag.
+// Stars are only available for user tokens, and the tag is only bridged if allowed in only_bridge_tags.
+func (s *SlackClient) shouldSyncStars() bool {
+	return s.IsRealUser && slices.Contains(s.Main.br.Config.OnlyBridgeTags, event.RoomTagFavourite)
+}
+
+func isStarredConversation(item slack.Item) bool {
+	switch item.Type {
+	case slack.TYPE_CHANNEL, slack.TYPE_IM, slack.TYPE_GROUP:
+		return item.Channel != ""
+	default:
+		return false
+	}
+}
+
+// loadStarredChannels fetches the list of starred conversations, which is used to tag portals as favourites
+// during the following channel syncs. The list stays unknown (nil) if fetching fails.
+func (s *SlackClient) loadStarredChannels(ctx context.Context) {
+	if !s.shouldSyncStars() {
+		return
+	}
+	starred := make(map[string]struct{})
+	for page := 1; page <= maxStarPages; page++ {
+		items, paging, err := s.Client.ListStarsContext(ctx, slack.StarsParameters{Count: slack.DEFAULT_STARS_COUNT, Page: page})
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to fetch starred conversations")
+			return
+		}
+		for _, item := range items {
+			if isStarredConversation(item) {
+				starred[item.Channel] = struct{}{}
+			}
+		}
+		if paging == nil || page >= paging.Pages {
+			break
+		}
+	}
+	zerolog.Ctx(ctx).Debug().Int("count", len(starred)).Msg("Fetched starred conversations")
+	s.starredLock.Lock()
+	s.starredChannels = starred
+	s.starredLock.Unlock()
+}
+
+// getFavouriteTag returns the favourite tag if the given conversation is starred, and nil otherwise.
+// Tags are never removed during syncs, as bridgev2 would also remove other tags set by the user.
+// Unstarring is handled separately by star_removed events.
+func (s *SlackClient) getFavouriteTag(channelID string) *event.RoomTag {
+	s.starredLock.RLock()
+	defer s.starredLock.RUnlock()
+	if _, ok := s.starredChannels[channelID]; !ok {
+		return nil
+	}
+	tag := event.RoomTagFavourite
+	return &tag
+}
+
+func (s *SlackClient) setStarred(channelID string, starred bool) {
+	s.starredLock.Lock()
+	defer s.starredLock.Unlock()
+	if s.starredChannels == nil {
+		return
+	} else if starred {
+		s.starredChannels[channelID] = struct{}{}
+	} else {
+		delete(s.starredChannels, channelID)
+	}
+}
+
+// wrapStarChange converts a star_added or star_removed event of a conversation into a room tag change.
+func (s *SlackClient) wrapStarChange(ctx context.Context, item slack.StarredItem, starred bool) (bridgev2.RemoteEvent, error) {
+	if !s.shouldSyncStars() || !isStarredConversation(slack.Item(item)) {
+		return nil, nil
+	}
+	s.setStarred(item.Channel, starred)
+	meta, err := s.makeEventMeta(ctx, item.Channel, nil, s.UserID, "")
+	if err != nil {
+		return nil, err
+	}
+	meta.Type = bridgev2.RemoteEventChatInfoChange
+	tag := event.RoomTag("")
+	if starred {
+		tag = event.RoomTagFavourite
+	}
+	return &SlackChatInfoChange{
+		SlackEventMeta: &meta,
+		Change: &bridgev2.ChatInfoChange{
+			ChatInfo: &bridgev2.ChatInfo{UserLocal: &bridgev2.UserLocalPortalInfo{Tag: &tag}},
+		},
+	}, nil
+}
+
+func isFavourite(content *event.TagEventContent) (favourite, fromBridge bool) {
+	if content == nil {
+		return false, false
+	}
+	meta, ok := content.Tags[event.RoomTagFavourite]
+	return ok, ok && meta.MauDoublePuppetSource != ""
+}
+
+// HandleRoomTag stars or unstars the Slack conversation when the m.favourite tag is added to or removed from the portal.
+func (s *SlackClient) HandleRoomTag(ctx context.Context, msg *bridgev2.MatrixRoomTag) error {
+	if !s.shouldSyncStars() || !s.IsLoggedIn() {
+		return nil
+	}
+	favourite, fromBridge := isFavourite(msg.Content)
+	wasFavourite, _ := isFavourite(msg.PrevContent)
+	if favourite == wasFavourite || fromBridge {
+		return nil
+	}
+	_, channelID := slackid.ParsePortalID(msg.Portal.ID)
+	if channelID == "" {
+		return nil
+	}
+	var err error
+	if favourite {
+		err = s.Client.AddStarContext(ctx, channelID, slack.ItemRef{})
+	} else {
+		err = s.Client.RemoveStarContext(ctx, channelID, slack.ItemRef{})
+	}
+	if err != nil && !isSlackError(err, "already_starred", "not_starred") {
+		return fmt.Errorf("failed to update star: %w", err)
+	}
+	s.setStarred(channelID, favourite)
+	return nil
+}
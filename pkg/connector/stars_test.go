@@ -0,0 +1,104 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/bridgeconfig"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func newStarTestClient(srv *slackapitest.Server) *SlackClient {
+	s := newTestSlackClient(srv.Client())
+	s.Main = &SlackConnector{br: &bridgev2.Bridge{Config: &bridgeconfig.BridgeConfig{
+		OnlyBridgeTags: []event.RoomTag{event.RoomTagFavourite, event.RoomTagLowPriority},
+	}}}
+	return s
+}
+
+func TestLoadStarredChannels(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("stars.list", func(form url.Values) (any, error) {
+		if form.Get("page") == "2" {
+			return map[string]any{
+				"items":  []map[string]any{{"type": "im", "channel": "D1"}},
+				"paging": map[string]any{"page": 2, "pages": 2},
+			}, nil
+		}
+		return map[string]any{
+			"items": []map[string]any{
+				{"type": "channel", "channel": "C1"},
+				{"type": "message", "channel": "C2", "message": map[string]any{"ts": "1.2"}},
+			},
+			"paging": map[string]any{"page": 1, "pages": 2},
+		}, nil
+	})
+	s := newStarTestClient(srv)
+
+	assert.Nil(t, s.getFavouriteTag("C1"), "no tag before loading stars")
+	s.loadStarredChannels(context.Background())
+	assert.Equal(t, event.RoomTagFavourite, *s.getFavouriteTag("C1"))
+	assert.Equal(t, event.RoomTagFavourite, *s.getFavouriteTag("D1"))
+	assert.Nil(t, s.getFavouriteTag("C2"), "unstarred conversations must not clear tags")
+	assert.Len(t, srv.Calls("stars.list"), 2)
+}
+
+func TestHandleRoomTag(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("stars.add", func(form url.Values) (any, error) {
+		return nil, nil
+	})
+	srv.Handle("stars.remove", func(form url.Values) (any, error) {
+		return nil, slackapitest.Error("not_starred")
+	})
+	s := newStarTestClient(srv)
+	portal := &bridgev2.Portal{Portal: &database.Portal{PortalKey: networkid.PortalKey{ID: slackid.MakePortalID("T1", "C1")}}}
+	makeTag := func(content, prev *event.TagEventContent) *bridgev2.MatrixRoomTag {
+		msg := &bridgev2.MatrixRoomTag{PrevContent: prev}
+		msg.Content = content
+		msg.Portal = portal
+		return msg
+	}
+	favourite := &event.TagEventContent{Tags: event.Tags{event.RoomTagFavourite: {}}}
+	bridgedFavourite := &event.TagEventContent{Tags: event.Tags{event.RoomTagFavourite: {MauDoublePuppetSource: "mautrix-slack"}}}
+	empty := &event.TagEventContent{Tags: event.Tags{}}
+	ctx := context.Background()
+
+	require.NoError(t, s.HandleRoomTag(ctx, makeTag(favourite, empty)))
+	calls := srv.Calls("stars.add")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "C1", calls[0].Get("channel"))
+	assert.Empty(t, calls[0].Get("timestamp"))
+
+	require.NoError(t, s.HandleRoomTag(ctx, makeTag(bridgedFavourite, nil)))
+	require.NoError(t, s.HandleRoomTag(ctx, makeTag(favourite, favourite)))
+	assert.Len(t, srv.Calls("stars.add"), 1, "unchanged and bridge-originated tags must not be sent to Slack")
+
+	require.NoError(t, s.HandleRoomTag(ctx, makeTag(empty, favourite)), "not_starred errors are ignored")
+	assert.Len(t, srv.Calls("stars.remove"), 1)
+}
@@ -41,8 +41,10 @@ func (s *SlackConnector) ValidateUserID(id networkid.UserID) bool {
 }
 
 func (s *SlackClient) ResolveIdentifier(ctx context.Context, identifier string, createChat bool) (*bridgev2.ResolveIdentifierResponse, error) {
-	if s.Client == nil {
-		return nil, bridgev2.ErrNotLoggedIn
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
+	} else if createChat && s.isReadOnly() {
+		return nil, errReadOnly
 	}
 	var userInfo *slack.User
 	var err error
@@ -78,7 +80,7 @@ func (s *SlackClient) ResolveIdentifier(ctx context.Context, identifier string,
 		if err != nil {
 			return nil, fmt.Errorf("failed to open conversation: %w", err)
 		}
-		chatInfo, err := s.wrapChatInfo(ctx, resp, true)
+		chatInfo, err := s.wrapChatInfo(ctx, resp, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to wrap chat info: %w", err)
 		}
@@ -95,8 +97,10 @@ func (s *SlackClient) ResolveIdentifier(ctx context.Context, identifier string,
 }
 
 func (s *SlackClient) CreateGroup(ctx context.Context, name string, users ...networkid.UserID) (*bridgev2.CreateChatResponse, error) {
-	if s.Client == nil {
-		return nil, bridgev2.ErrNotLoggedIn
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
+	} else if s.isReadOnly() {
+		return nil, errReadOnly
 	}
 	plainUsers := make([]string, len(users))
 	for i, user := range users {
@@ -130,7 +134,7 @@ func (s *SlackClient) CreateGroup(ctx context.Context, name string, users ...net
 			return nil, fmt.Errorf("failed to open conversation: %w", err)
 		}
 	}
-	chatInfo, err := s.wrapChatInfo(ctx, resp, true)
+	chatInfo, err := s.wrapChatInfo(ctx, resp, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to wrap chat info: %w", err)
 	}
@@ -141,10 +145,10 @@ func (s *SlackClient) CreateGroup(ctx context.Context, name string, users ...net
 }
 
 func (s *SlackClient) SearchUsers(ctx context.Context, query string) ([]*bridgev2.ResolveIdentifierResponse, error) {
-	if s.Client == nil {
-		return nil, bridgev2.ErrNotLoggedIn
+	if err := s.checkLoggedIn(); err != nil {
+		return nil, err
 	}
-	resp, err := s.Client.SearchUsersCacheContext(ctx, s.TeamID, query)
+	resp, err := s.userClient().SearchUsersCacheContext(ctx, s.TeamID, query)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,95 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func formatThreadSummary(root *slack.Msg) string {
+	replies := "replies"
+	if root.ReplyCount == 1 {
+		replies = "reply"
+	}
+	summary := fmt.Sprintf("💬 %d %s in thread", root.ReplyCount, replies)
+	if root.LatestReply != "" {
+		lastReply := slackid.ParseSlackTimestamp(root.LatestReply).UTC()
+		summary += fmt.Sprintf(", last reply at %s", lastReply.Format("2006-01-02 15:04 MST"))
+	}
+	return summary
+}
+
+// updateThreadSummary posts or edits a notice replying to a thread root with the reply count and last reply time,
+// for Matrix clients that don't show thread previews.
+func (s *SlackClient) updateThreadSummary(ctx context.Context, channelID string, root *slack.Msg) {
+	s.threadSummaryLock.Lock()
+	defer s.threadSummaryLock.Unlock()
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "update thread summary").
+		Str("channel_id", channelID).
+		Str("thread_ts", root.Timestamp).
+		Logger()
+	rootMsg, err := s.Main.br.DB.Message.GetFirstPartByID(ctx, s.UserLogin.ID, slackid.MakeMessageID(s.TeamID, channelID, root.Timestamp))
+	if err != nil {
+		log.Err(err).Msg("Failed to get thread root from database")
+		return
+	} else if rootMsg == nil {
+		log.Debug().Msg("Thread root not found, not updating summary")
+		return
+	}
+	portal, err := s.Main.br.GetExistingPortalByKey(ctx, rootMsg.Room)
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal of thread root")
+		return
+	} else if portal == nil || portal.MXID == "" {
+		return
+	}
+	meta := rootMsg.Metadata.(*slackid.MessageMetadata)
+	if meta.ThreadSummaryReplyCount == root.ReplyCount && meta.ThreadSummaryLatestReply == root.LatestReply {
+		return
+	}
+	content := &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    formatThreadSummary(root),
+	}
+	if meta.ThreadSummaryMXID != "" {
+		content.SetEdit(meta.ThreadSummaryMXID)
+	} else {
+		content.RelatesTo = (&event.RelatesTo{}).SetReplyTo(rootMsg.MXID)
+	}
+	resp, err := s.Main.br.Bot.SendMessage(ctx, portal.MXID, event.EventMessage, &event.Content{Parsed: content}, nil)
+	if err != nil {
+		log.Err(err).Msg("Failed to send thread summary")
+		return
+	}
+	if meta.ThreadSummaryMXID == "" {
+		meta.ThreadSummaryMXID = resp.EventID
+	}
+	meta.ThreadSummaryReplyCount = root.ReplyCount
+	meta.ThreadSummaryLatestReply = root.LatestReply
+	err = s.Main.br.DB.Message.Update(ctx, rootMsg)
+	if err != nil {
+		log.Err(err).Msg("Failed to save thread summary info to database")
+	}
+}
@@ -0,0 +1,99 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is used for all spans in the bridge. It's a no-op unless tracing is enabled in the config.
+var tracer = otel.Tracer("go.mau.fi/mautrix-slack")
+
+// setupTracing configures the global OpenTelemetry tracer provider based on the config.
+func (s *SlackConnector) setupTracing(ctx context.Context) error {
+	cfg := s.Config.Tracing
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s exporter: %w", cfg.Exporter, err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("mautrix-slack"),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create resource: %w", err)
+	}
+	s.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(s.tracerProvider)
+	return nil
+}
+
+func (s *SlackConnector) stopTracing() {
+	if s.tracerProvider != nil {
+		err := s.tracerProvider.Shutdown(context.Background())
+		if err != nil {
+			s.br.Log.Err(err).Msg("Failed to shut down tracer provider")
+		}
+	}
+}
+
+// endSpan records the error (if any) in the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startRemoteSpan starts a span for processing a queued Slack event, continuing the trace
+// that was started when the event was received.
+func (s *SlackEventMeta) startRemoteSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if s.SpanContext.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, s.SpanContext)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
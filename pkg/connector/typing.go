@@ -0,0 +1,76 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TypingTimeout is how long a Slack typing notification is shown on Matrix.
+// Repeated notifications from the same user within the timeout are not bridged again.
+const TypingTimeout = 5 * time.Second
+
+type typingKey struct {
+	channelID string
+	userID    string
+}
+
+// shouldBridgeTyping checks whether a Slack typing notification should be bridged to Matrix.
+func (s *SlackClient) shouldBridgeTyping(ctx context.Context, channelID, userID string) bool {
+	if !s.Main.currentConfig().TypingInChannels && !s.isDirectChat(ctx, channelID) {
+		return false
+	}
+	return s.markTyping(channelID, userID, time.Now())
+}
+
+// isDirectChat returns true if the channel is a DM or a group DM.
+func (s *SlackClient) isDirectChat(ctx context.Context, channelID string) bool {
+	if strings.HasPrefix(channelID, "D") {
+		return true
+	} else if !strings.HasPrefix(channelID, "G") {
+		// Only private channels and group DMs share the G prefix, anything else is a normal channel
+		return false
+	}
+	info, err := s.fetchChatInfoWithCache(ctx, channelID)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("channel_id", channelID).Msg("Failed to fetch channel info to check typing notification source")
+		return false
+	}
+	return info.IsIM || info.IsMpIM
+}
+
+// markTyping records a typing notification and returns false if one from the same user in the same channel
+// was already bridged within the typing timeout.
+func (s *SlackClient) markTyping(channelID, userID string, now time.Time) bool {
+	key := typingKey{channelID: channelID, userID: userID}
+	s.lastTypingLock.Lock()
+	defer s.lastTypingLock.Unlock()
+	if last, ok := s.lastTyping[key]; ok && now.Sub(last) < TypingTimeout {
+		return false
+	}
+	for otherKey, last := range s.lastTyping {
+		if now.Sub(last) >= TypingTimeout {
+			delete(s.lastTyping, otherKey)
+		}
+	}
+	s.lastTyping[key] = now
+	return true
+}
@@ -0,0 +1,61 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+)
+
+func TestShouldBridgeTyping(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.info", func(form url.Values) (any, error) {
+		channelID := form.Get("channel")
+		return map[string]any{"channel": map[string]any{"id": channelID, "is_mpim": channelID == "G1"}}, nil
+	})
+	s := newTestSlackClient(srv.Client())
+	s.Main = &SlackConnector{}
+	ctx := context.Background()
+
+	assert.True(t, s.shouldBridgeTyping(ctx, "D1", "U2"))
+	assert.True(t, s.shouldBridgeTyping(ctx, "G1", "U2"))
+	assert.False(t, s.shouldBridgeTyping(ctx, "G2", "U2"))
+	assert.False(t, s.shouldBridgeTyping(ctx, "C1", "U2"))
+
+	s.Main.Config.TypingInChannels = true
+	assert.True(t, s.shouldBridgeTyping(ctx, "C1", "U2"))
+}
+
+func TestMarkTyping(t *testing.T) {
+	s := newTestSlackClient(nil)
+	now := time.Now()
+
+	assert.True(t, s.markTyping("D1", "U2", now))
+	assert.False(t, s.markTyping("D1", "U2", now.Add(TypingTimeout/2)))
+	assert.True(t, s.markTyping("D1", "U3", now.Add(TypingTimeout/2)))
+	assert.True(t, s.markTyping("D2", "U2", now.Add(TypingTimeout/2)))
+	assert.True(t, s.markTyping("D1", "U2", now.Add(TypingTimeout)))
+	assert.Len(t, s.lastTyping, 3)
+	assert.True(t, s.markTyping("D1", "U2", now.Add(3*TypingTimeout)))
+	assert.Len(t, s.lastTyping, 1)
+}
@@ -132,8 +132,14 @@ func main() {
 	vs := getVariationSequences()
 
 	shortcodeToEmoji := make(map[string]string)
+	aliasToShortcode := make(map[string]string)
 	for _, emoji := range emojis {
 		shortcodeToEmoji[emoji.ShortName] = unifiedToUnicode(emoji.Unified)
+		for _, alias := range emoji.ShortNames {
+			if alias != emoji.ShortName {
+				aliasToShortcode[alias] = emoji.ShortName
+			}
+		}
 		if _, needsVariation := vs[emoji.Unified]; needsVariation {
 			shortcodeToEmoji[emoji.ShortName] += "\ufe0f"
 		}
@@ -141,9 +147,20 @@ func main() {
 			shortcodeToEmoji[fmt.Sprintf("%s::%s", emoji.ShortName, unifiedToSkinToneID(skinToneKey))] = unifiedToUnicode(stEmoji.Unified)
 		}
 	}
-	file := exerrors.Must(os.OpenFile("emoji.json", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644))
+	for alias := range aliasToShortcode {
+		// Some names are an alias of one emoji and the primary shortcode of another, prefer the primary meaning
+		if _, isPrimary := shortcodeToEmoji[alias]; isPrimary {
+			delete(aliasToShortcode, alias)
+		}
+	}
+	writeJSON("emoji.json", shortcodeToEmoji)
+	writeJSON("emoji-aliases.json", aliasToShortcode)
+}
+
+func writeJSON(path string, data map[string]string) {
+	file := exerrors.Must(os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644))
 	enc := json.NewEncoder(file)
 	enc.SetIndent("", " ")
-	exerrors.PanicIfNotNil(enc.Encode(shortcodeToEmoji))
+	exerrors.PanicIfNotNil(enc.Encode(data))
 	exerrors.PanicIfNotNil(file.Close())
 }
@@ -28,10 +28,11 @@ import (
 )
 
 //go:generate go run ./emoji-generate.go
-//go:embed emoji.json
+//go:embed emoji.json emoji-aliases.json
 var emojiFileData embed.FS
 
 var shortcodeToUnicodeMap map[string]string
+var aliasToShortcodeMap map[string]string
 var unicodeToShortcodeMap map[string]string
 var shortcodeRegex *regexp.Regexp
 var initOnce sync.Once
@@ -40,6 +41,9 @@ func doInit() {
 	file := exerrors.Must(emojiFileData.Open("emoji.json"))
 	exerrors.PanicIfNotNil(json.NewDecoder(file).Decode(&shortcodeToUnicodeMap))
 	exerrors.PanicIfNotNil(file.Close())
+	file = exerrors.Must(emojiFileData.Open("emoji-aliases.json"))
+	exerrors.PanicIfNotNil(json.NewDecoder(file).Decode(&aliasToShortcodeMap))
+	exerrors.PanicIfNotNil(file.Close())
 	unicodeToShortcodeMap = make(map[string]string, len(shortcodeToUnicodeMap))
 	for shortcode, emoji := range shortcodeToUnicodeMap {
 		unicodeToShortcodeMap[variationselector.Remove(emoji)] = shortcode
@@ -54,7 +58,25 @@ func GetShortcode(unicode string) string {
 
 func GetUnicode(shortcode string) string {
 	initOnce.Do(doInit)
-	return shortcodeToUnicodeMap[strings.Trim(shortcode, ":")]
+	return shortcodeToUnicodeMap[canonicalShortcode(strings.Trim(shortcode, ":"))]
+}
+
+// CanonicalShortcode resolves aliases of built-in emojis (e.g. thumbsup) to the primary shortcode (e.g. +1),
+// which is the one GetShortcode returns. Skin tone modifiers are preserved. Unknown shortcodes are returned as-is.
+func CanonicalShortcode(shortcode string) string {
+	initOnce.Do(doInit)
+	return canonicalShortcode(shortcode)
+}
+
+func canonicalShortcode(shortcode string) string {
+	base, skinTone, hasSkinTone := strings.Cut(shortcode, "::")
+	canonical, ok := aliasToShortcodeMap[base]
+	if !ok {
+		return shortcode
+	} else if hasSkinTone {
+		return canonical + "::" + skinTone
+	}
+	return canonical
 }
 
 func replaceShortcode(code string) string {
@@ -0,0 +1,50 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package emoji
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalShortcode(t *testing.T) {
+	tests := []struct {
+		name      string
+		shortcode string
+		expected  string
+	}{
+		{"primary", "+1", "+1"},
+		{"alias", "thumbsup", "+1"},
+		{"alias with skin tone", "thumbsup::skin-tone-3", "+1::skin-tone-3"},
+		{"flag alias", "flag-us", "us"},
+		{"custom", "partyparrot", "partyparrot"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, CanonicalShortcode(test.shortcode))
+		})
+	}
+}
+
+func TestAliasesRoundTrip(t *testing.T) {
+	for _, shortcode := range []string{"thumbsup", "satisfied", "poop", "thumbsdown::skin-tone-5"} {
+		unicode := GetUnicode(shortcode)
+		assert.NotEmpty(t, unicode, shortcode)
+		assert.Equal(t, CanonicalShortcode(shortcode), GetShortcode(unicode), shortcode)
+	}
+}
@@ -0,0 +1,124 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+// BlockActionOption is a button or a select menu option in an action block of a Slack message.
+// Matrix users can trigger them by replying to the message with the label.
+type BlockActionOption struct {
+	Label    string
+	BlockID  string
+	ActionID string
+	// Type is the type of the element, either a button or a static select menu
+	Type  slack.MessageElementType
+	Value string
+	// Option is the chosen option for select menus
+	Option *slack.OptionBlockObject
+}
+
+// withBlockActions returns a context that tells the block renderer whether the buttons in the message can be
+// triggered from Matrix. Block actions are sent on behalf of the app that posted the message, so messages without
+// a bot ID (e.g. from Slackbot) can't be interacted with, and neither can any message when using a bot login.
+func withBlockActions(ctx context.Context, source *bridgev2.UserLogin, msg *slack.Msg) context.Context {
+	actionable := msg.BotID != "" && source.Client.(SlackClientProvider).CanTriggerBlockActions()
+	return context.WithValue(ctx, contextKeyBlockActions, actionable)
+}
+
+func blockActionsEnabled(ctx context.Context) bool {
+	actionable, _ := ctx.Value(contextKeyBlockActions).(bool)
+	return actionable
+}
+
+// BlockActionOptions returns the interactive options in the action blocks of a message.
+// Link buttons aren't included, as they're rendered as plain links.
+func BlockActionOptions(blocks slack.Blocks) []BlockActionOption {
+	var options []BlockActionOption
+	for _, block := range blocks.BlockSet {
+		actionBlock, ok := block.(*slack.ActionBlock)
+		if !ok || actionBlock.Elements == nil {
+			continue
+		}
+		for _, element := range actionBlock.Elements.ElementSet {
+			options = append(options, elementActionOptions(actionBlock.BlockID, element)...)
+		}
+	}
+	return options
+}
+
+func elementActionOptions(blockID string, element slack.BlockElement) []BlockActionOption {
+	switch elem := element.(type) {
+	case *slack.ButtonBlockElement:
+		if elem.URL != "" || elem.Text == nil {
+			return nil
+		}
+		return []BlockActionOption{{
+			Label:    elem.Text.Text,
+			BlockID:  blockID,
+			ActionID: elem.ActionID,
+			Type:     slack.METButton,
+			Value:    elem.Value,
+		}}
+	case *slack.SelectBlockElement:
+		if elem.Type != slack.OptTypeStatic {
+			return nil
+		}
+		selectOptions := elem.Options
+		for _, group := range elem.OptionGroups {
+			selectOptions = append(selectOptions, group.Options...)
+		}
+		options := make([]BlockActionOption, 0, len(selectOptions))
+		for _, opt := range selectOptions {
+			if opt.Text == nil {
+				continue
+			}
+			options = append(options, BlockActionOption{
+				Label:    opt.Text.Text,
+				BlockID:  blockID,
+				ActionID: elem.ActionID,
+				Type:     slack.MessageElementType(elem.Type),
+				Value:    opt.Value,
+				Option:   opt,
+			})
+		}
+		return options
+	default:
+		return nil
+	}
+}
+
+// FindBlockActionOption finds the option with the given label. Labels are compared case-insensitively
+// and surrounding brackets are ignored, so the rendered form of a button label also matches.
+func FindBlockActionOption(options []BlockActionOption, label string) *BlockActionOption {
+	label = strings.TrimSpace(label)
+	label = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(label, "["), "]"))
+	if label == "" {
+		return nil
+	}
+	for i := range options {
+		if strings.EqualFold(strings.TrimSpace(options[i].Label), label) {
+			return &options[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+func TestBlockActionOptions(t *testing.T) {
+	plain := func(text string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.PlainTextType, text, false, false)
+	}
+	approve := slack.NewButtonBlockElement("approve", "req-1", plain("Approve"))
+	link := slack.NewButtonBlockElement("open", "", plain("Open")).WithURL("https://example.com")
+	high := slack.NewOptionBlockObject("high", plain("High"), nil)
+	menu := slack.NewOptionsGroupSelectBlockElement(slack.OptTypeStatic, plain("Priority"), "priority",
+		slack.NewOptionGroupBlockElement(plain("Levels"), high),
+	)
+	users := slack.NewOptionsSelectBlockElement(slack.OptTypeUser, plain("User"), "user")
+	blocks := slack.Blocks{BlockSet: []slack.Block{
+		slack.NewSectionBlock(plain("Request"), nil, nil),
+		slack.NewActionBlock("b1", approve, link, menu, users),
+	}}
+
+	options := BlockActionOptions(blocks)
+	require.Len(t, options, 2)
+	assert.Equal(t, BlockActionOption{Label: "Approve", BlockID: "b1", ActionID: "approve", Type: slack.METButton, Value: "req-1"}, options[0])
+	assert.Equal(t, BlockActionOption{Label: "High", BlockID: "b1", ActionID: "priority", Type: "static_select", Value: "high", Option: high}, options[1])
+}
+
+func TestFindBlockActionOption(t *testing.T) {
+	options := []BlockActionOption{{Label: "Approve"}, {Label: "Deny"}}
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Exact", "Approve", "Approve"},
+		{"CaseInsensitive", "  deny\n", "Deny"},
+		{"Brackets", "[Approve]", "Approve"},
+		{"Unknown", "Maybe", ""},
+		{"Empty", "[]", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := FindBlockActionOption(options, test.input)
+			if test.expected == "" {
+				assert.Nil(t, opt)
+			} else {
+				require.NotNil(t, opt)
+				assert.Equal(t, test.expected, opt.Label)
+			}
+		})
+	}
+}
+
+func TestWithBlockActions(t *testing.T) {
+	userLogin := &bridgev2.UserLogin{Client: &fakeClientProvider{blockActions: true}}
+	botLogin := &bridgev2.UserLogin{Client: &fakeClientProvider{}}
+	ctx := context.Background()
+	assert.True(t, blockActionsEnabled(withBlockActions(ctx, userLogin, &slack.Msg{BotID: "B1"})))
+	assert.False(t, blockActionsEnabled(withBlockActions(ctx, userLogin, &slack.Msg{})))
+	assert.False(t, blockActionsEnabled(withBlockActions(ctx, botLogin, &slack.Msg{BotID: "B1"})))
+	assert.False(t, blockActionsEnabled(ctx))
+}
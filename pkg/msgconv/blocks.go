@@ -228,7 +228,7 @@ func (mc *MessageConverter) renderRichTextSectionElements(ctx context.Context, e
 				sc := ctx.Value(contextKeySource).(*bridgev2.UserLogin).Client.(SlackClientProvider)
 				emoji, isImage := sc.GetEmoji(ctx, e.Name)
 				if isImage {
-					htmlText.WriteString(fmt.Sprintf(`<img data-mx-emoticon src="%[1]s" alt=":%[2]s:" title=":%[2]s:" height="32"/>`, emoji, e.Name))
+					mrkdwn.CustomEmojiToHTML(&htmlText, e.Name, id.ContentURIString(emoji))
 				} else {
 					htmlText.WriteString(emoji)
 				}
@@ -237,12 +237,21 @@ func (mc *MessageConverter) renderRichTextSectionElements(ctx context.Context, e
 		case *slack.RichTextSectionColorElement:
 			htmlText.WriteString(e.Value)
 		case *slack.RichTextSectionDateElement:
-			htmlText.WriteString(e.Timestamp.String())
+			var fallback string
+			if e.Fallback != nil {
+				fallback = *e.Fallback
+			}
+			content := fmt.Sprintf("date^%d^%s", e.Timestamp, e.Format)
+			if e.URL != nil {
+				content += "^" + *e.URL
+			}
+			mrkdwn.DateToHTML(&htmlText, content, fallback, mc.SlackMrkdwnParser.Params.Now())
 		default:
 			zerolog.Ctx(ctx).Debug().
 				Type("section_type", e).
 				Str("section_type_name", string(e.RichTextSectionElementType())).
 				Msg("Unsupported Slack rich text section")
+			markUnsupported(ctx)
 		}
 	}
 	return htmlText.String()
@@ -292,16 +301,79 @@ func (mc *MessageConverter) renderSlackBlock(ctx context.Context, block slack.Bl
 					Msg("Unsupported Slack block element")
 				htmlText.WriteString("<i>Slack message contains unsupported elements.</i>")
 				unsupported = true
+				markUnsupported(ctx)
 			}
 		}
 		return htmlText.String(), unsupported
+	case *slack.ActionBlock:
+		return mc.renderSlackActionBlock(ctx, b)
 	default:
 		zerolog.Ctx(ctx).Debug().
 			Type("block_type", b).
 			Type("block_type_name", b.BlockType()).
 			Msg("Unsupported Slack block")
+		markUnsupported(ctx)
+		return "<i>Slack message contains unsupported elements.</i>", true
+	}
+}
+
+// renderSlackActionBlock renders the buttons and select menus of an action block, like the approve and deny
+// buttons in channel join requests. Link buttons work as-is, while other buttons and select menu options can be
+// triggered by replying to the message with the label (see BlockActionOptions). Other elements can only be used
+// in Slack, so the message is marked as unsupported to get a link to the original message. The same applies to
+// all buttons if they can't be triggered from Matrix (see withBlockActions).
+func (mc *MessageConverter) renderSlackActionBlock(ctx context.Context, block *slack.ActionBlock) (string, bool) {
+	var parts []string
+	var hasInteractive, hasUnsupported bool
+	actionable := blockActionsEnabled(ctx)
+	if block.Elements != nil {
+		for _, element := range block.Elements.ElementSet {
+			switch elem := element.(type) {
+			case *slack.ButtonBlockElement:
+				if elem.Text == nil {
+					hasUnsupported = true
+				} else if elem.URL != "" {
+					parts = append(parts, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(elem.URL), html.EscapeString(elem.Text.Text)))
+				} else {
+					parts = append(parts, renderActionLabel(elem.Text.Text))
+					hasInteractive = true
+				}
+			case *slack.SelectBlockElement:
+				options := elementActionOptions(block.BlockID, elem)
+				if len(options) == 0 {
+					hasUnsupported = true
+					continue
+				}
+				labels := make([]string, len(options))
+				for i, opt := range options {
+					labels[i] = renderActionLabel(opt.Label)
+				}
+				selectText := strings.Join(labels, " ")
+				if elem.Placeholder != nil && elem.Placeholder.Text != "" {
+					selectText = fmt.Sprintf("<i>%s:</i> %s", html.EscapeString(elem.Placeholder.Text), selectText)
+				}
+				parts = append(parts, selectText)
+				hasInteractive = true
+			default:
+				hasUnsupported = true
+			}
+		}
+	}
+	if len(parts) == 0 {
+		markUnsupported(ctx)
 		return "<i>Slack message contains unsupported elements.</i>", true
 	}
+	if hasUnsupported || (hasInteractive && !actionable) {
+		markUnsupported(ctx)
+	}
+	if hasInteractive && actionable {
+		parts = append(parts, "<i>(reply with an option to respond)</i>")
+	}
+	return strings.Join(parts, " "), false
+}
+
+func renderActionLabel(label string) string {
+	return fmt.Sprintf("<b>[%s]</b>", html.EscapeString(label))
 }
 
 func getBlockquoteDepth(rawElem slack.RichTextElement) int {
@@ -427,6 +499,7 @@ func (mc *MessageConverter) renderSlackRichTextElement(ctx context.Context, numE
 		panic("renderSlackRichTextElement should not be called with RichTextList")
 	default:
 		zerolog.Ctx(ctx).Debug().Type("element_type", e).Msg("Unsupported Slack rich text element")
+		markUnsupported(ctx)
 		return fmt.Sprintf("<i>Unsupported section %s in Slack text.</i>", e.RichTextElementType())
 	}
 }
@@ -507,7 +580,7 @@ func (mc *MessageConverter) slackBlocksToMatrix(ctx context.Context, portal *bri
 			for _, message_block := range attachment.MessageBlocks {
 				renderedAttachment := mc.blocksToHTML(ctx, message_block.Message.Blocks, true, mentions)
 				htmlText.WriteString(fmt.Sprintf("<blockquote><b>%s</b><br>%s<a href=\"%s\"><i>%s</i></a><br></blockquote>",
-					attachment.AuthorName, renderedAttachment, attachment.FromURL, attachment.Footer))
+					html.EscapeString(attachment.AuthorName), renderedAttachment, html.EscapeString(attachment.FromURL), html.EscapeString(attachment.Footer)))
 			}
 		} else if len(attachment.Blocks.BlockSet) > 0 {
 			for _, message_block := range attachment.Blocks.BlockSet {
@@ -522,15 +595,15 @@ func (mc *MessageConverter) slackBlocksToMatrix(ctx context.Context, portal *bri
 			if len(attachment.AuthorName) > 0 {
 				if len(attachment.AuthorLink) > 0 {
 					attachParts = append(attachParts, fmt.Sprintf("<b><a href=\"%s\">%s</a></b>",
-						attachment.AuthorLink, attachment.AuthorName))
+						html.EscapeString(attachment.AuthorLink), html.EscapeString(attachment.AuthorName)))
 				} else {
-					attachParts = append(attachParts, fmt.Sprintf("<b>%s</b>", attachment.AuthorName))
+					attachParts = append(attachParts, fmt.Sprintf("<b>%s</b>", html.EscapeString(attachment.AuthorName)))
 				}
 			}
 			if len(attachment.Title) > 0 {
 				if len(attachment.TitleLink) > 0 {
 					attachParts = append(attachParts, fmt.Sprintf("<b><a href=\"%s\">%s</a></b>",
-						attachment.TitleLink, mc.mrkdwnToMatrixHtml(ctx, attachment.Title, mentions)))
+						html.EscapeString(attachment.TitleLink), mc.mrkdwnToMatrixHtml(ctx, attachment.Title, mentions)))
 				} else {
 					attachParts = append(attachParts, fmt.Sprintf("<b>%s</b>", mc.mrkdwnToMatrixHtml(ctx, attachment.Title, mentions)))
 				}
@@ -549,7 +622,7 @@ func (mc *MessageConverter) slackBlocksToMatrix(ctx context.Context, portal *bri
 						fieldBody += "<tr>"
 					}
 					fieldBody += fmt.Sprintf("<td><strong>%s</strong><br>%s</td>",
-						field.Title, mc.mrkdwnToMatrixHtml(ctx, field.Value, mentions))
+						html.EscapeString(field.Title), mc.mrkdwnToMatrixHtml(ctx, field.Value, mentions))
 					short = !short && field.Short
 					if !short {
 						fieldBody += "</tr>"
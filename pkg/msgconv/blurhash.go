@@ -0,0 +1,164 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	blurhashComponentsX = 4
+	blurhashComponentsY = 3
+	// blurhashSampleSize is the size images are downscaled to before computing the blurhash,
+	// which doesn't need any detail and is slow on full-size images.
+	blurhashSampleSize = 64
+)
+
+// addImagePlaceholder sets the blurhash of an image in the event content, as well as the dimensions
+// if they aren't known yet. Failures are only logged, as both are optional.
+func addImagePlaceholder(ctx context.Context, data io.ReadSeeker, content *event.MessageEventContent) {
+	hash, width, height, err := imagePlaceholder(data)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("Failed to compute blurhash for image")
+		return
+	}
+	content.Info.Blurhash = hash
+	content.Info.AnoaBlurhash = hash
+	if content.Info.Width == 0 && content.Info.Height == 0 {
+		content.Info.Width, content.Info.Height = width, height
+	}
+}
+
+// imagePlaceholder decodes an image and returns its dimensions and blurhash.
+func imagePlaceholder(data io.ReadSeeker) (hash string, width, height int, err error) {
+	if _, err = data.Seek(0, io.SeekStart); err != nil {
+		return "", 0, 0, err
+	}
+	cfg, _, err := image.DecodeConfig(data)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to decode image config: %w", err)
+	} else if cfg.Width*cfg.Height > MaxDecodePixels {
+		return "", 0, 0, fmt.Errorf("%w (%dx%d)", errImageTooLarge, cfg.Width, cfg.Height)
+	}
+	if _, err = data.Seek(0, io.SeekStart); err != nil {
+		return "", 0, 0, err
+	}
+	img, _, err := image.Decode(data)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	rgba := toRGBA(img)
+	width, height = rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	if width == 0 || height == 0 {
+		return "", 0, 0, errInvalidImage
+	}
+	return encodeBlurhash(downscale(rgba, blurhashSampleSize), blurhashComponentsX, blurhashComponentsY), width, height, nil
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encodeBase83(sb *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := (value / int(math.Pow(83, float64(i)))) % 83
+		sb.WriteByte(base83Chars[digit])
+	}
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+// encodeBlurhash implements the encoder from https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func encodeBlurhash(img *image.RGBA, componentsX, componentsY int) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	factors := make([][3]float64, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var sum [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					px := img.Pix[img.PixOffset(x, y):]
+					for c := range sum {
+						sum[c] += basis * sRGBToLinear(px[c])
+					}
+				}
+			}
+			scale := 1 / float64(w*h)
+			factors[j*componentsX+i] = [3]float64{sum[0] * scale, sum[1] * scale, sum[2] * scale}
+		}
+	}
+
+	var sb strings.Builder
+	encodeBase83(&sb, (componentsX-1)+(componentsY-1)*9, 1)
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, factor := range ac {
+			for _, v := range factor {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maximumValue = float64(quantisedMax+1) / 166
+		encodeBase83(&sb, quantisedMax, 1)
+	} else {
+		encodeBase83(&sb, 0, 1)
+	}
+	encodeBase83(&sb, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, factor := range ac {
+		var value int
+		for _, v := range factor {
+			quantised := int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+			value = value*19 + quantised
+		}
+		encodeBase83(&sb, value, 2)
+	}
+	return sb.String()
+}
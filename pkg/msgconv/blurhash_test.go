@@ -0,0 +1,63 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeBase83(str string) int {
+	value := 0
+	for _, char := range str {
+		value = value*83 + strings.IndexRune(base83Chars, char)
+	}
+	return value
+}
+
+func TestEncodeBlurhash_SolidColor(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 255, G: 128, B: 0, A: 255}}, image.Point{}, draw.Src)
+
+	hash := encodeBlurhash(img, 4, 3)
+	require.Len(t, hash, 1+1+4+2*(4*3-1))
+	assert.Equal(t, (4-1)+(3-1)*9, decodeBase83(hash[:1]))
+	assert.Equal(t, 255<<16|128<<8|0, decodeBase83(hash[2:6]))
+}
+
+func TestImagePlaceholder(t *testing.T) {
+	hash, width, height, err := imagePlaceholder(bytes.NewReader(makeTestPNG(t, 300, 120, "")))
+	require.NoError(t, err)
+	assert.Equal(t, 300, width)
+	assert.Equal(t, 120, height)
+	assert.Len(t, hash, 28)
+
+	_, _, _, err = imagePlaceholder(bytes.NewReader([]byte("not an image")))
+	assert.Error(t, err)
+}
+
+func TestImagePlaceholder_TooLarge(t *testing.T) {
+	_, _, _, err := imagePlaceholder(bytes.NewReader(makeOversizedPNG(t, 20000, 20000)))
+	assert.ErrorIs(t, err, errImageTooLarge)
+}
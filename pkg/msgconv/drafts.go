@@ -0,0 +1,44 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+)
+
+// DraftToMatrix converts the rich text of a Slack draft into Matrix message content.
+// Returns nil if the draft doesn't contain any text.
+func (mc *MessageConverter) DraftToMatrix(ctx context.Context, portal *bridgev2.Portal, source *bridgev2.UserLogin, blocks slack.Blocks) *event.MessageEventContent {
+	if len(blocks.BlockSet) == 0 {
+		return nil
+	}
+	ctx = context.WithValue(ctx, contextKeyPortal, portal)
+	ctx = context.WithValue(ctx, contextKeySource, source)
+	mentions := &event.Mentions{}
+	content := format.HTMLToContent(mc.blocksToHTML(ctx, blocks, false, mentions))
+	if content.Body == "" {
+		return nil
+	}
+	content.Mentions = mentions
+	sanitizeContent(&content)
+	return &content
+}
@@ -0,0 +1,273 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"maunium.net/go/mautrix/event"
+)
+
+// maxSummarizedFileSize is the maximum size of calendar and contact files that are parsed for summaries.
+const maxSummarizedFileSize = 256 * 1024
+
+type fileSummaryType int
+
+const (
+	fileSummaryNone fileSummaryType = iota
+	fileSummaryCalendar
+	fileSummaryContact
+)
+
+func getFileSummaryType(file *slack.File) fileSummaryType {
+	if file.Size > maxSummarizedFileSize {
+		return fileSummaryNone
+	}
+	switch {
+	case file.Mimetype == "text/calendar", strings.EqualFold(path.Ext(file.Name), ".ics"):
+		return fileSummaryCalendar
+	case file.Mimetype == "text/vcard", file.Mimetype == "text/x-vcard", strings.EqualFold(path.Ext(file.Name), ".vcf"):
+		return fileSummaryContact
+	default:
+		return fileSummaryNone
+	}
+}
+
+type contentLine struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// parseContentLines parses the line format shared by iCalendar (RFC 5545) and vCard (RFC 6350) files.
+func parseContentLines(data string) []contentLine {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	// Unfold continuation lines
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+	var lines []contentLine
+	for _, rawLine := range strings.Split(data, "\n") {
+		nameAndParams, value, found := strings.Cut(rawLine, ":")
+		if !found {
+			continue
+		}
+		parts := strings.Split(nameAndParams, ";")
+		line := contentLine{
+			Name:   strings.ToUpper(parts[0]),
+			Params: make(map[string]string, len(parts)-1),
+			Value:  unescapeContentValue(value),
+		}
+		// vCard 3 and 4 property names may be prefixed with a group name
+		if _, name, hasGroup := strings.Cut(line.Name, "."); hasGroup {
+			line.Name = name
+		}
+		for _, param := range parts[1:] {
+			key, val, _ := strings.Cut(param, "=")
+			line.Params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var contentValueUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func unescapeContentValue(val string) string {
+	return contentValueUnescaper.Replace(val)
+}
+
+func parseCalendarTime(line contentLine) (time.Time, bool, error) {
+	if line.Params["VALUE"] == "DATE" || len(line.Value) == 8 {
+		t, err := time.Parse("20060102", line.Value)
+		return t, true, err
+	}
+	loc := time.UTC
+	if tzid := line.Params["TZID"]; tzid != "" {
+		if tzLoc, err := time.LoadLocation(tzid); err == nil {
+			loc = tzLoc
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", strings.TrimSuffix(line.Value, "Z"), loc)
+	return t, false, err
+}
+
+func formatCalendarTime(line contentLine) string {
+	t, allDay, err := parseCalendarTime(line)
+	if err != nil {
+		return line.Value
+	} else if allDay {
+		return t.Format("Mon, 2 Jan 2006")
+	}
+	return t.Format("Mon, 2 Jan 2006 15:04 MST")
+}
+
+type summaryField struct {
+	Label string
+	Value string
+}
+
+func summarizeCalendar(data string) (title string, fields []summaryField) {
+	inEvent := false
+	for _, line := range parseContentLines(data) {
+		switch {
+		case line.Name == "BEGIN" && strings.EqualFold(line.Value, "VEVENT"):
+			inEvent = true
+		case line.Name == "END" && strings.EqualFold(line.Value, "VEVENT"):
+			// Only summarize the first event in the file
+			return
+		case !inEvent:
+		case line.Name == "SUMMARY":
+			title = line.Value
+		case line.Name == "DTSTART":
+			fields = append(fields, summaryField{"Starts", formatCalendarTime(line)})
+		case line.Name == "DTEND":
+			fields = append(fields, summaryField{"Ends", formatCalendarTime(line)})
+		case line.Name == "LOCATION" && line.Value != "":
+			fields = append(fields, summaryField{"Location", line.Value})
+		case line.Name == "ORGANIZER":
+			organizer := line.Params["CN"]
+			if organizer == "" {
+				organizer = strings.TrimPrefix(strings.TrimPrefix(line.Value, "mailto:"), "MAILTO:")
+			}
+			fields = append(fields, summaryField{"Organizer", organizer})
+		case line.Name == "DESCRIPTION" && line.Value != "":
+			fields = append(fields, summaryField{"Description", line.Value})
+		}
+	}
+	return
+}
+
+func summarizeContact(data string) (title string, fields []summaryField) {
+	for _, line := range parseContentLines(data) {
+		switch line.Name {
+		case "FN":
+			title = line.Value
+		case "ORG":
+			fields = append(fields, summaryField{"Organization", strings.Trim(strings.ReplaceAll(line.Value, ";", ", "), ", ")})
+		case "TITLE":
+			fields = append(fields, summaryField{"Title", line.Value})
+		case "TEL":
+			fields = append(fields, summaryField{"Phone", strings.TrimPrefix(line.Value, "tel:")})
+		case "EMAIL":
+			fields = append(fields, summaryField{"Email", line.Value})
+		case "END":
+			// Only summarize the first contact in the file
+			return
+		}
+	}
+	return
+}
+
+// addFileSummary reads a calendar or contact file and sets a human-readable summary as the caption of the file message.
+func addFileSummary(content *event.MessageEventContent, summaryType fileSummaryType, file io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(file, maxSummarizedFileSize))
+	if err != nil {
+		return err
+	}
+	var title, icon string
+	var fields []summaryField
+	switch summaryType {
+	case fileSummaryCalendar:
+		icon = "📅"
+		title, fields = summarizeCalendar(string(data))
+	case fileSummaryContact:
+		icon = "👤"
+		title, fields = summarizeContact(string(data))
+	default:
+		return nil
+	}
+	if title == "" && len(fields) == 0 {
+		return nil
+	}
+	var plain, formatted strings.Builder
+	_, _ = fmt.Fprintf(&plain, "%s %s", icon, title)
+	_, _ = fmt.Fprintf(&formatted, "<p>%s <strong>%s</strong></p>", icon, html.EscapeString(title))
+	if len(fields) > 0 {
+		formatted.WriteString("<ul>")
+		for _, field := range fields {
+			_, _ = fmt.Fprintf(&plain, "\n%s: %s", field.Label, field.Value)
+			_, _ = fmt.Fprintf(&formatted, "<li><strong>%s:</strong> %s</li>", field.Label, event.TextToHTML(field.Value))
+		}
+		formatted.WriteString("</ul>")
+	}
+	if content.FileName == "" {
+		content.FileName = content.Body
+	}
+	content.Body = plain.String()
+	content.Format = event.FormatHTML
+	content.FormattedBody = formatted.String()
+	return nil
+}
+
+func formatEmailAddresses(users []slack.EmailFileUserInfo) string {
+	formatted := make([]string, len(users))
+	for i, user := range users {
+		switch {
+		case user.Original != "":
+			formatted[i] = user.Original
+		case user.Name != "":
+			formatted[i] = fmt.Sprintf("%s <%s>", user.Name, user.Address)
+		default:
+			formatted[i] = user.Address
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// addEmailSummary sets the subject, headers and preview of a forwarded email file as the caption of the file message.
+func addEmailSummary(content *event.MessageEventContent, file *slack.File) {
+	subject := file.Subject
+	if subject == "" {
+		subject = file.Title
+	}
+	fields := make([]summaryField, 0, 4)
+	if len(file.From) > 0 {
+		fields = append(fields, summaryField{"From", formatEmailAddresses(file.From)})
+	}
+	if len(file.To) > 0 {
+		fields = append(fields, summaryField{"To", formatEmailAddresses(file.To)})
+	}
+	if len(file.Cc) > 0 {
+		fields = append(fields, summaryField{"Cc", formatEmailAddresses(file.Cc)})
+	}
+	if file.Headers.Date != "" {
+		fields = append(fields, summaryField{"Date", file.Headers.Date})
+	}
+	var plain, formatted strings.Builder
+	_, _ = fmt.Fprintf(&plain, "✉️ %s", subject)
+	_, _ = fmt.Fprintf(&formatted, "<p>✉️ <strong>%s</strong></p>", html.EscapeString(subject))
+	for _, field := range fields {
+		_, _ = fmt.Fprintf(&plain, "\n%s: %s", field.Label, field.Value)
+		_, _ = fmt.Fprintf(&formatted, "<strong>%s:</strong> %s<br>", html.EscapeString(field.Label), html.EscapeString(field.Value))
+	}
+	if file.Preview != "" {
+		_, _ = fmt.Fprintf(&plain, "\n\n%s", file.Preview)
+		_, _ = fmt.Fprintf(&formatted, "<blockquote>%s</blockquote>", event.TextToHTML(file.Preview))
+	}
+	if content.FileName == "" {
+		content.FileName = content.Body
+	}
+	content.Body = plain.String()
+	content.Format = event.FormatHTML
+	content.FormattedBody = formatted.String()
+}
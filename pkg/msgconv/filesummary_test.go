@@ -0,0 +1,69 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Weekly sync\r\n" +
+	"DTSTART:20240102T150000Z\r\n" +
+	"DTEND:20240102T153000Z\r\n" +
+	"LOCATION:Room 1\\, 2nd floor\r\n" +
+	"ORGANIZER;CN=\"Alice\":mailto:alice@example.com\r\n" +
+	"DESCRIPTION:Agenda:\\n- updates\\n- questions that are very long and\r\n" +
+	"  folded onto the next line\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Second event\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+const testContact = "BEGIN:VCARD\n" +
+	"VERSION:3.0\n" +
+	"FN:Bob Example\n" +
+	"ORG:Example Inc.;Engineering\n" +
+	"item1.TEL;TYPE=CELL:+1 555 0100\n" +
+	"EMAIL;TYPE=INTERNET:bob@example.com\n" +
+	"END:VCARD\n"
+
+func TestSummarizeCalendar(t *testing.T) {
+	title, fields := summarizeCalendar(testCalendar)
+	assert.Equal(t, "Weekly sync", title)
+	assert.Equal(t, []summaryField{
+		{"Starts", "Tue, 2 Jan 2024 15:00 UTC"},
+		{"Ends", "Tue, 2 Jan 2024 15:30 UTC"},
+		{"Location", "Room 1, 2nd floor"},
+		{"Organizer", "Alice"},
+		{"Description", "Agenda:\n- updates\n- questions that are very long and folded onto the next line"},
+	}, fields)
+}
+
+func TestSummarizeContact(t *testing.T) {
+	title, fields := summarizeContact(testContact)
+	assert.Equal(t, "Bob Example", title)
+	assert.Equal(t, []summaryField{
+		{"Organization", "Example Inc., Engineering"},
+		{"Phone", "+1 555 0100"},
+		{"Email", "bob@example.com"},
+	}, fields)
+}
@@ -21,16 +21,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"image"
+	"io"
+	"net/http"
+	"path/filepath"
 	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/slack-go/slack"
+	"go.mau.fi/util/exmime"
 	"go.mau.fi/util/ffmpeg"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/matrix"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/mautrix-slack/pkg/msgconv/matrixfmt"
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
@@ -40,6 +47,10 @@ var (
 	ErrMediaUploadFailed    = errors.New("failed to reupload media")
 	ErrMediaConvertFailed   = errors.New("failed to re-encode media")
 	ErrMediaOnlyEditCaption = errors.New("only media message caption can be edited")
+
+	ErrImageMetadataStripFailed = errors.New("failed to remove image metadata")
+
+	errFileTooLarge = errors.New("file is too large")
 )
 
 func isMediaMsgtype(msgType event.MessageType) bool {
@@ -50,11 +61,77 @@ type ConvertedSlackMessage struct {
 	SendReq    slack.MsgOption
 	FileUpload *slack.UploadFileV2Parameters
 	FileShare  *slack.ShareFileParams
+
+	// FailedParts contains the names of parts of the Matrix message (e.g. inline images)
+	// that couldn't be uploaded to Slack even after retrying, and were left out of the message.
+	FailedParts []string
+}
+
+// BotIdentityOptions returns the message options that apply the custom bot identity configured for the portal.
+// Slack only respects them for messages sent with bot tokens that have the chat:write.customize scope.
+func BotIdentityOptions(portal *bridgev2.Portal) []slack.MsgOption {
+	meta, ok := portal.Metadata.(*slackid.PortalMetadata)
+	if !ok {
+		return nil
+	}
+	var options []slack.MsgOption
+	if meta.BotUsername != "" {
+		options = append(options, slack.MsgOptionUsername(meta.BotUsername))
+	}
+	return append(options, botIconOptions(meta)...)
+}
+
+func botIconOptions(meta *slackid.PortalMetadata) []slack.MsgOption {
+	if IsEmojiShortcode(meta.BotIcon) {
+		return []slack.MsgOption{slack.MsgOptionIconEmoji(meta.BotIcon)}
+	} else if meta.BotIcon != "" {
+		return []slack.MsgOption{slack.MsgOptionIconURL(meta.BotIcon)}
+	}
+	return nil
+}
+
+// relayIdentityOptions returns the message options that show the original Matrix sender of a relayed message.
+// The sender's name and avatar take precedence over the portal's bot identity, which is only used as
+// the fallback icon when the sender has no publicly accessible avatar.
+func (mc *MessageConverter) relayIdentityOptions(portal *bridgev2.Portal, origSender *bridgev2.OrigSender) []slack.MsgOption {
+	options := []slack.MsgOption{slack.MsgOptionUsername(origSender.FormattedName)}
+	urlProvider, ok := mc.Bridge.Matrix.(bridgev2.MatrixConnectorWithPublicMedia)
+	if ok && origSender.AvatarURL != "" {
+		publicAvatarURL := urlProvider.GetPublicMediaAddress(origSender.AvatarURL)
+		if publicAvatarURL != "" {
+			return append(options, slack.MsgOptionIconURL(publicAvatarURL))
+		}
+	}
+	if meta, ok := portal.Metadata.(*slackid.PortalMetadata); ok {
+		options = append(options, botIconOptions(meta)...)
+	}
+	return options
+}
+
+// IsEmojiShortcode returns true if the given string is a single Slack emoji shortcode like :robot_face:.
+func IsEmojiShortcode(val string) bool {
+	return len(val) > 2 && strings.HasPrefix(val, ":") && strings.HasSuffix(val, ":") && !strings.ContainsAny(val[1:len(val)-1], ": ")
+}
+
+// getStickerEmoji returns the ID of a custom emoji in the portal's team that uses the same image as a sticker.
+func (mc *MessageConverter) getStickerEmoji(ctx context.Context, portal *bridgev2.Portal, mxc id.ContentURIString) string {
+	if mc.DB == nil || mxc == "" {
+		return ""
+	}
+	teamID, _ := slackid.ParsePortalID(portal.ID)
+	dbEmoji, err := mc.DB.Emoji.GetByTeamAndMXC(ctx, teamID, mxc)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get emoji by MXC to convert sticker")
+		return ""
+	} else if dbEmoji == nil {
+		return ""
+	}
+	return dbEmoji.EmojiID
 }
 
 func (mc *MessageConverter) ToSlack(
 	ctx context.Context,
-	client *slack.Client,
+	client slackapi.Client,
 	portal *bridgev2.Portal,
 	content *event.MessageEventContent,
 	evt *event.Event,
@@ -65,9 +142,16 @@ func (mc *MessageConverter) ToSlack(
 ) (conv *ConvertedSlackMessage, err error) {
 	log := zerolog.Ctx(ctx)
 
+	var stickerEmojiID string
 	if evt.Type == event.EventSticker {
-		// Slack doesn't have stickers, just bridge stickers as images
-		content.MsgType = event.MsgImage
+		// Slack doesn't have stickers, so send them as a large emoji if there's a matching custom emoji,
+		// and just bridge them as images otherwise.
+		stickerEmojiID = mc.getStickerEmoji(ctx, portal, content.URL)
+		if stickerEmojiID != "" {
+			content.MsgType = event.MsgText
+		} else {
+			content.MsgType = event.MsgImage
+		}
 	}
 
 	var editTargetID, threadRootID string
@@ -100,7 +184,28 @@ func (mc *MessageConverter) ToSlack(
 	case event.MsgText, event.MsgEmote, event.MsgNotice:
 		options := make([]slack.MsgOption, 0, 4)
 		var block slack.Block
-		if content.Format == event.FormatHTML {
+		var failedParts []string
+		if stickerEmojiID != "" {
+			// Messages that only contain an emoji are rendered large by Slack clients
+			block = slack.NewRichTextBlock("", slack.NewRichTextSection(slack.NewRichTextSectionEmojiElement(stickerEmojiID, 0, nil)))
+		} else if content.Format == event.FormatHTML && isRealUser && editTargetID == "" && origSender == nil && content.MsgType != event.MsgEmote {
+			richText, images := mc.MatrixHTMLParser.ParseWithImages(ctx, content.FormattedBody, content.Mentions, portal)
+			block = richText
+			var fileIDs []string
+			fileIDs, failedParts = mc.uploadInlineImages(ctx, client, images)
+			if len(fileIDs) > 0 {
+				_, channelID := slackid.ParsePortalID(portal.ID)
+				fileShare := &slack.ShareFileParams{
+					Files:    fileIDs,
+					Channel:  channelID,
+					ThreadTS: threadRootID,
+				}
+				if len(richText.Elements) > 0 {
+					fileShare.Blocks = []slack.Block{richText}
+				}
+				return &ConvertedSlackMessage{FileShare: fileShare, FailedParts: failedParts}, nil
+			}
+		} else if content.Format == event.FormatHTML {
 			block = mc.MatrixHTMLParser.Parse(ctx, content.FormattedBody, content.Mentions, portal)
 		} else {
 			block = mc.MatrixHTMLParser.ParseText(ctx, content.Body, content.Mentions, portal)
@@ -118,16 +223,11 @@ func (mc *MessageConverter) ToSlack(
 			options = append(options, slack.MsgOptionDisableLinkUnfurl(), slack.MsgOptionDisableMediaUnfurl())
 		}
 		if origSender != nil {
-			options = append(options, slack.MsgOptionUsername(origSender.FormattedName))
-			urlProvider, ok := mc.Bridge.Matrix.(bridgev2.MatrixConnectorWithPublicMedia)
-			if ok && origSender.AvatarURL != "" {
-				publicAvatarURL := urlProvider.GetPublicMediaAddress(origSender.AvatarURL)
-				if publicAvatarURL != "" {
-					options = append(options, slack.MsgOptionIconURL(publicAvatarURL))
-				}
-			}
+			options = append(options, mc.relayIdentityOptions(portal, origSender)...)
+		} else if !isRealUser {
+			options = append(options, BotIdentityOptions(portal)...)
 		}
-		return &ConvertedSlackMessage{SendReq: slack.MsgOptionCompose(options...)}, nil
+		return &ConvertedSlackMessage{SendReq: slack.MsgOptionCompose(options...), FailedParts: failedParts}, nil
 	case event.MsgAudio, event.MsgFile, event.MsgImage, event.MsgVideo:
 		data, err := mc.Bridge.Bot.DownloadMedia(ctx, content.URL, content.File)
 		if err != nil {
@@ -155,6 +255,15 @@ func (mc *MessageConverter) ToSlack(
 		} else if content.MSC3245Voice != nil && content.Info.MimeType == "audio/webm; codecs=opus" {
 			subtype = "slack_audio"
 		}
+		if content.MsgType == event.MsgImage && content.Info != nil && mc.Options().ImageProcessing.Enabled() {
+			var processed *ProcessedImage
+			processed, filename, err = mc.processImageFile(ctx, data, content.Info.MimeType, filename)
+			if err != nil {
+				log.Err(err).Msg("Failed to process image")
+				return nil, ErrImageMetadataStripFailed
+			}
+			data, content.Info.MimeType = processed.Data, processed.MimeType
+		}
 		_, channelID := slackid.ParsePortalID(portal.ID)
 		if !isRealUser {
 			fileUpload := &slack.UploadFileV2Parameters{
@@ -209,11 +318,129 @@ func (mc *MessageConverter) ToSlack(
 	}
 }
 
+// uploadInlineImages uploads inline images from a Matrix message as Slack files and returns the file IDs.
+// Images that fail to upload are retried once, and the names of the ones that still fail are returned
+// separately, so that the rest of the message can be sent without them.
+func (mc *MessageConverter) uploadInlineImages(ctx context.Context, client slackapi.Client, images []matrixfmt.InlineImage) (fileIDs, failed []string) {
+	log := zerolog.Ctx(ctx)
+	uploaded := make([]string, len(images))
+	pending := make([]int, len(images))
+	for i := range images {
+		pending[i] = i
+	}
+	for attempt := 1; attempt <= 2 && len(pending) > 0; attempt++ {
+		var retry []int
+		for _, i := range pending {
+			fileID, err := mc.uploadInlineImage(ctx, client, i, images[i])
+			if err != nil {
+				log.Warn().Err(err).
+					Str("mxc", string(images[i].MXC)).
+					Int("attempt", attempt).
+					Msg("Failed to upload inline image")
+				retry = append(retry, i)
+				continue
+			}
+			uploaded[i] = fileID
+		}
+		pending = retry
+	}
+	for _, i := range pending {
+		failed = append(failed, inlineImageName(i, images[i]))
+	}
+	for _, fileID := range uploaded {
+		if fileID != "" {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	return fileIDs, failed
+}
+
+func inlineImageName(i int, img matrixfmt.InlineImage) string {
+	if img.Alt != "" {
+		return img.Alt
+	}
+	return fmt.Sprintf("image%d", i+1)
+}
+
+func (mc *MessageConverter) uploadInlineImage(ctx context.Context, client slackapi.Client, i int, img matrixfmt.InlineImage) (string, error) {
+	data, err := mc.downloadInlineImage(ctx, img.MXC)
+	if err != nil {
+		return "", fmt.Errorf("failed to download: %w", err)
+	}
+	mimeType := http.DetectContentType(data)
+	filename := inlineImageName(i, img)
+	if mc.Options().ImageProcessing.Enabled() {
+		var processed *ProcessedImage
+		processed, filename, err = mc.processImageFile(ctx, data, mimeType, filename)
+		if err != nil {
+			return "", err
+		}
+		data, mimeType = processed.Data, processed.MimeType
+	}
+	if filepath.Ext(filename) == "" {
+		filename += exmime.ExtensionFromMimetype(mimeType)
+	}
+	resp, err := client.GetFileUploadURL(ctx, slack.GetFileUploadURLParameters{
+		Filename: filename,
+		Length:   len(data),
+	})
+	if err == nil {
+		err = client.UploadToURL(ctx, resp, mimeType, data)
+	}
+	if err == nil {
+		err = client.CompleteFileUpload(ctx, resp)
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.File, nil
+}
+
+// downloadInlineImage downloads an inline image from Matrix, refusing anything larger than MaxFileSize.
+// Inline images don't have any size info, so the limit is enforced on the response itself.
+func (mc *MessageConverter) downloadInlineImage(ctx context.Context, uri id.ContentURIString) ([]byte, error) {
+	matrixConn, ok := mc.Bridge.Matrix.(*matrix.Connector)
+	if !ok {
+		data, err := mc.Bridge.Bot.DownloadMedia(ctx, uri, nil)
+		if err == nil && len(data) > mc.MaxFileSize {
+			return nil, errFileTooLarge
+		}
+		return data, err
+	}
+	parsedURI, err := uri.Parse()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := matrixConn.Bot.Download(ctx, parsedURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength > int64(mc.MaxFileSize) {
+		return nil, errFileTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(mc.MaxFileSize)+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > mc.MaxFileSize {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
+
 func (mc *MessageConverter) uploadMedia(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, data []byte, content *event.MessageEventContent) error {
+	if mc.Options().ImageProcessing.Enabled() && strings.HasPrefix(content.Info.MimeType, "image/") {
+		processed, fileName, err := mc.processImageFile(ctx, data, content.Info.MimeType, content.Body)
+		if err != nil {
+			return err
+		}
+		content.Body = fileName
+		data, content.Info.MimeType = processed.Data, processed.MimeType
+		content.Info.Width, content.Info.Height = processed.Width, processed.Height
+	}
 	content.Info.Size = len(data)
-	if content.Info.Width == 0 && content.Info.Height == 0 && strings.HasPrefix(content.Info.MimeType, "image/") {
-		cfg, _, _ := image.DecodeConfig(bytes.NewReader(data))
-		content.Info.Width, content.Info.Height = cfg.Width, cfg.Height
+	if strings.HasPrefix(content.Info.MimeType, "image/") {
+		addImagePlaceholder(ctx, bytes.NewReader(data), content)
 	}
 
 	mxc, file, err := intent.UploadMedia(ctx, portal.MXID, data, "", content.Info.MimeType)
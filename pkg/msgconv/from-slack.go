@@ -21,7 +21,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"image"
 	"io"
 	"net/http"
 	"os"
@@ -42,6 +41,7 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
 
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
@@ -54,6 +54,7 @@ func (mc *MessageConverter) ToMatrix(
 ) *bridgev2.ConvertedMessage {
 	ctx = context.WithValue(ctx, contextKeyPortal, portal)
 	ctx = context.WithValue(ctx, contextKeySource, source)
+	ctx = withBlockActions(ctx, source, msg)
 	client := source.Client.(SlackClientProvider).GetClient()
 	output := &bridgev2.ConvertedMessage{}
 	if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
@@ -62,6 +63,7 @@ func (mc *MessageConverter) ToMatrix(
 	}
 	textPart := mc.makeTextPart(ctx, msg, portal, intent)
 	if textPart != nil {
+		mc.maybeTranslate(ctx, portal, textPart)
 		output.Parts = append(output.Parts, textPart)
 	}
 	for i, file := range msg.Files {
@@ -84,9 +86,28 @@ func (mc *MessageConverter) ToMatrix(
 			output.Parts = append(output.Parts, part)
 		}
 	}
-	if output.MergeCaption() {
+	if len(output.Parts) == 0 && len(msg.Files) == 0 {
+		description := "Unsupported Slack message"
+		if msg.SubType != "" {
+			description = fmt.Sprintf("Unsupported Slack message of type %s", msg.SubType)
+		}
+		output.Parts = append(output.Parts, makeUnsupportedMessage("", description, mc.messagePermalink(ctx, portal, msg.Timestamp)))
+	}
+	captionMerged := output.MergeCaption()
+	hasBlockActions := blockActionsEnabled(ctx) && len(BlockActionOptions(msg.Blocks)) > 0
+	if captionMerged || hasBlockActions {
 		output.Parts[0].DBMetadata = &slackid.MessageMetadata{
-			CaptionMerged: true,
+			CaptionMerged:   captionMerged,
+			HasBlockActions: hasBlockActions,
+		}
+	}
+	if output.ThreadRoot != nil && (msg.SubType == slack.MsgSubTypeThreadBroadcast || msg.SubType == slack.MsgSubTypeReplyBroadcast) {
+		// Broadcast replies are bridged into the thread, but flagged so clients can also show them in the main timeline
+		for _, part := range output.Parts {
+			if part.Extra == nil {
+				part.Extra = make(map[string]any)
+			}
+			part.Extra["fi.mau.slack.thread_broadcast"] = true
 		}
 	}
 	if msg.Username != "" {
@@ -112,49 +133,49 @@ func (mc *MessageConverter) EditToMatrix(
 ) *bridgev2.ConvertedEdit {
 	ctx = context.WithValue(ctx, contextKeyPortal, portal)
 	ctx = context.WithValue(ctx, contextKeySource, source)
+	ctx = withBlockActions(ctx, source, msg)
 	client := source.Client.(SlackClientProvider).GetClient()
 	output := &bridgev2.ConvertedEdit{}
 	existingMap := make(map[networkid.PartID]*database.Message, len(existing))
 	for _, part := range existing {
 		existingMap[part.PartID] = part
-		partType, _, innerPartID, ok := slackid.ParsePartID(part.PartID)
-		if ok && partType == slackid.PartTypeAttachment {
-			innerPartIDInt, _ := strconv.Atoi(innerPartID)
-			attachmentStillExists := slices.ContainsFunc(msg.Attachments, func(attachment slack.Attachment) bool {
-				return attachment.ID == innerPartIDInt
-			})
-			if !attachmentStillExists {
-				output.DeletedParts = append(output.DeletedParts, part)
-			}
-		}
 	}
 	editTargetPart := existing[0]
-	editTargetPart.Metadata.(*slackid.MessageMetadata).LastEditTS = msg.Edited.Timestamp
+	output.DeletedParts = removedParts(existing[1:], msg)
 	modifiedPart := mc.makeTextPart(ctx, msg, portal, intent)
+	mc.maybeTranslate(ctx, portal, modifiedPart)
 	captionMerged := false
 	for i, file := range msg.Files {
 		partID := slackid.MakePartID(slackid.PartTypeFile, i, file.ID)
 		existingPart, ok := existingMap[partID]
 		if file.Mode == "tombstone" {
-			if ok {
+			if ok && existingPart != editTargetPart {
 				output.DeletedParts = append(output.DeletedParts, existingPart)
 			}
 		} else {
 			// For edits where there's either only one media part, or there was no text part,
 			// we'll need to fetch the first media part to merge it in
 			if !captionMerged && modifiedPart != nil && (len(msg.Files) == 1 || editTargetPart.PartID != "") {
-				if editTargetPart.PartID != "" {
+				if editTargetPart.PartID != "" && ok {
 					editTargetPart = existingPart
 				}
 				filePart := mc.slackFileToMatrix(ctx, portal, intent, client, partID, &file)
 				modifiedPart = bridgev2.MergeCaption(modifiedPart, filePart)
-				modifiedPart.DBMetadata = &slackid.MessageMetadata{
-					CaptionMerged: true,
-				}
+				mergedMeta := *editTargetPart.Metadata.(*slackid.MessageMetadata)
+				mergedMeta.CaptionMerged = true
+				modifiedPart.DBMetadata = &mergedMeta
 				captionMerged = true
 			}
 		}
 	}
+	if modifiedPart == nil {
+		return output
+	}
+	if modifiedPart.DBMetadata != nil {
+		modifiedPart.DBMetadata.(*slackid.MessageMetadata).LastEditTS = msg.Edited.Timestamp
+	} else {
+		editTargetPart.Metadata.(*slackid.MessageMetadata).LastEditTS = msg.Edited.Timestamp
+	}
 	if msg.Username != "" {
 		modifiedPart.Content.BeeperPerMessageProfile = &event.BeeperPerMessageProfile{
 			ID:          msg.Username,
@@ -162,13 +183,63 @@ func (mc *MessageConverter) EditToMatrix(
 		}
 	}
 	// TODO this doesn't handle edits to captions in msg.Attachments gifs properly
-	if modifiedPart != nil {
-		output.ModifiedParts = append(output.ModifiedParts, modifiedPart.ToEditPart(editTargetPart))
-	}
+	output.ModifiedParts = append(output.ModifiedParts, modifiedPart.ToEditPart(editTargetPart))
 	return output
 }
 
+// removedParts returns the existing file and attachment parts whose file or attachment is no longer in the edited message.
+// Tombstoned files are handled separately, as Slack keeps them in the file list.
+func removedParts(existing []*database.Message, msg *slack.Msg) (removed []*database.Message) {
+	for _, part := range existing {
+		partType, _, innerPartID, ok := slackid.ParsePartID(part.PartID)
+		if !ok {
+			continue
+		}
+		var stillExists bool
+		switch partType {
+		case slackid.PartTypeAttachment:
+			innerPartIDInt, _ := strconv.Atoi(innerPartID)
+			stillExists = slices.ContainsFunc(msg.Attachments, func(attachment slack.Attachment) bool {
+				return attachment.ID == innerPartIDInt
+			})
+		case slackid.PartTypeFile:
+			stillExists = slices.ContainsFunc(msg.Files, func(file slack.File) bool {
+				return file.ID == innerPartID
+			})
+		default:
+			stillExists = true
+		}
+		if !stillExists {
+			removed = append(removed, part)
+		}
+	}
+	return
+}
+
+// EditChangesContent returns true if the edited message differs from the previous version in a way that's
+// visible on Matrix, i.e. the text, the attached files or the attachments changed.
+func EditChangesContent(msg, prevMsg *slack.Msg) bool {
+	if prevMsg == nil {
+		return true
+	} else if msg.Text != prevMsg.Text || len(msg.Files) != len(prevMsg.Files) || len(msg.Attachments) != len(prevMsg.Attachments) {
+		return true
+	}
+	for i, file := range msg.Files {
+		if file.ID != prevMsg.Files[i].ID || file.Mode != prevMsg.Files[i].Mode {
+			return true
+		}
+	}
+	for i, attachment := range msg.Attachments {
+		prevAttachment := prevMsg.Attachments[i]
+		if attachment.ID != prevAttachment.ID || attachment.Text != prevAttachment.Text || attachment.Fallback != prevAttachment.Fallback {
+			return true
+		}
+	}
+	return false
+}
+
 func (mc *MessageConverter) makeTextPart(ctx context.Context, msg *slack.Msg, portal *bridgev2.Portal, intent bridgev2.MatrixAPI) *bridgev2.ConvertedMessagePart {
+	ctx, unsupported := withUnsupportedTracker(ctx)
 	var text string
 	if msg.Text != "" {
 		text = msg.Text
@@ -199,6 +270,10 @@ func (mc *MessageConverter) makeTextPart(ctx context.Context, msg *slack.Msg, po
 			textPart.Content.Body += fmt.Sprintf("\n\nJoin via the Slack app: https://app.slack.com/client/%s/%s", teamID, channelID)
 			textPart.Content.FormattedBody += fmt.Sprintf(`<p><a href="https://app.slack.com/client/%s/%s">Click here to join via the Slack app</a></p>`, teamID, channelID)
 		}
+		if *unsupported {
+			addUnsupportedNotice(textPart.Content, mc.messagePermalink(ctx, portal, msg.Timestamp))
+		}
+		sanitizeContent(textPart.Content)
 	}
 	return textPart
 }
@@ -242,7 +317,21 @@ func (dtwp *doctypeCheckingWriteProxy) Write(p []byte) (n int, err error) {
 	return dtwp.Writer.Write(p)
 }
 
-func (mc *MessageConverter) slackFileToMatrix(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, client *slack.Client, partID networkid.PartID, file *slack.File) *bridgev2.ConvertedMessagePart {
+// limitedWriter fails once more than N bytes in total have been written to it.
+type limitedWriter struct {
+	io.Writer
+	N int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (n int, err error) {
+	if int64(len(p)) > lw.N {
+		return 0, errFileTooLarge
+	}
+	lw.N -= int64(len(p))
+	return lw.Writer.Write(p)
+}
+
+func (mc *MessageConverter) slackFileToMatrix(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, client slackapi.Client, partID networkid.PartID, file *slack.File) *bridgev2.ConvertedMessagePart {
 	log := zerolog.Ctx(ctx).With().Str("file_id", file.ID).Logger()
 	if file.FileAccess == "check_file_info" {
 		connectFile, _, _, err := client.GetFileInfoContext(ctx, file.ID, 0, 0)
@@ -264,12 +353,21 @@ func (mc *MessageConverter) slackFileToMatrix(ctx context.Context, portal *bridg
 		url = file.URLPrivate
 	}
 	if url == "" && file.PermalinkPublic == "" {
-		log.Warn().Msg("No usable URL found in file object")
+		log.Warn().Str("file_mode", file.Mode).Msg("No usable URL found in file object")
+		if file.Permalink != "" {
+			name := file.Title
+			if name == "" {
+				name = file.Name
+			}
+			return makeUnsupportedMessage(partID, strings.TrimSpace("Unsupported file "+name), file.Permalink)
+		}
 		return makeErrorMessage(partID, "File URL not found")
 	}
 	convertAudio := file.SubType == "slack_audio" && ffmpeg.Supported()
-	needsMediaSize := content.Info.Width == 0 && content.Info.Height == 0 && strings.HasPrefix(content.Info.MimeType, "image/")
-	requireFile := convertAudio || needsMediaSize
+	isImage := strings.HasPrefix(content.Info.MimeType, "image/")
+	processImage := mc.Options().ImageProcessing.Enabled() && isImage
+	summaryType := getFileSummaryType(file)
+	requireFile := convertAudio || processImage || summaryType != fileSummaryNone
 	var retErr *bridgev2.ConvertedMessagePart
 	var uploadErr error
 	content.URL, content.File, uploadErr = intent.UploadMediaStream(ctx, portal.MXID, int64(file.Size), requireFile, func(dest io.Writer) (res *bridgev2.FileStreamResult, err error) {
@@ -278,22 +376,37 @@ func (mc *MessageConverter) slackFileToMatrix(ctx context.Context, portal *bridg
 			FileName:        file.Name,
 			MimeType:        content.Info.MimeType,
 		}
+		// The size in the file object isn't guaranteed to match the download, so enforce the limit on the data too
+		maxSize := int64(mc.MaxFileSize)
 		if url != "" {
-			err = client.GetFileContext(ctx, url, &doctypeCheckingWriteProxy{Writer: dest})
+			err = client.GetFileContext(ctx, url, &doctypeCheckingWriteProxy{Writer: &limitedWriter{Writer: dest, N: maxSize}})
 			if errors.Is(err, errHTMLFile) {
 				log.Warn().Msg("Received HTML file from Slack, retrying in 5 seconds")
 				time.Sleep(5 * time.Second)
-				err = client.GetFileContext(ctx, url, dest)
+				err = client.GetFileContext(ctx, url, &limitedWriter{Writer: dest, N: maxSize})
 			}
 		} else if file.PermalinkPublic != "" {
 			var resp *http.Response
 			// TODO don't use DefaultClient and use context
 			resp, err = http.DefaultClient.Get(file.PermalinkPublic)
 			if err == nil {
-				_, err = io.Copy(dest, resp.Body)
+				if resp.ContentLength > maxSize {
+					err = errFileTooLarge
+				} else {
+					var n int64
+					n, err = io.Copy(dest, io.LimitReader(resp.Body, maxSize+1))
+					if err == nil && n > maxSize {
+						err = errFileTooLarge
+					}
+				}
+				_ = resp.Body.Close()
 			}
 		}
-		if err != nil {
+		if errors.Is(err, errFileTooLarge) {
+			log.Debug().Int64("max_size", maxSize).Msg("Dropping file that turned out to be too large")
+			retErr = makeErrorMessage(partID, "Too large file (over %d MB)", maxSize/1_000_000)
+			return
+		} else if err != nil {
 			log.Err(err).Msg("Failed to download file from Slack")
 			retErr = makeErrorMessage(partID, "Failed to download file from Slack")
 			return
@@ -335,12 +448,31 @@ func (mc *MessageConverter) slackFileToMatrix(ctx context.Context, portal *bridg
 				Waveform: file.AudioWaveSamples,
 			}
 			content.MSC3245Voice = &event.MSC3245Voice{}
-		} else if needsMediaSize {
+		} else if processImage {
+			err = mc.processSlackImage(ctx, dest.(*os.File), &content, res)
+			if err != nil {
+				log.Err(err).Msg("Failed to replace file with processed image")
+				retErr = makeErrorMessage(partID, "Failed to process image")
+				return
+			}
+		} else if summaryType != fileSummaryNone {
 			destRS := dest.(io.ReadSeeker)
 			_, err = destRS.Seek(0, io.SeekStart)
 			if err == nil {
-				cfg, _, _ := image.DecodeConfig(destRS)
-				content.Info.Width, content.Info.Height = cfg.Width, cfg.Height
+				err = addFileSummary(&content, summaryType, destRS)
+			}
+			if err != nil {
+				// The summary is optional, so just bridge the plain file if it fails
+				log.Warn().Err(err).Msg("Failed to generate file summary")
+				err = nil
+			}
+		}
+		if isImage {
+			// Small files that don't need processing are downloaded into memory instead of a temp file
+			if buf, ok := dest.(*bytes.Buffer); ok {
+				addImagePlaceholder(ctx, bytes.NewReader(buf.Bytes()), &content)
+			} else if destRS, ok := dest.(io.ReadSeeker); ok {
+				addImagePlaceholder(ctx, destRS, &content)
 			}
 		}
 		return
@@ -358,6 +490,13 @@ func (mc *MessageConverter) slackFileToMatrix(ctx context.Context, portal *bridg
 		}
 		return makeErrorMessage(partID, "Failed to transfer file")
 	}
+	if isImage {
+		mc.addSlackThumbnail(ctx, portal, intent, client, file, &content)
+	}
+	if file.Filetype == "email" {
+		addEmailSummary(&content, file)
+	}
+	sanitizeContent(&content)
 	return &bridgev2.ConvertedMessagePart{
 		ID:      partID,
 		Type:    event.EventMessage,
@@ -365,6 +504,46 @@ func (mc *MessageConverter) slackFileToMatrix(ctx context.Context, portal *bridg
 	}
 }
 
+// thumbnailMinDimension is the size above which images bridged to Matrix get a thumbnail,
+// so that clients don't have to download the full image just to render the timeline.
+const thumbnailMinDimension = 1024
+
+// addSlackThumbnail reuploads one of the thumbnails Slack generated for a large image.
+// Thumbnails are optional, so failures are only logged.
+func (mc *MessageConverter) addSlackThumbnail(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, client slackapi.Client, file *slack.File, content *event.MessageEventContent) {
+	if max(content.Info.Width, content.Info.Height) <= thumbnailMinDimension {
+		return
+	}
+	thumbURL, width, height := file.Thumb720, file.Thumb720W, file.Thumb720H
+	if thumbURL == "" {
+		thumbURL, width, height = file.Thumb480, file.Thumb480W, file.Thumb480H
+	}
+	if thumbURL == "" {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	var buf bytes.Buffer
+	err := client.GetFileContext(ctx, thumbURL, &buf)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to download thumbnail from Slack")
+		return
+	}
+	data := buf.Bytes()
+	mimeType := http.DetectContentType(data)
+	mxc, encryptedFile, err := intent.UploadMedia(ctx, portal.MXID, data, "", mimeType)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to upload thumbnail to Matrix")
+		return
+	}
+	content.Info.ThumbnailURL, content.Info.ThumbnailFile = mxc, encryptedFile
+	content.Info.ThumbnailInfo = &event.FileInfo{
+		MimeType: mimeType,
+		Size:     len(data),
+		Width:    width,
+		Height:   height,
+	}
+}
+
 func convertSlackFileMetadata(file *slack.File) event.MessageEventContent {
 	content := event.MessageEventContent{
 		Info: &event.FileInfo{
@@ -383,6 +562,8 @@ func convertSlackFileMetadata(file *slack.File) event.MessageEventContent {
 	}
 	if file.Name != "" {
 		content.Body = file.Name
+	} else if file.Filetype == "email" {
+		content.Body = "email.eml"
 	} else {
 		mimeClass := strings.Split(file.Mimetype, "/")[0]
 		switch mimeClass {
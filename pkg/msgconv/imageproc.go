@@ -0,0 +1,438 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ffmpeg"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+)
+
+// ImageProcessing configures optional changes made to images bridged in either direction.
+type ImageProcessing struct {
+	// StripMetadata removes EXIF (including GPS location), XMP and IPTC metadata from JPEG and PNG images.
+	StripMetadata bool `yaml:"strip_metadata"`
+	// ConvertHEIC converts HEIC/HEIF images to JPEG using ffmpeg.
+	ConvertHEIC bool `yaml:"convert_heic"`
+	// MaxDimension downscales images whose width or height is larger than this. 0 means no limit.
+	MaxDimension int `yaml:"max_dimension"`
+}
+
+// Enabled returns true if any processing step is enabled.
+func (ip *ImageProcessing) Enabled() bool {
+	return ip.StripMetadata || ip.ConvertHEIC || ip.MaxDimension > 0
+}
+
+// ProcessedImage is the result of ImageProcessing.Process.
+type ProcessedImage struct {
+	Data     []byte
+	MimeType string
+	Width    int
+	Height   int
+	// Changed is true if Data is different from the input.
+	Changed bool
+}
+
+const jpegQuality = 90
+
+// MaxDecodePixels is the largest image (width × height) that will be fully decoded for processing.
+// Larger images aren't rotated or resized, as decoding them could use gigabytes of memory,
+// but their metadata is still stripped if enabled.
+const MaxDecodePixels = 40_000_000
+
+var errImageTooLarge = errors.New("image is too large to decode")
+
+func isHEIC(mimeType string) bool {
+	return mimeType == "image/heic" || mimeType == "image/heif"
+}
+
+// Process applies the enabled processing steps to an image. Images in formats that aren't supported
+// are returned as-is, so callers don't need to check the type first.
+func (ip *ImageProcessing) Process(ctx context.Context, data []byte, mimeType string) (*ProcessedImage, error) {
+	out := &ProcessedImage{Data: data, MimeType: mimeType}
+	if isHEIC(mimeType) && ip.ConvertHEIC {
+		if !ffmpeg.Supported() {
+			zerolog.Ctx(ctx).Debug().Msg("Not converting HEIC image as ffmpeg isn't installed")
+			return out, nil
+		}
+		converted, err := ffmpeg.ConvertBytes(ctx, data, ".jpg", nil, []string{"-frames:v", "1", "-q:v", "2"}, mimeType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert HEIC image: %w", err)
+		}
+		out.Data, out.MimeType, out.Changed = converted, "image/jpeg", true
+		// ffmpeg doesn't copy metadata into the output by default
+	}
+	if out.MimeType != "image/jpeg" && out.MimeType != "image/png" {
+		return out, nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(out.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image config: %w", err)
+	}
+	out.Width, out.Height = cfg.Width, cfg.Height
+	orientation := 1
+	if out.MimeType == "image/jpeg" {
+		orientation = jpegOrientation(out.Data)
+	}
+	needsResize := ip.MaxDimension > 0 && max(cfg.Width, cfg.Height) > ip.MaxDimension
+	// Stripping EXIF also removes the orientation tag, so rotated images have to be rotated for real
+	needsRotate := ip.StripMetadata && orientation > 1
+	canDecode := cfg.Width*cfg.Height <= MaxDecodePixels
+	if (needsResize || needsRotate) && !canDecode && !ip.StripMetadata {
+		return nil, fmt.Errorf("%w (%dx%d)", errImageTooLarge, cfg.Width, cfg.Height)
+	} else if (needsResize || needsRotate) && canDecode {
+		img, _, err := image.Decode(bytes.NewReader(out.Data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		// Re-encoding drops all metadata, so the orientation must always be applied here
+		rgba := applyOrientation(toRGBA(img), orientation)
+		if needsResize {
+			rgba = downscale(rgba, ip.MaxDimension)
+		}
+		var buf bytes.Buffer
+		if out.MimeType == "image/png" {
+			err = png.Encode(&buf, rgba)
+		} else {
+			err = jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: jpegQuality})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		out.Data, out.Changed = buf.Bytes(), true
+		out.Width, out.Height = rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	} else if ip.StripMetadata {
+		if !canDecode {
+			zerolog.Ctx(ctx).Debug().
+				Int("width", cfg.Width).
+				Int("height", cfg.Height).
+				Msg("Image is too large to decode, only stripping metadata")
+		}
+		var stripped []byte
+		if out.MimeType == "image/png" {
+			stripped, err = stripPNGMetadata(out.Data)
+		} else {
+			// The image wasn't rotated, so the orientation has to be kept
+			stripped, err = stripJPEGMetadata(out.Data, orientation)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to strip metadata: %w", err)
+		}
+		if len(stripped) != len(out.Data) {
+			out.Data, out.Changed = stripped, true
+		}
+	}
+	return out, nil
+}
+
+// processImageFile runs the configured image processing on a file being bridged, updating the file name
+// to match the new format. If processing fails, the original file is kept, unless metadata stripping is enabled,
+// in which case an error is returned so that the metadata isn't leaked.
+func (mc *MessageConverter) processImageFile(ctx context.Context, data []byte, mimeType, fileName string) (*ProcessedImage, string, error) {
+	opts := &mc.Options().ImageProcessing
+	processed, err := opts.Process(ctx, data, mimeType)
+	if err != nil && opts.StripMetadata {
+		return nil, "", fmt.Errorf("%w: %w", ErrImageMetadataStripFailed, err)
+	} else if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("mime_type", mimeType).Msg("Failed to process image, bridging it as-is")
+		return &ProcessedImage{Data: data, MimeType: mimeType}, fileName, nil
+	}
+	if processed.MimeType != mimeType && processed.MimeType == "image/jpeg" {
+		fileName = fileName[:len(fileName)-len(filepath.Ext(fileName))] + ".jpg"
+	}
+	return processed, fileName, nil
+}
+
+// processSlackImage processes an image downloaded from Slack in place, updating the event content and upload
+// parameters to match. Images that can't be processed are bridged unchanged, unless their metadata
+// was supposed to be stripped.
+func (mc *MessageConverter) processSlackImage(ctx context.Context, file *os.File, content *event.MessageEventContent, res *bridgev2.FileStreamResult) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	processed, fileName, err := mc.processImageFile(ctx, data, content.Info.MimeType, res.FileName)
+	if err != nil {
+		return err
+	} else if processed.Changed {
+		if err = file.Truncate(0); err != nil {
+			return err
+		} else if _, err = file.WriteAt(processed.Data, 0); err != nil {
+			return err
+		}
+		if content.Body == res.FileName {
+			content.Body = fileName
+		} else if content.FileName == res.FileName {
+			content.FileName = fileName
+		}
+		res.FileName, res.MimeType = fileName, processed.MimeType
+		content.Info.MimeType = processed.MimeType
+		content.Info.Size = len(processed.Data)
+	}
+	if processed.Width != 0 {
+		content.Info.Width, content.Info.Height = processed.Width, processed.Height
+	}
+	return nil
+}
+
+var errInvalidImage = errors.New("invalid image data")
+
+// jpegMetadataMarkers are the JPEG segments that contain EXIF/XMP (APP1), IPTC (APP13) and comments (COM).
+// APP0 (JFIF), APP2 (ICC color profile) and APP14 (Adobe color transform) are kept as they affect rendering.
+var jpegMetadataMarkers = map[byte]bool{0xE1: true, 0xED: true, 0xFE: true}
+
+// stripJPEGMetadata removes the metadata segments from a JPEG image. If orientation is above 1,
+// a minimal EXIF segment containing only the orientation is added, so that the image still displays correctly.
+func stripJPEGMetadata(data []byte, orientation int) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errInvalidImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	if orientation > 1 {
+		out = append(out, makeOrientationEXIF(orientation)...)
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errInvalidImage
+		}
+		pos = skipJPEGFillBytes(data, pos)
+		if pos+4 > len(data) {
+			return nil, errInvalidImage
+		}
+		marker := data[pos+1]
+		if marker == 0xDA {
+			// Start of scan, the rest is image data
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errInvalidImage
+		}
+		if !jpegMetadataMarkers[marker] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return append(out, data[pos:]...), nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the ancillary PNG chunks that can contain EXIF data, free text or timestamps.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errInvalidImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errInvalidImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		// Length, type, data and CRC
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errInvalidImage
+		}
+		if !pngMetadataChunks[chunkType] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return out, nil
+}
+
+// skipJPEGFillBytes returns the position of the marker at pos, skipping any 0xFF fill bytes before it.
+func skipJPEGFillBytes(data []byte, pos int) int {
+	for pos+1 < len(data) && data[pos] == 0xFF && data[pos+1] == 0xFF {
+		pos++
+	}
+	return pos
+}
+
+// makeOrientationEXIF returns an APP1 segment with a big-endian EXIF structure that only contains the orientation tag.
+func makeOrientationEXIF(orientation int) []byte {
+	seg := []byte{0xFF, 0xE1, 0, 34}
+	seg = append(seg, "Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08"...)
+	// One IFD entry: orientation (0x0112), type SHORT, count 1, value padded to 4 bytes
+	seg = append(seg, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0)
+	// No next IFD
+	return append(seg, 0, 0, 0, 0)
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, or 1 if it doesn't have one.
+func jpegOrientation(data []byte) int {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		pos = skipJPEGFillBytes(data, pos)
+		if pos+4 > len(data) || data[pos+1] == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[pos+4 : end]
+		if data[pos+1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// applyOrientation transforms the image according to an EXIF orientation value, so that it displays
+// correctly without the orientation tag.
+func applyOrientation(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], img.Pix[img.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// downscale shrinks the image so that neither side is larger than maxDimension, averaging the
+// source pixels that fall into each destination pixel.
+func downscale(img *image.RGBA, maxDimension int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if max(w, h) <= maxDimension {
+		return img
+	}
+	dstW, dstH := maxDimension, max(h*maxDimension/w, 1)
+	if h > w {
+		dstW, dstH = max(w*maxDimension/h, 1), maxDimension
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for dy := 0; dy < dstH; dy++ {
+		y0, y1 := dy*h/dstH, max((dy+1)*h/dstH, dy*h/dstH+1)
+		for dx := 0; dx < dstW; dx++ {
+			x0, x1 := dx*w/dstW, max((dx+1)*w/dstW, dx*w/dstW+1)
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					px := img.Pix[img.PixOffset(x, y):][:4]
+					for i := range sum {
+						sum[i] += int(px[i])
+					}
+				}
+			}
+			count := (x1 - x0) * (y1 - y0)
+			out := dst.Pix[dst.PixOffset(dx, dy):][:4]
+			for i := range sum {
+				out[i] = uint8(sum[i] / count)
+			}
+		}
+	}
+	return dst
+}
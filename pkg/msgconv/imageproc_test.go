@@ -0,0 +1,214 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 10), G: uint8(y * 10), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+// makeTestJPEG encodes a JPEG with an EXIF segment containing only the orientation tag.
+func makeTestJPEG(t *testing.T, w, h, orientation int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, makeTestImage(w, h), nil))
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], uint16(orientation))
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(app1, segment...)...), data[2:]...)
+}
+
+func makeTestPNG(t *testing.T, w, h int, text string) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, makeTestImage(w, h)))
+	data := buf.Bytes()
+	if text == "" {
+		return data
+	}
+	chunk := make([]byte, 8, 12+len(text))
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	// Insert the text chunk right after the IHDR chunk
+	ihdrEnd := 8 + 12 + 13
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func TestImageProcessing_StripJPEG(t *testing.T) {
+	ctx := context.Background()
+	ip := &ImageProcessing{StripMetadata: true}
+	data := makeTestJPEG(t, 8, 4, 1)
+	require.Equal(t, 1, jpegOrientation(data))
+
+	out, err := ip.Process(ctx, data, "image/jpeg")
+	require.NoError(t, err)
+	assert.True(t, out.Changed)
+	assert.False(t, bytes.Contains(out.Data, []byte("Exif")))
+	assert.Equal(t, 8, out.Width)
+	assert.Equal(t, 4, out.Height)
+	_, err = jpeg.Decode(bytes.NewReader(out.Data))
+	assert.NoError(t, err)
+}
+
+func TestImageProcessing_RotateJPEG(t *testing.T) {
+	ip := &ImageProcessing{StripMetadata: true}
+	data := makeTestJPEG(t, 8, 4, 6)
+	require.Equal(t, 6, jpegOrientation(data))
+
+	out, err := ip.Process(context.Background(), data, "image/jpeg")
+	require.NoError(t, err)
+	assert.True(t, out.Changed)
+	assert.Equal(t, 1, jpegOrientation(out.Data))
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 4, cfg.Width)
+	assert.Equal(t, 8, cfg.Height)
+}
+
+func TestImageProcessing_StripPNG(t *testing.T) {
+	ip := &ImageProcessing{StripMetadata: true}
+	data := makeTestPNG(t, 4, 4, "Comment\x00secret location")
+
+	out, err := ip.Process(context.Background(), data, "image/png")
+	require.NoError(t, err)
+	assert.True(t, out.Changed)
+	assert.False(t, bytes.Contains(out.Data, []byte("secret location")))
+	_, err = png.Decode(bytes.NewReader(out.Data))
+	assert.NoError(t, err)
+
+	out, err = ip.Process(context.Background(), makeTestPNG(t, 4, 4, ""), "image/png")
+	require.NoError(t, err)
+	assert.False(t, out.Changed)
+}
+
+func TestImageProcessing_Downscale(t *testing.T) {
+	ip := &ImageProcessing{MaxDimension: 20}
+
+	out, err := ip.Process(context.Background(), makeTestPNG(t, 100, 50, ""), "image/png")
+	require.NoError(t, err)
+	assert.True(t, out.Changed)
+	assert.Equal(t, 20, out.Width)
+	assert.Equal(t, 10, out.Height)
+	cfg, err := png.DecodeConfig(bytes.NewReader(out.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 20, cfg.Width)
+	assert.Equal(t, 10, cfg.Height)
+
+	out, err = ip.Process(context.Background(), makeTestPNG(t, 10, 5, ""), "image/png")
+	require.NoError(t, err)
+	assert.False(t, out.Changed)
+}
+
+// makeOversizedPNG returns a PNG whose header claims the given size, so it can't actually be decoded.
+func makeOversizedPNG(t *testing.T, w, h int) []byte {
+	data := makeTestPNG(t, 1, 1, "")
+	binary.BigEndian.PutUint32(data[16:], uint32(w))
+	binary.BigEndian.PutUint32(data[20:], uint32(h))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestImageProcessing_TooLarge(t *testing.T) {
+	ip := &ImageProcessing{MaxDimension: 1000}
+	_, err := ip.Process(context.Background(), makeOversizedPNG(t, 10000, 10000), "image/png")
+	assert.ErrorIs(t, err, errImageTooLarge)
+}
+
+func TestImageProcessing_UnsupportedFormat(t *testing.T) {
+	ip := &ImageProcessing{StripMetadata: true, MaxDimension: 1}
+	data := []byte("GIF89a...")
+	out, err := ip.Process(context.Background(), data, "image/gif")
+	require.NoError(t, err)
+	assert.False(t, out.Changed)
+	assert.Equal(t, data, out.Data)
+}
+
+// insertJPEGSegment inserts a segment right after the SOI marker of a JPEG.
+func insertJPEGSegment(data []byte, marker byte, payload []byte, fill int) []byte {
+	segment := bytes.Repeat([]byte{0xFF}, fill)
+	segment = append(segment, 0xFF, marker, 0, 0)
+	binary.BigEndian.PutUint16(segment[fill+2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestImageProcessing_StripJPEGWithFillBytes(t *testing.T) {
+	ip := &ImageProcessing{StripMetadata: true}
+	data := insertJPEGSegment(makeTestJPEG(t, 8, 4, 1), 0xFE, []byte("secret location"), 3)
+
+	out, err := ip.Process(context.Background(), data, "image/jpeg")
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(out.Data, []byte("secret location")))
+	_, err = jpeg.Decode(bytes.NewReader(out.Data))
+	assert.NoError(t, err)
+}
+
+func TestImageProcessing_TooLargeJPEGStillStripped(t *testing.T) {
+	ip := &ImageProcessing{StripMetadata: true, MaxDimension: 1000}
+	data := insertJPEGSegment(makeTestJPEG(t, 8, 4, 6), 0xFE, []byte("secret location"), 0)
+	// Make the frame header claim a size that's too large to decode
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	require.NotEqual(t, -1, sof)
+	binary.BigEndian.PutUint16(data[sof+5:], 10000)
+	binary.BigEndian.PutUint16(data[sof+7:], 10000)
+
+	out, err := ip.Process(context.Background(), data, "image/jpeg")
+	require.NoError(t, err)
+	assert.True(t, out.Changed)
+	assert.False(t, bytes.Contains(out.Data, []byte("secret location")))
+	// The image couldn't be rotated, so the orientation is kept
+	assert.Equal(t, 6, jpegOrientation(out.Data))
+}
+
+func TestProcessImageFile_RefusesUnstrippedImages(t *testing.T) {
+	ctx := context.Background()
+	data := []byte("\xFF\xD8not actually a jpeg")
+	mc := &MessageConverter{}
+	mc.SetOptions(&Options{ImageProcessing: ImageProcessing{StripMetadata: true}})
+	_, _, err := mc.processImageFile(ctx, data, "image/jpeg", "photo.jpg")
+	assert.ErrorIs(t, err, ErrImageMetadataStripFailed)
+
+	// Without metadata stripping, images that can't be processed are bridged as-is
+	mc.SetOptions(&Options{ImageProcessing: ImageProcessing{MaxDimension: 100}})
+	processed, fileName, err := mc.processImageFile(ctx, data, "image/jpeg", "photo.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, data, processed.Data)
+	assert.Equal(t, "photo.jpg", fileName)
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/slack-go/slack"
 	"golang.org/x/net/html"
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/matrix"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
@@ -45,6 +46,9 @@ type Context struct {
 	Style    slack.RichTextSectionTextStyle
 	Link     string
 
+	// InlineImages collects non-emoticon inline images to be attached as files. If nil, they're linked instead.
+	InlineImages *[]InlineImage
+
 	PreserveWhitespace bool
 }
 
@@ -131,12 +135,29 @@ func (parser *HTMLParser) GetMentionedChannelID(mxid id.RoomID, ctx Context) str
 	if err != nil {
 		zerolog.Ctx(ctx.Ctx).Err(err).Msg("Failed to get portal by MXID to convert mention")
 	} else if portal != nil {
-		_, channelID := slackid.ParsePortalID(portal.ID)
-		return channelID
+		teamID, channelID := slackid.ParsePortalID(portal.ID)
+		currentTeamID, _ := slackid.ParsePortalID(ctx.Portal.ID)
+		// Channel mentions only work within the same workspace, and team portals aren't channels
+		if channelID != "" && teamID == currentTeamID {
+			return channelID
+		}
 	}
 	return ""
 }
 
+func (parser *HTMLParser) GetMentionedAliasChannelID(alias id.RoomAlias, ctx Context) string {
+	matrixConn, ok := parser.br.Matrix.(*matrix.Connector)
+	if !ok {
+		return ""
+	}
+	resp, err := matrixConn.Bot.ResolveAlias(ctx.Ctx, alias)
+	if err != nil {
+		zerolog.Ctx(ctx.Ctx).Debug().Err(err).Stringer("alias", alias).Msg("Failed to resolve room alias to convert mention")
+		return ""
+	}
+	return parser.GetMentionedChannelID(resp.RoomID, ctx)
+}
+
 func (parser *HTMLParser) GetMentionedEventLink(roomID id.RoomID, eventID id.EventID, ctx Context) string {
 	message, err := parser.br.DB.Message.GetPartByMXID(ctx.Ctx, eventID)
 	if err != nil {
@@ -165,6 +186,42 @@ func (parser *HTMLParser) GetMentionedEventLink(roomID id.RoomID, eventID id.Eve
 	return fmt.Sprintf("https://%s.slack.com/archives/%s/p%s", teamDomain, channelID, timestampWithoutDot)
 }
 
+// EmojiUploader can upload Matrix custom emoticons as custom emojis on Slack.
+type EmojiUploader interface {
+	// UploadEmoji uploads the given image as a custom emoji and returns the Slack emoji name.
+	UploadEmoji(ctx context.Context, mxc id.ContentURIString, shortcode string) (string, error)
+}
+
+type contextKey int
+
+const contextKeyEmojiUploader contextKey = iota
+
+// WithEmojiUploader returns a context that makes the parser upload unknown custom emoticons using the given uploader.
+func WithEmojiUploader(ctx context.Context, uploader EmojiUploader) context.Context {
+	return context.WithValue(ctx, contextKeyEmojiUploader, uploader)
+}
+
+func (parser *HTMLParser) uploadEmoticon(ctx Context, src, alt string) string {
+	uploader, ok := ctx.Ctx.Value(contextKeyEmojiUploader).(EmojiUploader)
+	if !ok || src == "" {
+		return ""
+	}
+	emojiID, err := uploader.UploadEmoji(ctx.Ctx, id.ContentURIString(src), alt)
+	if err != nil {
+		zerolog.Ctx(ctx.Ctx).Warn().Err(err).Str("mxc", src).Msg("Failed to upload custom emoticon to Slack")
+		return ""
+	}
+	return emojiID
+}
+
+func (parser *HTMLParser) getPublicMediaLink(src string) string {
+	urlProvider, ok := parser.br.Matrix.(bridgev2.MatrixConnectorWithPublicMedia)
+	if !ok {
+		return ""
+	}
+	return urlProvider.GetPublicMediaAddress(id.ContentURIString(src))
+}
+
 func (parser *HTMLParser) maybeGetAttribute(node *html.Node, attribute string) (string, bool) {
 	for _, attr := range node.Attr {
 		if attr.Key == attribute {
@@ -260,13 +317,28 @@ func (parser *HTMLParser) tagToElement(node *html.Node, ctx Context) ([]slack.Ri
 		return parser.nodeAndSiblingsToElement(node.FirstChild, ctx)
 	case "img":
 		src := parser.getAttribute(node, "src")
+		alt := parser.getAttribute(node, "alt")
 		dbEmoji, err := parser.db.Emoji.GetByMXC(ctx.Ctx, src)
 		if err != nil {
 			zerolog.Ctx(ctx.Ctx).Err(err).Msg("Failed to get emoji by MXC to convert image")
 		} else if dbEmoji != nil {
 			return []slack.RichTextSectionElement{slack.NewRichTextSectionEmojiElement(dbEmoji.EmojiID, 0, ctx.StylePtr())}, nil
 		}
-		if alt := parser.getAttribute(node, "alt"); alt != "" {
+		_, isEmoticon := parser.maybeGetAttribute(node, "data-mx-emoticon")
+		if isEmoticon {
+			if emojiID := parser.uploadEmoticon(ctx, src, alt); emojiID != "" {
+				return []slack.RichTextSectionElement{slack.NewRichTextSectionEmojiElement(emojiID, 0, ctx.StylePtr())}, nil
+			}
+		} else if ctx.InlineImages != nil && strings.HasPrefix(src, "mxc://") {
+			*ctx.InlineImages = append(*ctx.InlineImages, InlineImage{MXC: id.ContentURIString(src), Alt: alt})
+			return nil, nil
+		}
+		if link := parser.getPublicMediaLink(src); link != "" {
+			if alt == "" {
+				alt = link
+			}
+			return []slack.RichTextSectionElement{slack.NewRichTextSectionLinkElement(link, alt, ctx.StylePtr())}, nil
+		} else if alt != "" {
 			return []slack.RichTextSectionElement{slack.NewRichTextSectionTextElement(alt, ctx.StylePtr())}, nil
 		} else {
 			return nil, nil
@@ -297,6 +369,8 @@ func (parser *HTMLParser) tagToElement(node *html.Node, ctx Context) ([]slack.Ri
 		return nil, elems
 	case "ol", "ul":
 		return nil, parser.listToElement(node, ctx)
+	case "table":
+		return nil, parser.tableToElements(node, ctx)
 	case "pre":
 		//var language string
 		if node.FirstChild != nil && node.FirstChild.Type == html.ElementNode && node.FirstChild.Data == "code" {
@@ -339,9 +413,12 @@ func (parser *HTMLParser) textToElement(text string, ctx Context) slack.RichText
 				if eventLink != "" {
 					return slack.NewRichTextSectionLinkElement(ctx.Link, text, ctx.StylePtr())
 				}
+			} else if parsedMatrix.Sigil1 == '#' {
+				channelID := parser.GetMentionedAliasChannelID(parsedMatrix.RoomAlias(), ctx)
+				if channelID != "" {
+					return slack.NewRichTextSectionChannelElement(channelID, ctx.StylePtr())
+				}
 			}
-			// TODO add aliases for rooms so they can be mentioned easily
-			//else if parsedMatrix.Sigil1 == '#' {
 		}
 		return slack.NewRichTextSectionLinkElement(ctx.Link, text, ctx.StylePtr())
 	}
@@ -475,3 +552,24 @@ func (parser *HTMLParser) Parse(ctx context.Context, htmlData string, mentions *
 	node, _ := html.Parse(strings.NewReader(htmlData))
 	return parser.nodeToBlock(node, formatCtx)
 }
+
+// InlineImage is an inline image in Matrix HTML that isn't a custom emoticon.
+type InlineImage struct {
+	MXC id.ContentURIString
+	Alt string
+}
+
+// ParseWithImages is like Parse, but removes non-emoticon inline images from the output and returns them separately,
+// so that they can be uploaded as files.
+func (parser *HTMLParser) ParseWithImages(ctx context.Context, htmlData string, mentions *event.Mentions, portal *bridgev2.Portal) (*slack.RichTextBlock, []InlineImage) {
+	var images []InlineImage
+	formatCtx := Context{
+		Ctx:          ctx,
+		TagStack:     make(format.TagStack, 0, 4),
+		Portal:       portal,
+		Mentions:     mentions,
+		InlineImages: &images,
+	}
+	node, _ := html.Parse(strings.NewReader(htmlData))
+	return parser.nodeToBlock(node, formatCtx), images
+}
@@ -0,0 +1,189 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrixfmt
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/net/html"
+)
+
+// maxAlignedTableWidth is the maximum line length of tables rendered as aligned code blocks.
+// Wider tables are rendered as a list of rows instead, as they'd wrap in the Slack client anyway.
+const maxAlignedTableWidth = 100
+
+type tableData struct {
+	header []string
+	rows   [][]string
+}
+
+// cellText returns the plain text content of a table cell with whitespace collapsed.
+func cellText(node *html.Node) string {
+	var buf strings.Builder
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			switch {
+			case child.Type == html.TextNode:
+				buf.WriteString(child.Data)
+			case child.Type == html.ElementNode && child.Data == "br":
+				buf.WriteByte(' ')
+			case child.Type == html.ElementNode && child.Data == "img":
+				for _, attr := range child.Attr {
+					if attr.Key == "alt" {
+						buf.WriteString(attr.Val)
+					}
+				}
+			default:
+				walk(child)
+			}
+		}
+	}
+	walk(node)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+func parseTable(node *html.Node) *tableData {
+	var table tableData
+	var walk func(node *html.Node, inHead bool)
+	walk = func(node *html.Node, inHead bool) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "thead":
+				walk(child, true)
+			case "tbody", "tfoot":
+				walk(child, false)
+			case "tr":
+				var cells []string
+				allHeaders := true
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						cells = append(cells, cellText(cell))
+						allHeaders = allHeaders && cell.Data == "th"
+					}
+				}
+				if len(cells) == 0 {
+					continue
+				}
+				if table.header == nil && len(table.rows) == 0 && (inHead || allHeaders) {
+					table.header = cells
+				} else {
+					table.rows = append(table.rows, cells)
+				}
+			}
+		}
+	}
+	walk(node, false)
+	return &table
+}
+
+func (table *tableData) columnWidths() []int {
+	var widths []int
+	for _, row := range append([][]string{table.header}, table.rows...) {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	return widths
+}
+
+func writeTableRow(buf *strings.Builder, row []string, widths []int) {
+	var line strings.Builder
+	for i, width := range widths {
+		var cell string
+		if i < len(row) {
+			cell = row[i]
+		}
+		if i > 0 {
+			line.WriteString(" | ")
+		}
+		line.WriteString(cell)
+		line.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(cell)))
+	}
+	buf.WriteString(strings.TrimRight(line.String(), " "))
+	buf.WriteByte('\n')
+}
+
+// alignedText renders the table as plain text with aligned columns, or returns false if the table is too wide.
+func (table *tableData) alignedText() (string, bool) {
+	widths := table.columnWidths()
+	totalWidth := 3 * (len(widths) - 1)
+	for _, width := range widths {
+		totalWidth += width
+	}
+	if totalWidth > maxAlignedTableWidth {
+		return "", false
+	}
+	var buf strings.Builder
+	if table.header != nil {
+		writeTableRow(&buf, table.header, widths)
+		for i, width := range widths {
+			if i > 0 {
+				buf.WriteString("-+-")
+			}
+			buf.WriteString(strings.Repeat("-", width))
+		}
+		buf.WriteByte('\n')
+	}
+	for _, row := range table.rows {
+		writeTableRow(&buf, row, widths)
+	}
+	return strings.TrimRight(buf.String(), "\n"), true
+}
+
+// rowSections renders each table row as a separate section with the column headers as bold labels.
+func (table *tableData) rowSections(ctx Context) []slack.RichTextElement {
+	boldCtx := ctx.StyleBold()
+	output := make([]slack.RichTextElement, 0, len(table.rows))
+	for _, row := range table.rows {
+		var elems []slack.RichTextSectionElement
+		for i, cell := range row {
+			if i > 0 {
+				elems = append(elems, slack.NewRichTextSectionTextElement("\n", ctx.StylePtr()))
+			}
+			if i < len(table.header) && table.header[i] != "" {
+				elems = append(elems, slack.NewRichTextSectionTextElement(table.header[i]+": ", boldCtx.StylePtr()))
+			}
+			elems = append(elems, slack.NewRichTextSectionTextElement(cell, ctx.StylePtr()))
+		}
+		output = append(output, slack.NewRichTextSection(elems...))
+	}
+	return output
+}
+
+func (parser *HTMLParser) tableToElements(node *html.Node, ctx Context) []slack.RichTextElement {
+	table := parseTable(node)
+	if table.header == nil && len(table.rows) == 0 {
+		return nil
+	}
+	if text, ok := table.alignedText(); ok {
+		border := 0
+		if ctx.TagStack.Has("blockquote") {
+			border = 1
+		}
+		return []slack.RichTextElement{slack.NewRichTextPreformatted(border, slack.NewRichTextSectionTextElement(text, nil))}
+	}
+	return table.rowSections(ctx)
+}
@@ -0,0 +1,87 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrixfmt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+func parseTestTable(t *testing.T, input string) *tableData {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	var find func(*html.Node) *html.Node
+	find = func(node *html.Node) *html.Node {
+		if node.Type == html.ElementNode && node.Data == "table" {
+			return node
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if found := find(child); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	table := find(doc)
+	require.NotNil(t, table)
+	return parseTable(table)
+}
+
+func TestTableAlignedText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "thead",
+			input:    "<table><thead><tr><th>Name</th><th>Count</th></tr></thead><tbody><tr><td>apples</td><td>3</td></tr><tr><td>kiwi</td><td>12</td></tr></tbody></table>",
+			expected: "Name   | Count\n-------+------\napples | 3\nkiwi   | 12",
+		},
+		{
+			name:     "th row without thead",
+			input:    "<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td><b>two</b><br>lines</td></tr></table>",
+			expected: "A | B\n--+----------\n1 | two lines",
+		},
+		{
+			name:     "no header, ragged rows",
+			input:    "<table><tr><td>x</td></tr><tr><td>yy</td><td>z</td></tr></table>",
+			expected: "x  |\nyy | z",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text, ok := parseTestTable(t, test.input).alignedText()
+			require.True(t, ok)
+			assert.Equal(t, test.expected, text)
+		})
+	}
+}
+
+func TestTableTooWide(t *testing.T) {
+	long := strings.Repeat("a", maxAlignedTableWidth)
+	table := parseTestTable(t, "<table><tr><th>Key</th><th>Value</th></tr><tr><td>k</td><td>"+long+"</td></tr></table>")
+	_, ok := table.alignedText()
+	assert.False(t, ok)
+	sections := table.rowSections(Context{})
+	assert.Len(t, sections, 1)
+}
@@ -0,0 +1,135 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mrkdwn
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dateTokenRegex = regexp.MustCompile(`\{(date_num|date_slash|date_long_full|date_long_pretty|date_long|date_pretty|date_short_pretty|date_short|date|time_secs|time|day_divider_pretty|ago)}`)
+
+func prettyDay(t, now time.Time, fallbackLayout string) string {
+	y1, m1, d1 := t.Date()
+	y2, m2, d2 := now.Date()
+	days := time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC).Sub(time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour)
+	switch days {
+	case 0:
+		return "today"
+	case -1:
+		return "yesterday"
+	case 1:
+		return "tomorrow"
+	default:
+		return t.Format(fallbackLayout)
+	}
+}
+
+func relativeTime(t, now time.Time) string {
+	diff := now.Sub(t)
+	suffix := "ago"
+	if diff < 0 {
+		diff = -diff
+		suffix = "from now"
+	}
+	var amount int
+	var unit string
+	switch {
+	case diff < time.Minute:
+		return "just now"
+	case diff < time.Hour:
+		amount, unit = int(diff/time.Minute), "minute"
+	case diff < 24*time.Hour:
+		amount, unit = int(diff/time.Hour), "hour"
+	case diff < 30*24*time.Hour:
+		amount, unit = int(diff/(24*time.Hour)), "day"
+	case diff < 365*24*time.Hour:
+		amount, unit = int(diff/(30*24*time.Hour)), "month"
+	default:
+		amount, unit = int(diff/(365*24*time.Hour)), "year"
+	}
+	if amount != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s %s", amount, unit, suffix)
+}
+
+// FormatDate renders a Slack date format string (like "{date_short} at {time}") for the given time.
+// See https://api.slack.com/reference/surfaces/formatting#date-formatting
+func FormatDate(format string, t, now time.Time) string {
+	if loc := now.Location(); loc != nil {
+		t = t.In(loc)
+	}
+	return dateTokenRegex.ReplaceAllStringFunc(format, func(token string) string {
+		switch token[1 : len(token)-1] {
+		case "date_num":
+			return t.Format("2006-01-02")
+		case "date_slash":
+			return t.Format("01/02/2006")
+		case "date":
+			return t.Format("January 2, 2006")
+		case "date_pretty":
+			return prettyDay(t, now, "January 2, 2006")
+		case "date_short":
+			return t.Format("Jan 2, 2006")
+		case "date_short_pretty":
+			return prettyDay(t, now, "Jan 2, 2006")
+		case "date_long", "date_long_full":
+			return t.Format("Monday, January 2, 2006")
+		case "date_long_pretty", "day_divider_pretty":
+			return prettyDay(t, now, "Monday, January 2, 2006")
+		case "time":
+			return t.Format("15:04 MST")
+		case "time_secs":
+			return t.Format("15:04:05 MST")
+		case "ago":
+			return relativeTime(t, now)
+		default:
+			return token
+		}
+	})
+}
+
+// DateToHTML writes a Slack date token as HTML. The content is the part of the token after the
+// exclamation mark, i.e. date^<timestamp>^<format>[^<link>]. If the token can't be parsed,
+// the fallback text is written instead, or the raw token if there's no fallback.
+func DateToHTML(out io.Writer, content, fallback string, now time.Time) {
+	parts := strings.SplitN(content, "^", 4)
+	var timestamp int64
+	var err error
+	if len(parts) >= 3 && parts[0] == "date" {
+		timestamp, err = strconv.ParseInt(parts[1], 10, 64)
+	}
+	if len(parts) < 3 || parts[0] != "date" || err != nil || parts[2] == "" {
+		if fallback == "" {
+			fallback = "<!" + content + ">"
+		}
+		_, _ = io.WriteString(out, html.EscapeString(fallback))
+		return
+	}
+	formatted := html.EscapeString(FormatDate(parts[2], time.Unix(timestamp, 0), now))
+	if len(parts) > 3 && parts[3] != "" {
+		_, _ = fmt.Fprintf(out, `<a href="%s">%s</a>`, html.EscapeString(parts[3]), formatted)
+	} else {
+		_, _ = io.WriteString(out, formatted)
+	}
+}
@@ -0,0 +1,64 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mrkdwn
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDateToHTML(t *testing.T) {
+	loc := time.FixedZone("EET", 2*60*60)
+	// 2024-03-05 13:04:05 UTC
+	const ts = "1709643845"
+	now := time.Date(2024, time.March, 5, 18, 0, 0, 0, loc)
+	tests := []struct {
+		name     string
+		content  string
+		fallback string
+		expected string
+	}{
+		{"date and time", "date^" + ts + "^{date_short} at {time}", "fallback", "Mar 5, 2024 at 15:04 EET"},
+		{"numeric", "date^" + ts + "^{date_num} {time_secs}", "", "2024-03-05 15:04:05 EET"},
+		{"pretty today", "date^" + ts + "^{date_pretty}", "", "today"},
+		{"long", "date^" + ts + "^{date_long}", "", "Tuesday, March 5, 2024"},
+		{"relative", "date^" + ts + "^{ago}", "", "2 hours ago"},
+		{"link", "date^" + ts + "^{date}^https://example.com/?a=1&b=2", "", `<a href="https://example.com/?a=1&amp;b=2">March 5, 2024</a>`},
+		{"escapes text", "date^" + ts + "^<b>{date_num}</b>", "", "&lt;b&gt;2024-03-05&lt;/b&gt;"},
+		{"invalid timestamp uses fallback", "date^abc^{date}", "Some <day>", "Some &lt;day&gt;"},
+		{"missing format uses fallback", "date^" + ts, "Mar 5", "Mar 5"},
+		{"no fallback", "date^abc^{date}", "", "&lt;!date^abc^{date}&gt;"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf strings.Builder
+			DateToHTML(&buf, test.content, test.fallback, now)
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+}
+
+func TestFormatDatePretty(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 0, 30, 0, 0, time.UTC)
+	assert.Equal(t, "yesterday", FormatDate("{date_pretty}", now.Add(-time.Hour), now))
+	assert.Equal(t, "tomorrow", FormatDate("{date_short_pretty}", now.Add(24*time.Hour), now))
+	assert.Equal(t, "Mar 1, 2024", FormatDate("{date_short_pretty}", now.Add(-4*24*time.Hour), now))
+	assert.Equal(t, "in {unknown}", FormatDate("in {unknown}", now, now))
+}
@@ -22,7 +22,6 @@ import (
 	"html"
 	"io"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -119,6 +118,39 @@ type Params struct {
 	ServerName     string
 	GetUserInfo    func(ctx context.Context, userID string) (mxid id.UserID, name string)
 	GetChannelInfo func(ctx context.Context, channelID string) (mxid id.RoomID, alias id.RoomAlias, name string)
+	// GetCustomEmoji returns the mxc URI of a custom workspace emoji. If nil, custom emoji shortcodes are left as-is.
+	GetCustomEmoji func(ctx context.Context, shortcode string) (mxc id.ContentURIString, found bool)
+	// GetLocation returns the timezone used for rendering date tokens. If nil or if it returns nil,
+	// the local timezone is used.
+	GetLocation func() *time.Location
+}
+
+// Now returns the current time in the timezone that date tokens should be rendered in.
+func (p *Params) Now() time.Time {
+	if p.GetLocation != nil {
+		if loc := p.GetLocation(); loc != nil {
+			return time.Now().In(loc)
+		}
+	}
+	return time.Now()
+}
+
+type astSlackCustomEmoji struct {
+	ast.BaseInline
+
+	shortcode string
+	mxc       id.ContentURIString
+}
+
+var _ ast.Node = (*astSlackCustomEmoji)(nil)
+var astKindSlackCustomEmoji = ast.NewNodeKind("SlackCustomEmoji")
+
+func (n *astSlackCustomEmoji) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+func (n *astSlackCustomEmoji) Kind() ast.NodeKind {
+	return astKindSlackCustomEmoji
 }
 
 type slackTagParser struct {
@@ -180,16 +212,65 @@ func (s *slackTagParser) CloseBlock(parent ast.Node, pc parser.Context) {
 	// nothing to do
 }
 
-type slackTagHTMLRenderer struct{}
+type slackEmojiParser struct {
+	*Params
+}
+
+// Unicode emoji shortcodes are replaced before parsing, so anything left that looks like a shortcode may be a custom emoji.
+var slackEmojiRegex = regexp.MustCompile(`^:([a-z0-9_+'-]+):`)
 
-var defaultSlackTagHTMLRenderer = &slackTagHTMLRenderer{}
+func (s *slackEmojiParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (s *slackEmojiParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if s.GetCustomEmoji == nil {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	match := slackEmojiRegex.FindSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	shortcode := string(match[1])
+	mxc, found := s.GetCustomEmoji(pc.Get(ContextKeyContext).(context.Context), shortcode)
+	if !found {
+		return nil
+	}
+	block.Advance(len(match[0]))
+	return &astSlackCustomEmoji{shortcode: shortcode, mxc: mxc}
+}
+
+func (s *slackEmojiParser) CloseBlock(parent ast.Node, pc parser.Context) {
+	// nothing to do
+}
+
+type slackTagHTMLRenderer struct {
+	*Params
+}
 
 func (r *slackTagHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(astKindSlackTag, r.renderSlackTag)
+	reg.Register(astKindSlackCustomEmoji, r.renderSlackCustomEmoji)
+}
+
+func CustomEmojiToHTML(out io.Writer, shortcode string, mxc id.ContentURIString) {
+	_, _ = fmt.Fprintf(out, `<img data-mx-emoticon src="%[1]s" alt=":%[2]s:" title=":%[2]s:" height="32"/>`, html.EscapeString(string(mxc)), html.EscapeString(shortcode))
+}
+
+func (r *slackTagHTMLRenderer) renderSlackCustomEmoji(w goldmarkUtil.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		node := n.(*astSlackCustomEmoji)
+		CustomEmojiToHTML(w, node.shortcode, node.mxc)
+	}
+	return ast.WalkContinue, nil
 }
 
 func UserMentionToHTML(out io.Writer, userID string, mxid id.UserID, name string) {
 	if mxid != "" {
+		if name == "" {
+			name = mxid.String()
+		}
 		_, _ = fmt.Fprintf(out, `<a href="%s">%s</a>`, mxid.URI().MatrixToURL(), html.EscapeString(name))
 	} else {
 		_, _ = fmt.Fprintf(out, "&lt;@%s&gt;", userID)
@@ -202,7 +283,7 @@ func RoomMentionToHTML(out io.Writer, channelID string, mxid id.RoomID, alias id
 	} else if mxid != "" {
 		_, _ = fmt.Fprintf(out, `<a href="%s">%s</a>`, mxid.URI(serverName).MatrixToURL(), html.EscapeString(name))
 	} else if name != "" {
-		_, _ = fmt.Fprintf(out, "%s", name)
+		_, _ = io.WriteString(out, html.EscapeString(name))
 	} else {
 		_, _ = fmt.Fprintf(out, "&lt;#%s&gt;", channelID)
 	}
@@ -224,33 +305,7 @@ func (r *slackTagHTMLRenderer) renderSlackTag(w goldmarkUtil.BufWriter, source [
 		parts := strings.Split(node.content, "^")
 		switch parts[0] {
 		case "date":
-			timestamp, converr := strconv.ParseInt(parts[1], 10, 64)
-			if converr != nil {
-				return
-			}
-			t := time.Unix(timestamp, 0)
-
-			mapping := map[string]string{
-				"{date_num}":          t.Local().Format("2006-01-02"),
-				"{date}":              t.Local().Format("January 2, 2006"),
-				"{date_pretty}":       t.Local().Format("January 2, 2006"),
-				"{date_short}":        t.Local().Format("Jan 2, 2006"),
-				"{date_short_pretty}": t.Local().Format("Jan 2, 2006"),
-				"{date_long}":         t.Local().Format("Monday, January 2, 2006"),
-				"{date_long_pretty}":  t.Local().Format("Monday, January 2, 2006"),
-				"{time}":              t.Local().Format("15:04 MST"),
-				"{time_secs}":         t.Local().Format("15:04:05 MST"),
-			}
-
-			for k, v := range mapping {
-				parts[2] = strings.ReplaceAll(parts[2], k, v)
-			}
-
-			if len(parts) > 3 {
-				_, _ = fmt.Fprintf(w, `<a href="%s">%s</a>`, html.EscapeString(parts[3]), html.EscapeString(parts[2]))
-			} else {
-				_, _ = w.WriteString(html.EscapeString(parts[2]))
-			}
+			DateToHTML(w, node.content, node.label, r.Now())
 			return
 		case "channel", "everyone", "here":
 			// do @room mentions?
@@ -285,8 +340,9 @@ type slackTag struct {
 func (e *slackTag) Extend(m goldmark.Markdown) {
 	m.Parser().AddOptions(parser.WithInlineParsers(
 		goldmarkUtil.Prioritized(&slackTagParser{Params: e.Params}, 150),
+		goldmarkUtil.Prioritized(&slackEmojiParser{Params: e.Params}, 150),
 	))
 	m.Renderer().AddOptions(renderer.WithNodeRenderers(
-		goldmarkUtil.Prioritized(defaultSlackTagHTMLRenderer, 150),
+		goldmarkUtil.Prioritized(&slackTagHTMLRenderer{Params: e.Params}, 150),
 	))
 }
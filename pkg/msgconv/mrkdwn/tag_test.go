@@ -0,0 +1,76 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mrkdwn
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestUserMentionToHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		mxid     id.UserID
+		userName string
+		expected string
+	}{
+		{"ghost", "@slack_t1-u2:example.com", "Alice", `<a href="https://matrix.to/#/@slack_t1-u2:example.com">Alice</a>`},
+		{"escaped name", "@alice:example.com", "<Alice>", `<a href="https://matrix.to/#/@alice:example.com">&lt;Alice&gt;</a>`},
+		{"no name", "@slack_t1-u2:example.com", "", `<a href="https://matrix.to/#/@slack_t1-u2:example.com">@slack_t1-u2:example.com</a>`},
+		{"unknown user", "", "", `&lt;@U2&gt;`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf strings.Builder
+			UserMentionToHTML(&buf, "U2", test.mxid, test.userName)
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+}
+
+func TestParseCustomEmoji(t *testing.T) {
+	parser := New(&Params{
+		GetCustomEmoji: func(ctx context.Context, shortcode string) (id.ContentURIString, bool) {
+			if shortcode == "partyparrot" {
+				return "mxc://example.com/parrot", true
+			}
+			return "", false
+		},
+	})
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"custom", "hi :partyparrot:", `hi <img data-mx-emoticon src="mxc://example.com/parrot" alt=":partyparrot:" title=":partyparrot:" height="32"/>`},
+		{"unicode", ":smile: :partyparrot:", `😄 <img data-mx-emoticon src="mxc://example.com/parrot" alt=":partyparrot:" title=":partyparrot:" height="32"/>`},
+		{"unknown", "meet at 10:30:45 :notanemoji:", "meet at 10:30:45 :notanemoji:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := parser.Parse(context.Background(), test.input, &event.Mentions{})
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, output)
+		})
+	}
+}
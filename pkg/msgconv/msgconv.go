@@ -18,24 +18,31 @@ package msgconv
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/slack-go/slack"
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/matrix"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/mautrix-slack/pkg/connector/slackdb"
 	"go.mau.fi/mautrix-slack/pkg/msgconv/matrixfmt"
 	"go.mau.fi/mautrix-slack/pkg/msgconv/mrkdwn"
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
 	"go.mau.fi/mautrix-slack/pkg/slackid"
 )
 
 type MessageConverter struct {
 	Bridge *bridgev2.Bridge
+	DB     *slackdb.SlackDB
 	HTTP   http.Client
 
 	MatrixHTMLParser  *matrixfmt.HTMLParser
@@ -43,6 +50,43 @@ type MessageConverter struct {
 
 	ServerName  string
 	MaxFileSize int
+
+	Translator Translator
+	// options is swapped atomically when the config is reloaded, as conversions may be running at the same time
+	options atomic.Pointer[Options]
+
+	// mentionedChannelNames caches the names of mentioned channels that don't have portals,
+	// so that a channel mentioned in every message isn't fetched from Slack every time.
+	mentionedChannelNames     map[networkid.PortalID]string
+	mentionedChannelNamesLock sync.Mutex
+
+	translationCache     map[translationCacheKey]translationCacheEntry
+	translationCacheLock sync.Mutex
+}
+
+// Options contains the converter settings that can be changed by reloading the config.
+type Options struct {
+	// ChannelAliases makes channel mentions create and link to room aliases instead of room IDs
+	ChannelAliases bool
+	// TranslationTarget is the default language incoming messages are translated to
+	TranslationTarget string
+	// ImageProcessing is applied to images bridged in both directions
+	ImageProcessing ImageProcessing
+	// Timezone is used for rendering date tokens. If nil, the local timezone is used.
+	Timezone *time.Location
+}
+
+// SetOptions replaces the converter settings. The options must not be modified afterwards.
+func (mc *MessageConverter) SetOptions(opts *Options) {
+	mc.options.Store(opts)
+}
+
+// Options returns the current converter settings. The returned value must not be modified.
+func (mc *MessageConverter) Options() *Options {
+	if opts := mc.options.Load(); opts != nil {
+		return opts
+	}
+	return &Options{}
 }
 
 type contextKey int
@@ -50,11 +94,16 @@ type contextKey int
 const (
 	contextKeyPortal contextKey = iota
 	contextKeySource
+	contextKeyUnsupported
+	contextKeyBlockActions
 )
 
 type SlackClientProvider interface {
-	GetClient() *slack.Client
+	GetClient() slackapi.Client
 	GetEmoji(context.Context, string) (string, bool)
+	GetCustomEmoji(context.Context, string) (id.ContentURIString, bool)
+	// CanTriggerBlockActions returns whether the login can click buttons in Slack messages
+	CanTriggerBlockActions() bool
 }
 
 func (mc *MessageConverter) GetMentionedUserInfo(ctx context.Context, userID string) (mxid id.UserID, name string) {
@@ -64,9 +113,11 @@ func (mc *MessageConverter) GetMentionedUserInfo(ctx context.Context, userID str
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to get mentioned ghost")
 	} else if ghost != nil {
-		/*if ghost.Name == "" {
-			// TODO update ghost info
-		}*/
+		if ghost.Name == "" {
+			// The ghost may not have been seen before (e.g. a user from another workspace in a shared channel),
+			// so fetch its info to get a proper name for the pill.
+			ghost.UpdateInfoIfNecessary(ctx, source, bridgev2.RemoteEventMessage)
+		}
 		name = ghost.Name
 		mxid = ghost.Intent.GetMXID()
 	}
@@ -78,29 +129,107 @@ func (mc *MessageConverter) GetMentionedUserInfo(ctx context.Context, userID str
 	return
 }
 
+func (mc *MessageConverter) GetCustomEmoji(ctx context.Context, shortcode string) (id.ContentURIString, bool) {
+	source, ok := ctx.Value(contextKeySource).(*bridgev2.UserLogin)
+	if !ok {
+		return "", false
+	}
+	return source.Client.(SlackClientProvider).GetCustomEmoji(ctx, shortcode)
+}
+
 func (mc *MessageConverter) GetMentionedRoomInfo(ctx context.Context, channelID string) (mxid id.RoomID, alias id.RoomAlias, name string) {
 	source := ctx.Value(contextKeySource).(*bridgev2.UserLogin)
 	teamID, _ := slackid.ParseUserLoginID(source.ID)
-	portal, err := mc.Bridge.GetExistingPortalByKey(ctx, networkid.PortalKey{
-		ID:       slackid.MakePortalID(teamID, channelID),
-		Receiver: source.ID,
-	})
+	portal, err := mc.Bridge.GetExistingPortalByKey(ctx, slackid.MakePortalKey(teamID, channelID, source.ID, true))
+	if err == nil && portal == nil && !mc.Bridge.Config.SplitPortals {
+		// Only DMs have receivers when portals aren't split
+		portal, err = mc.Bridge.GetExistingPortalByKey(ctx, slackid.MakePortalKey(teamID, channelID, source.ID, false))
+	}
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to get mentioned portal")
-	} else if portal == nil {
-		client := source.Client.(SlackClientProvider).GetClient()
-		if client == nil {
-			return
-		}
-		// TODO fetch info and update portal
+	} else if portal == nil || portal.MXID == "" {
+		name = mc.getMentionedChannelName(ctx, source, teamID, channelID)
 		return
 	}
-	return portal.MXID, "", portal.Name
+	if mc.Options().ChannelAliases {
+		alias = mc.ensurePortalAlias(ctx, portal)
+	}
+	return portal.MXID, alias, portal.Name
+}
+
+func (mc *MessageConverter) getMentionedChannelName(ctx context.Context, source *bridgev2.UserLogin, teamID, channelID string) string {
+	cacheKey := slackid.MakePortalID(teamID, channelID)
+	mc.mentionedChannelNamesLock.Lock()
+	name, ok := mc.mentionedChannelNames[cacheKey]
+	mc.mentionedChannelNamesLock.Unlock()
+	if ok {
+		return name
+	}
+	client := source.Client.(SlackClientProvider).GetClient()
+	if client == nil {
+		return ""
+	}
+	info, err := client.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		// Failures aren't cached, the channel may just be temporarily unreachable
+		zerolog.Ctx(ctx).Warn().Err(err).Str("channel_id", channelID).Msg("Failed to get info of mentioned channel")
+		return ""
+	} else if info.Name != "" {
+		name = "#" + info.Name
+	}
+	mc.mentionedChannelNamesLock.Lock()
+	if mc.mentionedChannelNames == nil {
+		mc.mentionedChannelNames = make(map[networkid.PortalID]string)
+	}
+	mc.mentionedChannelNames[cacheKey] = name
+	mc.mentionedChannelNamesLock.Unlock()
+	return name
+}
+
+// portalAliasLocalpart returns the localpart of the room alias that the bridge creates for the given portal.
+func portalAliasLocalpart(portal *bridgev2.Portal) string {
+	teamID, channelID := slackid.ParsePortalID(portal.ID)
+	localpart := fmt.Sprintf("slack_%s_%s", teamID, channelID)
+	if portal.Receiver != "" {
+		_, userID := slackid.ParseUserLoginID(portal.Receiver)
+		localpart += "_" + userID
+	}
+	return strings.ToLower(localpart)
+}
+
+// ensurePortalAlias returns the room alias of the given portal, creating it first if necessary.
+func (mc *MessageConverter) ensurePortalAlias(ctx context.Context, portal *bridgev2.Portal) id.RoomAlias {
+	meta := portal.Metadata.(*slackid.PortalMetadata)
+	if meta.Alias != "" {
+		return meta.Alias
+	}
+	matrixConn, ok := mc.Bridge.Matrix.(*matrix.Connector)
+	if !ok {
+		return ""
+	}
+	log := zerolog.Ctx(ctx).With().Stringer("portal_mxid", portal.MXID).Logger()
+	alias := id.NewRoomAlias(portalAliasLocalpart(portal), mc.ServerName)
+	_, err := matrixConn.Bot.CreateAlias(ctx, alias, portal.MXID)
+	if err != nil {
+		// The alias may already exist if saving the metadata failed previously
+		resp, resolveErr := matrixConn.Bot.ResolveAlias(ctx, alias)
+		if resolveErr != nil || resp.RoomID != portal.MXID {
+			log.Warn().Err(err).Stringer("alias", alias).Msg("Failed to create alias for mentioned portal")
+			return ""
+		}
+	}
+	meta.Alias = alias
+	err = portal.Save(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to save portal after creating alias")
+	}
+	return alias
 }
 
 func New(br *bridgev2.Bridge, db *slackdb.SlackDB) *MessageConverter {
 	mc := &MessageConverter{
 		Bridge: br,
+		DB:     db,
 		HTTP: http.Client{
 			Transport: &http.Transport{
 				DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
@@ -120,6 +249,10 @@ func New(br *bridgev2.Bridge, db *slackdb.SlackDB) *MessageConverter {
 		ServerName:     br.Matrix.ServerName(),
 		GetUserInfo:    mc.GetMentionedUserInfo,
 		GetChannelInfo: mc.GetMentionedRoomInfo,
+		GetCustomEmoji: mc.GetCustomEmoji,
+		GetLocation: func() *time.Location {
+			return mc.Options().Timezone
+		},
 	})
 	return mc
 }
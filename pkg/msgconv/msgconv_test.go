@@ -0,0 +1,170 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+func TestPortalAliasLocalpart(t *testing.T) {
+	makePortal := func(key networkid.PortalKey) *bridgev2.Portal {
+		return &bridgev2.Portal{Portal: &database.Portal{PortalKey: key}}
+	}
+	loginID := slackid.MakeUserLoginID("T123", "U456")
+	assert.Equal(t, "slack_t123_c789", portalAliasLocalpart(makePortal(slackid.MakePortalKey("T123", "C789", loginID, false))))
+	assert.Equal(t, "slack_t123_c789_u456", portalAliasLocalpart(makePortal(slackid.MakePortalKey("T123", "C789", loginID, true))))
+}
+
+func TestBotIdentityOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     *slackid.PortalMetadata
+		expected map[string]string
+	}{
+		{"unset", &slackid.PortalMetadata{}, map[string]string{}},
+		{"name and URL", &slackid.PortalMetadata{BotUsername: "Relay", BotIcon: "https://example.com/icon.png"}, map[string]string{"username": "Relay", "icon_url": "https://example.com/icon.png"}},
+		{"emoji", &slackid.PortalMetadata{BotIcon: ":robot_face:"}, map[string]string{"icon_emoji": ":robot_face:"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			portal := &bridgev2.Portal{Portal: &database.Portal{Metadata: test.meta}}
+			_, values, err := slack.UnsafeApplyMsgOptions("", "C123", "", nil, BotIdentityOptions(portal)...)
+			require.NoError(t, err)
+			for _, key := range []string{"username", "icon_url", "icon_emoji"} {
+				assert.Equal(t, test.expected[key], values.Get(key), key)
+			}
+		})
+	}
+}
+
+func TestRelayIdentityOptions(t *testing.T) {
+	mc := &MessageConverter{Bridge: &bridgev2.Bridge{}}
+	portal := &bridgev2.Portal{Portal: &database.Portal{Metadata: &slackid.PortalMetadata{BotUsername: "Relay", BotIcon: ":robot_face:"}}}
+	origSender := &bridgev2.OrigSender{FormattedName: "Alice (Matrix)"}
+	origSender.AvatarURL = "mxc://example.com/avatar"
+	_, values, err := slack.UnsafeApplyMsgOptions("", "C123", "", nil, mc.relayIdentityOptions(portal, origSender)...)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice (Matrix)", values.Get("username"))
+	assert.Equal(t, ":robot_face:", values.Get("icon_emoji"))
+	assert.Empty(t, values.Get("icon_url"))
+}
+
+func TestIsEmojiShortcode(t *testing.T) {
+	assert.True(t, IsEmojiShortcode(":robot_face:"))
+	assert.False(t, IsEmojiShortcode("::"))
+	assert.False(t, IsEmojiShortcode(":a: :b:"))
+	assert.False(t, IsEmojiShortcode("https://example.com/:x:"))
+}
+
+func TestEditChangesContent(t *testing.T) {
+	prev := &slack.Msg{
+		Text:        "hello",
+		Files:       []slack.File{{ID: "F1"}},
+		Attachments: []slack.Attachment{{ID: 1, Text: "preview"}},
+	}
+	tests := []struct {
+		name     string
+		msg      slack.Msg
+		expected bool
+	}{
+		{"unchanged", slack.Msg{Text: "hello", Files: []slack.File{{ID: "F1"}}, Attachments: []slack.Attachment{{ID: 1, Text: "preview"}}}, false},
+		{"text", slack.Msg{Text: "hi", Files: []slack.File{{ID: "F1"}}, Attachments: []slack.Attachment{{ID: 1, Text: "preview"}}}, true},
+		{"file removed", slack.Msg{Text: "hello", Attachments: []slack.Attachment{{ID: 1, Text: "preview"}}}, true},
+		{"file tombstoned", slack.Msg{Text: "hello", Files: []slack.File{{ID: "F1", Mode: "tombstone"}}, Attachments: []slack.Attachment{{ID: 1, Text: "preview"}}}, true},
+		{"attachment changed", slack.Msg{Text: "hello", Files: []slack.File{{ID: "F1"}}, Attachments: []slack.Attachment{{ID: 1, Text: "new preview"}}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, EditChangesContent(&test.msg, prev))
+		})
+	}
+	assert.True(t, EditChangesContent(&slack.Msg{Text: "hello"}, nil))
+}
+
+func TestRemovedParts(t *testing.T) {
+	makePart := func(partID networkid.PartID) *database.Message {
+		return &database.Message{PartID: partID}
+	}
+	file1 := makePart(slackid.MakePartID(slackid.PartTypeFile, 0, "F1"))
+	file2 := makePart(slackid.MakePartID(slackid.PartTypeFile, 1, "F2"))
+	attachment := makePart(slackid.MakePartID(slackid.PartTypeAttachment, 0, "1"))
+	text := makePart("")
+	msg := &slack.Msg{Files: []slack.File{{ID: "F1"}}}
+	assert.Equal(t, []*database.Message{file2, attachment}, removedParts([]*database.Message{text, file1, file2, attachment}, msg))
+}
+
+func TestLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	lw := &limitedWriter{Writer: &buf, N: 5}
+	_, err := lw.Write([]byte("abc"))
+	require.NoError(t, err)
+	_, err = lw.Write([]byte("de"))
+	require.NoError(t, err)
+	_, err = lw.Write([]byte("f"))
+	assert.ErrorIs(t, err, errFileTooLarge)
+	assert.Equal(t, "abcde", buf.String())
+}
+
+type fakeClientProvider struct {
+	bridgev2.NetworkAPI
+	client       slackapi.Client
+	blockActions bool
+}
+
+func (fcp *fakeClientProvider) GetClient() slackapi.Client { return fcp.client }
+
+func (fcp *fakeClientProvider) GetEmoji(context.Context, string) (string, bool) { return "", false }
+
+func (fcp *fakeClientProvider) GetCustomEmoji(context.Context, string) (id.ContentURIString, bool) {
+	return "", false
+}
+
+func (fcp *fakeClientProvider) CanTriggerBlockActions() bool { return fcp.blockActions }
+
+func TestGetMentionedChannelName_Cached(t *testing.T) {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("conversations.info", func(form url.Values) (any, error) {
+		if form.Get("channel") == "C2" {
+			return nil, slackapitest.Error("channel_not_found")
+		}
+		return map[string]any{"channel": map[string]any{"id": form.Get("channel"), "name": "general"}}, nil
+	})
+	source := &bridgev2.UserLogin{Client: &fakeClientProvider{client: srv.Client()}}
+	mc := &MessageConverter{}
+	ctx := context.Background()
+	assert.Equal(t, "#general", mc.getMentionedChannelName(ctx, source, "T1", "C1"))
+	assert.Equal(t, "#general", mc.getMentionedChannelName(ctx, source, "T1", "C1"))
+	assert.Len(t, srv.Calls("conversations.info"), 1)
+	assert.Equal(t, "", mc.getMentionedChannelName(ctx, source, "T1", "C2"))
+	assert.Equal(t, "", mc.getMentionedChannelName(ctx, source, "T1", "C2"))
+	assert.Len(t, srv.Calls("conversations.info"), 3)
+}
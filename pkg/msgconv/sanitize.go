@@ -0,0 +1,183 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"maunium.net/go/mautrix/event"
+)
+
+// allowedTags is the set of HTML tags recommended by the Matrix spec for m.room.message formatted bodies,
+// mapped to the attributes that are allowed on each tag.
+var allowedTags = map[atom.Atom][]string{
+	atom.Font:       {"color", "data-mx-bg-color", "data-mx-color"},
+	atom.Del:        nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Blockquote: nil,
+	atom.P:          nil,
+	atom.A:          {"href", "target"},
+	atom.Ul:         nil,
+	atom.Ol:         {"start"},
+	atom.Sup:        nil,
+	atom.Sub:        nil,
+	atom.Li:         nil,
+	atom.B:          nil,
+	atom.I:          nil,
+	atom.U:          nil,
+	atom.Strong:     nil,
+	atom.Em:         nil,
+	atom.S:          nil,
+	atom.Code:       {"class"},
+	atom.Hr:         nil,
+	atom.Br:         nil,
+	atom.Div:        nil,
+	atom.Table:      nil,
+	atom.Thead:      nil,
+	atom.Tbody:      nil,
+	atom.Tr:         nil,
+	atom.Th:         nil,
+	atom.Td:         nil,
+	atom.Caption:    nil,
+	atom.Pre:        nil,
+	atom.Span:       {"data-mx-bg-color", "data-mx-color", "data-mx-spoiler"},
+	atom.Img:        {"width", "height", "alt", "title", "src", "data-mx-emoticon"},
+	atom.Details:    nil,
+	atom.Summary:    nil,
+}
+
+// droppedTags are removed together with all their content rather than being unwrapped.
+var droppedTags = map[atom.Atom]struct{}{
+	atom.Script:   {},
+	atom.Style:    {},
+	atom.Iframe:   {},
+	atom.Object:   {},
+	atom.Embed:    {},
+	atom.Noscript: {},
+	atom.Template: {},
+	atom.Textarea: {},
+	atom.Select:   {},
+	atom.Svg:      {},
+	atom.Math:     {},
+	atom.Head:     {},
+	atom.Title:    {},
+}
+
+var allowedLinkSchemes = []string{"https:", "http:", "ftp:", "mailto:", "magnet:", "matrix:", "slack:"}
+
+var (
+	colorRegex     = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	codeClassRegex = regexp.MustCompile(`^language-[a-zA-Z0-9_+-]+$`)
+	numberRegex    = regexp.MustCompile(`^[0-9]{1,6}$`)
+)
+
+func isAllowedAttribute(tag atom.Atom, attr html.Attribute) bool {
+	switch attr.Key {
+	case "href":
+		href := strings.ToLower(strings.TrimSpace(attr.Val))
+		for _, scheme := range allowedLinkSchemes {
+			if strings.HasPrefix(href, scheme) {
+				return true
+			}
+		}
+		return false
+	case "src":
+		return strings.HasPrefix(attr.Val, "mxc://")
+	case "target":
+		return attr.Val == "_blank"
+	case "color", "data-mx-color", "data-mx-bg-color":
+		return colorRegex.MatchString(attr.Val)
+	case "class":
+		return tag == atom.Code && codeClassRegex.MatchString(attr.Val)
+	case "start", "width", "height":
+		return numberRegex.MatchString(attr.Val)
+	default:
+		return true
+	}
+}
+
+func sanitizeNode(node *html.Node) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		switch child.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			sanitizeNode(child)
+			if _, drop := droppedTags[child.DataAtom]; drop {
+				node.RemoveChild(child)
+			} else if allowedAttrs, ok := allowedTags[child.DataAtom]; !ok {
+				// Unknown tags are unwrapped, so their (already sanitized) content is preserved
+				for grandchild := child.FirstChild; grandchild != nil; {
+					nextGrandchild := grandchild.NextSibling
+					child.RemoveChild(grandchild)
+					node.InsertBefore(grandchild, child)
+					grandchild = nextGrandchild
+				}
+				node.RemoveChild(child)
+			} else {
+				attrs := child.Attr[:0]
+				for _, attr := range child.Attr {
+					if attr.Namespace == "" && slices.Contains(allowedAttrs, attr.Key) && isAllowedAttribute(child.DataAtom, attr) {
+						attrs = append(attrs, attr)
+					}
+				}
+				child.Attr = attrs
+			}
+		default:
+			node.RemoveChild(child)
+		}
+		child = next
+	}
+}
+
+// sanitizeHTML strips everything from the given HTML that isn't allowed in Matrix formatted bodies.
+// Disallowed tags are unwrapped (or removed with their content for things like scripts), disallowed attributes
+// and link schemes are removed, and the output is always well-formed.
+func sanitizeHTML(input string) string {
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(input), container)
+	if err != nil {
+		return html.EscapeString(input)
+	}
+	for _, node := range nodes {
+		container.AppendChild(node)
+	}
+	sanitizeNode(container)
+	var buf strings.Builder
+	for child := container.FirstChild; child != nil; child = child.NextSibling {
+		if err = html.Render(&buf, child); err != nil {
+			return html.EscapeString(input)
+		}
+	}
+	return buf.String()
+}
+
+// sanitizeContent runs the formatted body of the given content through sanitizeHTML.
+func sanitizeContent(content *event.MessageEventContent) {
+	if content != nil && content.Format == event.FormatHTML {
+		content.FormattedBody = sanitizeHTML(content.FormattedBody)
+	}
+}
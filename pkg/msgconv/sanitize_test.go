@@ -0,0 +1,53 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain formatting", `<b>bold</b> and <a href="https://example.com">link</a>`, `<b>bold</b> and <a href="https://example.com">link</a>`},
+		{"script tag", `hi<script>alert(1)</script>!`, `hi!`},
+		{"style tag", `<style>body{display:none}</style>text`, `text`},
+		{"event handler", `<img src="mxc://example.com/abc" onerror="alert(1)" alt="x">`, `<img src="mxc://example.com/abc" alt="x"/>`},
+		{"javascript link", `<a href="javascript:alert(1)">click</a>`, `<a>click</a>`},
+		{"javascript link with whitespace", `<a href=" JaVaScRiPt:alert(1)">click</a>`, `<a>click</a>`},
+		{"external image", `<img src="https://evil.example/track.png" alt="pic">`, `<img alt="pic"/>`},
+		{"unknown tag unwrapped", `<marquee><b>spin</b></marquee>`, `<b>spin</b>`},
+		{"iframe", `<iframe src="https://evil.example"></iframe>after`, `after`},
+		{"unclosed tags", `<b><i>text`, `<b><i>text</i></b>`},
+		{"attribute breakout", `<b>"><script>alert(1)</script></b>`, `<b>&#34;&gt;</b>`},
+		{"comment", `a<!-- <script>x</script> -->b`, `ab`},
+		{"bad color", `<font color="red;background:url(x)">c</font>`, `<font>c</font>`},
+		{"good color", `<span data-mx-color="#ff0000">c</span>`, `<span data-mx-color="#ff0000">c</span>`},
+		{"code class", `<code class="language-go">x</code><code class="x onclick">y</code>`, `<code class="language-go">x</code><code>y</code>`},
+		{"nested forbidden in allowed", `<blockquote><svg><script>x</script></svg>quote</blockquote>`, `<blockquote>quote</blockquote>`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, sanitizeHTML(test.input))
+		})
+	}
+}
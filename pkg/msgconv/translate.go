@@ -0,0 +1,233 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// TranslationDisabled is the per-portal translation target value that disables translation in that portal.
+const TranslationDisabled = "off"
+
+const (
+	// TranslationTimeout limits how long a message is held up waiting for the translation backend.
+	TranslationTimeout = 5 * time.Second
+	// MaxTranslationCacheSize is the number of translations kept in memory, so that messages which are converted
+	// again (e.g. when only reactions or attachments are edited) don't hit the backend every time.
+	MaxTranslationCacheSize = 1000
+)
+
+type Translator interface {
+	// Translate translates the given text into the target language
+	// and returns the translated text along with the detected source language.
+	Translate(ctx context.Context, text, targetLang string) (translated, sourceLang string, err error)
+}
+
+func NewTranslator(cli *http.Client, backend, url, apiKey string) (Translator, error) {
+	switch strings.ToLower(backend) {
+	case "":
+		return nil, nil
+	case "deepl":
+		if url == "" {
+			url = "https://api-free.deepl.com"
+		}
+		return &DeepLTranslator{HTTP: cli, URL: strings.TrimSuffix(url, "/"), APIKey: apiKey}, nil
+	case "libretranslate":
+		if url == "" {
+			return nil, fmt.Errorf("libretranslate backend requires a URL")
+		}
+		return &LibreTranslator{HTTP: cli, URL: strings.TrimSuffix(url, "/"), APIKey: apiKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown translation backend %q", backend)
+	}
+}
+
+func doTranslationRequest(ctx context.Context, cli *http.Client, url string, headers map[string]string, reqData, respData any) error {
+	body, err := json.Marshal(reqData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(respData)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+type DeepLTranslator struct {
+	HTTP   *http.Client
+	URL    string
+	APIKey string
+}
+
+func (dt *DeepLTranslator) Translate(ctx context.Context, text, targetLang string) (string, string, error) {
+	var resp struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	err := doTranslationRequest(ctx, dt.HTTP, dt.URL+"/v2/translate", map[string]string{
+		"Authorization": "DeepL-Auth-Key " + dt.APIKey,
+	}, map[string]any{
+		"text":        []string{text},
+		"target_lang": strings.ToUpper(targetLang),
+	}, &resp)
+	if err != nil {
+		return "", "", err
+	} else if len(resp.Translations) == 0 {
+		return "", "", fmt.Errorf("no translations in response")
+	}
+	return resp.Translations[0].Text, resp.Translations[0].DetectedSourceLanguage, nil
+}
+
+type LibreTranslator struct {
+	HTTP   *http.Client
+	URL    string
+	APIKey string
+}
+
+func (lt *LibreTranslator) Translate(ctx context.Context, text, targetLang string) (string, string, error) {
+	var resp struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	err := doTranslationRequest(ctx, lt.HTTP, lt.URL+"/translate", nil, map[string]any{
+		"q":       text,
+		"source":  "auto",
+		"target":  strings.ToLower(targetLang),
+		"format":  "text",
+		"api_key": lt.APIKey,
+	}, &resp)
+	if err != nil {
+		return "", "", err
+	}
+	return resp.TranslatedText, resp.DetectedLanguage.Language, nil
+}
+
+func baseLanguage(lang string) string {
+	lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+	return lang
+}
+
+func (mc *MessageConverter) getTranslationTarget(portal *bridgev2.Portal) string {
+	if mc.Translator == nil {
+		return ""
+	}
+	target := mc.Options().TranslationTarget
+	if meta, ok := portal.Metadata.(*slackid.PortalMetadata); ok && meta.TranslationTarget != "" {
+		target = meta.TranslationTarget
+	}
+	if target == TranslationDisabled {
+		return ""
+	}
+	return target
+}
+
+type translationCacheKey struct {
+	text   string
+	target string
+}
+
+type translationCacheEntry struct {
+	translated string
+	sourceLang string
+}
+
+// translate translates the text with a timeout, using the cache if the same text was already translated.
+// Failed translations aren't cached.
+func (mc *MessageConverter) translate(ctx context.Context, text, target string) (string, string, error) {
+	key := translationCacheKey{text: text, target: target}
+	mc.translationCacheLock.Lock()
+	entry, ok := mc.translationCache[key]
+	mc.translationCacheLock.Unlock()
+	if ok {
+		return entry.translated, entry.sourceLang, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, TranslationTimeout)
+	defer cancel()
+	translated, sourceLang, err := mc.Translator.Translate(ctx, text, target)
+	if err != nil {
+		return "", "", err
+	}
+	mc.translationCacheLock.Lock()
+	if mc.translationCache == nil || len(mc.translationCache) >= MaxTranslationCacheSize {
+		mc.translationCache = make(map[translationCacheKey]translationCacheEntry)
+	}
+	mc.translationCache[key] = translationCacheEntry{translated: translated, sourceLang: sourceLang}
+	mc.translationCacheLock.Unlock()
+	return translated, sourceLang, nil
+}
+
+func (mc *MessageConverter) maybeTranslate(ctx context.Context, portal *bridgev2.Portal, part *bridgev2.ConvertedMessagePart) {
+	if part == nil || part.Content.Body == "" {
+		return
+	}
+	target := mc.getTranslationTarget(portal)
+	if target == "" {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	translated, sourceLang, err := mc.translate(ctx, part.Content.Body, target)
+	if err != nil {
+		log.Err(err).Str("target_lang", target).Msg("Failed to translate message")
+		return
+	} else if sourceLang == "" || baseLanguage(sourceLang) == baseLanguage(target) || translated == part.Content.Body {
+		return
+	}
+	part.Content.EnsureHasHTML()
+	label := fmt.Sprintf("Translated from %s", strings.ToUpper(sourceLang))
+	part.Content.Body += fmt.Sprintf("\n\n%s:\n%s", label, translated)
+	part.Content.FormattedBody += fmt.Sprintf("<blockquote><sup>%s</sup><br>%s</blockquote>", label, event.TextToHTML(translated))
+	if part.Extra == nil {
+		part.Extra = make(map[string]any)
+	}
+	part.Extra["fi.mau.slack.translation"] = map[string]any{
+		"source_lang": sourceLang,
+		"target_lang": target,
+	}
+}
@@ -0,0 +1,96 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+type fakeTranslator struct {
+	calls      int
+	sourceLang string
+	err        error
+	deadline   time.Time
+}
+
+func (ft *fakeTranslator) Translate(ctx context.Context, text, targetLang string) (string, string, error) {
+	ft.calls++
+	ft.deadline, _ = ctx.Deadline()
+	if ft.err != nil {
+		return "", "", ft.err
+	}
+	return "translated: " + text, ft.sourceLang, nil
+}
+
+func TestMaybeTranslate(t *testing.T) {
+	translator := &fakeTranslator{sourceLang: "DE"}
+	mc := &MessageConverter{Translator: translator}
+	mc.SetOptions(&Options{TranslationTarget: "en"})
+	portal := &bridgev2.Portal{Portal: &database.Portal{Metadata: &slackid.PortalMetadata{}}}
+	makePart := func(body string) *bridgev2.ConvertedMessagePart {
+		return &bridgev2.ConvertedMessagePart{Content: &event.MessageEventContent{MsgType: event.MsgText, Body: body}}
+	}
+	ctx := context.Background()
+
+	part := makePart("hallo")
+	mc.maybeTranslate(ctx, portal, part)
+	assert.Equal(t, "hallo\n\nTranslated from DE:\ntranslated: hallo", part.Content.Body)
+	assert.Equal(t, map[string]any{"source_lang": "DE", "target_lang": "en"}, part.Extra["fi.mau.slack.translation"])
+
+	// The same text is translated from the cache
+	part = makePart("hallo")
+	mc.maybeTranslate(ctx, portal, part)
+	assert.Equal(t, "hallo\n\nTranslated from DE:\ntranslated: hallo", part.Content.Body)
+	assert.Equal(t, 1, translator.calls)
+
+	// Text already in the target language is left alone
+	translator.sourceLang = "EN-US"
+	part = makePart("hello")
+	mc.maybeTranslate(ctx, portal, part)
+	assert.Equal(t, "hello", part.Content.Body)
+
+	portal.Metadata.(*slackid.PortalMetadata).TranslationTarget = TranslationDisabled
+	part = makePart("bonjour")
+	mc.maybeTranslate(ctx, portal, part)
+	assert.Equal(t, "bonjour", part.Content.Body)
+	assert.Equal(t, 2, translator.calls)
+}
+
+func TestMaybeTranslate_TimeoutAndErrors(t *testing.T) {
+	translator := &fakeTranslator{err: context.DeadlineExceeded}
+	mc := &MessageConverter{Translator: translator}
+	mc.SetOptions(&Options{TranslationTarget: "en"})
+	portal := &bridgev2.Portal{Portal: &database.Portal{Metadata: &slackid.PortalMetadata{}}}
+	part := &bridgev2.ConvertedMessagePart{Content: &event.MessageEventContent{MsgType: event.MsgText, Body: "hallo"}}
+	start := time.Now()
+	mc.maybeTranslate(context.Background(), portal, part)
+	assert.WithinRange(t, translator.deadline, start, time.Now().Add(TranslationTimeout))
+	assert.Equal(t, "hallo", part.Content.Body)
+
+	// Failed translations aren't cached
+	mc.maybeTranslate(context.Background(), portal, part)
+	assert.Equal(t, 2, translator.calls)
+}
@@ -0,0 +1,87 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-slack/pkg/slackid"
+)
+
+// withUnsupportedTracker returns a context that records whether any part of the message couldn't be converted.
+func withUnsupportedTracker(ctx context.Context) (context.Context, *bool) {
+	var unsupported bool
+	return context.WithValue(ctx, contextKeyUnsupported, &unsupported), &unsupported
+}
+
+// markUnsupported flags the message being converted as containing content that couldn't be converted.
+func markUnsupported(ctx context.Context) {
+	if flag, ok := ctx.Value(contextKeyUnsupported).(*bool); ok {
+		*flag = true
+	}
+}
+
+// messagePermalink returns a link for viewing the given message in Slack. If the workspace domain isn't known,
+// the link points at the channel in the Slack web app instead.
+func (mc *MessageConverter) messagePermalink(ctx context.Context, portal *bridgev2.Portal, timestamp string) string {
+	teamID, channelID := slackid.ParsePortalID(portal.ID)
+	teamPortalKey := networkid.PortalKey{ID: slackid.MakeTeamPortalID(teamID)}
+	if mc.Bridge.Config.SplitPortals {
+		teamPortalKey.Receiver = portal.Receiver
+	}
+	teamPortal, err := mc.Bridge.GetExistingPortalByKey(ctx, teamPortalKey)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get team portal to make message permalink")
+	} else if teamPortal != nil && timestamp != "" {
+		if teamDomain := teamPortal.Metadata.(*slackid.PortalMetadata).TeamDomain; teamDomain != "" {
+			return fmt.Sprintf("https://%s.slack.com/archives/%s/p%s", teamDomain, channelID, strings.ReplaceAll(timestamp, ".", ""))
+		}
+	}
+	return fmt.Sprintf("https://app.slack.com/client/%s/%s", teamID, channelID)
+}
+
+// addUnsupportedNotice appends a link to view the original message in Slack to a partially converted message.
+func addUnsupportedNotice(content *event.MessageEventContent, permalink string) {
+	content.EnsureHasHTML()
+	content.Body += "\n\nView the full message in Slack: " + permalink
+	content.FormattedBody += fmt.Sprintf(`<p><a href="%s">View the full message in Slack</a></p>`, html.EscapeString(permalink))
+}
+
+// makeUnsupportedMessage creates a notice for Slack content that can't be bridged at all.
+func makeUnsupportedMessage(partID networkid.PartID, description, permalink string) *bridgev2.ConvertedMessagePart {
+	return &bridgev2.ConvertedMessagePart{
+		ID:   partID,
+		Type: event.EventMessage,
+		Content: &event.MessageEventContent{
+			MsgType:       event.MsgNotice,
+			Body:          fmt.Sprintf("%s. View it in Slack: %s", description, permalink),
+			Format:        event.FormatHTML,
+			FormattedBody: fmt.Sprintf(`<p><i>%s.</i> <a href="%s">View it in Slack</a></p>`, html.EscapeString(description), html.EscapeString(permalink)),
+		},
+		Extra: map[string]any{
+			"fi.mau.slack.unsupported": true,
+		},
+	}
+}
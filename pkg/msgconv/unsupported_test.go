@@ -0,0 +1,96 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestUnsupportedTracker(t *testing.T) {
+	// Marking without a tracker must be a no-op
+	markUnsupported(context.Background())
+
+	ctx, unsupported := withUnsupportedTracker(context.Background())
+	assert.False(t, *unsupported)
+	markUnsupported(ctx)
+	assert.True(t, *unsupported)
+}
+
+func TestAddUnsupportedNotice(t *testing.T) {
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: "hello <world>"}
+	addUnsupportedNotice(content, "https://example.slack.com/archives/C1/p123?a=1&b=2")
+	assert.Equal(t, "hello <world>\n\nView the full message in Slack: https://example.slack.com/archives/C1/p123?a=1&b=2", content.Body)
+	assert.Equal(t, event.FormatHTML, content.Format)
+	assert.Equal(t, `hello &lt;world&gt;<p><a href="https://example.slack.com/archives/C1/p123?a=1&amp;b=2">View the full message in Slack</a></p>`, content.FormattedBody)
+}
+
+func TestMakeUnsupportedMessage(t *testing.T) {
+	part := makeUnsupportedMessage("file-0-F1", "Unsupported file <b>", "https://example.slack.com/files/U1/F1")
+	assert.Equal(t, event.MsgNotice, part.Content.MsgType)
+	assert.Equal(t, "Unsupported file <b>. View it in Slack: https://example.slack.com/files/U1/F1", part.Content.Body)
+	assert.Equal(t, `<p><i>Unsupported file &lt;b&gt;.</i> <a href="https://example.slack.com/files/U1/F1">View it in Slack</a></p>`, part.Content.FormattedBody)
+	assert.Equal(t, true, part.Extra["fi.mau.slack.unsupported"])
+}
+
+func TestRenderSlackActionBlock(t *testing.T) {
+	mc := &MessageConverter{}
+	approve := slack.NewButtonBlockElement("approve", "C1", slack.NewTextBlockObject(slack.PlainTextType, "Approve", false, false))
+	deny := slack.NewButtonBlockElement("deny", "C1", slack.NewTextBlockObject(slack.PlainTextType, "Deny <all>", false, false))
+	link := slack.NewButtonBlockElement("", "", slack.NewTextBlockObject(slack.PlainTextType, "Open", false, false)).WithURL("https://example.com/?a=1&b=2")
+
+	actionableCtx := context.WithValue(context.Background(), contextKeyBlockActions, true)
+
+	ctx, unsupported := withUnsupportedTracker(actionableCtx)
+	text, isUnsupported := mc.renderSlackActionBlock(ctx, slack.NewActionBlock("b1", approve, deny))
+	assert.Equal(t, "<b>[Approve]</b> <b>[Deny &lt;all&gt;]</b> <i>(reply with an option to respond)</i>", text)
+	assert.False(t, isUnsupported)
+	assert.False(t, *unsupported)
+
+	// Buttons that can't be triggered from Matrix don't get the hint, and link to the message in Slack instead
+	ctx, unsupported = withUnsupportedTracker(context.Background())
+	text, isUnsupported = mc.renderSlackActionBlock(ctx, slack.NewActionBlock("b1", approve, deny))
+	assert.Equal(t, "<b>[Approve]</b> <b>[Deny &lt;all&gt;]</b>", text)
+	assert.False(t, isUnsupported)
+	assert.True(t, *unsupported)
+
+	ctx, unsupported = withUnsupportedTracker(context.Background())
+	text, isUnsupported = mc.renderSlackActionBlock(ctx, slack.NewActionBlock("b2", link))
+	assert.Equal(t, `<a href="https://example.com/?a=1&amp;b=2">Open</a>`, text)
+	assert.False(t, isUnsupported)
+	assert.False(t, *unsupported)
+
+	menu := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, slack.NewTextBlockObject(slack.PlainTextType, "Priority", false, false), "priority",
+		slack.NewOptionBlockObject("high", slack.NewTextBlockObject(slack.PlainTextType, "High", false, false), nil),
+		slack.NewOptionBlockObject("low", slack.NewTextBlockObject(slack.PlainTextType, "Low", false, false), nil),
+	)
+	picker := slack.NewDatePickerBlockElement("date")
+	ctx, unsupported = withUnsupportedTracker(actionableCtx)
+	text, isUnsupported = mc.renderSlackActionBlock(ctx, slack.NewActionBlock("b4", menu, picker))
+	assert.Equal(t, "<i>Priority:</i> <b>[High]</b> <b>[Low]</b> <i>(reply with an option to respond)</i>", text)
+	assert.False(t, isUnsupported)
+	assert.True(t, *unsupported)
+
+	ctx, unsupported = withUnsupportedTracker(context.Background())
+	_, isUnsupported = mc.renderSlackActionBlock(ctx, slack.NewActionBlock("b3"))
+	assert.True(t, isUnsupported)
+	assert.True(t, *unsupported)
+}
@@ -0,0 +1,67 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackapi
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AdminClient calls the Slack admin API methods, which slack-go doesn't implement.
+// The methods are only available to owners and admins of Enterprise Grid organizations.
+type AdminClient struct {
+	WebClient
+}
+
+// NewAdminClient creates an admin API client using the given token and optional session cookie.
+func NewAdminClient(token, cookie string) *AdminClient {
+	return &AdminClient{WebClient: *NewWebClient(token, cookie)}
+}
+
+// InviteUser invites the given email address to the workspace and the given channels.
+func (ac *AdminClient) InviteUser(ctx context.Context, teamID, email string, channelIDs []string) error {
+	return ac.call(ctx, "admin.users.invite", url.Values{
+		"team_id":     {teamID},
+		"email":       {email},
+		"channel_ids": {strings.Join(channelIDs, ",")},
+	})
+}
+
+// RenameChannel renames a channel, even if the admin isn't a member of it.
+func (ac *AdminClient) RenameChannel(ctx context.Context, channelID, name string) error {
+	return ac.call(ctx, "admin.conversations.rename", url.Values{
+		"channel_id": {channelID},
+		"name":       {name},
+	})
+}
+
+// SetChannelRetention sets a custom message retention duration for a channel.
+func (ac *AdminClient) SetChannelRetention(ctx context.Context, channelID string, days int) error {
+	return ac.call(ctx, "admin.conversations.setCustomRetention", url.Values{
+		"channel_id":    {channelID},
+		"duration_days": {strconv.Itoa(days)},
+	})
+}
+
+// RemoveChannelRetention makes a channel use the workspace's default retention policy again.
+func (ac *AdminClient) RemoveChannelRetention(ctx context.Context, channelID string) error {
+	return ac.call(ctx, "admin.conversations.removeCustomRetention", url.Values{
+		"channel_id": {channelID},
+	})
+}
@@ -0,0 +1,79 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package slackapi defines the subset of the Slack web API that the bridge uses,
+// so that it can be replaced with a fake in tests.
+package slackapi
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Client is the subset of *slack.Client methods used by the connector and message converter.
+type Client interface {
+	AuthTestContext(ctx context.Context) (*slack.AuthTestResponse, error)
+	SendAuthSignoutContext(ctx context.Context) (*slack.SlackResponse, error)
+	ClientUserBootContext(ctx context.Context, minUpdated time.Time) (*slack.ClientUserBootResponse, error)
+	ClientCountsContext(ctx context.Context, params *slack.ClientCountsParams) (*slack.ClientCountsResponse, error)
+	FetchVersionData(ctx context.Context) error
+	GetTeamInfoContext(ctx context.Context) (*slack.TeamInfo, error)
+	GetEmojiContext(ctx context.Context) (map[string]string, error)
+
+	GetUserInfoContext(ctx context.Context, user string) (*slack.User, error)
+	GetUserByEmailContext(ctx context.Context, email string) (*slack.User, error)
+	GetUsersCacheContext(ctx context.Context, teamID string, params slack.GetCachedUsersParameters) (map[string]*slack.User, error)
+	SearchUsersCacheContext(ctx context.Context, teamID, query string) (*slack.SearchUsers, error)
+	GetBotInfoContext(ctx context.Context, params slack.GetBotInfoParameters) (*slack.Bot, error)
+
+	GetConversationInfoContext(ctx context.Context, input *slack.GetConversationInfoInput) (*slack.Channel, error)
+	GetConversationHistoryContext(ctx context.Context, params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error)
+	GetConversationRepliesContext(ctx context.Context, params *slack.GetConversationRepliesParameters) (*slack.GetConversationHistoryResponse, error)
+	GetConversationsForUserContext(ctx context.Context, params *slack.GetConversationsForUserParameters) ([]slack.Channel, string, error)
+	GetUsersInConversation(params *slack.GetUsersInConversationParameters) ([]string, string, error)
+	OpenConversationContext(ctx context.Context, params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error)
+	CreateConversationContext(ctx context.Context, params slack.CreateConversationParams) (*slack.Channel, error)
+	RenameConversationContext(ctx context.Context, channelID, channelName string) (*slack.Channel, error)
+	SetTopicOfConversationContext(ctx context.Context, channelID, topic string) (*slack.Channel, error)
+	InviteUsersToConversationContext(ctx context.Context, channelID string, users ...string) (*slack.Channel, error)
+	MarkConversationContext(ctx context.Context, channel, ts string) error
+	LeaveConversationContext(ctx context.Context, channelID string) (bool, error)
+	CloseConversationContext(ctx context.Context, channelID string) (bool, bool, error)
+
+	PostMessageContext(ctx context.Context, channelID string, options ...slack.MsgOption) (string, string, error)
+	DeleteMessageContext(ctx context.Context, channel, messageTimestamp string) (string, string, error)
+	AddReactionContext(ctx context.Context, name string, item slack.ItemRef) error
+	RemoveReactionContext(ctx context.Context, name string, item slack.ItemRef) error
+	GetReactionsContext(ctx context.Context, item slack.ItemRef, params slack.GetReactionsParameters) ([]slack.ItemReaction, error)
+	AddStarContext(ctx context.Context, channel string, item slack.ItemRef) error
+	RemoveStarContext(ctx context.Context, channel string, item slack.ItemRef) error
+	ListStarsContext(ctx context.Context, params slack.StarsParameters) ([]slack.Item, *slack.Paging, error)
+
+	GetFileInfoContext(ctx context.Context, fileID string, count, page int) (*slack.File, []slack.Comment, *slack.Paging, error)
+	GetFileContext(ctx context.Context, downloadURL string, writer io.Writer) error
+	GetFileUploadURL(ctx context.Context, params slack.GetFileUploadURLParameters) (*slack.FileUploadURL, error)
+	UploadToURL(ctx context.Context, fu *slack.FileUploadURL, mimeType string, data []byte) error
+	CompleteFileUpload(ctx context.Context, fu *slack.FileUploadURL) error
+	UploadFileV2Context(ctx context.Context, params slack.UploadFileV2Parameters) (*slack.File, error)
+	GetUploadURLExternalContext(ctx context.Context, params slack.GetUploadURLExternalParameters) (*slack.GetUploadURLExternalResponse, error)
+	CompleteUploadExternalContext(ctx context.Context, params slack.CompleteUploadExternalParameters) (*slack.CompleteUploadExternalResponse, error)
+	ShareFile(ctx context.Context, params slack.ShareFileParams) (*slack.ShareFile, error)
+}
+
+var _ Client = (*slack.Client)(nil)
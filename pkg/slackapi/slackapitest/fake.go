@@ -0,0 +1,193 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackapitest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/slack-go/slack"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+)
+
+// PostedMessage is a message sent through Fake.PostMessageContext.
+type PostedMessage struct {
+	ChannelID string
+	Timestamp string
+	Values    url.Values
+}
+
+// Reaction is a reaction added through Fake.AddReactionContext.
+type Reaction struct {
+	Name string
+	Item slack.ItemRef
+}
+
+// Fake is an in-memory implementation of the parts of slackapi.Client that are commonly needed in tests.
+//
+// Methods that aren't implemented are delegated to the embedded Client, which panics if it's nil.
+type Fake struct {
+	slackapi.Client
+
+	lock sync.Mutex
+	// Users contains user info by user ID.
+	Users map[string]*slack.User
+	// Channels contains conversation info by channel ID.
+	Channels map[string]*slack.Channel
+	// Members contains member user IDs by channel ID.
+	Members map[string][]string
+	// Messages contains channel history by channel ID, sorted oldest first.
+	Messages map[string][]slack.Message
+
+	Posted    []PostedMessage
+	Reactions []Reaction
+	nextTS    int64
+}
+
+var _ slackapi.Client = (*Fake)(nil)
+
+// NewFake creates an empty Fake.
+func NewFake() *Fake {
+	return &Fake{
+		Users:    make(map[string]*slack.User),
+		Channels: make(map[string]*slack.Channel),
+		Members:  make(map[string][]string),
+		Messages: make(map[string][]slack.Message),
+		nextTS:   1700000000,
+	}
+}
+
+func (f *Fake) GetUserInfoContext(ctx context.Context, user string) (*slack.User, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	info, ok := f.Users[user]
+	if !ok {
+		return nil, slack.SlackErrorResponse{Err: "user_not_found"}
+	}
+	return info, nil
+}
+
+func (f *Fake) GetConversationInfoContext(ctx context.Context, input *slack.GetConversationInfoInput) (*slack.Channel, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	info, ok := f.Channels[input.ChannelID]
+	if !ok {
+		return nil, slack.SlackErrorResponse{Err: "channel_not_found"}
+	}
+	return info, nil
+}
+
+func (f *Fake) GetUsersInConversation(params *slack.GetUsersInConversationParameters) ([]string, string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	members, ok := f.Members[params.ChannelID]
+	if !ok {
+		return nil, "", slack.SlackErrorResponse{Err: "channel_not_found"}
+	}
+	start, _ := strconv.Atoi(params.Cursor)
+	end := len(members)
+	if params.Limit > 0 && start+params.Limit < end {
+		end = start + params.Limit
+	}
+	var nextCursor string
+	if end < len(members) {
+		nextCursor = strconv.Itoa(end)
+	}
+	return slices.Clone(members[start:end]), nextCursor, nil
+}
+
+func (f *Fake) GetConversationHistoryContext(ctx context.Context, params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	messages, ok := f.Messages[params.ChannelID]
+	if !ok {
+		return nil, slack.SlackErrorResponse{Err: "channel_not_found"}
+	}
+	resp := &slack.GetConversationHistoryResponse{}
+	resp.Ok = true
+	// Slack returns history newest first
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if !inRange(msg.Timestamp, params.Oldest, params.Latest, params.Inclusive) {
+			continue
+		} else if params.Limit > 0 && len(resp.Messages) >= params.Limit {
+			resp.HasMore = true
+			break
+		}
+		resp.Messages = append(resp.Messages, msg)
+	}
+	return resp, nil
+}
+
+func inRange(ts, oldest, latest string, inclusive bool) bool {
+	val := parseTS(ts)
+	if oldest != "" {
+		if minVal := parseTS(oldest); val < minVal || (!inclusive && val == minVal) {
+			return false
+		}
+	}
+	if latest != "" {
+		if maxVal := parseTS(latest); val > maxVal || (!inclusive && val == maxVal) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseTS(ts string) float64 {
+	val, _ := strconv.ParseFloat(ts, 64)
+	return val
+}
+
+func (f *Fake) PostMessageContext(ctx context.Context, channelID string, options ...slack.MsgOption) (string, string, error) {
+	_, values, err := slack.UnsafeApplyMsgOptions("", channelID, "", nil, options...)
+	if err != nil {
+		return "", "", err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nextTS++
+	ts := fmt.Sprintf("%d.000100", f.nextTS)
+	f.Posted = append(f.Posted, PostedMessage{ChannelID: channelID, Timestamp: ts, Values: values})
+	return channelID, ts, nil
+}
+
+func (f *Fake) AddReactionContext(ctx context.Context, name string, item slack.ItemRef) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if slices.Contains(f.Reactions, Reaction{Name: name, Item: item}) {
+		return slack.SlackErrorResponse{Err: "already_reacted"}
+	}
+	f.Reactions = append(f.Reactions, Reaction{Name: name, Item: item})
+	return nil
+}
+
+func (f *Fake) RemoveReactionContext(ctx context.Context, name string, item slack.ItemRef) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	idx := slices.Index(f.Reactions, Reaction{Name: name, Item: item})
+	if idx < 0 {
+		return slack.SlackErrorResponse{Err: "no_reaction"}
+	}
+	f.Reactions = slices.Delete(f.Reactions, idx, idx+1)
+	return nil
+}
@@ -0,0 +1,153 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package slackapitest contains helpers for testing code that talks to the Slack web API.
+package slackapitest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// HandlerFunc handles a single Slack API method call. The form contains the request parameters.
+//
+// The returned value is encoded as the JSON response body, with "ok": true added automatically.
+// Returning an error produces an "ok": false response instead (see Error and RateLimited).
+type HandlerFunc func(form url.Values) (any, error)
+
+// Error is returned by handlers to make the server respond with a Slack API error code.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// RateLimited is returned by handlers to make the server respond with HTTP 429.
+type RateLimited time.Duration
+
+func (rl RateLimited) Error() string {
+	return "ratelimited"
+}
+
+// Server is a fake Slack web API server backed by httptest.
+type Server struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	handlers map[string]HandlerFunc
+	calls    map[string][]url.Values
+}
+
+// NewServer starts a new fake Slack API server, which is closed automatically when the test ends.
+func NewServer(t testing.TB) *Server {
+	srv := &Server{
+		handlers: make(map[string]HandlerFunc),
+		calls:    make(map[string][]url.Values),
+	}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.serveHTTP))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Handle registers a handler for the given API method (e.g. "conversations.info").
+func (srv *Server) Handle(method string, handler HandlerFunc) {
+	srv.lock.Lock()
+	srv.handlers[method] = handler
+	srv.lock.Unlock()
+}
+
+// Respond registers a handler that always returns the given response.
+func (srv *Server) Respond(method string, response any) {
+	srv.Handle(method, func(url.Values) (any, error) {
+		return response, nil
+	})
+}
+
+// Calls returns the parameters of all calls made to the given API method so far.
+func (srv *Server) Calls(method string) []url.Values {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.calls[method]
+}
+
+// Client returns a Slack client that sends all requests to this server.
+func (srv *Server) Client() *slack.Client {
+	return slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/api/"))
+}
+
+func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		_ = r.ParseMultipartForm(32 << 20)
+	} else {
+		_ = r.ParseForm()
+	}
+	srv.lock.Lock()
+	handler, ok := srv.handlers[method]
+	srv.calls[method] = append(srv.calls[method], r.Form)
+	srv.lock.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]any{"ok": false, "error": "unknown_method"})
+		return
+	}
+	resp, err := handler(r.Form)
+	var rateLimited RateLimited
+	var apiErr Error
+	if errors.As(err, &rateLimited) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Duration(rateLimited).Seconds())))
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{"ok": false, "error": "ratelimited"})
+		return
+	} else if errors.As(err, &apiErr) {
+		writeJSON(w, http.StatusOK, map[string]any{"ok": false, "error": string(apiErr)})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{}
+	if resp != nil {
+		raw, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = json.Unmarshal(raw, &data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, ok = data["ok"]; !ok {
+		data["ok"] = true
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,193 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// ResumableUploadThreshold is the file size above which UploadFileV2 should be replaced with UploadFileResumable.
+	ResumableUploadThreshold = 8 * 1024 * 1024
+	// UploadChunkSize is the number of bytes written to the upload URL at once, and the granularity of progress logs.
+	UploadChunkSize = 1024 * 1024
+	// MaxUploadAttempts is the number of times the file data is sent to the upload URL before giving up.
+	MaxUploadAttempts = 4
+)
+
+var uploadRetryDelay = 2 * time.Second
+
+// UploadHTTPClient is the HTTP client used for sending file data to upload URLs. It has no overall timeout,
+// as large files can take a long time to transfer, but the connection itself is still bounded.
+var UploadHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Minute,
+		ForceAttemptHTTP2:     true,
+	},
+}
+
+// UploadFileResumable uploads a file using the same files.getUploadURLExternal flow as UploadFileV2,
+// but streams the data to the upload URL in chunks with progress logging and retries failed transfers.
+//
+// Slack's upload URLs don't accept byte ranges, so a retry has to send the data from the start,
+// but it reuses the upload URL instead of starting the whole flow over, so the file ID stays the same
+// and nothing is shared to the channel until the data has been transferred fully.
+func UploadFileResumable(ctx context.Context, client Client, httpClient *http.Client, params slack.UploadFileV2Parameters) (*slack.File, error) {
+	reader, ok := params.Reader.(io.ReadSeeker)
+	if !ok {
+		return nil, errors.New("resumable uploads require a seekable reader")
+	} else if params.Filename == "" {
+		return nil, errors.New("filename cannot be empty")
+	} else if params.FileSize == 0 {
+		return nil, errors.New("file size cannot be 0")
+	}
+	log := zerolog.Ctx(ctx).With().
+		Str("filename", params.Filename).
+		Int("file_size", params.FileSize).
+		Logger()
+	uploadURL, err := client.GetUploadURLExternalContext(ctx, slack.GetUploadURLExternalParameters{
+		FileName: params.Filename,
+		FileSize: params.FileSize,
+		AltText:  params.AltTxt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload URL: %w", err)
+	}
+	log = log.With().Str("file_id", uploadURL.FileID).Logger()
+	for attempt := 1; ; attempt++ {
+		if _, err = reader.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind file: %w", err)
+		}
+		err = uploadChunked(log.WithContext(ctx), httpClient, uploadURL.UploadURL, params.Filename, reader, params.FileSize)
+		if err == nil {
+			break
+		} else if attempt >= MaxUploadAttempts || !isRetryableUploadError(ctx, err) {
+			return nil, fmt.Errorf("failed to upload file data after %d attempts: %w", attempt, err)
+		}
+		delay := uploadRetryDelay * time.Duration(attempt)
+		log.Warn().Err(err).
+			Int("attempt", attempt).
+			Stringer("retry_in", delay).
+			Msg("Failed to upload file data, retrying")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	resp, err := client.CompleteUploadExternalContext(ctx, slack.CompleteUploadExternalParameters{
+		Files:           []slack.FileSummary{{ID: uploadURL.FileID, Title: params.Title}},
+		Channel:         params.Channel,
+		InitialComment:  params.InitialComment,
+		ThreadTimestamp: params.ThreadTimestamp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete upload: %w", err)
+	} else if len(resp.Files) != 1 {
+		return nil, fmt.Errorf("expected 1 file in upload completion response, got %d", len(resp.Files))
+	}
+	return &resp.Files[0], nil
+}
+
+func isRetryableUploadError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *uploadStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	// Anything else is a network error or the connection being cut off
+	return true
+}
+
+type uploadStatusError struct {
+	StatusCode int
+}
+
+func (e *uploadStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d from upload URL", e.StatusCode)
+}
+
+// uploadChunked sends the file to the upload URL as a multipart form, writing it in UploadChunkSize pieces
+// through a pipe, so the file isn't buffered again in memory and progress can be logged as it goes.
+func uploadChunked(ctx context.Context, httpClient *http.Client, uploadURL, filename string, data io.Reader, size int) error {
+	log := zerolog.Ctx(ctx)
+	pr, pw := io.Pipe()
+	mp := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeMultipartFile(ctx, mp, filename, data, size))
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, pr)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		return err
+	}
+	req.Header.Set("Content-Type", mp.FormDataContentType())
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &uploadStatusError{StatusCode: resp.StatusCode}
+	}
+	log.Debug().Msg("Finished uploading file data")
+	return nil
+}
+
+func writeMultipartFile(ctx context.Context, mp *multipart.Writer, filename string, data io.Reader, size int) error {
+	log := zerolog.Ctx(ctx)
+	part, err := mp.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, UploadChunkSize)
+	written := 0
+	for {
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			if _, writeErr := part.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			written += n
+			log.Debug().
+				Int("uploaded_bytes", written).
+				Int("percent", written*100/max(size, 1)).
+				Msg("File upload progress")
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return mp.Close()
+}
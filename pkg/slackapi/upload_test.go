@@ -0,0 +1,86 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackapi_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/mautrix-slack/pkg/slackapi"
+	"go.mau.fi/mautrix-slack/pkg/slackapi/slackapitest"
+)
+
+func newUploadTestServer(t *testing.T, uploadFailures int) *slackapitest.Server {
+	srv := slackapitest.NewServer(t)
+	srv.Handle("files.getUploadURLExternal", func(form url.Values) (any, error) {
+		return map[string]any{"upload_url": srv.URL + "/api/upload", "file_id": "F1"}, nil
+	})
+	srv.Handle("upload", func(form url.Values) (any, error) {
+		if len(srv.Calls("upload")) <= uploadFailures {
+			return nil, errors.New("connection reset")
+		}
+		return nil, nil
+	})
+	srv.Handle("files.completeUploadExternal", func(form url.Values) (any, error) {
+		return map[string]any{"files": []any{map[string]any{"id": "F1", "title": "big.bin"}}}, nil
+	})
+	return srv
+}
+
+func makeUploadParams() slack.UploadFileV2Parameters {
+	data := bytes.Repeat([]byte{'a'}, slackapi.UploadChunkSize*2+100)
+	return slack.UploadFileV2Parameters{
+		Filename: "big.bin",
+		Reader:   bytes.NewReader(data),
+		FileSize: len(data),
+		Channel:  "C1",
+	}
+}
+
+func TestUploadFileResumable(t *testing.T) {
+	defer slackapi.SetUploadRetryDelay(0)()
+	srv := newUploadTestServer(t, 2)
+
+	file, err := slackapi.UploadFileResumable(context.Background(), srv.Client(), http.DefaultClient, makeUploadParams())
+	require.NoError(t, err)
+	assert.Equal(t, "F1", file.ID)
+	// The upload URL is reused for retries instead of starting over
+	assert.Len(t, srv.Calls("files.getUploadURLExternal"), 1)
+	assert.Len(t, srv.Calls("upload"), 3)
+	calls := srv.Calls("files.completeUploadExternal")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "C1", calls[0].Get("channel_id"))
+	assert.Contains(t, calls[0].Get("files"), `"F1"`)
+}
+
+func TestUploadFileResumable_GivesUp(t *testing.T) {
+	defer slackapi.SetUploadRetryDelay(0)()
+	srv := newUploadTestServer(t, slackapi.MaxUploadAttempts)
+
+	_, err := slackapi.UploadFileResumable(context.Background(), srv.Client(), http.DefaultClient, makeUploadParams())
+	assert.ErrorContains(t, err, "500")
+	assert.Len(t, srv.Calls("upload"), slackapi.MaxUploadAttempts)
+	assert.Empty(t, srv.Calls("files.completeUploadExternal"))
+}
@@ -0,0 +1,122 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// WebClient calls Slack web API methods that slack-go doesn't implement.
+type WebClient struct {
+	HTTP   *http.Client
+	APIURL string
+	Token  string
+	// Cookie is the value of the d cookie, needed when Token is a browser session token.
+	Cookie string
+}
+
+// NewWebClient creates a web API client using the given token and optional session cookie.
+func NewWebClient(token, cookie string) *WebClient {
+	return &WebClient{
+		HTTP:   &http.Client{Timeout: 30 * time.Second},
+		APIURL: slack.APIURL,
+		Token:  token,
+		Cookie: cookie,
+	}
+}
+
+func (wc *WebClient) call(ctx context.Context, method string, values url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wc.APIURL+method, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+wc.Token)
+	if wc.Cookie != "" {
+		req.AddCookie(&http.Cookie{Name: "d", Value: wc.Cookie})
+	}
+	resp, err := wc.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &slack.RateLimitedError{RetryAfter: time.Duration(retryAfter) * time.Second}
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, method)
+	}
+	var slackResp slack.SlackResponse
+	err = json.NewDecoder(resp.Body).Decode(&slackResp)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	return slackResp.Err()
+}
+
+// BlockAction is a single interaction with a button or select menu in a message.
+type BlockAction struct {
+	ActionID       string                   `json:"action_id"`
+	BlockID        string                   `json:"block_id"`
+	Type           slack.MessageElementType `json:"type"`
+	Value          string                   `json:"value,omitempty"`
+	SelectedOption *slack.OptionBlockObject `json:"selected_option,omitempty"`
+	ActionTS       string                   `json:"action_ts"`
+}
+
+type blockActionContainer struct {
+	Type        string `json:"type"`
+	MessageTS   string `json:"message_ts"`
+	ChannelID   string `json:"channel_id"`
+	IsEphemeral bool   `json:"is_ephemeral"`
+}
+
+// PostBlockActions triggers an interactive element in a message posted by an app, like clicking a button in the
+// Slack client. Slack then sends the app a block_actions payload on behalf of the user. The blocks.actions method
+// is what the Slack web client uses, so it requires a browser session token.
+func (wc *WebClient) PostBlockActions(ctx context.Context, channelID, messageTS, serviceID string, action BlockAction) error {
+	if action.ActionTS == "" {
+		now := time.Now()
+		action.ActionTS = fmt.Sprintf("%d.%06d", now.Unix(), now.Nanosecond()/1000)
+	}
+	actions, err := json.Marshal([]BlockAction{action})
+	if err != nil {
+		return err
+	}
+	container, err := json.Marshal(&blockActionContainer{
+		Type:      "message",
+		MessageTS: messageTS,
+		ChannelID: channelID,
+	})
+	if err != nil {
+		return err
+	}
+	return wc.call(ctx, "blocks.actions", url.Values{
+		"service_id": {serviceID},
+		"actions":    {string(actions)},
+		"container":  {string(container)},
+	})
+}
@@ -17,7 +17,11 @@
 package slackid
 
 import (
+	"strings"
+
 	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/id"
 )
 
 type PortalMetadata struct {
@@ -25,11 +29,43 @@ type PortalMetadata struct {
 	TeamDomain  string `json:"team_domain,omitempty"`
 	EditMaxAge  *int   `json:"edit_max_age,omitempty"`
 	AllowDelete *bool  `json:"allow_delete,omitempty"`
+	// Hash of the last custom emoji pack published in the team space
+	EmojiPackHash string `json:"emoji_pack_hash,omitempty"`
+
+	// Language code to translate incoming messages to, overriding the bridge-wide default
+	TranslationTarget string `json:"translation_target,omitempty"`
+	// Hash of the last chat info applied to the room, used to skip no-op resyncs
+	InfoHash string `json:"info_hash,omitempty"`
+	// Set for portals migrated from the legacy bridge, whose message history may have gaps
+	// that would make backfill produce duplicates without checking each message.
+	LegacyMigrated bool `json:"legacy_migrated,omitempty"`
+	// Set for DMs that were closed on Slack after the Matrix user left the portal
+	DMClosed bool `json:"dm_closed,omitempty"`
+	// Room alias created for the portal so that channel mentions can link to it
+	Alias id.RoomAlias `json:"alias,omitempty"`
+	// Set when the encryption policy requires encrypting the room, but it hasn't been done yet
+	EncryptionPending bool `json:"encryption_pending,omitempty"`
+	// Username and icon (an URL or :emoji:) used instead of the default ones when the bridge posts relayed messages
+	// or messages of app logins into the channel
+	BotUsername string `json:"bot_username,omitempty"`
+	BotIcon     string `json:"bot_icon,omitempty"`
+
+	// Only present for channels, not team portals
+	ChannelType     string        `json:"channel_type,omitempty"`
+	IsPrivate       bool          `json:"is_private,omitempty"`
+	IsShared        bool          `json:"is_shared,omitempty"`
+	IsArchived      bool          `json:"is_archived,omitempty"`
+	MembersSyncedAt jsontime.Unix `json:"members_synced_at,omitempty"`
+	InfoSyncedAt    jsontime.Unix `json:"info_synced_at,omitempty"`
 }
 
 type GhostMetadata struct {
 	SlackUpdatedTS int64         `json:"slack_updated_ts"`
 	LastSync       jsontime.Unix `json:"last_sync"`
+	// Slack's hash of the avatar that was last applied to the ghost
+	AvatarHash string `json:"avatar_hash,omitempty"`
+	// Number of times a changed profile has been applied to the ghost
+	ProfileVersion int `json:"profile_version,omitempty"`
 }
 
 type UserLoginMetadata struct {
@@ -37,9 +73,62 @@ type UserLoginMetadata struct {
 	Token       string `json:"token"`
 	CookieToken string `json:"cookie_token,omitempty"`
 	AppToken    string `json:"app_token,omitempty"`
+	// UserToken is an OAuth user token held by hybrid logins in addition to the bot token,
+	// used for actions that bots can't perform, like marking messages as read.
+	UserToken string `json:"user_token,omitempty"`
+	// ReadOnly logins only bridge Slack to Matrix and reject all events from Matrix
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	Settings LoginSettings `json:"settings"`
+
+	plaintextTokens bool
+	storedSecrets   bool
+}
+
+var _ database.MetaMerger = (*UserLoginMetadata)(nil)
+
+// CopyFrom replaces the credentials with the ones from a new login of the same account,
+// keeping the login settings that were configured for the existing login.
+//
+// The read-only flag is taken from the new login, so login flows that don't ask for a mode must copy it
+// from the existing login. The user token of a hybrid login is kept if the new login is a bot login without one.
+// The secret flags describe the stored row, which isn't changed by the merge.
+func (ulm *UserLoginMetadata) CopyFrom(other any) {
+	otherMeta, ok := other.(*UserLoginMetadata)
+	if !ok {
+		return
+	}
+	ulm.Email = otherMeta.Email
+	ulm.Token = otherMeta.Token
+	ulm.CookieToken = otherMeta.CookieToken
+	ulm.AppToken = otherMeta.AppToken
+	if otherMeta.UserToken != "" || !strings.HasPrefix(otherMeta.Token, "xoxb-") {
+		ulm.UserToken = otherMeta.UserToken
+	}
+	ulm.ReadOnly = otherMeta.ReadOnly
+}
+
+// LoginSettings contains per-login overrides of connector options. Unset values use the bridge-wide defaults.
+type LoginSettings struct {
+	ConversationCount *int   `json:"conversation_count,omitempty"`
+	DMPolicy          string `json:"dm_policy,omitempty"`
+	BridgeTyping      *bool  `json:"bridge_typing,omitempty"`
+	BridgeReceipts    *bool  `json:"bridge_receipts,omitempty"`
+	LeaveBehavior     string `json:"leave_behavior,omitempty"`
 }
 
 type MessageMetadata struct {
 	CaptionMerged bool   `json:"caption_merged"`
 	LastEditTS    string `json:"last_edit_ts"`
+
+	// Only present for thread roots when thread summaries are enabled
+	ThreadSummaryMXID        id.EventID `json:"thread_summary_mxid,omitempty"`
+	ThreadSummaryReplyCount  int        `json:"thread_summary_reply_count,omitempty"`
+	ThreadSummaryLatestReply string     `json:"thread_summary_latest_reply,omitempty"`
+
+	// Only present for polls sent from Matrix, the Matrix answer IDs in the order of the Slack vote emojis
+	PollOptionIDs []string `json:"poll_option_ids,omitempty"`
+
+	// Set for messages with buttons or select menus that can be triggered by replying to the message
+	HasBlockActions bool `json:"has_block_actions,omitempty"`
 }
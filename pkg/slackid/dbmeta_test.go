@@ -0,0 +1,73 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserLoginMetadata_CopyFrom(t *testing.T) {
+	count := 100
+	meta := &UserLoginMetadata{
+		Email:    "old@example.com",
+		Token:    "xoxc-old",
+		ReadOnly: true,
+		Settings: LoginSettings{ConversationCount: &count, DMPolicy: "none"},
+
+		plaintextTokens: true,
+		storedSecrets:   true,
+	}
+	meta.CopyFrom(&UserLoginMetadata{
+		Email:       "new@example.com",
+		Token:       "xoxc-new",
+		CookieToken: "xoxd-new",
+		Settings:    LoginSettings{DMPolicy: "all"},
+	})
+	assert.Equal(t, &UserLoginMetadata{
+		Email:       "new@example.com",
+		Token:       "xoxc-new",
+		CookieToken: "xoxd-new",
+		Settings:    LoginSettings{ConversationCount: &count, DMPolicy: "none"},
+
+		plaintextTokens: true,
+		storedSecrets:   true,
+	}, meta)
+}
+
+func TestUserLoginMetadata_CopyFromTakesReadOnly(t *testing.T) {
+	meta := &UserLoginMetadata{Token: "xoxb-old"}
+	meta.CopyFrom(&UserLoginMetadata{Token: "xoxb-new", ReadOnly: true})
+	assert.True(t, meta.ReadOnly)
+
+	meta.CopyFrom(&UserLoginMetadata{Token: "xoxb-new"})
+	assert.False(t, meta.ReadOnly)
+}
+
+func TestUserLoginMetadata_CopyFromKeepsUserToken(t *testing.T) {
+	meta := &UserLoginMetadata{Token: "xoxb-old", AppToken: "xapp-old", UserToken: "xoxp-old"}
+	meta.CopyFrom(&UserLoginMetadata{Token: "xoxb-new", AppToken: "xapp-new"})
+	assert.Equal(t, "xoxp-old", meta.UserToken)
+
+	meta.CopyFrom(&UserLoginMetadata{Token: "xoxb-new", AppToken: "xapp-new", UserToken: "xoxp-new"})
+	assert.Equal(t, "xoxp-new", meta.UserToken)
+
+	// Logins with a user session token don't use a separate user token
+	meta.CopyFrom(&UserLoginMetadata{Token: "xoxc-new", CookieToken: "xoxd-new"})
+	assert.Equal(t, "", meta.UserToken)
+}
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
 )
 
 func MakeMessageID(teamID, channelID, timestamp string) networkid.MessageID {
@@ -37,6 +38,18 @@ func ParseMessageID(id networkid.MessageID) (teamID, channelID, timestamp string
 	return parts[0], parts[1], parts[2], true
 }
 
+// MakePollVoteID returns the message ID of a vote on a poll bridged from Matrix.
+// Votes are keyed by voter and option(s), so they never collide with the poll message itself.
+func MakePollVoteID(pollID networkid.MessageID, voterID, option, timestamp string) networkid.MessageID {
+	return networkid.MessageID(fmt.Sprintf("%s-vote-%s-%s-%s", pollID, voterID, option, timestamp))
+}
+
+// MakeBlockActionID returns the message ID of a Matrix reply that was sent to Slack as a block action
+// (a button click or menu choice) instead of a message, so it doesn't overwrite the message it was replying to.
+func MakeBlockActionID(targetID networkid.MessageID, eventID id.EventID) networkid.MessageID {
+	return networkid.MessageID(fmt.Sprintf("%s-action-%s", targetID, eventID))
+}
+
 func ParseSlackTimestamp(timestamp string) time.Time {
 	parts := strings.Split(timestamp, ".")
 
@@ -59,6 +72,34 @@ func ParseSlackTimestamp(timestamp string) time.Time {
 	return time.Unix(seconds, nanoSeconds)
 }
 
+// ParseSlackStreamOrder converts a Slack timestamp into an integer that preserves the full ordering of the
+// timestamp, unlike Matrix timestamps which only have millisecond precision. Slack timestamps are unique
+// per channel, with the fractional part acting as a sequence number when multiple events share a second.
+func ParseSlackStreamOrder(timestamp string) int64 {
+	secondsStr, fracStr, _ := strings.Cut(timestamp, ".")
+	seconds, err := strconv.ParseInt(secondsStr, 10, 64)
+	if err != nil {
+		return 0
+	}
+	if len(fracStr) > 6 {
+		fracStr = fracStr[:6]
+	} else {
+		fracStr += strings.Repeat("0", 6-len(fracStr))
+	}
+	frac, err := strconv.ParseInt(fracStr, 10, 64)
+	if err != nil {
+		frac = 0
+	}
+	return seconds*1_000_000 + frac
+}
+
+// MakeUserID returns the network user ID of a Slack user, which bridgev2 turns into the ghost MXID using the
+// bridge-wide username_template. The team ID is part of the ID, so users of different teams (including teams
+// that were migrated and reuse user IDs) never share a ghost.
+//
+// Per-team localpart templates (e.g. using the team domain) aren't supported: bridgev2 has no hook for formatting
+// ghost MXIDs, and existing ghosts couldn't be renamed anyway, as Matrix user IDs are immutable. A migration would
+// have to create new ghosts and move every membership, message and reaction over to them.
 func MakeUserID(teamID, userID string) networkid.UserID {
 	return networkid.UserID(fmt.Sprintf("%s-%s", strings.ToLower(teamID), strings.ToLower(userID)))
 }
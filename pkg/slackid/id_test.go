@@ -42,3 +42,24 @@ func TestParseSlackTimestamp(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSlackStreamOrder(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    string
+		expected int64
+	}
+	testCases := []testCase{
+		{"Normal", "1234567890.123456", 1234567890123456},
+		{"SameSecondSequence", "1234567890.000002", 1234567890000002},
+		{"Short", "1234567890.12345", 1234567890123450},
+		{"Long", "1234567890.1234567", 1234567890123456},
+		{"SecondsOnly", "1234567890", 1234567890000000},
+		{"Invalid", "foo", 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ParseSlackStreamOrder(tc.input))
+		})
+	}
+}
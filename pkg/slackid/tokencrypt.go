@@ -0,0 +1,165 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackid
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// encryptedTokenPrefix marks login metadata values that are encrypted with the token encryption key.
+const encryptedTokenPrefix = "enc:v1:"
+
+var ErrTokenEncryptionKeyMissing = errors.New("login credentials are encrypted, but no token encryption key is configured")
+
+var tokenCipher atomic.Pointer[cipher.AEAD]
+
+// secretsStoredExternally makes UserLoginMetadata leave out the credentials when it's saved to the database.
+var secretsStoredExternally atomic.Bool
+
+// SetSecretsStoredExternally sets whether login credentials are kept in an external secret backend
+// instead of the database.
+func SetSecretsStoredExternally(external bool) {
+	secretsStoredExternally.Store(external)
+}
+
+// ParseTokenEncryptionKey parses an AES-256 key encoded as standard base64 into an AES-GCM cipher.
+func ParseTokenEncryptionKey(key string) (cipher.AEAD, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key: %w", err)
+	} else if len(rawKey) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(rawKey))
+	}
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SetTokenEncryptionKey sets the key used to encrypt the credentials in UserLoginMetadata in the database.
+// An empty key disables encryption of newly saved credentials, but previously encrypted values can't be read without the key.
+func SetTokenEncryptionKey(key string) error {
+	if key == "" {
+		tokenCipher.Store(nil)
+		return nil
+	}
+	gcm, err := ParseTokenEncryptionKey(key)
+	if err != nil {
+		return err
+	}
+	tokenCipher.Store(&gcm)
+	return nil
+}
+
+func encryptToken(token string) (string, error) {
+	gcm := tokenCipher.Load()
+	if gcm == nil || token == "" {
+		return token, nil
+	}
+	nonce := make([]byte, (*gcm).NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := (*gcm).Seal(nonce, nonce, []byte(token), nil)
+	return encryptedTokenPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptToken(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedTokenPrefix)
+	if !ok {
+		return value, nil
+	}
+	gcm := tokenCipher.Load()
+	if gcm == nil {
+		return "", ErrTokenEncryptionKeyMissing
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode encrypted token: %w", err)
+	} else if len(sealed) < (*gcm).NonceSize() {
+		return "", fmt.Errorf("encrypted token is too short")
+	}
+	nonce, ciphertext := sealed[:(*gcm).NonceSize()], sealed[(*gcm).NonceSize():]
+	plaintext, err := (*gcm).Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+func (ulm *UserLoginMetadata) secretFields() []*string {
+	return []*string{&ulm.Token, &ulm.CookieToken, &ulm.AppToken, &ulm.UserToken}
+}
+
+type marshalableUserLoginMetadata UserLoginMetadata
+
+// MarshalJSON encrypts the credentials if a token encryption key is set,
+// or leaves them out entirely if they're stored in an external secret backend.
+func (ulm UserLoginMetadata) MarshalJSON() ([]byte, error) {
+	encrypted := ulm
+	for _, field := range encrypted.secretFields() {
+		var err error
+		if secretsStoredExternally.Load() {
+			*field = ""
+		} else if *field, err = encryptToken(*field); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal((*marshalableUserLoginMetadata)(&encrypted))
+}
+
+// UnmarshalJSON decrypts encrypted credentials. Plaintext credentials are accepted as-is,
+// and are encrypted the next time the metadata is saved if a token encryption key is set.
+func (ulm *UserLoginMetadata) UnmarshalJSON(data []byte) error {
+	err := json.Unmarshal(data, (*marshalableUserLoginMetadata)(ulm))
+	if err != nil {
+		return err
+	}
+	ulm.plaintextTokens = false
+	ulm.storedSecrets = false
+	for _, field := range ulm.secretFields() {
+		if *field != "" {
+			ulm.storedSecrets = true
+		}
+		if *field != "" && !strings.HasPrefix(*field, encryptedTokenPrefix) {
+			ulm.plaintextTokens = true
+		} else if *field, err = decryptToken(*field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasStoredSecrets returns true if the metadata was loaded with credentials in it.
+func (ulm *UserLoginMetadata) HasStoredSecrets() bool {
+	return ulm.storedSecrets
+}
+
+// NeedsTokenEncryption returns true if the metadata was loaded with plaintext credentials
+// while a token encryption key is set, i.e. it should be saved again to encrypt them.
+func (ulm *UserLoginMetadata) NeedsTokenEncryption() bool {
+	return ulm.plaintextTokens && tokenCipher.Load() != nil && !secretsStoredExternally.Load()
+}
@@ -0,0 +1,110 @@
+// mautrix-slack - A Matrix-Slack puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package slackid
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTokenKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+
+func setTestTokenKey(t *testing.T, key string) {
+	require.NoError(t, SetTokenEncryptionKey(key))
+	t.Cleanup(func() {
+		_ = SetTokenEncryptionKey("")
+	})
+}
+
+func TestUserLoginMetadata_EncryptedRoundTrip(t *testing.T) {
+	setTestTokenKey(t, testTokenKey)
+	meta := &UserLoginMetadata{Email: "user@example.com", Token: "xoxc-secret", CookieToken: "xoxd-secret"}
+	data, err := json.Marshal(meta)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(data, &raw))
+	assert.Equal(t, "user@example.com", raw["email"])
+	assert.Regexp(t, "^enc:v1:", raw["token"])
+	assert.NotContains(t, raw, "app_token")
+
+	var parsed UserLoginMetadata
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, "xoxc-secret", parsed.Token)
+	assert.Equal(t, "xoxd-secret", parsed.CookieToken)
+	assert.False(t, parsed.NeedsTokenEncryption())
+}
+
+func TestUserLoginMetadata_PlaintextMigration(t *testing.T) {
+	data := []byte(`{"email":"user@example.com","token":"xoxb-plain","settings":{}}`)
+	var parsed UserLoginMetadata
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, "xoxb-plain", parsed.Token)
+	assert.False(t, parsed.NeedsTokenEncryption(), "no key is set")
+
+	setTestTokenKey(t, testTokenKey)
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.True(t, parsed.NeedsTokenEncryption())
+	reencoded, err := json.Marshal(&parsed)
+	require.NoError(t, err)
+	assert.NotContains(t, string(reencoded), "xoxb-plain")
+}
+
+func TestUserLoginMetadata_MissingOrWrongKey(t *testing.T) {
+	setTestTokenKey(t, testTokenKey)
+	data, err := json.Marshal(&UserLoginMetadata{Token: "xoxb-secret"})
+	require.NoError(t, err)
+
+	require.NoError(t, SetTokenEncryptionKey(""))
+	var parsed UserLoginMetadata
+	assert.ErrorIs(t, json.Unmarshal(data, &parsed), ErrTokenEncryptionKeyMissing)
+
+	require.NoError(t, SetTokenEncryptionKey("HyAhHx0cGxoZGBcWFRQTEhEQDw4NDAsKCQgHBgUEAwI="))
+	assert.ErrorContains(t, json.Unmarshal(data, &parsed), "failed to decrypt token")
+}
+
+func TestParseTokenEncryptionKey(t *testing.T) {
+	_, err := ParseTokenEncryptionKey(testTokenKey)
+	assert.NoError(t, err)
+	_, err = ParseTokenEncryptionKey("c2hvcnQ=")
+	assert.ErrorContains(t, err, "must be 32 bytes")
+	_, err = ParseTokenEncryptionKey("not base64!")
+	assert.ErrorContains(t, err, "failed to decode key")
+}
+
+func TestUserLoginMetadata_SecretsStoredExternally(t *testing.T) {
+	setTestTokenKey(t, testTokenKey)
+	SetSecretsStoredExternally(true)
+	t.Cleanup(func() {
+		SetSecretsStoredExternally(false)
+	})
+	data, err := json.Marshal(&UserLoginMetadata{Email: "user@example.com", Token: "xoxc-secret", CookieToken: "xoxd-secret"})
+	require.NoError(t, err)
+	var parsed UserLoginMetadata
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, "user@example.com", parsed.Email)
+	assert.Empty(t, parsed.Token)
+	assert.Empty(t, parsed.CookieToken)
+	assert.False(t, parsed.HasStoredSecrets())
+
+	require.NoError(t, json.Unmarshal([]byte(`{"token":"xoxb-plain","settings":{}}`), &parsed))
+	assert.True(t, parsed.HasStoredSecrets())
+	assert.False(t, parsed.NeedsTokenEncryption())
+}